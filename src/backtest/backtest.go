@@ -0,0 +1,239 @@
+// Package backtest replays recorded market data through a strategy trading on paper
+// venues and measures its trades, PnL, drawdown, Sharpe ratio and hit rate
+package backtest
+
+import (
+	"errors"
+	"sort"
+	"time"
+	"trade/src/market"
+	"trade/src/paper"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultInterval is the period of the PnL curve and of the returns of the Sharpe ratio
+const DefaultInterval = 5 * time.Minute
+
+// Strategy is replayed by Run. Its orders are filled by the paper.Venue of each exchange
+// with the venue's latency and fees, the order updates come from the calls of OnTick
+type Strategy interface {
+	// Init is called before the replay with the venue of every exchange of the ticks
+	Init(venues map[string]market.ExecutionVenue) error
+	// OnTick is called with every tick in LocalTime order, after the venue of its
+	// exchange matched the open orders against it
+	OnTick(tick *market.Tick)
+}
+
+// Grouper is implemented by the strategies trading several legs as one position, such
+// as a hedge. A trade of a group lasts from the first fill while its legs are flat until
+// every leg is flat again, the trades of a strategy without Grouper are per symbol
+type Grouper interface {
+	Group(exchange string, symbol string) string
+}
+
+type Backtest struct {
+	interval     time.Duration
+	venueOptions map[string][]paper.Option
+}
+
+type Option func(*Backtest)
+
+// WithInterval overrides DefaultInterval
+func WithInterval(interval time.Duration) Option {
+	return func(b *Backtest) {
+		b.interval = interval
+	}
+}
+
+// WithVenue sets the options of the paper venue of exchange, the other venues have the
+// paper defaults
+func WithVenue(exchange string, opts ...paper.Option) Option {
+	return func(b *Backtest) {
+		b.venueOptions[exchange] = opts
+	}
+}
+
+func New(opts ...Option) *Backtest {
+	b := &Backtest{
+		interval:     DefaultInterval,
+		venueOptions: make(map[string][]paper.Option),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Run sorts ticks by LocalTime, keeping the order of equal times, and replays them
+// through strategy. The positions still open at the end are valued at the last prices
+func (b *Backtest) Run(ticks []*market.Tick, strategy Strategy) (*Result, error) {
+	if len(ticks) == 0 {
+		return nil, errors.New("backtest Run error: no ticks")
+	}
+	sort.SliceStable(ticks, func(i, j int) bool {
+		return ticks[i].LocalTime < ticks[j].LocalTime
+	})
+	venues := make(map[string]*paper.Venue)
+	executionVenues := make(map[string]market.ExecutionVenue)
+	for _, tick := range ticks {
+		if _, ok := venues[tick.Exchange]; ok {
+			continue
+		}
+		venue := paper.NewVenue(tick.Exchange, b.venueOptions[tick.Exchange]...)
+		venues[tick.Exchange] = venue
+		executionVenues[tick.Exchange] = venue
+	}
+	grouper, _ := strategy.(Grouper)
+	r := newRecorder(venues, grouper, b.interval, ticks[0].LocalTime)
+	// the recorder sees every fill before the strategy reacts to it
+	for _, venue := range venues {
+		venue.OnOrderUpdate(r.onOrderUpdate)
+	}
+	err := strategy.Init(executionVenues)
+	if err != nil {
+		return nil, err
+	}
+	for _, tick := range ticks {
+		venues[tick.Exchange].OnTick(tick)
+		strategy.OnTick(tick)
+		r.onTick(tick.LocalTime)
+	}
+	return r.result(len(ticks)), nil
+}
+
+// recorder builds the Result from the order updates and the accounts of the venues, it
+// runs on the replay goroutine
+type recorder struct {
+	venues   map[string]*paper.Venue
+	grouper  Grouper
+	interval int64
+	start    int64
+	now      int64
+	next     int64
+	// initial is the sum of the initial balances, the returns of the Sharpe ratio are
+	// relative to it
+	initial decimal.Decimal
+	peak    decimal.Decimal
+	res     *Result
+	// orders keeps the last update of the open orders, a canceled order is kept since the
+	// paper venues only return it to CancelOrder. realized is the realized PnL seen of
+	// every leg
+	orders   map[string]*market.Order
+	realized map[string]decimal.Decimal
+	trades   map[string]*Trade
+}
+
+func newRecorder(venues map[string]*paper.Venue, grouper Grouper, interval time.Duration, start int64) *recorder {
+	r := &recorder{
+		venues:   venues,
+		grouper:  grouper,
+		interval: max(interval.Milliseconds(), 1),
+		start:    start,
+		now:      start,
+		next:     start,
+		initial:  decimal.Zero,
+		peak:     decimal.Zero,
+		res:      &Result{Start: start, Trades: []*Trade{}, Equity: []*EquityPoint{}},
+		orders:   make(map[string]*market.Order),
+		realized: make(map[string]decimal.Decimal),
+		trades:   make(map[string]*Trade),
+	}
+	for _, venue := range venues {
+		r.initial = r.initial.Add(venue.Account().Balance)
+	}
+	return r
+}
+
+func (r *recorder) group(exchange string, symbol string) string {
+	if r.grouper != nil {
+		return r.grouper.Group(exchange, symbol)
+	}
+	return exchange + "/" + symbol
+}
+
+// onOrderUpdate attributes the new fill, fee and realized PnL of order to the trade of
+// its group, the realized PnL of the venue position covers every fill of the tick so
+// the later updates of the same tick add nothing
+func (r *recorder) onOrderUpdate(order *market.Order) {
+	key := order.Exchange + "/" + order.ID
+	last, ok := r.orders[key]
+	if !ok {
+		last = &market.Order{FilledQuantity: decimal.Zero, Fee: decimal.Zero}
+	}
+	if order.Status.Final() {
+		delete(r.orders, key)
+	} else {
+		r.orders[key] = order
+	}
+	filled := order.FilledQuantity.Sub(last.FilledQuantity)
+	if !filled.IsPositive() {
+		return
+	}
+	fee := order.Fee.Sub(last.Fee)
+	venue := r.venues[order.Exchange]
+	leg := order.Exchange + "/" + order.Symbol
+	position := venue.Position(order.Symbol)
+	realized := position.RealizedPnL.Sub(r.realized[leg])
+	r.realized[leg] = position.RealizedPnL
+
+	group := r.group(order.Exchange, order.Symbol)
+	trade, ok := r.trades[group]
+	if !ok {
+		trade = &Trade{
+			Group:    group,
+			OpenTime: order.UpdateTime,
+			PnL:      decimal.Zero,
+			Fees:     decimal.Zero,
+			legs:     make(map[string]decimal.Decimal),
+		}
+		r.trades[group] = trade
+		r.res.Trades = append(r.res.Trades, trade)
+	}
+	trade.Fills++
+	r.res.Fills++
+	trade.PnL = trade.PnL.Add(realized).Sub(fee)
+	trade.Fees = trade.Fees.Add(fee)
+	trade.legs[leg] = position.Quantity
+	for _, quantity := range trade.legs {
+		if !quantity.IsZero() {
+			return
+		}
+	}
+	trade.CloseTime = order.UpdateTime
+	delete(r.trades, group)
+}
+
+// onTick adds the points of the PnL curve due by now
+func (r *recorder) onTick(now int64) {
+	r.now = now
+	if now < r.next {
+		return
+	}
+	r.point(now)
+	r.next = now - (now-r.start)%r.interval + r.interval
+}
+
+func (r *recorder) point(now int64) {
+	pnl := decimal.Zero
+	for _, venue := range r.venues {
+		pnl = pnl.Add(venue.Account().PnL)
+	}
+	r.peak = decimal.Max(r.peak, pnl)
+	r.res.Equity = append(r.res.Equity, &EquityPoint{
+		Time:     now,
+		PnL:      pnl,
+		Drawdown: r.peak.Sub(pnl),
+	})
+}
+
+func (r *recorder) result(ticks int) *Result {
+	if last := r.res.Equity[len(r.res.Equity)-1]; last.Time != r.now {
+		r.point(r.now)
+	}
+	result := r.res
+	result.End = r.now
+	result.Ticks = ticks
+	result.summarize(r.initial, r.interval)
+	return result
+}
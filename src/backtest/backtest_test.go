@@ -0,0 +1,255 @@
+package backtest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trade/src/market"
+	"trade/src/paper"
+	"trade/src/strategy"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var d = decimal.RequireFromString
+
+// roundTrips buys one unit on the first book ticker and sells it on the next, again and
+// again
+type roundTrips struct {
+	venue market.ExecutionVenue
+	long  bool
+	err   error
+}
+
+func (s *roundTrips) Init(venues map[string]market.ExecutionVenue) error {
+	s.venue = venues[market.ExchangeOKX]
+	return nil
+}
+
+func (s *roundTrips) OnTick(tick *market.Tick) {
+	if tick.Type != market.TickTypeBookTicker {
+		return
+	}
+	side := market.SideBuy
+	if s.long {
+		side = market.SideSell
+	}
+	_, err := s.venue.PlaceOrder(context.Background(), &market.OrderRequest{Symbol: tick.Symbol, Side: side, Type: market.OrderTypeMarket, Quantity: d("1")})
+	s.err = err
+	s.long = !s.long
+}
+
+func TestBacktest(t *testing.T) {
+	book := func(bid string, ask string, localTime int64) *market.Tick {
+		return market.NewBookTick(market.ExchangeOKX, "BTC-USDT-SWAP", d(bid), d("10"), d(ask), d("10"), localTime, localTime)
+	}
+	minute := time.Minute.Milliseconds()
+	ticks := []*market.Tick{
+		// out of order, Run sorts them
+		book("101", "102", 2*minute),
+		book("99", "100", 0),
+		book("104", "105", 4*minute),
+		book("98", "99", 6*minute),
+		book("100", "101", 8*minute),
+		book("96", "97", 10*minute),
+	}
+	s := &roundTrips{}
+	result, err := New(
+		WithInterval(2*time.Minute),
+		WithVenue(market.ExchangeOKX, paper.WithLatency(0), paper.WithFees(decimal.Zero, d("0.001"))),
+	).Run(ticks, s)
+	require.NoError(t, err)
+	require.NoError(t, s.err)
+
+	// each order fills on the next book ticker: buy 102 sell 104, buy 99 sell 100, buy 97
+	require.Len(t, result.Trades, 3)
+	won := result.Trades[0]
+	assert.Equal(t, "okx/BTC-USDT-SWAP", won.Group)
+	assert.Equal(t, 2*minute, won.OpenTime)
+	assert.Equal(t, 4*minute, won.CloseTime)
+	assert.Equal(t, 2, won.Fills)
+	assert.True(t, won.Fees.Equal(d("0.206")), won.Fees.String())
+	assert.True(t, won.PnL.Equal(d("1.794")), won.PnL.String())
+	second := result.Trades[1]
+	assert.True(t, second.PnL.Equal(d("0.801")), second.PnL.String())
+	open := result.Trades[2]
+	assert.Zero(t, open.CloseTime)
+	assert.Equal(t, 2, result.ClosedTrades)
+	assert.Equal(t, 1.0, result.HitRate)
+	assert.Equal(t, 5, result.Fills)
+	assert.Equal(t, 6, result.Ticks)
+	assert.Equal(t, int64(0), result.Start)
+	assert.Equal(t, 10*minute, result.End)
+
+	// a point every 2 minutes, the open long of 97 is valued at the book mid of 96.5
+	require.Len(t, result.Equity, 6)
+	last := result.Equity[5]
+	assert.True(t, last.PnL.Equal(d("1.794").Add(d("0.801")).Sub(d("0.097")).Sub(d("0.5"))), last.PnL.String())
+	assert.True(t, result.PnL.Equal(last.PnL))
+	assert.True(t, result.Fees.Equal(d("0.206").Add(d("0.199")).Add(d("0.097"))), result.Fees.String())
+	assert.True(t, result.MaxDrawdown.IsPositive())
+	assert.NotZero(t, result.Sharpe)
+
+	_, err = New().Run(nil, s)
+	assert.Error(t, err)
+}
+
+func TestPriceGapBacktestV1(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.InfoLevel))
+	// the gap opens to 2% and closes, the second line has the cross spreads
+	for i, line := range []string{"0.01", "2,1.9,-2.1", "2", "0.05", "0.05", "0.05"} {
+		logger.Info(fmt.Sprintf("%d,BTC-USDT-SWAP,%s", 1_700_000_000+i, line))
+	}
+	require.NoError(t, logger.Sync())
+	path := filepath.Join(t.TempDir(), "price_gap.log")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	_, skipped, err := ReadPriceGapLog(path, nil)
+	assert.ErrorContains(t, err, "all 6 lines skipped")
+	assert.Equal(t, 6, skipped)
+	_, _, err = ReadPriceGapLog(path, &PriceGapV1{ExchangeA: market.ExchangeOKX, ExchangeB: market.ExchangeBinance, Pairs: map[string]string{"ETH-USDT-SWAP": "ETHUSDT"}})
+	assert.Error(t, err, "no line of a known pair")
+
+	records, skipped, err := ReadPriceGapLog(path, &PriceGapV1{ExchangeA: market.ExchangeOKX, ExchangeB: market.ExchangeBinance, Pairs: map[string]string{"BTC-USDT-SWAP": "BTCUSDT"}})
+	require.NoError(t, err)
+	assert.Zero(t, skipped)
+	require.Len(t, records, 6)
+	assert.True(t, records[1].PriceA.Equal(d("1.02")))
+	assert.Nil(t, records[1].Executable)
+
+	pairs := []*strategy.Pair{{A: &strategy.ExchangePrice{Symbol: "BTC-USDT-SWAP"}, B: &strategy.ExchangePrice{Symbol: "BTCUSDT"}}}
+	priceGap := strategy.NewPriceGapBacktest(market.ExchangeOKX, market.ExchangeBinance, pairs, strategy.WithTrading(&strategy.PriceGapTrading{
+		Entry:    d("0.5"),
+		Exit:     d("0.1"),
+		Notional: d("1000"),
+	}))
+	result, err := New(
+		WithVenue(market.ExchangeOKX, paper.WithLatency(0), paper.WithFees(decimal.Zero, decimal.Zero)),
+		WithVenue(market.ExchangeBinance, paper.WithLatency(0), paper.WithFees(decimal.Zero, decimal.Zero)),
+	).Run(PriceGapTicks(records), priceGap)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.ClosedTrades)
+	assert.True(t, result.PnL.IsPositive(), result.PnL.String())
+}
+
+func TestPriceGapBacktest(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(gz), zapcore.InfoLevel))
+	// version 1 lines are skipped
+	logger.Info("1700000000,BTC-USDT-SWAP,0.01")
+	second := time.Second.Milliseconds()
+	for i, prices := range [][2]string{
+		{"100", "100"},
+		// 2% of gap opens the hedge, short A and long B
+		{"102", "100"},
+		{"102", "100"},
+		// the gap closes
+		{"101", "101"},
+		{"101", "101"},
+		{"101", "101"},
+	} {
+		now := 1_700_000_000_000 + int64(i)*second
+		priceA, priceB := d(prices[0]), d(prices[1])
+		logger.Info(strategy.PriceGapMessage, zap.Inline(&strategy.PriceGapRecord{
+			Schema:    strategy.PriceGapSchemaVersion,
+			Symbol:    "BTC-USDT-SWAP",
+			LocalTime: now,
+			Ratio:     priceA.Sub(priceB).Div(priceA.Add(priceB).Div(decimal.NewFromInt(2))).Mul(decimal.NewFromInt(100)),
+			ExchangeA: market.ExchangeOKX,
+			SymbolA:   "BTC-USDT-SWAP",
+			PriceA:    priceA,
+			TimeA:     now - 10,
+			LatencyA:  5,
+			ExchangeB: market.ExchangeBinance,
+			SymbolB:   "BTCUSDT",
+			PriceB:    priceB,
+			TimeB:     now - 20,
+			LatencyB:  5,
+		}))
+	}
+	require.NoError(t, logger.Sync())
+	require.NoError(t, gz.Close())
+	path := filepath.Join(t.TempDir(), "price_gap.log.gz")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	records, skipped, err := ReadPriceGapLog(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, skipped)
+	require.Len(t, records, 6)
+	// the version 1 line of a known pair is replayed at synthetic prices
+	withV1, skipped, err := ReadPriceGapLog(path, &PriceGapV1{ExchangeA: market.ExchangeOKX, ExchangeB: market.ExchangeBinance, Pairs: map[string]string{"BTC-USDT-SWAP": "BTCUSDT"}})
+	require.NoError(t, err)
+	assert.Zero(t, skipped)
+	require.Len(t, withV1, 7)
+	assert.Equal(t, "BTCUSDT", withV1[0].SymbolB)
+	assert.True(t, withV1[0].PriceA.Equal(d("1.0001")), withV1[0].PriceA.String())
+	assert.True(t, withV1[0].PriceB.Equal(d("1")))
+	assert.Equal(t, int64(1_700_000_000_000), withV1[0].TimeA)
+	ticks := PriceGapTicks(records)
+	// a mark price and a book ticker of each leg per record
+	require.Len(t, ticks, 24)
+	assert.Equal(t, market.TickTypeMarkPrice, ticks[0].Type)
+	assert.Equal(t, records[0].TimeA+5, ticks[0].LocalTime)
+
+	pairs := []*strategy.Pair{{A: &strategy.ExchangePrice{Symbol: "BTC-USDT-SWAP"}, B: &strategy.ExchangePrice{Symbol: "BTCUSDT"}}}
+	priceGap := strategy.NewPriceGapBacktest(market.ExchangeOKX, market.ExchangeBinance, pairs, strategy.WithTrading(&strategy.PriceGapTrading{
+		Entry:    d("0.5"),
+		Exit:     d("0.1"),
+		Notional: d("1000"),
+		FeeA:     d("0.0005"),
+		FeeB:     d("0.0005"),
+	}))
+	result, err := New(
+		WithVenue(market.ExchangeOKX, paper.WithLatency(0)),
+		WithVenue(market.ExchangeBinance, paper.WithLatency(0)),
+	).Run(ticks, priceGap)
+	require.NoError(t, err)
+	assert.Positive(t, priceGap.Stats().Samples)
+
+	// sold A at 102 and bought B at 100 then closed both at 101
+	require.Len(t, result.Trades, 1)
+	trade := result.Trades[0]
+	assert.Equal(t, "BTC-USDT-SWAP", trade.Group)
+	assert.NotZero(t, trade.CloseTime)
+	assert.Equal(t, 4, trade.Fills)
+	assert.True(t, trade.PnL.IsPositive(), trade.PnL.String())
+	assert.Equal(t, 1.0, result.HitRate)
+	assert.True(t, result.PnL.Equal(trade.PnL), result.PnL.String())
+
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"hit_rate":1`)
+	var html bytes.Buffer
+	require.NoError(t, result.RenderHTML(&html, "Price Gap Backtest"))
+	assert.Contains(t, html.String(), "echarts")
+	assert.Contains(t, html.String(), "BTC-USDT-SWAP")
+}
+
+func TestReadTicks(t *testing.T) {
+	tick := market.NewBookTick(market.ExchangeBinance, "BTCUSDT", d("99.5"), d("1"), d("100.5"), d("2"), 10, 12)
+	data, err := json.Marshal(tick)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ticks.jsonl")
+	require.NoError(t, os.WriteFile(path, append(append(data, '\n'), data...), 0o644))
+	ticks, err := ReadTicks(path)
+	require.NoError(t, err)
+	require.Len(t, ticks, 2)
+	assert.True(t, ticks[1].Price.Equal(d("100")))
+	assert.Equal(t, int64(12), ticks[1].LocalTime)
+
+	require.NoError(t, os.WriteFile(path, []byte("{\n"), 0o644))
+	_, err = ReadTicks(path)
+	assert.ErrorContains(t, err, "line 1")
+}
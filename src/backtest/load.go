@@ -0,0 +1,194 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"trade/src/common"
+	"trade/src/market"
+	"trade/src/strategy"
+
+	"github.com/shopspring/decimal"
+)
+
+// unlimitedDepth is the quantity of the book tickers replayed from the chart logs, which
+// have no book quantities, so any order fills at the logged prices
+var unlimitedDepth = decimal.New(1, 12)
+
+// readLines reads the non empty lines of a file, gzipped when its name ends with .gz
+func readLines(path string) ([]string, error) {
+	var (
+		raw string
+		err error
+	)
+	if strings.HasSuffix(path, ".gz") {
+		raw, err = common.ReadGzLogFile(path)
+	} else {
+		raw, err = common.ReadLogFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(raw, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// PriceGapV1 names the legs of the version 1 lines, which only log the time in seconds,
+// the symbol of A and the mark price ratio. Pairs maps the symbol of A to the symbol of
+// B, the lines of the other symbols are skipped
+type PriceGapV1 struct {
+	ExchangeA string
+	ExchangeB string
+	Pairs     map[string]string
+}
+
+// ReadPriceGapLog reads the records of a price_gap chart log, .log or .log.gz. The
+// version 1 lines are replayed with v1, skipped when it is nil, at the synthetic mark
+// prices 1 for B and 1+ratio/100 for A so a hedge earns the moves of the ratio in
+// percent of its notional. Their cross spreads and trade flow are dropped. The lines
+// that can't be decoded are skipped and counted, it is an error when every line was
+// skipped
+func ReadPriceGapLog(path string, v1 *PriceGapV1) ([]*strategy.PriceGapRecord, int, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := make([]*strategy.PriceGapRecord, 0, len(lines))
+	skipped := 0
+	for _, line := range lines {
+		record := &strategy.PriceGapRecord{}
+		err := json.Unmarshal([]byte(line), record)
+		switch {
+		case err != nil:
+		case record.Schema == strategy.PriceGapSchemaVersion:
+			records = append(records, record)
+			continue
+		case record.Schema == 0 && v1 != nil:
+			record, err = v1.record(line)
+			if err == nil && record != nil {
+				records = append(records, record)
+				continue
+			}
+		}
+		skipped++
+	}
+	if len(records) == 0 && skipped > 0 {
+		return nil, skipped, fmt.Errorf("ReadPriceGapLog %s error: all %d lines skipped, none is of version %d or a version 1 line of a known pair", path, skipped, strategy.PriceGapSchemaVersion)
+	}
+	return records, skipped, nil
+}
+
+// record converts a version 1 line "ts,symbol,ratio[,spreads][,flow]", it is nil when
+// the symbol is not one of the pairs
+func (v *PriceGapV1) record(line string) (*strategy.PriceGapRecord, error) {
+	logData := &common.LogData{}
+	err := json.Unmarshal([]byte(line), logData)
+	if err != nil {
+		return nil, err
+	}
+	params := strings.Split(logData.Message, ",")
+	if len(params) < 3 {
+		return nil, fmt.Errorf("invalid version 1 message %q", logData.Message)
+	}
+	seconds, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ratio, err := decimal.NewFromString(params[2])
+	if err != nil {
+		return nil, err
+	}
+	symbolB, ok := v.Pairs[params[1]]
+	if !ok {
+		return nil, nil
+	}
+	now := seconds * 1000
+	return &strategy.PriceGapRecord{
+		Symbol:    params[1],
+		LocalTime: now,
+		Ratio:     ratio,
+		ExchangeA: v.ExchangeA,
+		SymbolA:   params[1],
+		PriceA:    decimal.NewFromInt(1).Add(ratio.Div(decimal.NewFromInt(100))),
+		TimeA:     now,
+		ExchangeB: v.ExchangeB,
+		SymbolB:   symbolB,
+		PriceB:    decimal.NewFromInt(1),
+		TimeB:     now,
+	}, nil
+}
+
+// PriceGapTicks converts the records to the mark prices and book tickers of both legs,
+// each logged once. A mark price is received at its exchange time plus the logged
+// latency and a book ticker at the local time of the first record logging it. The
+// records without book tickers, logged in the mark price mode, get a book ticker without
+// spread at the mark price so the orders fill at the mark price
+func PriceGapTicks(records []*strategy.PriceGapRecord) []*market.Tick {
+	ticks := []*market.Tick{}
+	seen := make(map[string]bool)
+	add := func(tick *market.Tick) {
+		key := fmt.Sprintf("%s/%s/%s/%d", tick.Exchange, tick.Symbol, tick.Type, tick.ExchangeTime)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		ticks = append(ticks, tick)
+	}
+	for _, record := range records {
+		for _, leg := range []struct {
+			exchange string
+			symbol   string
+			price    decimal.Decimal
+			time     int64
+			latency  int64
+		}{
+			{record.ExchangeA, record.SymbolA, record.PriceA, record.TimeA, record.LatencyA},
+			{record.ExchangeB, record.SymbolB, record.PriceB, record.TimeB, record.LatencyB},
+		} {
+			if leg.exchange == "" || leg.symbol == "" || !leg.price.IsPositive() {
+				continue
+			}
+			add(&market.Tick{
+				Exchange:     leg.exchange,
+				Symbol:       leg.symbol,
+				Type:         market.TickTypeMarkPrice,
+				Price:        leg.price,
+				ExchangeTime: leg.time,
+				LocalTime:    leg.time + leg.latency,
+			})
+		}
+		if e := record.Executable; e != nil {
+			add(market.NewBookTick(record.ExchangeA, record.SymbolA, e.BidA, unlimitedDepth, e.AskA, unlimitedDepth, e.BookTimeA, record.LocalTime))
+			add(market.NewBookTick(record.ExchangeB, record.SymbolB, e.BidB, unlimitedDepth, e.AskB, unlimitedDepth, e.BookTimeB, record.LocalTime))
+			continue
+		}
+		add(market.NewBookTick(record.ExchangeA, record.SymbolA, record.PriceA, unlimitedDepth, record.PriceA, unlimitedDepth, record.TimeA, record.TimeA+record.LatencyA))
+		add(market.NewBookTick(record.ExchangeB, record.SymbolB, record.PriceB, unlimitedDepth, record.PriceB, unlimitedDepth, record.TimeB, record.TimeB+record.LatencyB))
+	}
+	return ticks
+}
+
+// ReadTicks reads a tick capture, .jsonl or .jsonl.gz, of one JSON encoded market.Tick
+// per line
+func ReadTicks(path string) ([]*market.Tick, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	ticks := make([]*market.Tick, 0, len(lines))
+	for i, line := range lines {
+		tick := &market.Tick{}
+		err := json.Unmarshal([]byte(line), tick)
+		if err != nil {
+			return nil, fmt.Errorf("ReadTicks %s line %d error: %w", path, i+1, err)
+		}
+		ticks = append(ticks, tick)
+	}
+	return ticks, nil
+}
@@ -0,0 +1,97 @@
+package backtest
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/components"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// RenderHTML writes an echarts page of the PnL curve with its drawdown and of the PnL of
+// every closed trade, titled with the summary of the result
+func (r *Result) RenderHTML(w io.Writer, title string) error {
+	summary := fmt.Sprintf("pnl %s, fees %s, max drawdown %s, sharpe %.2f, hit rate %.1f%% of %d trades",
+		r.PnL.StringFixed(2), r.Fees.StringFixed(2), r.MaxDrawdown.StringFixed(2), r.Sharpe, r.HitRate*100, r.ClosedTrades)
+	page := components.NewPage()
+	page.SetPageTitle(title)
+	page.AddCharts(r.equityChart(title, summary), r.tradesChart())
+	return page.Render(w)
+}
+
+func (r *Result) equityChart(title string, summary string) *charts.Line {
+	chart := charts.NewLine()
+	chart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title:    title,
+			Subtitle: summary,
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Type: "time",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    opts.Bool(true),
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: opts.Bool(true),
+			Top:  "bottom",
+		}),
+		charts.WithDataZoomOpts(
+			opts.DataZoom{
+				Type:  "slider",
+				Start: 0,
+				End:   100,
+			},
+			opts.DataZoom{
+				Type:  "inside",
+				Start: 0,
+				End:   100,
+			},
+		),
+	)
+	pnl := make([]opts.LineData, 0, len(r.Equity))
+	drawdown := make([]opts.LineData, 0, len(r.Equity))
+	for _, point := range r.Equity {
+		t := time.UnixMilli(point.Time)
+		pnl = append(pnl, opts.LineData{Value: []interface{}{t, point.PnL.InexactFloat64()}})
+		drawdown = append(drawdown, opts.LineData{Value: []interface{}{t, point.Drawdown.Neg().InexactFloat64()}})
+	}
+	chart.AddSeries("pnl", pnl)
+	chart.AddSeries("drawdown", drawdown)
+	return chart
+}
+
+func (r *Result) tradesChart() *charts.Scatter {
+	chart := charts.NewScatter()
+	chart.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: "Trades",
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Type: "time",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: opts.Bool(true),
+		}),
+	)
+	trades := make(map[string][]opts.ScatterData)
+	groups := []string{}
+	for _, trade := range r.Trades {
+		if trade.CloseTime == 0 {
+			continue
+		}
+		if _, ok := trades[trade.Group]; !ok {
+			groups = append(groups, trade.Group)
+		}
+		trades[trade.Group] = append(trades[trade.Group], opts.ScatterData{
+			Value: []interface{}{time.UnixMilli(trade.CloseTime), trade.PnL.InexactFloat64()},
+		})
+	}
+	for _, group := range groups {
+		chart.AddSeries(group, trades[group])
+	}
+	return chart
+}
@@ -0,0 +1,104 @@
+package backtest
+
+import (
+	"math"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Result is the outcome of a replay, times are in milliseconds and amounts in the quote
+// currency, fees included. MaxDrawdown is the largest fall of the PnL curve from a
+// previous peak, Sharpe is annualized from the returns of the curve over each interval
+// relative to the initial balance of the venues and HitRate is the share of the closed
+// trades with a positive PnL
+type Result struct {
+	Start       int64           `json:"start"`
+	End         int64           `json:"end"`
+	Ticks       int             `json:"ticks"`
+	Fills       int             `json:"fills"`
+	PnL         decimal.Decimal `json:"pnl"`
+	Fees        decimal.Decimal `json:"fees"`
+	MaxDrawdown decimal.Decimal `json:"max_drawdown"`
+	Sharpe      float64         `json:"sharpe"`
+	HitRate     float64         `json:"hit_rate"`
+	// ClosedTrades counts the trades with a CloseTime, the open trades have no hit
+	ClosedTrades int            `json:"closed_trades"`
+	Trades       []*Trade       `json:"trades"`
+	Equity       []*EquityPoint `json:"equity"`
+}
+
+// Trade is a position of a group from flat to flat, CloseTime is zero when it is still
+// open at the end and its PnL only counts the realized part
+type Trade struct {
+	Group     string          `json:"group"`
+	OpenTime  int64           `json:"open_time"`
+	CloseTime int64           `json:"close_time"`
+	Fills     int             `json:"fills"`
+	PnL       decimal.Decimal `json:"pnl"`
+	Fees      decimal.Decimal `json:"fees"`
+	// legs is the position of each exchange/symbol of the group
+	legs map[string]decimal.Decimal
+}
+
+// EquityPoint is the PnL of every venue at Time and its Drawdown from the peak before
+type EquityPoint struct {
+	Time     int64           `json:"time"`
+	PnL      decimal.Decimal `json:"pnl"`
+	Drawdown decimal.Decimal `json:"drawdown"`
+}
+
+// summarize computes the metrics of the trades and the PnL curve sampled every interval
+// milliseconds
+func (r *Result) summarize(initial decimal.Decimal, interval int64) {
+	r.PnL = r.Equity[len(r.Equity)-1].PnL
+	r.Fees = decimal.Zero
+	r.MaxDrawdown = decimal.Zero
+	wins := 0
+	for _, trade := range r.Trades {
+		r.Fees = r.Fees.Add(trade.Fees)
+		if trade.CloseTime == 0 {
+			continue
+		}
+		r.ClosedTrades++
+		if trade.PnL.IsPositive() {
+			wins++
+		}
+	}
+	if r.ClosedTrades > 0 {
+		r.HitRate = float64(wins) / float64(r.ClosedTrades)
+	}
+	for _, point := range r.Equity {
+		r.MaxDrawdown = decimal.Max(r.MaxDrawdown, point.Drawdown)
+	}
+	r.Sharpe = sharpe(r.Equity, initial, interval)
+}
+
+// sharpe is the mean over the standard deviation of the interval returns, times the
+// square root of the intervals in a year. It is zero without at least two returns or
+// without variance
+func sharpe(equity []*EquityPoint, initial decimal.Decimal, interval int64) float64 {
+	if len(equity) < 3 || !initial.IsPositive() {
+		return 0
+	}
+	capital := initial.InexactFloat64()
+	returns := make([]float64, 0, len(equity)-1)
+	for i := 1; i < len(equity); i++ {
+		returns = append(returns, equity[i].PnL.Sub(equity[i-1].PnL).InexactFloat64()/capital)
+	}
+	mean := 0.0
+	for _, ret := range returns {
+		mean += ret
+	}
+	mean /= float64(len(returns))
+	variance := 0.0
+	for _, ret := range returns {
+		variance += (ret - mean) * (ret - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+	periods := float64(365*24*time.Hour.Milliseconds()) / float64(interval)
+	return mean / std * math.Sqrt(periods)
+}
@@ -3,11 +3,14 @@ package chart
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 	"trade/src/common"
+	"trade/src/strategy"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/opts"
@@ -23,6 +26,12 @@ type PriceGapChartData struct {
 	Timestamp int64
 	Symbol    string
 	Ratio     float64
+	// Executable is the better of the two cross spreads, only set for lines logged in
+	// the executable mode
+	Executable    float64
+	HasExecutable bool
+	// Stale samples were flagged by the staleness or skew guards and are not plotted
+	Stale bool
 }
 
 func NewPriceGapChart() *PriceGapChart {
@@ -59,6 +68,11 @@ func (c *PriceGapChart) Run(ctx context.Context) error {
 	return c.chart.Render(chartFile)
 }
 
+// Close has nothing to release, Run renders the chart synchronously
+func (c *PriceGapChart) Close(ctx context.Context) error {
+	return nil
+}
+
 func (c *PriceGapChart) readLogFile(file string) error {
 	var (
 		rawStr string
@@ -78,40 +92,90 @@ func (c *PriceGapChart) readLogFile(file string) error {
 			common.Logger.Sugar().Warnf("PriceGapChart readLogFile empty line")
 			continue
 		}
-		logData := &common.LogData{}
-		err = json.Unmarshal([]byte(line), logData)
-		if err != nil {
-			common.Logger.Sugar().Errorf("PriceGapChart readLogFile json.Unmarshal error: %v", err)
-			continue
-		}
-		params := strings.Split(logData.Message, ",")
-		if len(params) != 3 {
-			common.Logger.Sugar().Warnf("PriceGapChart readLogFile invalid line format: %s", line)
-			continue
-		}
-		timestamp, err := strconv.ParseInt(params[0], 10, 64)
+		data, err := parsePriceGapLine(line)
 		if err != nil {
-			common.Logger.Sugar().Errorf("PriceGapChart readLogFile strconv.ParseInt error: %v", err)
-			continue
-		}
-		symbol := params[1]
-		ratio, err := strconv.ParseFloat(params[2], 64)
-		if err != nil {
-			common.Logger.Sugar().Errorf("PriceGapChart readLogFile strconv.ParseFloat error: %v", err)
+			common.Logger.Sugar().Warnf("PriceGapChart readLogFile %v: %s", err, line)
 			continue
 		}
+		symbol := data.Symbol
 		if c.datas[symbol] == nil {
 			c.datas[symbol] = make([]*PriceGapChartData, 0, 1024*8)
 		}
-		c.datas[symbol] = append(c.datas[symbol], &PriceGapChartData{
-			Timestamp: timestamp,
-			Symbol:    symbol,
-			Ratio:     ratio,
-		})
+		c.datas[symbol] = append(c.datas[symbol], data)
 	}
 	return nil
 }
 
+// priceGapLine decodes both schema versions, version 1 lines have no schema field and
+// keep their data in the message
+type priceGapLine struct {
+	common.LogData
+	strategy.PriceGapRecord
+}
+
+func parsePriceGapLine(line string) (*PriceGapChartData, error) {
+	logData := &priceGapLine{}
+	err := json.Unmarshal([]byte(line), logData)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	switch logData.Schema {
+	case 0:
+		return parsePriceGapMessage(logData.Message)
+	case strategy.PriceGapSchemaVersion:
+	default:
+		return nil, fmt.Errorf("unknown schema %d", logData.Schema)
+	}
+	record := &logData.PriceGapRecord
+	data := &PriceGapChartData{
+		Timestamp: record.LocalTime / 1000,
+		Symbol:    record.Symbol,
+		Ratio:     record.Ratio.InexactFloat64(),
+		Stale:     len(record.Stale) > 0,
+	}
+	if record.Executable != nil {
+		data.Executable = max(record.Executable.SellABuyB.InexactFloat64(), record.Executable.SellBBuyA.InexactFloat64())
+		data.HasExecutable = true
+	}
+	return data, nil
+}
+
+// parsePriceGapMessage parses the version 1 message "ts,symbol,ratio", followed by the 2
+// cross spreads in the executable mode and then the 6 trade flow fields of WithTradeFlow
+func parsePriceGapMessage(message string) (*PriceGapChartData, error) {
+	params := strings.Split(message, ",")
+	// the chart does not plot the trade flow
+	if len(params) == 9 || len(params) == 11 {
+		params = params[:len(params)-6]
+	}
+	if len(params) != 3 && len(params) != 5 {
+		return nil, fmt.Errorf("invalid line format")
+	}
+	timestamp, err := strconv.ParseInt(params[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("strconv.ParseInt error: %w", err)
+	}
+	ratio, err := strconv.ParseFloat(params[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("strconv.ParseFloat error: %w", err)
+	}
+	data := &PriceGapChartData{
+		Timestamp: timestamp,
+		Symbol:    params[1],
+		Ratio:     ratio,
+	}
+	if len(params) == 5 {
+		sellABuyB, errA := strconv.ParseFloat(params[3], 64)
+		sellBBuyA, errB := strconv.ParseFloat(params[4], 64)
+		if errA != nil || errB != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat spread error: %w", errors.Join(errA, errB))
+		}
+		data.Executable = max(sellABuyB, sellBBuyA)
+		data.HasExecutable = true
+	}
+	return data, nil
+}
+
 func (c *PriceGapChart) constructGraph() error {
 	c.chart = charts.NewLine()
 	c.chart.SetGlobalOptions(
@@ -144,10 +208,20 @@ func (c *PriceGapChart) constructGraph() error {
 	)
 	for symbol, data := range c.datas {
 		line := make([]opts.LineData, 0, len(data))
+		executable := []opts.LineData{}
 		for _, d := range data {
+			if d.Stale {
+				continue
+			}
 			line = append(line, opts.LineData{Value: []interface{}{time.Unix(d.Timestamp, 0), d.Ratio}})
+			if d.HasExecutable {
+				executable = append(executable, opts.LineData{Value: []interface{}{time.Unix(d.Timestamp, 0), d.Executable}})
+			}
 		}
 		c.chart.AddSeries(symbol, line)
+		if len(executable) > 0 {
+			c.chart.AddSeries(symbol+" executable", executable)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,80 @@
+package chart
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"trade/src/strategy"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestPriceGapChartReadLogFile(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.InfoLevel))
+	// version 1 lines in every layout
+	logger.Info("1700000000,BTC-USDT-SWAP,0.01")
+	logger.Info("1700000001,BTC-USDT-SWAP,0.02,-0.05,0.03")
+	logger.Info("1700000002,BTC-USDT-SWAP,0.03,3,100.00,0.3333,2,50.00,-1.0000")
+	logger.Info("1700000003,BTC-USDT-SWAP,0.04,-0.05,-0.01,3,100.00,0.3333,2,50.00,-1.0000")
+	logger.Info("1700000004,BTC-USDT-SWAP")
+	// version 2 lines
+	d := decimal.RequireFromString
+	logger.Info(strategy.PriceGapMessage, zap.Inline(&strategy.PriceGapRecord{
+		Schema:    strategy.PriceGapSchemaVersion,
+		Symbol:    "ETH-USDT-SWAP",
+		LocalTime: 1700000005_123,
+		Ratio:     d("0.05"),
+		SymbolA:   "ETH-USDT-SWAP",
+		PriceA:    d("2000.5"),
+		SymbolB:   "ETHUSDT",
+		PriceB:    d("2000.4"),
+	}))
+	logger.Info(strategy.PriceGapMessage, zap.Inline(&strategy.PriceGapRecord{
+		Schema:     strategy.PriceGapSchemaVersion,
+		Symbol:     "ETH-USDT-SWAP",
+		LocalTime:  1700000006_000,
+		Ratio:      d("0.06"),
+		Executable: &strategy.PriceGapExecutable{SellABuyB: d("-0.02"), SellBBuyA: d("0.01")},
+		Flow:       &strategy.PriceGapFlow{TradesA: 1, NotionalA: d("10")},
+	}))
+	logger.Info(strategy.PriceGapMessage, zap.Inline(&strategy.PriceGapRecord{
+		Schema:    strategy.PriceGapSchemaVersion,
+		Symbol:    "ETH-USDT-SWAP",
+		LocalTime: 1700000007_000,
+		Ratio:     d("5"),
+		Stale:     []string{strategy.PriceGapStaleB, strategy.PriceGapStaleSkew},
+	}))
+	logger.Info(strategy.PriceGapMessage, zap.Int("schema", strategy.PriceGapSchemaVersion+1))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.log"), buf.Bytes(), 0o644))
+	c := NewPriceGapChart()
+	c.path = dir + "/"
+	require.NoError(t, c.readLogFile("app.log"))
+
+	btc := c.datas["BTC-USDT-SWAP"]
+	require.Len(t, btc, 4)
+	assert.Equal(t, int64(1700000000), btc[0].Timestamp)
+	assert.False(t, btc[0].HasExecutable)
+	assert.True(t, btc[1].HasExecutable)
+	assert.Equal(t, 0.03, btc[1].Executable)
+	assert.Equal(t, 0.03, btc[2].Ratio)
+	assert.False(t, btc[2].HasExecutable)
+	assert.Equal(t, -0.01, btc[3].Executable)
+
+	eth := c.datas["ETH-USDT-SWAP"]
+	require.Len(t, eth, 3)
+	assert.Equal(t, int64(1700000005), eth[0].Timestamp)
+	assert.Equal(t, 0.05, eth[0].Ratio)
+	assert.False(t, eth[0].HasExecutable)
+	assert.True(t, eth[1].HasExecutable)
+	assert.Equal(t, 0.01, eth[1].Executable)
+	assert.False(t, eth[1].Stale)
+	assert.True(t, eth[2].Stale)
+}
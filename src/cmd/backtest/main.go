@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"trade/src/backtest"
+	"trade/src/common"
+	"trade/src/config"
+	"trade/src/strategy"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path of the YAML or JSON config file whose price_gap trading rules and paper venues are tested, the default config is used when empty")
+	logDir := flag.String("logs", "", "directory of the price_gap chart logs, the chart dir of the config when empty")
+	tickFiles := flag.String("ticks", "", "comma separated tick captures replayed with the chart logs, one JSON market.Tick per line")
+	outDir := flag.String("out", "./backtest/", "directory of backtest.json and backtest.html")
+	interval := flag.Duration("interval", backtest.DefaultInterval, "period of the PnL curve and of the Sharpe ratio returns")
+	flag.Parse()
+
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	cfg.Apply()
+	if *logDir == "" {
+		*logDir = filepath.Join(common.ChartDir, "price_gap")
+	}
+
+	exchangeA, exchangeB := cfg.PriceGap.ExchangeA, cfg.PriceGap.ExchangeB
+	v1 := &backtest.PriceGapV1{ExchangeA: exchangeA, ExchangeB: exchangeB, Pairs: make(map[string]string)}
+	for _, pair := range cfg.PriceGap.Pairs {
+		v1.Pairs[pair.A] = pair.B
+	}
+	records, err := readRecords(*logDir, v1)
+	if err != nil {
+		common.Logger.Sugar().Fatalf("Failed to read the chart logs: %v", err)
+	}
+	ticks := backtest.PriceGapTicks(records)
+	if *tickFiles != "" {
+		for _, file := range strings.Split(*tickFiles, ",") {
+			captured, err := backtest.ReadTicks(file)
+			if err != nil {
+				common.Logger.Sugar().Fatalf("Failed to read the tick capture: %v", err)
+			}
+			ticks = append(ticks, captured...)
+		}
+	}
+	pairs := newPairs(cfg, records)
+	if len(pairs) == 0 {
+		common.Logger.Sugar().Fatalf("No %s %s pair to backtest, set price_gap.pairs or record chart logs", exchangeA, exchangeB)
+	}
+	common.Logger.Sugar().Infof("Backtest %d pairs on %d ticks", len(pairs), len(ticks))
+
+	priceGap := strategy.NewPriceGapBacktest(exchangeA, exchangeB, pairs, cfg.PriceGapBacktestOptions()...)
+	result, err := backtest.New(
+		backtest.WithInterval(*interval),
+		backtest.WithVenue(exchangeA, cfg.PriceGap.Trading.PaperVenue(exchangeA).Options()...),
+		backtest.WithVenue(exchangeB, cfg.PriceGap.Trading.PaperVenue(exchangeB).Options()...),
+	).Run(ticks, priceGap)
+	if err != nil {
+		common.Logger.Sugar().Fatalf("Failed to run the backtest: %v", err)
+	}
+	stats := priceGap.Stats()
+	common.Logger.Sugar().Infof("Backtest samples: %d, stale: %d, pnl: %s, fees: %s, max drawdown: %s, sharpe: %.2f, hit rate: %.2f, trades: %d",
+		stats.Samples, stats.Flagged+stats.Suppressed, result.PnL.StringFixed(4), result.Fees.StringFixed(4), result.MaxDrawdown.StringFixed(4),
+		result.Sharpe, result.HitRate, result.ClosedTrades)
+
+	if err := writeResult(*outDir, result); err != nil {
+		common.Logger.Sugar().Fatalf("Failed to write the result: %v", err)
+	}
+}
+
+// readRecords reads every price_gap chart log of dir comparing the exchanges of v1, the
+// version 1 lines of the configured pairs included
+func readRecords(dir string, v1 *backtest.PriceGapV1) ([]*strategy.PriceGapRecord, error) {
+	files, err := common.ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	records := []*strategy.PriceGapRecord{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".log") && !strings.HasSuffix(file, ".log.gz") {
+			continue
+		}
+		read, skipped, err := backtest.ReadPriceGapLog(filepath.Join(dir, file), v1)
+		if err != nil {
+			common.Logger.Sugar().Errorf("Backtest ReadPriceGapLog %s error: %v", file, err)
+			continue
+		}
+		if skipped > 0 {
+			common.Logger.Sugar().Warnf("Backtest ReadPriceGapLog %s skipped %d lines neither of version %d nor of a configured pair", file, skipped, strategy.PriceGapSchemaVersion)
+		}
+		for _, record := range read {
+			if record.ExchangeA == v1.ExchangeA && record.ExchangeB == v1.ExchangeB {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+// newPairs uses the configured pairs, or else every pair of the records
+func newPairs(cfg *config.Config, records []*strategy.PriceGapRecord) []*strategy.Pair {
+	pairs := []*strategy.Pair{}
+	newPair := func(a string, b string) {
+		pairs = append(pairs, &strategy.Pair{
+			A: &strategy.ExchangePrice{Symbol: a},
+			B: &strategy.ExchangePrice{Symbol: b},
+		})
+	}
+	if len(cfg.PriceGap.Pairs) > 0 {
+		for _, pair := range cfg.PriceGap.Pairs {
+			newPair(pair.A, pair.B)
+		}
+		return pairs
+	}
+	seen := make(map[string]bool)
+	for _, record := range records {
+		key := record.SymbolA + "/" + record.SymbolB
+		if !seen[key] {
+			seen[key] = true
+			newPair(record.SymbolA, record.SymbolB)
+		}
+	}
+	return pairs
+}
+
+func writeResult(dir string, result *backtest.Result) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, "backtest.json"), data, 0o644)
+	if err != nil {
+		return err
+	}
+	report, err := os.Create(filepath.Join(dir, "backtest.html"))
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	title := fmt.Sprintf("Price Gap Backtest %s - %s", time.UnixMilli(result.Start).UTC().Format(time.DateTime), time.UnixMilli(result.End).UTC().Format(time.DateTime))
+	return result.RenderHTML(report, title)
+}
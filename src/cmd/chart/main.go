@@ -14,5 +14,8 @@ func main() {
 		if err := component.Run(context.Background()); err != nil {
 			common.Logger.Sugar().Fatalf("Failed to run component: %v", err)
 		}
+		if err := component.Close(context.Background()); err != nil {
+			common.Logger.Sugar().Errorf("Failed to close component: %v", err)
+		}
 	}
 }
@@ -2,30 +2,189 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"trade/src/common"
+	"trade/src/config"
+	"trade/src/exchange/binance"
+	"trade/src/exchange/okx"
+	"trade/src/market"
+	"trade/src/risk"
 	"trade/src/strategy"
 )
 
 func main() {
+	configPath := flag.String("config", "", "path of the YAML or JSON config file, the default config is used when empty")
+	printDefaultConfig := flag.Bool("print-default-config", false, "print the default config as YAML and exit")
+	flag.Parse()
+
+	if *printDefaultConfig {
+		data, err := config.Default().Marshal()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	cfg.Apply()
+
 	common.InitLogger(false)
 	common.Logger.Sugar().Info("Starting trade application...")
 
-	components := []common.Component{
-		strategy.NewPriceGap(),
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// every component runs under the supervisor, a critical component that is given up
+	// shuts the process down
+	supervisor := common.NewSupervisor(common.WithRestartPolicy(cfg.RestartPolicy()))
+	replay := cfg.NewReplay()
+	components := newComponents(cfg, supervisor, cfg.NewRecorder(), replay)
+	dones := []<-chan struct{}{}
 	for _, component := range components {
-		if err := component.Run(context.Background()); err != nil {
-			common.Logger.Sugar().Fatalf("Failed to run component: %v", err)
+		dones = append(dones, supervisor.Go(ctx, common.ComponentTask(component.name, component, component.critical)))
+	}
+	// the components run on the replayed market data until the last frame
+	var replayed <-chan struct{}
+	if replay != nil {
+		replayed = supervisor.Go(ctx, common.Task{
+			Name:   "replay",
+			Run:    replay.Run,
+			Policy: &common.RestartPolicy{Restart: common.RestartNever},
+		})
+	}
+	code := 0
+	select {
+	case <-ctx.Done():
+		common.Logger.Sugar().Info("Shutting down trade application...")
+	case err := <-supervisor.Escalated():
+		common.Logger.Sugar().Errorf("Shutting down trade application: %v", err)
+		code = 1
+	case <-replayed:
+		common.Logger.Sugar().Info("Replay finished, shutting down trade application...")
+		for _, status := range supervisor.Status() {
+			if status.Name == "replay" && status.LastError != nil {
+				common.Logger.Sugar().Errorf("Replay error: %v", status.LastError)
+				code = 1
+			}
 		}
 	}
+	// a second signal kills the process instead of waiting for the shutdown
+	stop()
+	if !shutdown(supervisor, dones, components, time.Duration(cfg.ShutdownTimeout)) {
+		code = 1
+	}
+	if err := common.CloseLogs(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	select {
-	case <-sigChan:
-		return
+// shutdown waits for the supervised components and closes them in reverse order within
+// timeout, it returns false if any of them failed or timed out
+func shutdown(supervisor *common.Supervisor, dones []<-chan struct{}, components []*namedComponent, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ok := true
+	if err := common.Wait(ctx, dones...); err != nil {
+		common.Logger.Sugar().Errorf("Failed to stop components: %v", err)
+		ok = false
+	}
+	for i := len(components) - 1; i >= 0; i-- {
+		if err := components[i].Close(ctx); err != nil {
+			common.Logger.Sugar().Errorf("Failed to close component %s: %v", components[i].name, err)
+			ok = false
+		}
+	}
+	for _, status := range supervisor.Status() {
+		common.Logger.Sugar().Infof("Supervisor task %s %s restarts %d last error %v", status.Name, status.State, status.Restarts, status.LastError)
+	}
+	return ok
+}
+
+type namedComponent struct {
+	common.Component
+	name     string
+	critical bool
+}
+
+// newComponents builds the components listed in the validated config, each component
+// gets its own exchange clients. The clients write their frames to recorder when it is
+// set, and read them from replay instead of the exchange when it is set. The orders of
+// every trading component go through one risk manager, which starts first and is
+// critical since nothing may trade without it
+func newComponents(cfg *config.Config, supervisor *common.Supervisor, recorder *common.Recorder, replay *common.Replay) []*namedComponent {
+	newSource := func(exchange string) market.MarketDataSource {
+		if exchange == market.ExchangeOKX {
+			options := cfg.OKXOptions()
+			if recorder != nil {
+				options = append(options, okx.WithRecorder(recorder))
+			}
+			if replay != nil {
+				return okx.NewReplayClient(replay, options...)
+			}
+			return okx.NewClient(options...)
+		}
+		options := cfg.BinanceOptions()
+		if recorder != nil {
+			options = append(options, binance.WithRecorder(recorder))
+		}
+		if replay != nil {
+			return binance.NewReplayClient(replay, options...)
+		}
+		return binance.NewClient(options...)
+	}
+	components := []*namedComponent{}
+	var riskOptions []risk.Option
+	if cfg.Risk.KillSignal {
+		riskOptions = append(riskOptions, risk.WithKillSignal(syscall.SIGUSR1))
+	}
+	if replay != nil {
+		riskOptions = append(riskOptions, risk.WithClock(replay.Now))
+	}
+	manager := cfg.NewRiskManager(riskOptions...)
+	if manager != nil {
+		components = append(components, &namedComponent{Component: manager, name: "risk", critical: true})
+	}
+	for _, name := range cfg.Components {
+		switch name {
+		case config.ComponentPriceGap:
+			var pairs []*strategy.Pair
+			for _, pair := range cfg.PriceGap.Pairs {
+				pairs = append(pairs, &strategy.Pair{
+					A: &strategy.ExchangePrice{Symbol: pair.A},
+					B: &strategy.ExchangePrice{Symbol: pair.B},
+				})
+			}
+			options := append(cfg.PriceGapOptions(), strategy.WithSupervisor(supervisor))
+			if replay != nil {
+				options = append(options, strategy.WithClock(replay.Now))
+			}
+			if manager != nil {
+				options = append(options, strategy.WithRisk(manager))
+			}
+			priceGap := strategy.NewPriceGapWithSources(
+				newSource(cfg.PriceGap.ExchangeA),
+				newSource(cfg.PriceGap.ExchangeB),
+				pairs,
+				options...,
+			)
+			components = append(components, &namedComponent{Component: priceGap, name: name, critical: cfg.IsCritical(name)})
+		}
 	}
+	return components
 }
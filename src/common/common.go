@@ -1,7 +1,9 @@
 package common
 
 import (
+	"context"
 	"runtime/debug"
+	"time"
 )
 
 func HandlePanic() {
@@ -9,3 +11,15 @@ func HandlePanic() {
 		Logger.Sugar().Errorf("catch panic: %v \n stack: %s", r, string(debug.Stack()))
 	}
 }
+
+// Sleep waits for d and returns false if ctx is cancelled first
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
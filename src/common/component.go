@@ -2,6 +2,11 @@ package common
 
 import "context"
 
+// Component is started once with Run, which must not block, and stopped with Close
+// after the Run context is cancelled. Close waits for the component goroutines,
+// releases its connections and flushes its logs, it returns ctx.Err() when ctx
+// expires first
 type Component interface {
 	Run(context.Context) error
+	Close(context.Context) error
 }
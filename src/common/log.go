@@ -1,7 +1,11 @@
 package common
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -9,13 +13,50 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-const (
+// LogDir, ChartDir and LogLevel are the defaults of the trade config, they must be set
+// before InitLogger and NewChart are called
+var (
 	LogDir   = "./log/"
 	ChartDir = "./chart/"
+	LogLevel = zapcore.InfoLevel
 )
 
 var Logger *zap.Logger
 
+// writers keeps every rotating file opened by NewLogger and NewChart so CloseLogs can close them
+var (
+	writersMu sync.Mutex
+	writers   []*lumberjack.Logger
+)
+
+func newWriter(filename string, maxAge int) *lumberjack.Logger {
+	w := &lumberjack.Logger{
+		Filename: filename,
+		MaxSize:  100, // MB
+		MaxAge:   maxAge,
+		Compress: true,
+	}
+	writersMu.Lock()
+	writers = append(writers, w)
+	writersMu.Unlock()
+	return w
+}
+
+// CloseLogs flushes Logger and closes every log and chart file, a later write reopens its file
+func CloseLogs() error {
+	err := Logger.Sync()
+	// stdout can't be synced in a test environment
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) {
+		err = nil
+	}
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	for _, w := range writers {
+		err = errors.Join(err, w.Close())
+	}
+	return err
+}
+
 type LogData struct {
 	Level     string  `json:"level"`
 	Timestamp float64 `json:"ts"`
@@ -37,16 +78,11 @@ func NewLogger(testEnv bool) *zap.Logger {
 		consoleEncoder := zapcore.NewConsoleEncoder(encoderCfg)
 		core = zapcore.NewCore(consoleEncoder, zapcore.AddSync(zapcore.Lock(os.Stdout)), zapcore.DebugLevel)
 	} else {
-		w := zapcore.AddSync(&lumberjack.Logger{
-			Filename: LogDir + "app.log",
-			MaxSize:  100, // MB
-			MaxAge:   7,   // days
-			Compress: true,
-		})
+		w := zapcore.AddSync(newWriter(filepath.Join(LogDir, "app.log"), 7))
 		core = zapcore.NewCore(
 			zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
 			w,
-			zapcore.InfoLevel,
+			LogLevel,
 		)
 	}
 	return zap.New(core)
@@ -54,12 +90,7 @@ func NewLogger(testEnv bool) *zap.Logger {
 
 func NewChart(name string, age time.Duration) *zap.Logger {
 	var core zapcore.Core
-	w := zapcore.AddSync(&lumberjack.Logger{
-		Filename: ChartDir + name + "/app.log",
-		MaxSize:  100,                   // MB
-		MaxAge:   int(age.Hours() / 24), // days
-		Compress: true,
-	})
+	w := zapcore.AddSync(newWriter(filepath.Join(ChartDir, name, "app.log"), int(age.Hours()/24)))
 	core = zapcore.NewCore(
 		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
 		w,
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// DefaultRecordRotation is how often the frames file is rotated and gzipped
+	DefaultRecordRotation = time.Hour
+	// DefaultRecordMaxAge is how long the rotated frames files are retained
+	DefaultRecordMaxAge = 7 * 24 * time.Hour
+	// RecordFile is the name of the frames file being written in the record dir, the
+	// rotated files are named frames-<rotation time>.log.gz
+	RecordFile = "frames.log"
+)
+
+// Frame is one raw websocket message of a market data connection, Time is its local
+// receive time in milliseconds and Source the connection it was read from
+type Frame struct {
+	Time   int64           `json:"t"`
+	Source string          `json:"s"`
+	Data   json.RawMessage `json:"d"`
+}
+
+type RecorderOption func(*Recorder)
+
+// WithRotation overrides DefaultRecordRotation, the frames file is also rotated when it
+// reaches 100 MB and only then with 0
+func WithRotation(rotation time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.rotation = rotation.Milliseconds()
+	}
+}
+
+// WithMaxAge overrides DefaultRecordMaxAge, it is rounded down to whole days
+func WithMaxAge(age time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.maxAge = age
+	}
+}
+
+// Recorder appends every Frame as one JSON line to the frames file of its dir. It is
+// safe for concurrent use so the connections of several clients may share it, the file
+// then keeps their frames in the order they were received
+type Recorder struct {
+	mu       sync.Mutex
+	w        *lumberjack.Logger
+	rotation int64
+	maxAge   time.Duration
+	// period is the start of the rotation period of the last frame written
+	period int64
+	buf    bytes.Buffer
+}
+
+func NewRecorder(dir string, opts ...RecorderOption) *Recorder {
+	r := &Recorder{
+		rotation: DefaultRecordRotation.Milliseconds(),
+		maxAge:   DefaultRecordMaxAge,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.w = newWriter(filepath.Join(dir, RecordFile), int(r.maxAge.Hours()/24))
+	return r
+}
+
+// Record writes data read from source at localTime, a frame that isn't valid JSON is
+// dropped since it can't be dispatched either
+func (r *Recorder) Record(source string, localTime int64, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rotation > 0 {
+		r.rotate(localTime)
+	}
+	r.buf.Reset()
+	r.buf.WriteString(`{"t":`)
+	r.buf.WriteString(strconv.FormatInt(localTime, 10))
+	r.buf.WriteString(`,"s":`)
+	r.buf.WriteString(strconv.Quote(source))
+	r.buf.WriteString(`,"d":`)
+	if err := json.Compact(&r.buf, data); err != nil {
+		Logger.Sugar().Warnf("Recorder %s Compact error: %v %s", source, err, string(data))
+		return
+	}
+	r.buf.WriteString("}\n")
+	if _, err := r.w.Write(r.buf.Bytes()); err != nil {
+		Logger.Sugar().Warnf("Recorder %s Write error: %v", source, err)
+	}
+}
+
+// rotate starts a new frames file when localTime enters the next rotation period, the
+// first frame appends to the file left by a previous run
+func (r *Recorder) rotate(localTime int64) {
+	period := localTime - localTime%r.rotation
+	if period == r.period {
+		return
+	}
+	if r.period != 0 {
+		if err := r.w.Rotate(); err != nil {
+			Logger.Sugar().Warnf("Recorder Rotate error: %v", err)
+		}
+	}
+	r.period = period
+}
+
+// Close closes the frames file, a later Record reopens it
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Close()
+}
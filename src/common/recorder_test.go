@@ -0,0 +1,99 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Run("RotateReplay", func(t *testing.T) {
+		testRecorderRotateReplay(t)
+	})
+	t.Run("ReplaySpeed", func(t *testing.T) {
+		testReplaySpeed(t)
+	})
+}
+
+func testRecorderRotateReplay(t *testing.T) {
+	dir := t.TempDir()
+	hour := time.Hour.Milliseconds()
+	start := 1_700_000_000_000 - 1_700_000_000_000%hour
+	recorder := NewRecorder(dir)
+	recorder.Record("a", start+1, []byte("{\"x\": 1,\n \"y\": [1, 2]}"))
+	// not JSON, dropped
+	recorder.Record("a", start+2, []byte("{\"x\""))
+	// the next hour rotates the file
+	recorder.Record("a", start+hour, []byte(`{"x":2}`))
+	recorder.Record("unknown", start+hour+1, []byte(`{"x":3}`))
+	require.NoError(t, recorder.Close())
+
+	// the rotated file is gzipped in the background
+	var files []string
+	require.Eventually(t, func() bool {
+		var err error
+		files, err = ReplayFiles(dir)
+		require.NoError(t, err)
+		return len(files) == 2 && strings.HasSuffix(files[0], ".log.gz")
+	}, 3*time.Second, 10*time.Millisecond, "%v", files)
+	assert.Equal(t, filepath.Join(dir, RecordFile), files[1])
+	// the last line of a crashed recorder
+	f, err := os.OpenFile(files[1], os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"t":1700003600002,"s":"a","d":{"x"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	replay := NewReplay(dir, WithSpeed(0))
+	frames := []*Frame{}
+	nows := []int64{}
+	replay.Handle("a", func(frame *Frame) {
+		frames = append(frames, frame)
+		nows = append(nows, replay.Now())
+	})
+	require.NoError(t, replay.Run(context.Background()))
+	require.Len(t, frames, 2)
+	assert.Equal(t, `{"x":1,"y":[1,2]}`, string(frames[0].Data))
+	assert.Equal(t, "a", frames[0].Source)
+	assert.Equal(t, `{"x":2}`, string(frames[1].Data))
+	assert.Equal(t, []int64{start + 1, start + hour}, nows)
+	// the frames without handler still move the clock
+	assert.Equal(t, start+hour+1, replay.Now())
+
+	assert.Error(t, NewReplay(t.TempDir()).Run(context.Background()))
+}
+
+func testReplaySpeed(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorder(dir)
+	for i := int64(0); i < 3; i++ {
+		recorder.Record("a", 1_700_000_000_000+i*100, []byte(`{}`))
+	}
+	require.NoError(t, recorder.Close())
+
+	// 200ms recorded are replayed in 100ms at twice the speed
+	replay := NewReplay(dir, WithSpeed(2))
+	count := 0
+	replay.Handle("a", func(*Frame) {
+		count++
+	})
+	begin := time.Now()
+	require.NoError(t, replay.Run(context.Background()))
+	elapsed := time.Since(begin)
+	assert.Equal(t, 3, count)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+
+	// a cancelled replay stops during the start delay
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	count = 0
+	require.NoError(t, NewReplay(dir, WithStartDelay(time.Minute)).Run(ctx))
+	assert.Zero(t, count)
+}
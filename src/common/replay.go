@@ -0,0 +1,205 @@
+package common
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultReplaySpeed replays the frames in real time
+	DefaultReplaySpeed = 1.0
+	// maxFrameSize bounds a line of the frames files, a full order book snapshot of OKX
+	// is a few hundred KB
+	maxFrameSize = 64 << 20
+)
+
+type ReplayOption func(*Replay)
+
+// WithSpeed overrides DefaultReplaySpeed, 10 replays ten times faster than recorded and
+// 0 as fast as the handlers allow
+func WithSpeed(speed float64) ReplayOption {
+	return func(r *Replay) {
+		r.speed = speed
+	}
+}
+
+// WithStartDelay makes Run wait before the first frame so the components have time to
+// subscribe, the frames of the streams subscribed later are skipped
+func WithStartDelay(delay time.Duration) ReplayOption {
+	return func(r *Replay) {
+		r.startDelay = delay
+	}
+}
+
+// Replay feeds the frames written by a Recorder back to the handler of their source, in
+// the recorded order and from a single goroutine so a run is deterministic. Now is the
+// receive time of the frame being dispatched, the replayed clients and strategies use it
+// as their clock
+type Replay struct {
+	dir        string
+	speed      float64
+	startDelay time.Duration
+	mu         sync.RWMutex
+	handlers   map[string]func(*Frame)
+	now        atomic.Int64
+}
+
+func NewReplay(dir string, opts ...ReplayOption) *Replay {
+	r := &Replay{
+		dir:      dir,
+		speed:    DefaultReplaySpeed,
+		handlers: make(map[string]func(*Frame)),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Handle registers the handler of the frames of source, replacing the previous one
+func (r *Replay) Handle(source string, handler func(*Frame)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[source] = handler
+}
+
+// Now is the receive time in milliseconds of the last frame dispatched, 0 before the first
+func (r *Replay) Now() int64 {
+	return r.now.Load()
+}
+
+// ReplayFiles lists the frames files of dir oldest first, the rotated files sort before
+// RecordFile
+func ReplayFiles(dir string) ([]string, error) {
+	names, err := ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(RecordFile, ".log")
+	files := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) || (!strings.HasSuffix(name, ".log") && !strings.HasSuffix(name, ".log.gz")) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// Run dispatches every frame of the dir and returns once they are all replayed or ctx
+// is cancelled. The lines that can't be decoded, e.g. the last one of a crashed
+// recorder, are skipped
+func (r *Replay) Run(ctx context.Context) error {
+	files, err := ReplayFiles(r.dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("Replay %s error: no frames file", r.dir)
+	}
+	if !Sleep(ctx, r.startDelay) {
+		return nil
+	}
+	p := &replayProgress{}
+	for _, file := range files {
+		err := r.replayFile(ctx, file, p)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	Logger.Sugar().Infof("Replay %s done: %d frames, %d without handler, %d skipped", r.dir, p.frames, p.unhandled, p.skipped)
+	return nil
+}
+
+// replayProgress paces the frames of every file from the first one
+type replayProgress struct {
+	first     int64
+	start     time.Time
+	frames    int
+	unhandled int
+	skipped   int
+}
+
+func (r *Replay) replayFile(ctx context.Context, file string, p *replayProgress) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("Replay %s error: %w", file, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFrameSize)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		frame := &Frame{}
+		err := json.Unmarshal(scanner.Bytes(), frame)
+		if err != nil {
+			Logger.Sugar().Warnf("Replay %s Unmarshal error: %v", file, err)
+			p.skipped++
+			continue
+		}
+		if !r.wait(ctx, frame, p) {
+			return nil
+		}
+		r.now.Store(frame.Time)
+		r.mu.RLock()
+		handler, ok := r.handlers[frame.Source]
+		r.mu.RUnlock()
+		p.frames++
+		if !ok {
+			p.unhandled++
+			continue
+		}
+		handler(frame)
+	}
+	err = scanner.Err()
+	// a file cut by a crash ends with a truncated gzip stream, its frames are replayed
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		Logger.Sugar().Warnf("Replay %s truncated", file)
+		return nil
+	}
+	return err
+}
+
+// wait sleeps until frame is due at the replay speed, it returns false if ctx is
+// cancelled first
+func (r *Replay) wait(ctx context.Context, frame *Frame, p *replayProgress) bool {
+	if p.start.IsZero() {
+		p.first = frame.Time
+		p.start = time.Now()
+		return true
+	}
+	if r.speed <= 0 {
+		return true
+	}
+	due := time.Duration(float64(frame.Time-p.first) / r.speed * float64(time.Millisecond))
+	wait := due - time.Since(p.start)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	return Sleep(ctx, wait)
+}
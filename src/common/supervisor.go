@@ -0,0 +1,242 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Restart string
+
+const (
+	RestartAlways    Restart = "always"
+	RestartOnFailure Restart = "on_failure"
+	RestartNever     Restart = "never"
+)
+
+type TaskState string
+
+const (
+	TaskStateRunning TaskState = "running"
+	TaskStateBackoff TaskState = "backoff"
+	// TaskStateStopped is a task that returned and is not restarted, or whose context was cancelled
+	TaskStateStopped TaskState = "stopped"
+	// TaskStateFailed is a task that exceeded MaxRestarts within Window
+	TaskStateFailed TaskState = "failed"
+)
+
+// RestartPolicy decides when a task is restarted. The backoff doubles from MinBackoff
+// up to MaxBackoff with every restart inside Window, and the task is given up once it
+// would be restarted more than MaxRestarts times inside Window, 0 means no limit
+type RestartPolicy struct {
+	Restart     Restart
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxRestarts int
+	Window      time.Duration
+}
+
+var DefaultRestartPolicy = RestartPolicy{
+	Restart:     RestartOnFailure,
+	MinBackoff:  time.Second,
+	MaxBackoff:  time.Minute,
+	MaxRestarts: 5,
+	Window:      10 * time.Minute,
+}
+
+// Task is a blocking function run by a Supervisor, a panic counts as a failure
+type Task struct {
+	Name string
+	Run  func(context.Context) error
+	// Policy overrides the supervisor policy when set
+	Policy *RestartPolicy
+	// Critical tasks are escalated to Supervisor.Escalated once they are given up
+	Critical bool
+}
+
+type TaskStatus struct {
+	Name      string
+	State     TaskState
+	Restarts  int
+	LastError error
+	Since     time.Time
+}
+
+type SupervisorOption func(*Supervisor)
+
+// WithRestartPolicy overrides DefaultRestartPolicy for every task without its own policy
+func WithRestartPolicy(policy RestartPolicy) SupervisorOption {
+	return func(s *Supervisor) {
+		s.policy = policy
+	}
+}
+
+// Supervisor runs tasks in their own goroutines and restarts them according to their
+// RestartPolicy, it is safe for concurrent use
+type Supervisor struct {
+	mu        sync.Mutex
+	policy    RestartPolicy
+	tasks     map[string]*TaskStatus
+	escalated chan error
+}
+
+func NewSupervisor(opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		policy:    DefaultRestartPolicy,
+		tasks:     make(map[string]*TaskStatus),
+		escalated: make(chan error, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Escalated receives the error of the first critical task given up, the process is
+// expected to shut down
+func (s *Supervisor) Escalated() <-chan error {
+	return s.escalated
+}
+
+// Go starts the task until ctx is cancelled or the policy gives up, the returned
+// channel is closed when the task goroutine has exited
+func (s *Supervisor) Go(ctx context.Context, task Task) <-chan struct{} {
+	policy := s.policy
+	if task.Policy != nil {
+		policy = *task.Policy
+	}
+	done := make(chan struct{})
+	go func() {
+		defer HandlePanic()
+		defer close(done)
+		s.supervise(ctx, task, policy)
+	}()
+	return done
+}
+
+func (s *Supervisor) supervise(ctx context.Context, task Task, policy RestartPolicy) {
+	var restarts []time.Time
+	for {
+		s.setState(task.Name, TaskStateRunning, nil, len(restarts))
+		err := s.run(ctx, task)
+		if ctx.Err() != nil {
+			s.setState(task.Name, TaskStateStopped, err, len(restarts))
+			return
+		}
+		if policy.Restart == RestartNever || (err == nil && policy.Restart == RestartOnFailure) {
+			s.setState(task.Name, TaskStateStopped, err, len(restarts))
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("returned without error")
+		}
+		Logger.Sugar().Warnf("Supervisor task %s error: %v", task.Name, err)
+
+		now := time.Now()
+		inWindow := restarts[:0]
+		for _, restart := range restarts {
+			if policy.Window <= 0 || now.Sub(restart) < policy.Window {
+				inWindow = append(inWindow, restart)
+			}
+		}
+		restarts = inWindow
+		if policy.MaxRestarts > 0 && len(restarts) >= policy.MaxRestarts {
+			failure := fmt.Errorf("Supervisor task %s restarted %d times within %s: %w", task.Name, len(restarts), policy.Window, err)
+			s.setState(task.Name, TaskStateFailed, err, len(restarts))
+			Logger.Sugar().Errorf("%v", failure)
+			if task.Critical {
+				select {
+				case s.escalated <- failure:
+				default:
+				}
+			}
+			return
+		}
+
+		backoff := policy.MinBackoff << len(restarts)
+		if backoff > policy.MaxBackoff || backoff <= 0 {
+			backoff = policy.MaxBackoff
+		}
+		restarts = append(restarts, now)
+		s.setState(task.Name, TaskStateBackoff, err, len(restarts))
+		Logger.Sugar().Infof("Supervisor task %s restarting in %s", task.Name, backoff)
+		if !Sleep(ctx, backoff) {
+			s.setState(task.Name, TaskStateStopped, err, len(restarts))
+			return
+		}
+	}
+}
+
+// run turns a panic of the task into an error
+func (s *Supervisor) run(ctx context.Context, task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Sugar().Errorf("Supervisor task %s panic: %v \n stack: %s", task.Name, r, string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return task.Run(ctx)
+}
+
+func (s *Supervisor) setState(name string, state TaskState, err error, restarts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.tasks[name]
+	if !ok {
+		status = &TaskStatus{Name: name}
+		s.tasks[name] = status
+	}
+	if status.State != state {
+		status.Since = time.Now()
+	}
+	status.State = state
+	status.Restarts = restarts
+	if err != nil {
+		status.LastError = err
+	}
+}
+
+// Status returns the state of every task sorted by name
+func (s *Supervisor) Status() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, status := range s.tasks {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// ComponentTask runs a component under a supervisor, the task fails when Run fails and
+// otherwise blocks until ctx is cancelled
+func ComponentTask(name string, component Component, critical bool) Task {
+	return Task{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			if err := component.Run(ctx); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return nil
+		},
+		Critical: critical,
+	}
+}
+
+// Wait waits for every done channel returned by Go, it returns ctx.Err() when ctx expires first
+func Wait(ctx context.Context, dones ...<-chan struct{}) error {
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRestartPolicy = RestartPolicy{
+	Restart:     RestartOnFailure,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  4 * time.Millisecond,
+	MaxRestarts: 3,
+	Window:      time.Minute,
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("task did not stop")
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Run("RestartOnFailure", func(t *testing.T) {
+		testSupervisorRestartOnFailure(t)
+	})
+	t.Run("Escalate", func(t *testing.T) {
+		testSupervisorEscalate(t)
+	})
+	t.Run("RestartAlways", func(t *testing.T) {
+		testSupervisorRestartAlways(t)
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		testSupervisorCancel(t)
+	})
+}
+
+func testSupervisorRestartOnFailure(t *testing.T) {
+	s := NewSupervisor(WithRestartPolicy(testRestartPolicy))
+	var runs atomic.Int64
+	done := s.Go(context.Background(), Task{
+		Name: "flaky",
+		Run: func(context.Context) error {
+			switch runs.Add(1) {
+			case 1:
+				return errors.New("failed")
+			case 2:
+				panic("boom")
+			}
+			return nil
+		},
+	})
+	waitDone(t, done)
+	assert.Equal(t, int64(3), runs.Load())
+	status := s.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, TaskStateStopped, status[0].State)
+	assert.Equal(t, 2, status[0].Restarts)
+	assert.ErrorContains(t, status[0].LastError, "panic: boom")
+}
+
+func testSupervisorEscalate(t *testing.T) {
+	s := NewSupervisor(WithRestartPolicy(testRestartPolicy))
+	var runs atomic.Int64
+	failing := func(context.Context) error {
+		runs.Add(1)
+		return errors.New("unavailable")
+	}
+	waitDone(t, s.Go(context.Background(), Task{Name: "optional", Run: failing}))
+	assert.Equal(t, int64(testRestartPolicy.MaxRestarts+1), runs.Load())
+	select {
+	case err := <-s.Escalated():
+		t.Fatalf("non critical task escalated: %v", err)
+	default:
+	}
+
+	waitDone(t, s.Go(context.Background(), Task{Name: "critical", Run: failing, Critical: true}))
+	select {
+	case err := <-s.Escalated():
+		assert.ErrorContains(t, err, "critical restarted 3 times")
+		assert.ErrorContains(t, err, "unavailable")
+	default:
+		t.Fatal("critical task was not escalated")
+	}
+	for _, status := range s.Status() {
+		assert.Equal(t, TaskStateFailed, status.State)
+	}
+}
+
+func testSupervisorRestartAlways(t *testing.T) {
+	s := NewSupervisor()
+	var runs atomic.Int64
+	policy := testRestartPolicy
+	policy.Restart = RestartAlways
+	waitDone(t, s.Go(context.Background(), Task{
+		Name:   "always",
+		Policy: &policy,
+		Run: func(context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	}))
+	assert.Equal(t, int64(policy.MaxRestarts+1), runs.Load())
+}
+
+func testSupervisorCancel(t *testing.T) {
+	s := NewSupervisor(WithRestartPolicy(RestartPolicy{Restart: RestartAlways, MinBackoff: time.Hour, MaxBackoff: time.Hour}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs atomic.Int64
+	done := s.Go(ctx, Task{
+		Name: "backoff",
+		Run: func(context.Context) error {
+			runs.Add(1)
+			return errors.New("failed")
+		},
+	})
+	require.Eventually(t, func() bool {
+		status := s.Status()
+		return len(status) == 1 && status[0].State == TaskStateBackoff
+	}, time.Second, time.Millisecond)
+	cancel()
+	waitDone(t, done)
+	assert.Equal(t, int64(1), runs.Load())
+	assert.Equal(t, TaskStateStopped, s.Status()[0].State)
+	require.NoError(t, Wait(context.Background(), done))
+}
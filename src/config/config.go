@@ -0,0 +1,901 @@
+// Package config loads and validates the YAML or JSON config of the trade binary
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"trade/src/common"
+	"trade/src/exchange/binance"
+	"trade/src/exchange/okx"
+	"trade/src/market"
+	"trade/src/paper"
+	"trade/src/risk"
+	"trade/src/strategy"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ComponentPriceGap = "price_gap"
+)
+
+const (
+	// TradingModeOff only logs the gaps
+	TradingModeOff = "off"
+	// TradingModePaper trades the gaps on simulated venues fed by the live market data
+	TradingModePaper = "paper"
+	// TradingModeLive trades the gaps on the exchanges with the configured credentials
+	TradingModeLive = "live"
+)
+
+// Components lists every component name accepted in Config.Components
+var Components = []string{ComponentPriceGap}
+
+type Config struct {
+	// ShutdownTimeout bounds how long the components may take to close on SIGINT/SIGTERM
+	ShutdownTimeout Duration         `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	Log             LogConfig        `yaml:"log" json:"log"`
+	Chart           ChartConfig      `yaml:"chart" json:"chart"`
+	Record          RecordConfig     `yaml:"record" json:"record"`
+	Replay          ReplayConfig     `yaml:"replay" json:"replay"`
+	Exchanges       ExchangesConfig  `yaml:"exchanges" json:"exchanges"`
+	Components      []string         `yaml:"components" json:"components"`
+	Supervisor      SupervisorConfig `yaml:"supervisor" json:"supervisor"`
+	PriceGap        PriceGapConfig   `yaml:"price_gap" json:"price_gap"`
+	Risk            RiskConfig       `yaml:"risk" json:"risk"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn, error
+	Level string `yaml:"level" json:"level"`
+	Dir   string `yaml:"dir" json:"dir"`
+}
+
+type ChartConfig struct {
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+// RecordConfig writes every raw frame of the market data connections with its local
+// receive time to the append-only frames file of Dir, rotated every Rotation and gzipped
+type RecordConfig struct {
+	Enabled  bool     `yaml:"enabled" json:"enabled"`
+	Dir      string   `yaml:"dir" json:"dir"`
+	Rotation Duration `yaml:"rotation" json:"rotation"`
+	MaxAge   Duration `yaml:"max_age" json:"max_age"`
+}
+
+// ReplayConfig feeds the frames recorded in Dir to the components instead of the market
+// data connections, the live streams are used when empty. Speed 1 replays in real time,
+// 10 ten times faster and 0 as fast as possible. StartDelay gives the components time to
+// subscribe before the first frame
+type ReplayConfig struct {
+	Dir        string   `yaml:"dir" json:"dir"`
+	Speed      float64  `yaml:"speed" json:"speed"`
+	StartDelay Duration `yaml:"start_delay" json:"start_delay"`
+}
+
+// RiskConfig checks every order of a trading mode other than off before it reaches the
+// venues, the limits are in the quote currency and 0 disables a limit. MaxDailyLoss
+// counts the fees and unrealized PnL since the start of the UTC day, MaxOrderRate is
+// per second and PriceBand is the largest distance to the mark price as a fraction.
+// The kill switch cancels every order and flattens both venues on SIGUSR1 when
+// KillSignal is set, once KillFile exists, or on POST /kill of the HTTPAddr server,
+// which also serves GET /status
+type RiskConfig struct {
+	MaxSymbolNotional float64 `yaml:"max_symbol_notional" json:"max_symbol_notional"`
+	MaxTotalNotional  float64 `yaml:"max_total_notional" json:"max_total_notional"`
+	MaxOpenOrders     int     `yaml:"max_open_orders" json:"max_open_orders"`
+	MaxDailyLoss      float64 `yaml:"max_daily_loss" json:"max_daily_loss"`
+	MaxOrderRate      int     `yaml:"max_order_rate" json:"max_order_rate"`
+	PriceBand         float64 `yaml:"price_band" json:"price_band"`
+	KillSignal        bool    `yaml:"kill_signal" json:"kill_signal"`
+	KillFile          string  `yaml:"kill_file" json:"kill_file"`
+	HTTPAddr          string  `yaml:"http_addr" json:"http_addr"`
+}
+
+type ExchangesConfig struct {
+	Binance BinanceConfig `yaml:"binance" json:"binance"`
+	OKX     OKXConfig     `yaml:"okx" json:"okx"`
+}
+
+// BinanceConfig signs with the Ed25519 key of PrivateKeyFile, in PEM, or else with the
+// HMAC SecretKey. The credentials are only needed to trade live
+type BinanceConfig struct {
+	FuturesAPIWebSocketURL    string `yaml:"futures_api_websocket_url" json:"futures_api_websocket_url"`
+	FuturesStreamWebSocketURL string `yaml:"futures_stream_websocket_url" json:"futures_stream_websocket_url"`
+	FuturesRESTURL            string `yaml:"futures_rest_url" json:"futures_rest_url"`
+	APIKey                    string `yaml:"api_key" json:"api_key"`
+	SecretKey                 string `yaml:"secret_key" json:"secret_key"`
+	PrivateKeyFile            string `yaml:"private_key_file" json:"private_key_file"`
+}
+
+type OKXConfig struct {
+	PublicWebSocketURL   string `yaml:"public_websocket_url" json:"public_websocket_url"`
+	PrivateWebSocketURL  string `yaml:"private_websocket_url" json:"private_websocket_url"`
+	BusinessWebSocketURL string `yaml:"business_websocket_url" json:"business_websocket_url"`
+	RESTURL              string `yaml:"rest_url" json:"rest_url"`
+	// BookTickerChannel is bbo-tbt or tickers
+	BookTickerChannel string `yaml:"book_ticker_channel" json:"book_ticker_channel"`
+	// the credentials are only needed to trade live
+	APIKey     string `yaml:"api_key" json:"api_key"`
+	SecretKey  string `yaml:"secret_key" json:"secret_key"`
+	Passphrase string `yaml:"passphrase" json:"passphrase"`
+}
+
+// SupervisorConfig is the restart policy of the components and their tasks such as
+// the PriceGap pairs
+type SupervisorConfig struct {
+	// Restart is one of always, on_failure, never
+	Restart     string   `yaml:"restart" json:"restart"`
+	MinBackoff  Duration `yaml:"min_backoff" json:"min_backoff"`
+	MaxBackoff  Duration `yaml:"max_backoff" json:"max_backoff"`
+	MaxRestarts int      `yaml:"max_restarts" json:"max_restarts"`
+	Window      Duration `yaml:"window" json:"window"`
+	// Critical components shut the process down once they are given up
+	Critical []string `yaml:"critical" json:"critical"`
+}
+
+type PriceGapConfig struct {
+	// ExchangeA is the venue the gap is measured from
+	ExchangeA string `yaml:"exchange_a" json:"exchange_a"`
+	ExchangeB string `yaml:"exchange_b" json:"exchange_b"`
+	// Mode is mark_price or executable, which adds the book ticker cross spreads
+	Mode string `yaml:"mode" json:"mode"`
+	// Pairs are in each venue's own symbol format, every instrument trading on both
+	// venues is compared when empty
+	Pairs         []PairConfig `yaml:"pairs" json:"pairs"`
+	CheckInterval Duration     `yaml:"check_interval" json:"check_interval"`
+	StartInterval Duration     `yaml:"start_interval" json:"start_interval"`
+	ChartAge      Duration     `yaml:"chart_age" json:"chart_age"`
+	// Trigger is periodic, which samples every CheckInterval, or tick, which samples on
+	// every tick of either leg at most once per Coalesce
+	Trigger  string   `yaml:"trigger" json:"trigger"`
+	Coalesce Duration `yaml:"coalesce" json:"coalesce"`
+	// TradeFlowWindow adds the traded volume of both venues over the window to the
+	// gap log, 0 disables it
+	TradeFlowWindow Duration `yaml:"trade_flow_window" json:"trade_flow_window"`
+	// FundingInterval is how often the funding differential of both venues is written to
+	// its own chart log, 0 disables it
+	FundingInterval Duration `yaml:"funding_interval" json:"funding_interval"`
+	// MaxStaleness is the oldest mark price or book ticker still compared, keyed by
+	// exchange, 0 disables the check of that venue
+	MaxStaleness map[string]Duration `yaml:"max_staleness" json:"max_staleness"`
+	// MaxSkew is the largest difference of the exchange times of both legs, 0 disables it
+	MaxSkew Duration `yaml:"max_skew" json:"max_skew"`
+	// StaleAction is flag, which logs the stale samples marked, or suppress
+	StaleAction string        `yaml:"stale_action" json:"stale_action"`
+	Trading     TradingConfig `yaml:"trading" json:"trading"`
+}
+
+// TradingConfig opens a hedge of a pair at Entry percent of gap net of the round trip
+// taker fees and closes it within Exit percent, each leg is Notional in the quote
+// currency. Trading requires the executable mode
+type TradingConfig struct {
+	// Mode is off, paper or live
+	Mode     string  `yaml:"mode" json:"mode"`
+	Entry    float64 `yaml:"entry" json:"entry"`
+	Exit     float64 `yaml:"exit" json:"exit"`
+	Notional float64 `yaml:"notional" json:"notional"`
+	// LegTimeout is how long the orders of an action may take to fill before they are
+	// canceled and the hedge flattened
+	LegTimeout Duration `yaml:"leg_timeout" json:"leg_timeout"`
+	// DryRun logs the live orders instead of sending them, no credentials are needed
+	DryRun bool `yaml:"dry_run" json:"dry_run"`
+	// TakerFees are the live fees of each exchange as fractions, keyed by exchange, the
+	// paper mode uses the fees of Paper
+	TakerFees map[string]float64 `yaml:"taker_fees" json:"taker_fees"`
+	// Paper is the simulated account of each exchange in the paper mode, keyed by exchange
+	Paper map[string]PaperConfig `yaml:"paper" json:"paper"`
+}
+
+type PaperConfig struct {
+	Balance  float64  `yaml:"balance" json:"balance"`
+	Leverage float64  `yaml:"leverage" json:"leverage"`
+	MakerFee float64  `yaml:"maker_fee" json:"maker_fee"`
+	TakerFee float64  `yaml:"taker_fee" json:"taker_fee"`
+	Latency  Duration `yaml:"latency" json:"latency"`
+}
+
+type PairConfig struct {
+	A string `yaml:"a" json:"a"`
+	B string `yaml:"b" json:"b"`
+}
+
+// Duration is a time.Duration written as a string such as 1s or 168h in both formats
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %s", string(data))
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// Default is the config used without a config file, it runs PriceGap on every
+// perpetual listed on both Binance and OKX
+func Default() *Config {
+	return &Config{
+		ShutdownTimeout: Duration(10 * time.Second),
+		Log: LogConfig{
+			Level: "info",
+			Dir:   common.LogDir,
+		},
+		Chart: ChartConfig{
+			Dir: common.ChartDir,
+		},
+		Record: RecordConfig{
+			Dir:      "./record/",
+			Rotation: Duration(common.DefaultRecordRotation),
+			MaxAge:   Duration(common.DefaultRecordMaxAge),
+		},
+		Replay: ReplayConfig{
+			Speed:      common.DefaultReplaySpeed,
+			StartDelay: Duration(10 * time.Second),
+		},
+		Exchanges: ExchangesConfig{
+			Binance: BinanceConfig{
+				FuturesAPIWebSocketURL:    binance.FuturesAPIWebSocketBaseURL,
+				FuturesStreamWebSocketURL: binance.FuturesStreamWebSocketBaseURL,
+				FuturesRESTURL:            binance.FuturesRESTBaseURL,
+			},
+			OKX: OKXConfig{
+				PublicWebSocketURL:   okx.PublicWebSocketBaseURL,
+				PrivateWebSocketURL:  okx.PrivateWebSocketBaseURL,
+				BusinessWebSocketURL: okx.BusinessWebSocketBaseURL,
+				RESTURL:              okx.RESTBaseURL,
+				BookTickerChannel:    okx.BookTickerChannelBBO,
+			},
+		},
+		Components: []string{ComponentPriceGap},
+		Supervisor: SupervisorConfig{
+			Restart:     string(common.DefaultRestartPolicy.Restart),
+			MinBackoff:  Duration(common.DefaultRestartPolicy.MinBackoff),
+			MaxBackoff:  Duration(common.DefaultRestartPolicy.MaxBackoff),
+			MaxRestarts: common.DefaultRestartPolicy.MaxRestarts,
+			Window:      Duration(common.DefaultRestartPolicy.Window),
+			Critical:    []string{ComponentPriceGap},
+		},
+		PriceGap: PriceGapConfig{
+			ExchangeA:     market.ExchangeBinance,
+			ExchangeB:     market.ExchangeOKX,
+			Mode:          strategy.PriceGapModeMarkPrice,
+			Trigger:       strategy.PriceGapTriggerPeriodic,
+			Pairs:         []PairConfig{},
+			CheckInterval: Duration(strategy.DefaultPriceGapCheckInterval),
+			StartInterval: Duration(strategy.DefaultPriceGapStartInterval),
+			ChartAge:      Duration(strategy.DefaultPriceGapChartAge),
+			MaxStaleness: map[string]Duration{
+				market.ExchangeBinance: Duration(strategy.DefaultPriceGapMaxStaleness),
+				market.ExchangeOKX:     Duration(strategy.DefaultPriceGapMaxStaleness),
+			},
+			StaleAction: strategy.PriceGapStaleFlag,
+			Trading: TradingConfig{
+				Mode:       TradingModeOff,
+				Entry:      0.5,
+				Exit:       0.1,
+				Notional:   100,
+				LegTimeout: Duration(strategy.DefaultPriceGapLegTimeout),
+				TakerFees: map[string]float64{
+					market.ExchangeBinance: 0.0005,
+					market.ExchangeOKX:     0.0005,
+				},
+				Paper: map[string]PaperConfig{
+					market.ExchangeBinance: defaultPaper(),
+					market.ExchangeOKX:     defaultPaper(),
+				},
+			},
+		},
+		Risk: RiskConfig{
+			MaxSymbolNotional: 1000,
+			MaxTotalNotional:  5000,
+			MaxOpenOrders:     10,
+			MaxDailyLoss:      100,
+			MaxOrderRate:      10,
+			PriceBand:         0.01,
+			KillSignal:        true,
+		},
+	}
+}
+
+func defaultPaper() PaperConfig {
+	return PaperConfig{
+		Balance:  paper.DefaultBalance.InexactFloat64(),
+		Leverage: paper.DefaultLeverage.InexactFloat64(),
+		MakerFee: paper.DefaultMakerFee.InexactFloat64(),
+		TakerFee: paper.DefaultTakerFee.InexactFloat64(),
+		Latency:  Duration(paper.DefaultLatency),
+	}
+}
+
+// Load reads a config file over Default, files ending in .json are decoded as JSON
+// and anything else as YAML. Unknown fields are rejected and the result is validated
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Default()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Marshal writes the config as YAML
+func (c *Config) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Validate returns every problem of the config joined into one error
+func (c *Config) Validate() error {
+	var errs []error
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout: must be positive, got %s", c.ShutdownTimeout))
+	}
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if c.Log.Dir == "" {
+		errs = append(errs, errors.New("log.dir: must not be empty"))
+	}
+	if c.Chart.Dir == "" {
+		errs = append(errs, errors.New("chart.dir: must not be empty"))
+	}
+	errs = append(errs, c.Record.validate(), c.Replay.validate(&c.Record, c.PriceGap.Trading.Mode))
+	errs = append(errs,
+		validateURL("exchanges.binance.futures_api_websocket_url", c.Exchanges.Binance.FuturesAPIWebSocketURL, "ws", "wss"),
+		validateURL("exchanges.binance.futures_stream_websocket_url", c.Exchanges.Binance.FuturesStreamWebSocketURL, "ws", "wss"),
+		validateURL("exchanges.binance.futures_rest_url", c.Exchanges.Binance.FuturesRESTURL, "http", "https"),
+		validateURL("exchanges.okx.public_websocket_url", c.Exchanges.OKX.PublicWebSocketURL, "ws", "wss"),
+		validateURL("exchanges.okx.private_websocket_url", c.Exchanges.OKX.PrivateWebSocketURL, "ws", "wss"),
+		validateURL("exchanges.okx.business_websocket_url", c.Exchanges.OKX.BusinessWebSocketURL, "ws", "wss"),
+		validateURL("exchanges.okx.rest_url", c.Exchanges.OKX.RESTURL, "http", "https"),
+	)
+	if channel := c.Exchanges.OKX.BookTickerChannel; channel != okx.BookTickerChannelBBO && channel != okx.BookTickerChannelTickers {
+		errs = append(errs, fmt.Errorf("exchanges.okx.book_ticker_channel: unknown channel %q, expected %s or %s", channel, okx.BookTickerChannelBBO, okx.BookTickerChannelTickers))
+	}
+	if len(c.Components) == 0 {
+		errs = append(errs, errors.New("components: at least one component is required"))
+	}
+	seen := make(map[string]bool)
+	for _, component := range c.Components {
+		switch component {
+		case ComponentPriceGap:
+			errs = append(errs, c.PriceGap.validate(), c.PriceGap.Trading.validate(c.PriceGap.Mode, []string{c.PriceGap.ExchangeA, c.PriceGap.ExchangeB}, &c.Exchanges))
+		default:
+			errs = append(errs, fmt.Errorf("components: unknown component %q, expected one of %s", component, strings.Join(Components, ", ")))
+		}
+		if seen[component] {
+			errs = append(errs, fmt.Errorf("components: %q is listed twice", component))
+		}
+		seen[component] = true
+	}
+	errs = append(errs, c.Supervisor.validate(seen), c.Risk.validate(&c.PriceGap.Trading))
+	return errors.Join(errs...)
+}
+
+func (r *RecordConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	var errs []error
+	if r.Dir == "" {
+		errs = append(errs, errors.New("record.dir: must not be empty"))
+	}
+	if r.Rotation <= 0 {
+		errs = append(errs, fmt.Errorf("record.rotation: must be positive, got %s", r.Rotation))
+	}
+	if err := validateMaxAge("record.max_age", r.MaxAge); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *ReplayConfig) validate(record *RecordConfig, tradingMode string) error {
+	if r.Dir == "" {
+		return nil
+	}
+	var errs []error
+	files, err := common.ReplayFiles(r.Dir)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("replay.dir: %w", err))
+	} else if len(files) == 0 {
+		errs = append(errs, fmt.Errorf("replay.dir: no %s file in %q", common.RecordFile, r.Dir))
+	}
+	if r.Speed < 0 {
+		errs = append(errs, fmt.Errorf("replay.speed: must not be negative, got %v", r.Speed))
+	}
+	if r.StartDelay < 0 {
+		errs = append(errs, fmt.Errorf("replay.start_delay: must not be negative, got %s", r.StartDelay))
+	}
+	if record.Enabled {
+		errs = append(errs, errors.New("replay.dir: record.enabled must be off while replaying"))
+	}
+	if tradingMode == TradingModeLive {
+		errs = append(errs, errors.New("replay.dir: price_gap.trading.mode live can't trade on replayed market data"))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RiskConfig) validate(trading *TradingConfig) error {
+	var errs []error
+	for _, limit := range []struct {
+		name  string
+		value float64
+	}{
+		{"risk.max_symbol_notional", r.MaxSymbolNotional},
+		{"risk.max_total_notional", r.MaxTotalNotional},
+		{"risk.max_open_orders", float64(r.MaxOpenOrders)},
+		{"risk.max_daily_loss", r.MaxDailyLoss},
+		{"risk.max_order_rate", float64(r.MaxOrderRate)},
+	} {
+		if limit.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %v", limit.name, limit.value))
+		}
+	}
+	if r.PriceBand < 0 || r.PriceBand >= 1 {
+		errs = append(errs, fmt.Errorf("risk.price_band: must be a fraction such as 0.01, got %v", r.PriceBand))
+	}
+	if r.HTTPAddr != "" {
+		if _, _, err := net.SplitHostPort(r.HTTPAddr); err != nil {
+			errs = append(errs, fmt.Errorf("risk.http_addr: %w", err))
+		}
+	}
+	// a hedge leg over the limit would never open
+	if trading.Mode != TradingModeOff && r.MaxSymbolNotional > 0 && trading.Notional > r.MaxSymbolNotional {
+		errs = append(errs, fmt.Errorf("risk.max_symbol_notional: must not be below price_gap.trading.notional %v, got %v", trading.Notional, r.MaxSymbolNotional))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SupervisorConfig) validate(components map[string]bool) error {
+	var errs []error
+	switch common.Restart(s.Restart) {
+	case common.RestartAlways, common.RestartOnFailure, common.RestartNever:
+	default:
+		errs = append(errs, fmt.Errorf("supervisor.restart: unknown policy %q, expected always, on_failure or never", s.Restart))
+	}
+	if s.MinBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("supervisor.min_backoff: must be positive, got %s", s.MinBackoff))
+	}
+	if s.MaxBackoff < s.MinBackoff {
+		errs = append(errs, fmt.Errorf("supervisor.max_backoff: must not be below min_backoff, got %s", s.MaxBackoff))
+	}
+	if s.MaxRestarts < 0 {
+		errs = append(errs, fmt.Errorf("supervisor.max_restarts: must not be negative, got %d", s.MaxRestarts))
+	}
+	if s.MaxRestarts > 0 && s.Window <= 0 {
+		errs = append(errs, fmt.Errorf("supervisor.window: must be positive with max_restarts, got %s", s.Window))
+	}
+	for _, component := range s.Critical {
+		if !components[component] {
+			errs = append(errs, fmt.Errorf("supervisor.critical: %q is not in components", component))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *PriceGapConfig) validate() error {
+	var errs []error
+	errs = append(errs,
+		validateExchange("price_gap.exchange_a", p.ExchangeA),
+		validateExchange("price_gap.exchange_b", p.ExchangeB),
+	)
+	if p.Mode != strategy.PriceGapModeMarkPrice && p.Mode != strategy.PriceGapModeExecutable {
+		errs = append(errs, fmt.Errorf("price_gap.mode: unknown mode %q, expected %s or %s", p.Mode, strategy.PriceGapModeMarkPrice, strategy.PriceGapModeExecutable))
+	}
+	if p.ExchangeA == p.ExchangeB {
+		errs = append(errs, fmt.Errorf("price_gap: exchange_a and exchange_b are both %q", p.ExchangeA))
+	}
+	for i, pair := range p.Pairs {
+		if pair.A == "" || pair.B == "" {
+			errs = append(errs, fmt.Errorf("price_gap.pairs[%d]: a and b must not be empty", i))
+		}
+	}
+	if p.CheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("price_gap.check_interval: must be positive, got %s", p.CheckInterval))
+	}
+	if p.StartInterval < 0 {
+		errs = append(errs, fmt.Errorf("price_gap.start_interval: must not be negative, got %s", p.StartInterval))
+	}
+	if err := validateMaxAge("price_gap.chart_age", p.ChartAge); err != nil {
+		errs = append(errs, err)
+	}
+	if p.TradeFlowWindow < 0 {
+		errs = append(errs, fmt.Errorf("price_gap.trade_flow_window: must not be negative, got %s", p.TradeFlowWindow))
+	}
+	if p.FundingInterval < 0 {
+		errs = append(errs, fmt.Errorf("price_gap.funding_interval: must not be negative, got %s", p.FundingInterval))
+	}
+	for _, exchange := range sortedKeys(p.MaxStaleness) {
+		name := "price_gap.max_staleness." + exchange
+		if err := validateExchange(name, exchange); err != nil {
+			errs = append(errs, err)
+		}
+		if p.MaxStaleness[exchange] < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %s", name, p.MaxStaleness[exchange]))
+		}
+	}
+	if p.Trigger != strategy.PriceGapTriggerPeriodic && p.Trigger != strategy.PriceGapTriggerTick {
+		errs = append(errs, fmt.Errorf("price_gap.trigger: unknown trigger %q, expected %s or %s", p.Trigger, strategy.PriceGapTriggerPeriodic, strategy.PriceGapTriggerTick))
+	}
+	if p.Coalesce < 0 {
+		errs = append(errs, fmt.Errorf("price_gap.coalesce: must not be negative, got %s", p.Coalesce))
+	}
+	if p.MaxSkew < 0 {
+		errs = append(errs, fmt.Errorf("price_gap.max_skew: must not be negative, got %s", p.MaxSkew))
+	}
+	if p.StaleAction != strategy.PriceGapStaleFlag && p.StaleAction != strategy.PriceGapStaleSuppress {
+		errs = append(errs, fmt.Errorf("price_gap.stale_action: unknown action %q, expected %s or %s", p.StaleAction, strategy.PriceGapStaleFlag, strategy.PriceGapStaleSuppress))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the credentials of the exchanges traded live
+func (t *TradingConfig) validate(mode string, exchanges []string, credentials *ExchangesConfig) error {
+	var errs []error
+	switch t.Mode {
+	case TradingModeOff:
+		return nil
+	case TradingModePaper, TradingModeLive:
+	default:
+		return fmt.Errorf("price_gap.trading.mode: unknown mode %q, expected %s, %s or %s", t.Mode, TradingModeOff, TradingModePaper, TradingModeLive)
+	}
+	if mode != strategy.PriceGapModeExecutable {
+		errs = append(errs, fmt.Errorf("price_gap.trading.mode: %s requires price_gap.mode %s, got %s", t.Mode, strategy.PriceGapModeExecutable, mode))
+	}
+	if t.Entry <= 0 {
+		errs = append(errs, fmt.Errorf("price_gap.trading.entry: must be positive, got %v", t.Entry))
+	}
+	if t.Exit < 0 || t.Exit >= t.Entry {
+		errs = append(errs, fmt.Errorf("price_gap.trading.exit: must be at least 0 and below entry, got %v", t.Exit))
+	}
+	if t.Notional <= 0 {
+		errs = append(errs, fmt.Errorf("price_gap.trading.notional: must be positive, got %v", t.Notional))
+	}
+	if t.LegTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("price_gap.trading.leg_timeout: must be positive, got %s", t.LegTimeout))
+	}
+	for _, exchange := range sortedKeys(t.TakerFees) {
+		name := "price_gap.trading.taker_fees." + exchange
+		if err := validateExchange(name, exchange); err != nil {
+			errs = append(errs, err)
+		}
+		if fee := t.TakerFees[exchange]; fee < 0 || fee >= 1 {
+			errs = append(errs, fmt.Errorf("%s: must be a fraction such as 0.0005, got %v", name, fee))
+		}
+	}
+	if t.Mode == TradingModeLive && !t.DryRun {
+		for _, exchange := range exchanges {
+			errs = append(errs, credentials.validate(exchange))
+		}
+	}
+	for _, exchange := range sortedKeys(t.Paper) {
+		name := "price_gap.trading.paper." + exchange
+		if err := validateExchange(name, exchange); err != nil {
+			errs = append(errs, err)
+		}
+		venue := t.Paper[exchange]
+		if venue.Balance <= 0 {
+			errs = append(errs, fmt.Errorf("%s.balance: must be positive, got %v", name, venue.Balance))
+		}
+		if venue.Leverage < 1 {
+			errs = append(errs, fmt.Errorf("%s.leverage: must be at least 1, got %v", name, venue.Leverage))
+		}
+		// a negative maker fee is a rebate
+		if venue.MakerFee <= -1 || venue.MakerFee >= 1 || venue.TakerFee < 0 || venue.TakerFee >= 1 {
+			errs = append(errs, fmt.Errorf("%s: fees must be fractions such as 0.0005, got maker %v and taker %v", name, venue.MakerFee, venue.TakerFee))
+		}
+		if venue.Latency < 0 {
+			errs = append(errs, fmt.Errorf("%s.latency: must not be negative, got %s", name, venue.Latency))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *ExchangesConfig) validate(exchange string) error {
+	switch exchange {
+	case market.ExchangeBinance:
+		if e.Binance.APIKey == "" || (e.Binance.SecretKey == "" && e.Binance.PrivateKeyFile == "") {
+			return errors.New("exchanges.binance: live trading needs api_key and secret_key or private_key_file")
+		}
+		if e.Binance.PrivateKeyFile != "" {
+			if _, err := e.Binance.signer(); err != nil {
+				return fmt.Errorf("exchanges.binance.private_key_file: %w", err)
+			}
+		}
+	case market.ExchangeOKX:
+		if e.OKX.APIKey == "" || e.OKX.SecretKey == "" || e.OKX.Passphrase == "" {
+			return errors.New("exchanges.okx: live trading needs api_key, secret_key and passphrase")
+		}
+	}
+	return nil
+}
+
+func validateExchange(name string, exchange string) error {
+	if exchange != market.ExchangeBinance && exchange != market.ExchangeOKX {
+		return fmt.Errorf("%s: unknown exchange %q, expected %s or %s", name, exchange, market.ExchangeBinance, market.ExchangeOKX)
+	}
+	return nil
+}
+
+// validateMaxAge checks the retention of the files rotated by lumberjack, which keeps
+// whole days so a shorter age would disable the cleanup
+func validateMaxAge(name string, age Duration) error {
+	if time.Duration(age) < 24*time.Hour {
+		return fmt.Errorf("%s: must be at least 24h, got %s", name, age)
+	}
+	return nil
+}
+
+func validateURL(name string, u string, schemes ...string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme && parsed.Host != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %q must be a %s url", name, u, strings.Join(schemes, " or "))
+}
+
+// Apply sets the process wide log and chart settings, it must run before
+// common.InitLogger and before any component is built
+func (c *Config) Apply() {
+	common.LogDir = c.Log.Dir
+	common.ChartDir = c.Chart.Dir
+	common.LogLevel, _ = zapcore.ParseLevel(c.Log.Level)
+}
+
+// IsCritical reports whether the component is listed in supervisor.critical
+func (c *Config) IsCritical(component string) bool {
+	for _, critical := range c.Supervisor.Critical {
+		if critical == component {
+			return true
+		}
+	}
+	return false
+}
+
+// RestartPolicy returns the configured supervisor policy
+func (c *Config) RestartPolicy() common.RestartPolicy {
+	return common.RestartPolicy{
+		Restart:     common.Restart(c.Supervisor.Restart),
+		MinBackoff:  time.Duration(c.Supervisor.MinBackoff),
+		MaxBackoff:  time.Duration(c.Supervisor.MaxBackoff),
+		MaxRestarts: c.Supervisor.MaxRestarts,
+		Window:      time.Duration(c.Supervisor.Window),
+	}
+}
+
+// NewRecorder returns the recorder shared by every market data connection, nil when
+// record.enabled is off
+func (c *Config) NewRecorder() *common.Recorder {
+	if !c.Record.Enabled {
+		return nil
+	}
+	return common.NewRecorder(c.Record.Dir, common.WithRotation(time.Duration(c.Record.Rotation)), common.WithMaxAge(time.Duration(c.Record.MaxAge)))
+}
+
+// NewReplay returns the replay of replay.dir, nil when the live streams are used
+func (c *Config) NewReplay() *common.Replay {
+	if c.Replay.Dir == "" {
+		return nil
+	}
+	return common.NewReplay(c.Replay.Dir, common.WithSpeed(c.Replay.Speed), common.WithStartDelay(time.Duration(c.Replay.StartDelay)))
+}
+
+// NewRiskManager returns the risk manager of the configured limits and kill switch, or
+// nil when price_gap.trading.mode is off. The kill signal is left to the caller, which
+// owns the signal handling of the process
+func (c *Config) NewRiskManager(opts ...risk.Option) *risk.Manager {
+	if c.PriceGap.Trading.Mode == TradingModeOff {
+		return nil
+	}
+	limits := risk.Limits{
+		MaxSymbolNotional: decimal.NewFromFloat(c.Risk.MaxSymbolNotional),
+		MaxTotalNotional:  decimal.NewFromFloat(c.Risk.MaxTotalNotional),
+		MaxOpenOrders:     c.Risk.MaxOpenOrders,
+		MaxDailyLoss:      decimal.NewFromFloat(c.Risk.MaxDailyLoss),
+		MaxOrderRate:      c.Risk.MaxOrderRate,
+		PriceBand:         decimal.NewFromFloat(c.Risk.PriceBand),
+	}
+	if c.Risk.KillFile != "" {
+		opts = append(opts, risk.WithKillFile(c.Risk.KillFile))
+	}
+	if c.Risk.HTTPAddr != "" {
+		opts = append(opts, risk.WithHTTP(c.Risk.HTTPAddr))
+	}
+	return risk.NewManager(limits, opts...)
+}
+
+// BinanceOptions returns the client options of the configured endpoints and credentials
+func (c *Config) BinanceOptions() []binance.Option {
+	options := []binance.Option{
+		binance.WithFuturesAPIWebSocketURL(c.Exchanges.Binance.FuturesAPIWebSocketURL),
+		binance.WithFuturesStreamWebSocketURL(c.Exchanges.Binance.FuturesStreamWebSocketURL),
+		binance.WithFuturesRESTURL(c.Exchanges.Binance.FuturesRESTURL),
+	}
+	if c.Exchanges.Binance.APIKey != "" {
+		// Validate already read the key file, a signer that fails now fails the orders
+		signer, err := c.Exchanges.Binance.signer()
+		if err != nil {
+			common.Logger.Sugar().Errorf("BinanceOptions signer error: %v", err)
+		}
+		options = append(options, binance.WithAPIKey(c.Exchanges.Binance.APIKey, signer))
+	}
+	return options
+}
+
+func (b *BinanceConfig) signer() (binance.Signer, error) {
+	if b.PrivateKeyFile == "" {
+		return binance.NewHMACSigner(b.SecretKey), nil
+	}
+	data, err := os.ReadFile(b.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return binance.NewEd25519SignerFromPEM(data)
+}
+
+// OKXOptions returns the client options of the configured endpoints and credentials
+func (c *Config) OKXOptions() []okx.Option {
+	options := []okx.Option{
+		okx.WithPublicWebSocketURL(c.Exchanges.OKX.PublicWebSocketURL),
+		okx.WithPrivateWebSocketURL(c.Exchanges.OKX.PrivateWebSocketURL),
+		okx.WithBusinessWebSocketURL(c.Exchanges.OKX.BusinessWebSocketURL),
+		okx.WithRESTURL(c.Exchanges.OKX.RESTURL),
+		okx.WithBookTickerChannel(c.Exchanges.OKX.BookTickerChannel),
+	}
+	if c.Exchanges.OKX.APIKey != "" {
+		options = append(options, okx.WithAPIKey(c.Exchanges.OKX.APIKey, c.Exchanges.OKX.SecretKey, c.Exchanges.OKX.Passphrase))
+	}
+	return options
+}
+
+// PriceGapOptions returns the PriceGap options of the configured intervals, mode,
+// staleness guards and trading
+func (c *Config) PriceGapOptions() []strategy.PriceGapOption {
+	options := c.priceGapSamplingOptions()
+	if c.PriceGap.Trading.Mode == TradingModeOff {
+		return options
+	}
+	return append(options, strategy.WithTrading(c.priceGapTrading(c.PriceGap.Trading.Mode)))
+}
+
+// PriceGapBacktestOptions returns the PriceGapOptions trading in the paper mode whatever
+// the configured mode, the backtest replaces the paper venues with its own
+func (c *Config) PriceGapBacktestOptions() []strategy.PriceGapOption {
+	return append(c.priceGapSamplingOptions(), strategy.WithTrading(c.priceGapTrading(TradingModePaper)))
+}
+
+func (c *Config) priceGapSamplingOptions() []strategy.PriceGapOption {
+	options := []strategy.PriceGapOption{
+		strategy.WithCheckInterval(time.Duration(c.PriceGap.CheckInterval)),
+		strategy.WithStartInterval(time.Duration(c.PriceGap.StartInterval)),
+		strategy.WithChartAge(time.Duration(c.PriceGap.ChartAge)),
+		strategy.WithMode(c.PriceGap.Mode),
+		strategy.WithTrigger(c.PriceGap.Trigger),
+		strategy.WithCoalesce(time.Duration(c.PriceGap.Coalesce)),
+		strategy.WithTradeFlow(time.Duration(c.PriceGap.TradeFlowWindow)),
+		strategy.WithFunding(time.Duration(c.PriceGap.FundingInterval)),
+		strategy.WithMaxSkew(time.Duration(c.PriceGap.MaxSkew)),
+		strategy.WithStaleAction(c.PriceGap.StaleAction),
+	}
+	for _, exchange := range sortedKeys(c.PriceGap.MaxStaleness) {
+		options = append(options, strategy.WithMaxStaleness(exchange, time.Duration(c.PriceGap.MaxStaleness[exchange])))
+	}
+	return options
+}
+
+func (c *Config) priceGapTrading(mode string) *strategy.PriceGapTrading {
+	trading := c.PriceGap.Trading
+	exchangeA, exchangeB := c.PriceGap.ExchangeA, c.PriceGap.ExchangeB
+	// the live venues are the PriceGap sources
+	priceGapTrading := &strategy.PriceGapTrading{
+		Entry:      decimal.NewFromFloat(trading.Entry),
+		Exit:       decimal.NewFromFloat(trading.Exit),
+		Notional:   decimal.NewFromFloat(trading.Notional),
+		FeeA:       decimal.NewFromFloat(trading.TakerFees[exchangeA]),
+		FeeB:       decimal.NewFromFloat(trading.TakerFees[exchangeB]),
+		LegTimeout: time.Duration(trading.LegTimeout),
+		DryRun:     trading.DryRun,
+	}
+	if mode == TradingModePaper {
+		paperA, paperB := trading.PaperVenue(exchangeA), trading.PaperVenue(exchangeB)
+		priceGapTrading.VenueA = paper.NewVenue(exchangeA, paperA.Options()...)
+		priceGapTrading.VenueB = paper.NewVenue(exchangeB, paperB.Options()...)
+		priceGapTrading.FeeA = decimal.NewFromFloat(paperA.TakerFee)
+		priceGapTrading.FeeB = decimal.NewFromFloat(paperB.TakerFee)
+		priceGapTrading.DryRun = false
+	}
+	return priceGapTrading
+}
+
+// PaperVenue uses the paper defaults for an exchange missing from Paper
+func (t *TradingConfig) PaperVenue(exchange string) PaperConfig {
+	venue, ok := t.Paper[exchange]
+	if !ok {
+		return defaultPaper()
+	}
+	return venue
+}
+
+// Options returns the options of a paper.Venue simulating the account
+func (p PaperConfig) Options() []paper.Option {
+	return []paper.Option{
+		paper.WithBalance(decimal.NewFromFloat(p.Balance)),
+		paper.WithLeverage(decimal.NewFromFloat(p.Leverage)),
+		paper.WithFees(decimal.NewFromFloat(p.MakerFee), decimal.NewFromFloat(p.TakerFee)),
+		paper.WithLatency(time.Duration(p.Latency)),
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
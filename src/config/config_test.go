@@ -0,0 +1,282 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trade/src/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		testDefault(t)
+	})
+	t.Run("YAML", func(t *testing.T) {
+		testYAML(t)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		testJSON(t)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		testInvalid(t)
+	})
+}
+
+func testDefault(t *testing.T) {
+	require.NoError(t, Default().Validate())
+	data, err := Default().Marshal()
+	require.NoError(t, err)
+	cfg, err := Load(writeConfig(t, "default.yaml", string(data)))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func testYAML(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "trade.yaml", `
+log:
+  level: debug
+components: [price_gap]
+price_gap:
+  exchange_a: okx
+  exchange_b: binance
+  pairs:
+    - {a: BTC-USDT-SWAP, b: BTCUSDT}
+  check_interval: 250ms
+  max_staleness: {okx: 10s}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	// omitted fields keep their defaults
+	assert.Equal(t, Default().Log.Dir, cfg.Log.Dir)
+	assert.Equal(t, Default().PriceGap.ChartAge, cfg.PriceGap.ChartAge)
+	assert.Equal(t, []PairConfig{{A: "BTC-USDT-SWAP", B: "BTCUSDT"}}, cfg.PriceGap.Pairs)
+	assert.Equal(t, Duration(250*time.Millisecond), cfg.PriceGap.CheckInterval)
+	assert.Equal(t, map[string]Duration{"binance": Default().PriceGap.MaxStaleness["binance"], "okx": Duration(10 * time.Second)}, cfg.PriceGap.MaxStaleness)
+	assert.Len(t, cfg.PriceGapOptions(), 12)
+	assert.Len(t, cfg.PriceGapBacktestOptions(), 13, "the backtest trades whatever the trading mode")
+	assert.True(t, cfg.IsCritical(ComponentPriceGap))
+
+	cfg, err = Load(writeConfig(t, "trade.yaml", `
+price_gap:
+  mode: executable
+  trading: {mode: paper, entry: 0.3}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Default().PriceGap.Trading.Paper, cfg.PriceGap.Trading.Paper)
+	assert.Len(t, cfg.PriceGapOptions(), 13)
+	assert.NotNil(t, cfg.NewRiskManager())
+
+	cfg, err = Load(writeConfig(t, "trade.yaml", `
+risk: {max_daily_loss: 0, kill_file: ./kill, http_addr: "127.0.0.1:8081"}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, cfg.Risk.MaxDailyLoss)
+	assert.Equal(t, Default().Risk.MaxOpenOrders, cfg.Risk.MaxOpenOrders)
+	assert.Equal(t, "127.0.0.1:8081", cfg.Risk.HTTPAddr)
+	assert.Nil(t, cfg.NewRiskManager(), "nothing to check without trading")
+
+	cfg, err = Load(writeConfig(t, "trade.yaml", `
+exchanges:
+  okx: {api_key: key, secret_key: secret, passphrase: pass}
+price_gap:
+  exchange_a: okx
+  exchange_b: binance
+  mode: executable
+  trading: {mode: live, dry_run: true, leg_timeout: 2s}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(2*time.Second), cfg.PriceGap.Trading.LegTimeout)
+	assert.Len(t, cfg.PriceGapOptions(), 13)
+	assert.Len(t, cfg.OKXOptions(), 6)
+	assert.Len(t, cfg.BinanceOptions(), 3)
+	assert.Nil(t, cfg.NewRecorder())
+	assert.Nil(t, cfg.NewReplay())
+
+	cfg, err = Load(writeConfig(t, "trade.yaml", `
+record: {enabled: true, rotation: 15m}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(15*time.Minute), cfg.Record.Rotation)
+	assert.Equal(t, Default().Record.MaxAge, cfg.Record.MaxAge)
+	cfg.Record.Dir = t.TempDir()
+	assert.NotNil(t, cfg.NewRecorder())
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, common.RecordFile), nil, 0o644))
+	cfg, err = Load(writeConfig(t, "trade.yaml", `
+replay: {dir: `+dir+`, speed: 0}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, cfg.Replay.Speed)
+	assert.Equal(t, Default().Replay.StartDelay, cfg.Replay.StartDelay)
+	assert.NotNil(t, cfg.NewReplay())
+}
+
+func testJSON(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "trade.json", `{
+		"exchanges": {"okx": {"rest_url": "http://127.0.0.1:8080"}},
+		"price_gap": {"start_interval": "0s", "chart_age": "720h"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8080", cfg.Exchanges.OKX.RESTURL)
+	assert.Equal(t, Duration(0), cfg.PriceGap.StartInterval)
+	assert.Equal(t, Duration(720*time.Hour), cfg.PriceGap.ChartAge)
+
+	_, err = Load(writeConfig(t, "trade.json", `{"price_gap": {"check_interval": 1}}`))
+	require.ErrorContains(t, err, "duration must be a string")
+}
+
+func testInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		content string
+		errs    []string
+	}{
+		"UnknownField": {
+			content: "price_gap:\n  interval: 1s\n",
+			errs:    []string{"field interval not found"},
+		},
+		"UnknownComponent": {
+			content: "components: [price_gap, funding]\n",
+			errs:    []string{`unknown component "funding"`},
+		},
+		"Values": {
+			content: `
+log: {level: verbose}
+exchanges:
+  binance: {futures_stream_websocket_url: "https://fstream.binance.com/stream"}
+price_gap:
+  exchange_a: okx
+  exchange_b: okx
+  mode: bid_ask
+  pairs: [{a: BTCUSDT}]
+  check_interval: 0s
+  chart_age: 1h
+  trade_flow_window: -1m
+  funding_interval: -1m
+  max_staleness: {kraken: 1s, okx: -1s}
+  max_skew: -1s
+  trigger: sometimes
+  coalesce: -1ms
+  stale_action: drop
+supervisor: {restart: sometimes, max_backoff: 1ms, critical: [funding]}
+`,
+			errs: []string{
+				"log.level",
+				"exchanges.binance.futures_stream_websocket_url",
+				`exchange_a and exchange_b are both "okx"`,
+				"price_gap.mode",
+				"price_gap.pairs[0]",
+				"price_gap.check_interval",
+				"price_gap.chart_age",
+				"price_gap.trade_flow_window",
+				"price_gap.funding_interval",
+				`price_gap.max_staleness.kraken: unknown exchange "kraken"`,
+				"price_gap.max_staleness.okx: must not be negative",
+				"price_gap.max_skew",
+				"price_gap.trigger",
+				"price_gap.coalesce",
+				"price_gap.stale_action",
+				"supervisor.restart",
+				"supervisor.max_backoff",
+				`supervisor.critical: "funding"`,
+			},
+		},
+		"Trading": {
+			content: `
+price_gap:
+  trading:
+    mode: paper
+    entry: 0.1
+    exit: 0.2
+    notional: 0
+    paper: {okx: {balance: 100, leverage: 0.5, taker_fee: 0.05, latency: -1ms}}
+`,
+			errs: []string{
+				"price_gap.trading.mode: paper requires price_gap.mode executable",
+				"price_gap.trading.exit",
+				"price_gap.trading.notional",
+				"price_gap.trading.paper.okx.leverage",
+				"price_gap.trading.paper.okx.latency",
+			},
+		},
+		"Live": {
+			content: `
+exchanges:
+  binance: {api_key: key, private_key_file: /nonexistent.pem}
+  okx: {api_key: key}
+price_gap:
+  exchange_a: okx
+  exchange_b: binance
+  mode: executable
+  trading:
+    mode: live
+    leg_timeout: 0s
+    taker_fees: {kraken: 0.001, okx: 1.5}
+`,
+			errs: []string{
+				"price_gap.trading.leg_timeout",
+				`price_gap.trading.taker_fees.kraken: unknown exchange "kraken"`,
+				"price_gap.trading.taker_fees.okx: must be a fraction",
+				"exchanges.okx: live trading needs api_key, secret_key and passphrase",
+				"exchanges.binance.private_key_file",
+			},
+		},
+		"Record": {
+			content: "record: {enabled: true, dir: \"\", rotation: 0s, max_age: 1h}\n",
+			errs: []string{
+				"record.dir",
+				"record.rotation",
+				"record.max_age",
+			},
+		},
+		"Risk": {
+			content: `
+risk: {max_symbol_notional: 50, max_open_orders: -1, max_order_rate: -1, price_band: 1, http_addr: "8081"}
+price_gap:
+  mode: executable
+  trading: {mode: paper, notional: 100}
+`,
+			errs: []string{
+				"risk.max_symbol_notional: must not be below price_gap.trading.notional",
+				"risk.max_open_orders",
+				"risk.max_order_rate",
+				"risk.price_band",
+				"risk.http_addr",
+			},
+		},
+		"Replay": {
+			content: `
+record: {enabled: true}
+replay: {dir: /nonexistent, speed: -1, start_delay: -1s}
+price_gap:
+  mode: executable
+  trading: {mode: live, dry_run: true}
+`,
+			errs: []string{
+				"replay.dir: open /nonexistent",
+				"replay.speed",
+				"replay.start_delay",
+				"replay.dir: record.enabled must be off",
+				"replay.dir: price_gap.trading.mode live",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, "trade.yaml", test.content))
+			require.Error(t, err)
+			for _, msg := range test.errs {
+				assert.ErrorContains(t, err, msg)
+			}
+		})
+	}
+}
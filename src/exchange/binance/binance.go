@@ -1,35 +1,155 @@
 package binance
 
 import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 )
 
 const (
 	FuturesAPIWebSocketBaseURL    = "wss://ws-fapi.binance.com/ws-fapi/v1"
 	FuturesStreamWebSocketBaseURL = "wss://fstream.binance.com/stream"
+	FuturesRESTBaseURL            = "https://fapi.binance.com"
 )
 
+// Client is safe for concurrent use. Each connection has its own write lock since
+// gorilla websocket supports one concurrent writer, the handler registries are guarded
+// by mu and connections are swapped atomically on reconnect
 type Client struct {
-	closed                         bool
-	futuresAPIWebSocketConn        *websocket.Conn
-	futuresAPIWebSocketResponses   map[string]chan *FuturesAPIWebSocketResponse
-	futuresStreamWebSocketConn     *websocket.Conn
-	futuresStreamWebSocketHandlers map[string]func(*FuturesStreamWebSocketStream)
+	closed                          atomic.Bool
+	mu                              sync.RWMutex
+	apiKey                          string
+	signer                          Signer
+	recvWindow                      int64
+	futuresRESTURL                  string
+	httpClient                      *http.Client
+	futuresAPIWebSocketURL          string
+	futuresAPIWebSocketConn         atomic.Pointer[websocket.Conn]
+	futuresAPIWebSocketWriteMu      sync.Mutex
+	futuresAPIWebSocketResponses    map[string]chan *FuturesAPIWebSocketResponse
+	futuresStreamWebSocketURL       string
+	futuresStreamWebSocketConn      atomic.Pointer[websocket.Conn]
+	futuresStreamWebSocketWriteMu   sync.Mutex
+	futuresStreamWebSocketHandlers  map[string]func(*FuturesStreamWebSocketStream)
+	futuresStreamWebSocketResponses map[string]chan *FuturesStreamWebSocketStream
+	// futuresStreamWebSocketSubscriptions keeps every SUBSCRIBE request sent on the
+	// stream connection so they can be replayed after a reconnect
+	futuresStreamWebSocketSubscriptions     []*FuturesStreamWebSocketRequest
+	futuresStreamWebSocketReconnectHandlers []func()
+	// fundingHandlers are keyed by symbol and share the !markPrice@arr subscription,
+	// fundingIntervals holds the symbols not settling every DefaultFundingInterval
+	fundingHandlers     map[string]func(*market.Funding)
+	fundingIntervals    map[string]time.Duration
+	fundingSubscription *fundingSubscription
+	// orders delivers the ORDER_TRADE_UPDATE events of userDataStream once InitTrading
+	// ran, orderFees sums the commissions of the orders until they are final
+	orders         *market.OrderDispatcher
+	orderFees      map[int64]decimal.Decimal
+	userDataStream *FuturesUserDataStream
+	// recorder keeps every frame of the stream connection, replay feeds the recorded
+	// frames instead of the connection and now is the local time of the market data
+	recorder *common.Recorder
+	replay   *common.Replay
+	now      func() int64
 }
 
-func NewClient() *Client {
-	return &Client{
-		futuresAPIWebSocketResponses:   make(map[string]chan *FuturesAPIWebSocketResponse, 100),
-		futuresStreamWebSocketHandlers: make(map[string]func(*FuturesStreamWebSocketStream), 100),
+type Option func(*Client)
+
+// WithFuturesAPIWebSocketURL overrides FuturesAPIWebSocketBaseURL
+func WithFuturesAPIWebSocketURL(url string) Option {
+	return func(c *Client) {
+		c.futuresAPIWebSocketURL = url
 	}
 }
 
+// WithFuturesStreamWebSocketURL overrides FuturesStreamWebSocketBaseURL
+func WithFuturesStreamWebSocketURL(url string) Option {
+	return func(c *Client) {
+		c.futuresStreamWebSocketURL = url
+	}
+}
+
+// WithFuturesRESTURL overrides FuturesRESTBaseURL
+func WithFuturesRESTURL(url string) Option {
+	return func(c *Client) {
+		c.futuresRESTURL = url
+	}
+}
+
+// WithAPIKey sets the credentials used for user stream and signed ws-fapi methods
+func WithAPIKey(apiKey string, signer Signer) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+		c.signer = signer
+	}
+}
+
+// WithRecvWindow sets the recvWindow in milliseconds sent with signed requests
+func WithRecvWindow(recvWindow int64) Option {
+	return func(c *Client) {
+		c.recvWindow = recvWindow
+	}
+}
+
+// WithRecorder writes every frame read on the stream connection to recorder
+func WithRecorder(recorder *common.Recorder) Option {
+	return func(c *Client) {
+		c.recorder = recorder
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		futuresRESTURL:                  FuturesRESTBaseURL,
+		httpClient:                      &http.Client{Timeout: 10 * time.Second},
+		futuresAPIWebSocketURL:          FuturesAPIWebSocketBaseURL,
+		futuresAPIWebSocketResponses:    make(map[string]chan *FuturesAPIWebSocketResponse, 100),
+		futuresStreamWebSocketURL:       FuturesStreamWebSocketBaseURL,
+		futuresStreamWebSocketHandlers:  make(map[string]func(*FuturesStreamWebSocketStream), 100),
+		futuresStreamWebSocketResponses: make(map[string]chan *FuturesStreamWebSocketStream, 100),
+		fundingHandlers:                 make(map[string]func(*market.Funding)),
+		orders:                          market.NewOrderDispatcher(),
+		orderFees:                       make(map[int64]decimal.Decimal),
+		now:                             func() int64 { return time.Now().UnixMilli() },
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewReplayClient dispatches the recorded frames of replay to the stream subscriptions
+// instead of connecting the stream, the ticks are stamped with the recorded receive
+// times. The REST and ws-fapi methods still reach the exchange
+func NewReplayClient(replay *common.Replay, opts ...Option) *Client {
+	c := NewClient(opts...)
+	c.replay = replay
+	c.now = replay.Now
+	replay.Handle(market.ExchangeBinance, func(frame *common.Frame) {
+		c.handleFuturesStreamWebSocketMessage(frame.Data)
+	})
+	return c
+}
+
 func (c *Client) Clean() {
-	c.closed = true
-	if c.futuresAPIWebSocketConn != nil {
-		c.futuresAPIWebSocketConn.Close()
+	c.closed.Store(true)
+	if conn := c.futuresAPIWebSocketConn.Load(); conn != nil {
+		conn.Close()
 	}
-	if c.futuresStreamWebSocketConn != nil {
-		c.futuresStreamWebSocketConn.Close()
+	if conn := c.futuresStreamWebSocketConn.Load(); conn != nil {
+		conn.Close()
 	}
 }
+
+// writeJSON serializes writes on one connection
+func writeJSON(mu *sync.Mutex, conn *websocket.Conn, v interface{}) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return conn.WriteJSON(v)
+}
@@ -0,0 +1,211 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	_ market.ExecutionVenue = (*Client)(nil)
+	_ market.PositionSource = (*Client)(nil)
+)
+
+// InitTrading connects the api connection, and the stream connection unless
+// InitMarketData already did, and starts the user data stream whose order updates are
+// delivered to the OnOrderUpdate handlers. It needs WithAPIKey
+func (c *Client) InitTrading(ctx context.Context) error {
+	if c.futuresAPIWebSocketConn.Load() == nil {
+		err := c.InitFuturesAPIWebSocketConnection(ctx)
+		if err != nil {
+			return fmt.Errorf("InitTrading api connection error: %w", err)
+		}
+	}
+	if c.futuresStreamWebSocketConn.Load() == nil {
+		err := c.InitFuturesStreamWebSocketConnection(ctx)
+		if err != nil {
+			return fmt.Errorf("InitTrading stream connection error: %w", err)
+		}
+	}
+	c.orders.Start(ctx)
+	c.mu.Lock()
+	stream := c.userDataStream
+	if stream == nil {
+		stream = NewFuturesUserDataStream(c, 0)
+		stream.OnOrderTradeUpdate = c.handleOrderTradeUpdate
+		c.userDataStream = stream
+	}
+	c.mu.Unlock()
+	if stream.ListenKey() != "" {
+		return nil
+	}
+	return stream.Run(ctx)
+}
+
+// OnOrderUpdate handlers are called on their own goroutine in the order of the events
+func (c *Client) OnOrderUpdate(handler func(*market.Order)) {
+	c.orders.OnOrderUpdate(handler)
+}
+
+// PlaceOrder sends order.place, a limit order is GTC. An order refused by Binance is
+// returned rejected with the error as the reason
+func (c *Client) PlaceOrder(ctx context.Context, request *market.OrderRequest) (*market.Order, error) {
+	place := NewFuturesAPIWebSocketOrderPlace(request.Symbol, strings.ToUpper(request.Side), request.Quantity)
+	if request.Type == market.OrderTypeLimit {
+		place.Limit(request.Price, TimeInForceGTC)
+	}
+	place.ReduceOnly = request.ReduceOnly
+	place.NewClientOrderID = request.ClientOrderID
+	resp, err := c.CallContext(ctx, place.Request())
+	var apiErr *FuturesAPIWebSocketError
+	if errors.As(err, &apiErr) {
+		return market.NewRejectedOrder(market.ExchangeBinance, request, apiErr.Error(), time.Now().UnixMilli()), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PlaceOrder %s error: %w", request.Symbol, err)
+	}
+	order, err := place.Response(resp)
+	if err != nil {
+		return nil, fmt.Errorf("PlaceOrder %s Response error: %w", request.Symbol, err)
+	}
+	return order.Order(), nil
+}
+
+// CancelOrder sends order.cancel, an order Binance doesn't know or already closed is an
+// error
+func (c *Client) CancelOrder(ctx context.Context, symbol string, orderID string) (*market.Order, error) {
+	id, err := strconv.ParseInt(orderID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("CancelOrder %s invalid order id %q", symbol, orderID)
+	}
+	cancel := NewFuturesAPIWebSocketOrderCancel(symbol, id, "")
+	resp, err := c.CallContext(ctx, cancel.Request())
+	if err != nil {
+		return nil, fmt.Errorf("CancelOrder %s %s error: %w", symbol, orderID, err)
+	}
+	order, err := cancel.Response(resp)
+	if err != nil {
+		return nil, fmt.Errorf("CancelOrder %s %s Response error: %w", symbol, orderID, err)
+	}
+	return order.Order(), nil
+}
+
+// GetPositions sends v2/account.position and returns the open positions, positionAmt is
+// negative when short
+func (c *Client) GetPositions(ctx context.Context) ([]market.Position, error) {
+	account := NewFuturesAPIWebSocketAccountPosition("")
+	resp, err := c.CallContext(ctx, account.Request())
+	if err != nil {
+		return nil, fmt.Errorf("GetPositions error: %w", err)
+	}
+	account, err = account.Response(resp)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositions Response error: %w", err)
+	}
+	positions := []market.Position{}
+	for _, p := range account.Positions {
+		if p.PositionAmt.IsZero() {
+			continue
+		}
+		positions = append(positions, market.Position{
+			Exchange:    market.ExchangeBinance,
+			Symbol:      p.Symbol,
+			Quantity:    p.PositionAmt,
+			EntryPrice:  p.EntryPrice,
+			RealizedPnL: decimal.Zero,
+		})
+	}
+	return positions, nil
+}
+
+// Order converts the result of the order methods, it has no fee
+func (f *FuturesAPIWebSocketOrder) Order() *market.Order {
+	status, reason := orderStatus(f.Status)
+	return &market.Order{
+		ID:             strconv.FormatInt(f.OrderID, 10),
+		ClientOrderID:  f.ClientOrderID,
+		Exchange:       market.ExchangeBinance,
+		Symbol:         f.Symbol,
+		Side:           strings.ToLower(f.Side),
+		Type:           orderType(f.Type),
+		Price:          f.Price,
+		Quantity:       f.OrigQty,
+		ReduceOnly:     f.ReduceOnly,
+		FilledQuantity: f.ExecutedQty,
+		AvgPrice:       f.AvgPrice,
+		Fee:            decimal.Zero,
+		Status:         status,
+		Reason:         reason,
+		CreateTime:     f.Time,
+		UpdateTime:     f.UpdateTime,
+	}
+}
+
+// handleOrderTradeUpdate sums the commission of each fill, in the commission asset which
+// is the quote currency unless paid in BNB
+func (c *Client) handleOrderTradeUpdate(update *FuturesStreamWebSocketOrderTradeUpdate) {
+	o := update.Order
+	if o == nil {
+		return
+	}
+	status, reason := orderStatus(o.OrderStatus)
+	c.mu.Lock()
+	fee := c.orderFees[o.OrderID]
+	if o.ExecutionType == "TRADE" {
+		fee = fee.Add(o.Commission)
+	}
+	if status.Final() {
+		delete(c.orderFees, o.OrderID)
+	} else {
+		c.orderFees[o.OrderID] = fee
+	}
+	c.mu.Unlock()
+	c.orders.Dispatch(&market.Order{
+		ID:             strconv.FormatInt(o.OrderID, 10),
+		ClientOrderID:  o.ClientOrderID,
+		Exchange:       market.ExchangeBinance,
+		Symbol:         o.Symbol,
+		Side:           strings.ToLower(o.Side),
+		Type:           orderType(o.OrderType),
+		Price:          o.Price,
+		Quantity:       o.OrigQty,
+		ReduceOnly:     o.ReduceOnly,
+		FilledQuantity: o.CumFilledQty,
+		AvgPrice:       o.AvgPrice,
+		Fee:            fee,
+		Status:         status,
+		Reason:         reason,
+		UpdateTime:     update.TransactionTime,
+	})
+}
+
+// orderStatus maps EXPIRED, e.g. an IOC or a market order out of liquidity, to canceled
+func orderStatus(status string) (market.OrderStatus, string) {
+	switch status {
+	case OrderStatusPartiallyFilled:
+		return market.OrderStatusPartiallyFilled, ""
+	case OrderStatusFilled:
+		return market.OrderStatusFilled, ""
+	case OrderStatusCanceled:
+		return market.OrderStatusCanceled, ""
+	case OrderStatusExpired:
+		return market.OrderStatusCanceled, "expired"
+	case OrderStatusRejected:
+		return market.OrderStatusRejected, ""
+	default:
+		return market.OrderStatusNew, ""
+	}
+}
+
+func orderType(orderType string) market.OrderType {
+	if orderType == OrderTypeMarket {
+		return market.OrderTypeMarket
+	}
+	return market.OrderTypeLimit
+}
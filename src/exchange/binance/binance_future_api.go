@@ -10,18 +10,33 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 )
 
+// CallTimeout is how long Call waits for the response of a request
+const CallTimeout = 10 * time.Second
+
 type FuturesAPIWebSocketRequest struct {
-	ID     string          `json:"id"`
-	Method string          `json:"method"`
-	Params json.RawMessage `json:"params"`
+	ID       string          `json:"id"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params"`
+	Security SecurityType    `json:"-"`
 }
 
 type FuturesAPIWebSocketResponse struct {
-	ID     string          `json:"id"`
-	Status int64           `json:"status"`
-	Result json.RawMessage `json:"result"`
+	ID     string                    `json:"id"`
+	Status int64                     `json:"status"`
+	Result json.RawMessage           `json:"result"`
+	Error  *FuturesAPIWebSocketError `json:"error"`
+}
+
+type FuturesAPIWebSocketError struct {
+	Code int64  `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *FuturesAPIWebSocketError) Error() string {
+	return fmt.Sprintf("code %d msg %s", e.Code, e.Msg)
 }
 
 func (c *Client) InitFuturesAPIWebSocketConnection(ctx context.Context) error {
@@ -31,14 +46,27 @@ func (c *Client) InitFuturesAPIWebSocketConnection(ctx context.Context) error {
 
 func (c *Client) ReadFuturesAPIWebSocketMessages(ctx context.Context) {
 	defer common.HandlePanic()
+	// unblock ReadMessage once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		if conn := c.futuresAPIWebSocketConn.Load(); conn != nil {
+			conn.Close()
+		}
+	})
+	defer stop()
 	for {
-		if c.futuresAPIWebSocketConn == nil {
-			time.Sleep(100 * time.Millisecond)
+		if c.closed.Load() || ctx.Err() != nil {
+			return
+		}
+		conn := c.futuresAPIWebSocketConn.Load()
+		if conn == nil {
+			if !common.Sleep(ctx, 100*time.Millisecond) {
+				return
+			}
 			continue
 		}
-		_, message, err := c.futuresAPIWebSocketConn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			if c.closed {
+			if c.closed.Load() || ctx.Err() != nil {
 				return
 			}
 			common.Logger.Sugar().Warnf("ReadFuturesAPIWebSocketMessages ReadMessage error: %v", err)
@@ -51,7 +79,10 @@ func (c *Client) ReadFuturesAPIWebSocketMessages(ctx context.Context) {
 			common.Logger.Sugar().Warnf("ReadFuturesAPIWebSocketMessages Unmarshal error: %v %s", err, string(message))
 			continue
 		}
-		if ch, ok := c.futuresAPIWebSocketResponses[response.ID]; ok {
+		c.mu.RLock()
+		ch, ok := c.futuresAPIWebSocketResponses[response.ID]
+		c.mu.RUnlock()
+		if ok {
 			ch <- &response
 		} else {
 			common.Logger.Sugar().Warnf("ReadFuturesAPIWebSocketMessages No handler for message: %s", string(message))
@@ -60,17 +91,22 @@ func (c *Client) ReadFuturesAPIWebSocketMessages(ctx context.Context) {
 }
 
 func (c *Client) ConnectFuturesAPIWebSocket(ctx context.Context) error {
-	var err error
-	c.futuresAPIWebSocketConn, _, err = websocket.DefaultDialer.DialContext(ctx, FuturesAPIWebSocketBaseURL, nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.futuresAPIWebSocketURL, nil)
 	if err != nil {
 		return err
 	}
+	c.futuresAPIWebSocketConn.Store(conn)
 	return nil
 }
 
 func (c *Client) ReconnectFuturesAPIWebSocket(ctx context.Context) {
+	if conn := c.futuresAPIWebSocketConn.Load(); conn != nil {
+		conn.Close()
+	}
 	for i := 0; i < 5; i++ {
-		time.Sleep(time.Second)
+		if !common.Sleep(ctx, time.Second) {
+			return
+		}
 		err := c.ConnectFuturesAPIWebSocket(ctx)
 		if err != nil {
 			common.Logger.Sugar().Warnf("ReconnectFuturesAPIWebSocket error %v", err)
@@ -82,28 +118,57 @@ func (c *Client) ReconnectFuturesAPIWebSocket(ctx context.Context) {
 	}
 }
 
+// Call waits for the response at most CallTimeout
 func (c *Client) Call(request *FuturesAPIWebSocketRequest) (*FuturesAPIWebSocketResponse, error) {
-	if c.futuresAPIWebSocketConn == nil {
+	return c.CallContext(context.Background(), request)
+}
+
+// CallContext works like Call but gives up once ctx is done, the request may still be
+// executed by Binance
+func (c *Client) CallContext(ctx context.Context, request *FuturesAPIWebSocketRequest) (*FuturesAPIWebSocketResponse, error) {
+	conn := c.futuresAPIWebSocketConn.Load()
+	if conn == nil {
 		return nil, fmt.Errorf("Call futuresAPIWebSocketConn is nil")
 	}
 	if request == nil {
 		return nil, fmt.Errorf("Call request is nil")
 	}
+	if request.Security != SecurityTypeNone {
+		err := c.signRequest(request)
+		if err != nil {
+			return nil, err
+		}
+	}
 	id := uuid.New().String()
 	request.ID = id
-	c.futuresAPIWebSocketResponses[id] = make(chan *FuturesAPIWebSocketResponse, 1)
-	defer delete(c.futuresAPIWebSocketResponses, id)
-	err := c.futuresAPIWebSocketConn.WriteJSON(request)
+	ch := make(chan *FuturesAPIWebSocketResponse, 1)
+	c.mu.Lock()
+	c.futuresAPIWebSocketResponses[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.futuresAPIWebSocketResponses, id)
+		c.mu.Unlock()
+	}()
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Call %s error: %w", request.Method, err)
+	}
+	err := writeJSON(&c.futuresAPIWebSocketWriteMu, conn, request)
 	if err != nil {
 		return nil, err
 	}
 	select {
-	case response := <-c.futuresAPIWebSocketResponses[id]:
+	case response := <-ch:
 		if response.Status != http.StatusOK {
+			if response.Error != nil {
+				return nil, fmt.Errorf("Call %s error: status %d %w", request.Method, response.Status, response.Error)
+			}
 			return nil, fmt.Errorf("Call error: %+v", response)
 		}
 		return response, nil
-	case <-time.After(time.Second * 10):
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Call %s error: %w", request.Method, ctx.Err())
+	case <-time.After(CallTimeout):
 		return nil, fmt.Errorf("Call timeout waiting for response")
 	}
 }
@@ -138,3 +203,52 @@ func (f *FuturesAPIWebSocketPriceTicker) Response(resp *FuturesAPIWebSocketRespo
 	}
 	return f, nil
 }
+
+// FuturesAPIWebSocketPosition is one item of the result of v2/account.position
+type FuturesAPIWebSocketPosition struct {
+	Symbol           string          `json:"symbol"`
+	PositionSide     string          `json:"positionSide"`
+	PositionAmt      decimal.Decimal `json:"positionAmt"`
+	EntryPrice       decimal.Decimal `json:"entryPrice"`
+	BreakEvenPrice   decimal.Decimal `json:"breakEvenPrice"`
+	MarkPrice        decimal.Decimal `json:"markPrice"`
+	UnrealizedProfit decimal.Decimal `json:"unRealizedProfit"`
+	LiquidationPrice decimal.Decimal `json:"liquidationPrice"`
+	Notional         decimal.Decimal `json:"notional"`
+	MarginAsset      string          `json:"marginAsset"`
+	UpdateTime       int64           `json:"updateTime"`
+}
+
+type FuturesAPIWebSocketAccountPosition struct {
+	Symbol    string
+	Positions []*FuturesAPIWebSocketPosition
+}
+
+// NewFuturesAPIWebSocketAccountPosition lists the positions of symbol, or of every
+// symbol when it is empty
+func NewFuturesAPIWebSocketAccountPosition(symbol string) *FuturesAPIWebSocketAccountPosition {
+	return &FuturesAPIWebSocketAccountPosition{
+		Symbol: symbol,
+	}
+}
+
+func (f *FuturesAPIWebSocketAccountPosition) Request() *FuturesAPIWebSocketRequest {
+	params := map[string]interface{}{}
+	if f.Symbol != "" {
+		params["symbol"] = f.Symbol
+	}
+	paramsBytes, _ := json.Marshal(params)
+	return &FuturesAPIWebSocketRequest{
+		Method:   "v2/account.position",
+		Params:   paramsBytes,
+		Security: SecurityTypeSigned,
+	}
+}
+
+func (f *FuturesAPIWebSocketAccountPosition) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketAccountPosition, error) {
+	err := json.Unmarshal(resp.Result, &f.Positions)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
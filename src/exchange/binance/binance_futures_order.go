@@ -0,0 +1,238 @@
+package binance
+
+import (
+	"encoding/json"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OrderSideBuy  = "BUY"
+	OrderSideSell = "SELL"
+
+	OrderTypeLimit  = "LIMIT"
+	OrderTypeMarket = "MARKET"
+
+	TimeInForceGTC = "GTC"
+	TimeInForceIOC = "IOC"
+	TimeInForceFOK = "FOK"
+	TimeInForceGTX = "GTX"
+
+	OrderStatusNew             = "NEW"
+	OrderStatusPartiallyFilled = "PARTIALLY_FILLED"
+	OrderStatusFilled          = "FILLED"
+	OrderStatusCanceled        = "CANCELED"
+	OrderStatusRejected        = "REJECTED"
+	OrderStatusExpired         = "EXPIRED"
+)
+
+// FuturesAPIWebSocketOrder is the result of order.place, order.cancel, order.modify and order.status
+type FuturesAPIWebSocketOrder struct {
+	OrderID                 int64           `json:"orderId"`
+	Symbol                  string          `json:"symbol"`
+	Status                  string          `json:"status"`
+	ClientOrderID           string          `json:"clientOrderId"`
+	Price                   decimal.Decimal `json:"price"`
+	AvgPrice                decimal.Decimal `json:"avgPrice"`
+	OrigQty                 decimal.Decimal `json:"origQty"`
+	ExecutedQty             decimal.Decimal `json:"executedQty"`
+	CumQuote                decimal.Decimal `json:"cumQuote"`
+	TimeInForce             string          `json:"timeInForce"`
+	Type                    string          `json:"type"`
+	ReduceOnly              bool            `json:"reduceOnly"`
+	ClosePosition           bool            `json:"closePosition"`
+	Side                    string          `json:"side"`
+	PositionSide            string          `json:"positionSide"`
+	StopPrice               decimal.Decimal `json:"stopPrice"`
+	WorkingType             string          `json:"workingType"`
+	PriceProtect            bool            `json:"priceProtect"`
+	OrigType                string          `json:"origType"`
+	PriceMatch              string          `json:"priceMatch"`
+	SelfTradePreventionMode string          `json:"selfTradePreventionMode"`
+	GoodTillDate            int64           `json:"goodTillDate"`
+	Time                    int64           `json:"time"`
+	UpdateTime              int64           `json:"updateTime"`
+}
+
+func orderResponse(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketOrder, error) {
+	order := &FuturesAPIWebSocketOrder{}
+	err := json.Unmarshal(resp.Result, order)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// orderID sets orderId or origClientOrderId, Binance requires one of them
+func orderID(params map[string]interface{}, id int64, origClientOrderID string) {
+	if id != 0 {
+		params["orderId"] = id
+	}
+	if origClientOrderID != "" {
+		params["origClientOrderId"] = origClientOrderID
+	}
+}
+
+type FuturesAPIWebSocketOrderPlace struct {
+	Symbol           string
+	Side             string
+	PositionSide     string
+	Type             string
+	TimeInForce      string
+	Quantity         decimal.Decimal
+	Price            decimal.Decimal
+	ReduceOnly       bool
+	NewClientOrderID string
+}
+
+// NewFuturesAPIWebSocketOrderPlace builds a market order, set Price and TimeInForce for a limit order
+func NewFuturesAPIWebSocketOrderPlace(symbol string, side string, quantity decimal.Decimal) *FuturesAPIWebSocketOrderPlace {
+	return &FuturesAPIWebSocketOrderPlace{
+		Symbol:   symbol,
+		Side:     side,
+		Type:     OrderTypeMarket,
+		Quantity: quantity,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderPlace) Limit(price decimal.Decimal, timeInForce string) *FuturesAPIWebSocketOrderPlace {
+	f.Type = OrderTypeLimit
+	f.Price = price
+	f.TimeInForce = timeInForce
+	return f
+}
+
+func (f *FuturesAPIWebSocketOrderPlace) Request() *FuturesAPIWebSocketRequest {
+	params := map[string]interface{}{
+		"symbol":   f.Symbol,
+		"side":     f.Side,
+		"type":     f.Type,
+		"quantity": f.Quantity.String(),
+	}
+	if f.PositionSide != "" {
+		params["positionSide"] = f.PositionSide
+	}
+	if f.Type == OrderTypeLimit {
+		params["price"] = f.Price.String()
+		params["timeInForce"] = f.TimeInForce
+	}
+	if f.ReduceOnly {
+		params["reduceOnly"] = "true"
+	}
+	if f.NewClientOrderID != "" {
+		params["newClientOrderId"] = f.NewClientOrderID
+	}
+	paramsBytes, _ := json.Marshal(params)
+	return &FuturesAPIWebSocketRequest{
+		Method:   "order.place",
+		Params:   paramsBytes,
+		Security: SecurityTypeSigned,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderPlace) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketOrder, error) {
+	return orderResponse(resp)
+}
+
+type FuturesAPIWebSocketOrderCancel struct {
+	Symbol            string
+	OrderID           int64
+	OrigClientOrderID string
+}
+
+func NewFuturesAPIWebSocketOrderCancel(symbol string, orderID int64, origClientOrderID string) *FuturesAPIWebSocketOrderCancel {
+	return &FuturesAPIWebSocketOrderCancel{
+		Symbol:            symbol,
+		OrderID:           orderID,
+		OrigClientOrderID: origClientOrderID,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderCancel) Request() *FuturesAPIWebSocketRequest {
+	params := map[string]interface{}{
+		"symbol": f.Symbol,
+	}
+	orderID(params, f.OrderID, f.OrigClientOrderID)
+	paramsBytes, _ := json.Marshal(params)
+	return &FuturesAPIWebSocketRequest{
+		Method:   "order.cancel",
+		Params:   paramsBytes,
+		Security: SecurityTypeSigned,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderCancel) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketOrder, error) {
+	return orderResponse(resp)
+}
+
+// FuturesAPIWebSocketOrderModify changes price and quantity of an open limit order
+type FuturesAPIWebSocketOrderModify struct {
+	Symbol            string
+	OrderID           int64
+	OrigClientOrderID string
+	Side              string
+	Quantity          decimal.Decimal
+	Price             decimal.Decimal
+}
+
+func NewFuturesAPIWebSocketOrderModify(symbol string, orderID int64, origClientOrderID string, side string, quantity decimal.Decimal, price decimal.Decimal) *FuturesAPIWebSocketOrderModify {
+	return &FuturesAPIWebSocketOrderModify{
+		Symbol:            symbol,
+		OrderID:           orderID,
+		OrigClientOrderID: origClientOrderID,
+		Side:              side,
+		Quantity:          quantity,
+		Price:             price,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderModify) Request() *FuturesAPIWebSocketRequest {
+	params := map[string]interface{}{
+		"symbol":   f.Symbol,
+		"side":     f.Side,
+		"quantity": f.Quantity.String(),
+		"price":    f.Price.String(),
+	}
+	orderID(params, f.OrderID, f.OrigClientOrderID)
+	paramsBytes, _ := json.Marshal(params)
+	return &FuturesAPIWebSocketRequest{
+		Method:   "order.modify",
+		Params:   paramsBytes,
+		Security: SecurityTypeSigned,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderModify) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketOrder, error) {
+	return orderResponse(resp)
+}
+
+type FuturesAPIWebSocketOrderStatus struct {
+	Symbol            string
+	OrderID           int64
+	OrigClientOrderID string
+}
+
+func NewFuturesAPIWebSocketOrderStatus(symbol string, orderID int64, origClientOrderID string) *FuturesAPIWebSocketOrderStatus {
+	return &FuturesAPIWebSocketOrderStatus{
+		Symbol:            symbol,
+		OrderID:           orderID,
+		OrigClientOrderID: origClientOrderID,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderStatus) Request() *FuturesAPIWebSocketRequest {
+	params := map[string]interface{}{
+		"symbol": f.Symbol,
+	}
+	orderID(params, f.OrderID, f.OrigClientOrderID)
+	paramsBytes, _ := json.Marshal(params)
+	return &FuturesAPIWebSocketRequest{
+		Method:   "order.status",
+		Params:   paramsBytes,
+		Security: SecurityTypeSigned,
+	}
+}
+
+func (f *FuturesAPIWebSocketOrderStatus) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketOrder, error) {
+	return orderResponse(resp)
+}
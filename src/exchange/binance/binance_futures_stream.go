@@ -7,100 +7,308 @@ import (
 	"strings"
 	"time"
 	"trade/src/common"
+	"trade/src/market"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/shopspring/decimal"
 )
 
+const (
+	// FuturesStreamWebSocketSubscribeBatchSize is the max number of streams sent in one
+	// SUBSCRIBE request when replaying subscriptions after a reconnect
+	FuturesStreamWebSocketSubscribeBatchSize = 50
+	// FuturesStreamWebSocketSubscribeInterval keeps replayed SUBSCRIBE requests under
+	// the limit of 10 incoming messages per second
+	FuturesStreamWebSocketSubscribeInterval = 200 * time.Millisecond
+)
+
 type FuturesStreamWebSocketRequest struct {
 	ID     string   `json:"id"`
 	Method string   `json:"method"`
 	Params []string `json:"params"`
 }
 
+// FuturesStreamWebSocketStream is either a stream event (Stream and Data) or the reply
+// to a SUBSCRIBE/UNSUBSCRIBE request (ID with Result or Error)
 type FuturesStreamWebSocketStream struct {
-	Stream string          `json:"stream"`
-	Data   json.RawMessage `json:"data"`
+	Stream string                    `json:"stream"`
+	Data   json.RawMessage           `json:"data"`
+	ID     string                    `json:"id"`
+	Result json.RawMessage           `json:"result"`
+	Error  *FuturesAPIWebSocketError `json:"error"`
 }
 
 func (c *Client) InitFuturesStreamWebSocketConnection(ctx context.Context) error {
+	if c.replay != nil {
+		return nil
+	}
 	go c.ReadFuturesStreamWebSocketMessages(ctx)
 	return c.ConnectFuturesStreamWebSocket(ctx)
 }
 
 func (c *Client) ReadFuturesStreamWebSocketMessages(ctx context.Context) {
 	defer common.HandlePanic()
+	// unblock ReadMessage once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		if conn := c.futuresStreamWebSocketConn.Load(); conn != nil {
+			conn.Close()
+		}
+	})
+	defer stop()
 	for {
-		if c.futuresStreamWebSocketConn == nil {
-			time.Sleep(100 * time.Millisecond)
+		if c.closed.Load() || ctx.Err() != nil {
+			return
+		}
+		conn := c.futuresStreamWebSocketConn.Load()
+		if conn == nil {
+			if !common.Sleep(ctx, 100*time.Millisecond) {
+				return
+			}
 			continue
 		}
-		_, message, err := c.futuresStreamWebSocketConn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			if c.closed {
+			if c.closed.Load() || ctx.Err() != nil {
 				return
 			}
 			common.Logger.Sugar().Warnf("ReadFuturesStreamWebSocketMessages ReadMessage error: %v", err)
 			c.ReconnectFuturesStreamWebSocket(ctx)
 			continue
 		}
-		var stream FuturesStreamWebSocketStream
-		err = json.Unmarshal(message, &stream)
-		if err != nil {
-			common.Logger.Sugar().Warnf("ReadFuturesStreamWebSocketMessages Unmarshal error: %v %s", err, string(message))
-			continue
-		}
-		if hand, ok := c.futuresStreamWebSocketHandlers[stream.Stream]; ok {
-			hand(&stream)
-		} else {
-			common.Logger.Sugar().Warnf("ReadFuturesStreamWebSocketMessages No handler for message: %s", string(message))
+		if c.recorder != nil {
+			c.recorder.Record(market.ExchangeBinance, time.Now().UnixMilli(), message)
 		}
+		c.handleFuturesStreamWebSocketMessage(message)
+	}
+}
+
+// handleFuturesStreamWebSocketMessage dispatches a frame of the stream connection, read
+// or replayed, to the handler of its stream
+func (c *Client) handleFuturesStreamWebSocketMessage(message []byte) {
+	var stream FuturesStreamWebSocketStream
+	err := json.Unmarshal(message, &stream)
+	if err != nil {
+		common.Logger.Sugar().Warnf("ReadFuturesStreamWebSocketMessages Unmarshal error: %v %s", err, string(message))
+		return
+	}
+	if stream.Stream == "" && stream.ID != "" {
+		c.handleFuturesStreamWebSocketResponse(&stream)
+		return
+	}
+	c.mu.RLock()
+	hand, ok := c.futuresStreamWebSocketHandlers[stream.Stream]
+	c.mu.RUnlock()
+	if ok {
+		hand(&stream)
+	} else {
+		common.Logger.Sugar().Warnf("ReadFuturesStreamWebSocketMessages No handler for message: %s", string(message))
+	}
+}
+
+func (c *Client) handleFuturesStreamWebSocketResponse(stream *FuturesStreamWebSocketStream) {
+	c.mu.RLock()
+	ch, ok := c.futuresStreamWebSocketResponses[stream.ID]
+	c.mu.RUnlock()
+	if ok {
+		ch <- stream
+		return
+	}
+	if stream.Error != nil {
+		common.Logger.Sugar().Errorf("ReadFuturesStreamWebSocketMessages error response: %s %v", stream.ID, stream.Error)
 	}
 }
 
 func (c *Client) ConnectFuturesStreamWebSocket(ctx context.Context) error {
-	var err error
-	c.futuresStreamWebSocketConn, _, err = websocket.DefaultDialer.DialContext(ctx, FuturesStreamWebSocketBaseURL, nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.futuresStreamWebSocketURL, nil)
 	if err != nil {
 		return err
 	}
+	c.futuresStreamWebSocketConn.Store(conn)
 	return nil
 }
 
 func (c *Client) ReconnectFuturesStreamWebSocket(ctx context.Context) {
+	if conn := c.futuresStreamWebSocketConn.Load(); conn != nil {
+		conn.Close()
+	}
 	for i := 0; i < 5; i++ {
-		time.Sleep(time.Second)
+		if !common.Sleep(ctx, time.Second) {
+			return
+		}
 		err := c.ConnectFuturesStreamWebSocket(ctx)
 		if err != nil {
 			common.Logger.Sugar().Warnf("ReconnectFuturesStreamWebSocket error %v", err)
 			continue
-		} else {
-			common.Logger.Sugar().Infof("ReconnectFuturesStreamWebSocket success after %d attempts", i+1)
-			break
+		}
+		common.Logger.Sugar().Infof("ReconnectFuturesStreamWebSocket success after %d attempts", i+1)
+		err = c.resubscribeFuturesStreamWebSocket()
+		if err != nil {
+			common.Logger.Sugar().Warnf("ReconnectFuturesStreamWebSocket resubscribe error %v", err)
+		}
+		c.mu.RLock()
+		handlers := c.futuresStreamWebSocketReconnectHandlers
+		c.mu.RUnlock()
+		for _, handler := range handlers {
+			handler()
+		}
+		break
+	}
+}
+
+// resubscribeFuturesStreamWebSocket replays every remembered SUBSCRIBE request on the
+// current connection, merged into batches of FuturesStreamWebSocketSubscribeBatchSize streams
+func (c *Client) resubscribeFuturesStreamWebSocket() error {
+	var (
+		streams []string
+		seen    = make(map[string]bool)
+	)
+	c.mu.RLock()
+	for _, subscribe := range c.futuresStreamWebSocketSubscriptions {
+		for _, stream := range subscribe.Params {
+			if !seen[stream] {
+				seen[stream] = true
+				streams = append(streams, stream)
+			}
 		}
 	}
+	c.mu.RUnlock()
+	conn := c.futuresStreamWebSocketConn.Load()
+	for start := 0; start < len(streams); start += FuturesStreamWebSocketSubscribeBatchSize {
+		if start > 0 {
+			time.Sleep(FuturesStreamWebSocketSubscribeInterval)
+		}
+		end := min(start+FuturesStreamWebSocketSubscribeBatchSize, len(streams))
+		subscribe := &FuturesStreamWebSocketRequest{
+			ID:     uuid.New().String(),
+			Method: "SUBSCRIBE",
+			Params: streams[start:end],
+		}
+		err := writeJSON(&c.futuresStreamWebSocketWriteMu, conn, subscribe)
+		if err != nil {
+			return err
+		}
+	}
+	common.Logger.Sugar().Infof("resubscribeFuturesStreamWebSocket %d streams", len(streams))
+	return nil
 }
 
+// OnFuturesStreamWebSocketReconnect registers a handler called after the stream
+// connection is re-established and all subscriptions have been replayed
+func (c *Client) OnFuturesStreamWebSocketReconnect(handler func()) {
+	if handler == nil {
+		return
+	}
+	c.mu.Lock()
+	c.futuresStreamWebSocketReconnectHandlers = append(c.futuresStreamWebSocketReconnectHandlers, handler)
+	c.mu.Unlock()
+}
+
+// Subscribe sends the SUBSCRIBE request and waits for its reply, an error reply is
+// returned to the caller and the streams of the request are forgotten
 func (c *Client) Subscribe(subscribe *FuturesStreamWebSocketRequest, handler func(*FuturesStreamWebSocketStream)) error {
-	if c.futuresStreamWebSocketConn == nil {
+	if c.replay != nil {
+		return c.subscribeReplay(subscribe, handler)
+	}
+	conn := c.futuresStreamWebSocketConn.Load()
+	if conn == nil {
 		return fmt.Errorf("SubscribeFuturesStreamMarketPrice futuresStreamWebSocketConn is nil")
 	}
 	if subscribe == nil || handler == nil {
 		return fmt.Errorf("SubscribeFuturesStreamMarketPrice stream/handler is empty")
 	}
+	id := uuid.New().String()
+	subscribe.ID = id
+	ch := make(chan *FuturesStreamWebSocketStream, 1)
+	// remember the request before writing it so a reconnect in between still replays it
+	c.mu.Lock()
 	for _, stream := range subscribe.Params {
 		c.futuresStreamWebSocketHandlers[stream] = handler
 	}
-	id := uuid.New().String()
-	subscribe.ID = id
-	err := c.futuresStreamWebSocketConn.WriteJSON(subscribe)
+	c.futuresStreamWebSocketSubscriptions = append(c.futuresStreamWebSocketSubscriptions, subscribe)
+	c.futuresStreamWebSocketResponses[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.futuresStreamWebSocketResponses, id)
+		c.mu.Unlock()
+	}()
+	err := writeJSON(&c.futuresStreamWebSocketWriteMu, conn, subscribe)
 	if err != nil {
+		c.forgetFuturesStreams(subscribe.Params)
 		return fmt.Errorf("SubscribeFuturesStreamMarketPrice WriteJSON error: %v", err)
 	}
+	select {
+	case response := <-ch:
+		if response.Error != nil {
+			c.forgetFuturesStreams(subscribe.Params)
+			return fmt.Errorf("SubscribeFuturesStreamMarketPrice error: %w", response.Error)
+		}
+		return nil
+	case <-time.After(time.Second * 10):
+		c.forgetFuturesStreams(subscribe.Params)
+		return fmt.Errorf("SubscribeFuturesStreamMarketPrice timeout waiting for response")
+	}
+}
+
+// subscribeReplay only registers the handler, the replayed frames hold the replies of
+// the recorded subscriptions
+func (c *Client) subscribeReplay(subscribe *FuturesStreamWebSocketRequest, handler func(*FuturesStreamWebSocketStream)) error {
+	if subscribe == nil || handler == nil {
+		return fmt.Errorf("SubscribeFuturesStreamMarketPrice stream/handler is empty")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stream := range subscribe.Params {
+		c.futuresStreamWebSocketHandlers[stream] = handler
+	}
+	return nil
+}
+
+// Unsubscribe sends UNSUBSCRIBE for the streams of request and forgets them, so they are
+// neither dispatched nor replayed after a reconnect
+func (c *Client) Unsubscribe(unsubscribe *FuturesStreamWebSocketRequest) error {
+	conn := c.futuresStreamWebSocketConn.Load()
+	if conn == nil {
+		return fmt.Errorf("UnsubscribeFuturesStream futuresStreamWebSocketConn is nil")
+	}
+	if unsubscribe == nil {
+		return fmt.Errorf("UnsubscribeFuturesStream request is nil")
+	}
+	unsubscribe.Method = "UNSUBSCRIBE"
+	unsubscribe.ID = uuid.New().String()
+	c.forgetFuturesStreams(unsubscribe.Params)
+	err := writeJSON(&c.futuresStreamWebSocketWriteMu, conn, unsubscribe)
+	if err != nil {
+		return fmt.Errorf("UnsubscribeFuturesStream WriteJSON error: %v", err)
+	}
 	return nil
 }
 
+// forgetFuturesStreams removes the handlers of streams and drops them from the replayed subscriptions
+func (c *Client) forgetFuturesStreams(streams []string) {
+	removed := make(map[string]bool, len(streams))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stream := range streams {
+		delete(c.futuresStreamWebSocketHandlers, stream)
+		removed[stream] = true
+	}
+	subscriptions := make([]*FuturesStreamWebSocketRequest, 0, len(c.futuresStreamWebSocketSubscriptions))
+	for _, subscribe := range c.futuresStreamWebSocketSubscriptions {
+		params := make([]string, 0, len(subscribe.Params))
+		for _, stream := range subscribe.Params {
+			if !removed[stream] {
+				params = append(params, stream)
+			}
+		}
+		if len(params) > 0 {
+			subscriptions = append(subscriptions, &FuturesStreamWebSocketRequest{ID: subscribe.ID, Method: subscribe.Method, Params: params})
+		}
+	}
+	c.futuresStreamWebSocketSubscriptions = subscriptions
+}
+
 type FuturesStreamWebSocketMarketPrice struct {
 	EventType            string          `json:"e"`
 	EventTime            int64           `json:"E"`
@@ -133,3 +341,185 @@ func (f *FuturesStreamWebSocketMarketPrice) Stream(stream *FuturesStreamWebSocke
 	}
 	return f, nil
 }
+
+// FuturesStreamWebSocketMarketPrices is the !markPrice@arr stream, one event per second
+// with the mark price and funding of every perpetual
+type FuturesStreamWebSocketMarketPrices struct {
+	Prices []*FuturesStreamWebSocketMarketPrice
+}
+
+func NewFuturesStreamWebSocketMarketPrices() *FuturesStreamWebSocketMarketPrices {
+	return &FuturesStreamWebSocketMarketPrices{}
+}
+
+func (f *FuturesStreamWebSocketMarketPrices) Subscribe() *FuturesStreamWebSocketRequest {
+	return &FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{"!markPrice@arr"},
+	}
+}
+
+func (f *FuturesStreamWebSocketMarketPrices) Stream(stream *FuturesStreamWebSocketStream) (*FuturesStreamWebSocketMarketPrices, error) {
+	err := json.Unmarshal(stream.Data, &f.Prices)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type FuturesStreamWebSocketBookTicker struct {
+	EventType       string          `json:"e"`
+	UpdateID        int64           `json:"u"`
+	EventTime       int64           `json:"E"`
+	TransactionTime int64           `json:"T"`
+	Symbol          string          `json:"s"`
+	BidPrice        decimal.Decimal `json:"b"`
+	BidQuantity     decimal.Decimal `json:"B"`
+	AskPrice        decimal.Decimal `json:"a"`
+	AskQuantity     decimal.Decimal `json:"A"`
+}
+
+func NewFuturesStreamWebSocketBookTicker(symbol string) *FuturesStreamWebSocketBookTicker {
+	return &FuturesStreamWebSocketBookTicker{
+		Symbol: symbol,
+	}
+}
+
+func (f *FuturesStreamWebSocketBookTicker) Subscribe() *FuturesStreamWebSocketRequest {
+	stream := strings.ToLower(f.Symbol) + "@bookTicker"
+	return &FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{stream},
+	}
+}
+
+func (f *FuturesStreamWebSocketBookTicker) Stream(stream *FuturesStreamWebSocketStream) (*FuturesStreamWebSocketBookTicker, error) {
+	err := json.Unmarshal(stream.Data, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type FuturesStreamWebSocketAggTrade struct {
+	EventType    string          `json:"e"`
+	EventTime    int64           `json:"E"`
+	Symbol       string          `json:"s"`
+	AggTradeID   int64           `json:"a"`
+	Price        decimal.Decimal `json:"p"`
+	Quantity     decimal.Decimal `json:"q"`
+	FirstTradeID int64           `json:"f"`
+	LastTradeID  int64           `json:"l"`
+	TradeTime    int64           `json:"T"`
+	IsBuyerMaker bool            `json:"m"`
+}
+
+func NewFuturesStreamWebSocketAggTrade(symbol string) *FuturesStreamWebSocketAggTrade {
+	return &FuturesStreamWebSocketAggTrade{
+		Symbol: symbol,
+	}
+}
+
+func (f *FuturesStreamWebSocketAggTrade) Subscribe() *FuturesStreamWebSocketRequest {
+	stream := strings.ToLower(f.Symbol) + "@aggTrade"
+	return &FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{stream},
+	}
+}
+
+func (f *FuturesStreamWebSocketAggTrade) Stream(stream *FuturesStreamWebSocketStream) (*FuturesStreamWebSocketAggTrade, error) {
+	err := json.Unmarshal(stream.Data, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FuturesStreamWebSocketDepth is one diff of the local order book, every level is
+// [price, quantity] and a zero quantity removes the level. U and u are the first and
+// final update ids of the event and pu is the final update id of the previous event
+type FuturesStreamWebSocketDepth struct {
+	EventType         string               `json:"e"`
+	EventTime         int64                `json:"E"`
+	TransactionTime   int64                `json:"T"`
+	Symbol            string               `json:"s"`
+	FirstUpdateID     int64                `json:"U"`
+	FinalUpdateID     int64                `json:"u"`
+	PrevFinalUpdateID int64                `json:"pu"`
+	Bids              [][2]decimal.Decimal `json:"b"`
+	Asks              [][2]decimal.Decimal `json:"a"`
+}
+
+func NewFuturesStreamWebSocketDepth(symbol string) *FuturesStreamWebSocketDepth {
+	return &FuturesStreamWebSocketDepth{
+		Symbol: symbol,
+	}
+}
+
+func (f *FuturesStreamWebSocketDepth) Subscribe() *FuturesStreamWebSocketRequest {
+	stream := strings.ToLower(f.Symbol) + "@depth@100ms"
+	return &FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{stream},
+	}
+}
+
+func (f *FuturesStreamWebSocketDepth) Stream(stream *FuturesStreamWebSocketStream) (*FuturesStreamWebSocketDepth, error) {
+	err := json.Unmarshal(stream.Data, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type FuturesStreamWebSocketKline struct {
+	EventType string                   `json:"e"`
+	EventTime int64                    `json:"E"`
+	Symbol    string                   `json:"s"`
+	Interval  string                   `json:"-"`
+	Kline     FuturesStreamKlineDetail `json:"k"`
+}
+
+// FuturesStreamKlineDetail is the current kline, IsClosed is set on its final update
+type FuturesStreamKlineDetail struct {
+	OpenTime            int64           `json:"t"`
+	CloseTime           int64           `json:"T"`
+	Symbol              string          `json:"s"`
+	Interval            string          `json:"i"`
+	FirstTradeID        int64           `json:"f"`
+	LastTradeID         int64           `json:"L"`
+	Open                decimal.Decimal `json:"o"`
+	Close               decimal.Decimal `json:"c"`
+	High                decimal.Decimal `json:"h"`
+	Low                 decimal.Decimal `json:"l"`
+	Volume              decimal.Decimal `json:"v"`
+	Trades              int64           `json:"n"`
+	IsClosed            bool            `json:"x"`
+	QuoteVolume         decimal.Decimal `json:"q"`
+	TakerBuyVolume      decimal.Decimal `json:"V"`
+	TakerBuyQuoteVolume decimal.Decimal `json:"Q"`
+}
+
+func NewFuturesStreamWebSocketKline(symbol string, interval string) *FuturesStreamWebSocketKline {
+	return &FuturesStreamWebSocketKline{
+		Symbol:   symbol,
+		Interval: interval,
+	}
+}
+
+func (f *FuturesStreamWebSocketKline) Subscribe() *FuturesStreamWebSocketRequest {
+	stream := strings.ToLower(f.Symbol) + "@kline_" + f.Interval
+	return &FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{stream},
+	}
+}
+
+func (f *FuturesStreamWebSocketKline) Stream(stream *FuturesStreamWebSocketStream) (*FuturesStreamWebSocketKline, error) {
+	err := json.Unmarshal(stream.Data, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
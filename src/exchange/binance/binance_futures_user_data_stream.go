@@ -0,0 +1,304 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"trade/src/common"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// FuturesUserDataStreamKeepAlive is how often the listenKey is pinged, it expires
+	// after 60 minutes without a ping
+	FuturesUserDataStreamKeepAlive = 30 * time.Minute
+
+	FuturesUserDataEventOrderTradeUpdate = "ORDER_TRADE_UPDATE"
+	FuturesUserDataEventAccountUpdate    = "ACCOUNT_UPDATE"
+	FuturesUserDataEventMarginCall       = "MARGIN_CALL"
+	FuturesUserDataEventListenKeyExpired = "listenKeyExpired"
+)
+
+// FuturesAPIWebSocketUserDataStream is the request and result of userDataStream.start,
+// userDataStream.ping and userDataStream.stop
+type FuturesAPIWebSocketUserDataStream struct {
+	method    string
+	ListenKey string `json:"listenKey"`
+}
+
+func NewFuturesAPIWebSocketUserDataStreamStart() *FuturesAPIWebSocketUserDataStream {
+	return &FuturesAPIWebSocketUserDataStream{method: "userDataStream.start"}
+}
+
+func NewFuturesAPIWebSocketUserDataStreamPing() *FuturesAPIWebSocketUserDataStream {
+	return &FuturesAPIWebSocketUserDataStream{method: "userDataStream.ping"}
+}
+
+func NewFuturesAPIWebSocketUserDataStreamStop() *FuturesAPIWebSocketUserDataStream {
+	return &FuturesAPIWebSocketUserDataStream{method: "userDataStream.stop"}
+}
+
+func (f *FuturesAPIWebSocketUserDataStream) Request() *FuturesAPIWebSocketRequest {
+	return &FuturesAPIWebSocketRequest{
+		Method:   f.method,
+		Params:   json.RawMessage("{}"),
+		Security: SecurityTypeUserStream,
+	}
+}
+
+func (f *FuturesAPIWebSocketUserDataStream) Response(resp *FuturesAPIWebSocketResponse) (*FuturesAPIWebSocketUserDataStream, error) {
+	err := json.Unmarshal(resp.Result, f)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FuturesStreamWebSocketUserDataEvent holds the fields shared by every user data event,
+// it is decoded first to find the concrete type
+type FuturesStreamWebSocketUserDataEvent struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+}
+
+type FuturesStreamWebSocketOrderTradeUpdate struct {
+	EventType       string                             `json:"e"`
+	EventTime       int64                              `json:"E"`
+	TransactionTime int64                              `json:"T"`
+	Order           *FuturesStreamWebSocketOrderUpdate `json:"o"`
+}
+
+type FuturesStreamWebSocketOrderUpdate struct {
+	Symbol          string          `json:"s"`
+	ClientOrderID   string          `json:"c"`
+	Side            string          `json:"S"`
+	OrderType       string          `json:"o"`
+	TimeInForce     string          `json:"f"`
+	OrigQty         decimal.Decimal `json:"q"`
+	Price           decimal.Decimal `json:"p"`
+	AvgPrice        decimal.Decimal `json:"ap"`
+	StopPrice       decimal.Decimal `json:"sp"`
+	ExecutionType   string          `json:"x"`
+	OrderStatus     string          `json:"X"`
+	OrderID         int64           `json:"i"`
+	LastFilledQty   decimal.Decimal `json:"l"`
+	CumFilledQty    decimal.Decimal `json:"z"`
+	LastFilledPrice decimal.Decimal `json:"L"`
+	CommissionAsset string          `json:"N"`
+	Commission      decimal.Decimal `json:"n"`
+	TradeTime       int64           `json:"T"`
+	TradeID         int64           `json:"t"`
+	IsMaker         bool            `json:"m"`
+	ReduceOnly      bool            `json:"R"`
+	PositionSide    string          `json:"ps"`
+	RealizedProfit  decimal.Decimal `json:"rp"`
+}
+
+type FuturesStreamWebSocketAccountUpdate struct {
+	EventType       string                                   `json:"e"`
+	EventTime       int64                                    `json:"E"`
+	TransactionTime int64                                    `json:"T"`
+	Account         *FuturesStreamWebSocketAccountUpdateData `json:"a"`
+}
+
+type FuturesStreamWebSocketAccountUpdateData struct {
+	Reason    string                            `json:"m"`
+	Balances  []*FuturesStreamWebSocketBalance  `json:"B"`
+	Positions []*FuturesStreamWebSocketPosition `json:"P"`
+}
+
+type FuturesStreamWebSocketBalance struct {
+	Asset              string          `json:"a"`
+	WalletBalance      decimal.Decimal `json:"wb"`
+	CrossWalletBalance decimal.Decimal `json:"cw"`
+	BalanceChange      decimal.Decimal `json:"bc"`
+}
+
+type FuturesStreamWebSocketPosition struct {
+	Symbol              string          `json:"s"`
+	PositionAmount      decimal.Decimal `json:"pa"`
+	EntryPrice          decimal.Decimal `json:"ep"`
+	BreakevenPrice      decimal.Decimal `json:"bep"`
+	AccumulatedRealized decimal.Decimal `json:"cr"`
+	UnrealizedPnl       decimal.Decimal `json:"up"`
+	MarginType          string          `json:"mt"`
+	IsolatedWallet      decimal.Decimal `json:"iw"`
+	PositionSide        string          `json:"ps"`
+}
+
+type FuturesStreamWebSocketMarginCall struct {
+	EventType          string                                      `json:"e"`
+	EventTime          int64                                       `json:"E"`
+	CrossWalletBalance decimal.Decimal                             `json:"cw"`
+	Positions          []*FuturesStreamWebSocketMarginCallPosition `json:"p"`
+}
+
+type FuturesStreamWebSocketMarginCallPosition struct {
+	Symbol            string          `json:"s"`
+	PositionSide      string          `json:"ps"`
+	PositionAmount    decimal.Decimal `json:"pa"`
+	MarginType        string          `json:"mt"`
+	IsolatedWallet    decimal.Decimal `json:"iw"`
+	MarkPrice         decimal.Decimal `json:"mp"`
+	UnrealizedPnl     decimal.Decimal `json:"up"`
+	MaintenanceMargin decimal.Decimal `json:"mm"`
+}
+
+type FuturesStreamWebSocketListenKeyExpired struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	ListenKey string `json:"listenKey"`
+}
+
+// FuturesUserDataStream keeps a listenKey alive and dispatches the decoded user data events.
+// The listenKey is created with userDataStream.start on the api connection, pinged every
+// keepAlive and subscribed on the stream connection, a new one is created when it expires
+type FuturesUserDataStream struct {
+	cli       *Client
+	keepAlive time.Duration
+	expired   chan string
+	mu        sync.Mutex
+	listenKey string
+
+	OnOrderTradeUpdate func(*FuturesStreamWebSocketOrderTradeUpdate)
+	OnAccountUpdate    func(*FuturesStreamWebSocketAccountUpdate)
+	OnMarginCall       func(*FuturesStreamWebSocketMarginCall)
+}
+
+// NewFuturesUserDataStream needs a Client with an apiKey whose api and stream connections
+// are initialized
+func NewFuturesUserDataStream(cli *Client, keepAlive time.Duration) *FuturesUserDataStream {
+	if keepAlive <= 0 {
+		keepAlive = FuturesUserDataStreamKeepAlive
+	}
+	return &FuturesUserDataStream{
+		cli:       cli,
+		keepAlive: keepAlive,
+		expired:   make(chan string, 1),
+	}
+}
+
+func (s *FuturesUserDataStream) ListenKey() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listenKey
+}
+
+func (s *FuturesUserDataStream) Run(ctx context.Context) error {
+	err := s.start()
+	if err != nil {
+		return err
+	}
+	go s.keepAliveLoop(ctx)
+	return nil
+}
+
+// start creates a listenKey, subscribes it and drops the subscription of the previous one
+func (s *FuturesUserDataStream) start() error {
+	start := NewFuturesAPIWebSocketUserDataStreamStart()
+	resp, err := s.cli.Call(start.Request())
+	if err != nil {
+		return fmt.Errorf("FuturesUserDataStream start error: %w", err)
+	}
+	_, err = start.Response(resp)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	previous := s.listenKey
+	s.listenKey = start.ListenKey
+	s.mu.Unlock()
+	if previous == start.ListenKey {
+		return nil
+	}
+	if previous != "" {
+		err = s.cli.Unsubscribe(&FuturesStreamWebSocketRequest{Params: []string{previous}})
+		if err != nil {
+			common.Logger.Sugar().Warnf("FuturesUserDataStream Unsubscribe %s error: %v", previous, err)
+		}
+	}
+	err = s.cli.Subscribe(&FuturesStreamWebSocketRequest{
+		Method: "SUBSCRIBE",
+		Params: []string{start.ListenKey},
+	}, s.handle)
+	if err != nil {
+		return fmt.Errorf("FuturesUserDataStream Subscribe error: %w", err)
+	}
+	common.Logger.Sugar().Infof("FuturesUserDataStream started listenKey %s", start.ListenKey)
+	return nil
+}
+
+// keepAliveLoop returns once ctx is cancelled without sending userDataStream.stop, the api
+// connection closes with ctx so the listenKey is left to expire
+func (s *FuturesUserDataStream) keepAliveLoop(ctx context.Context) {
+	defer common.HandlePanic()
+	ticker := time.NewTicker(s.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.cli.Call(NewFuturesAPIWebSocketUserDataStreamPing().Request())
+			if err == nil {
+				continue
+			}
+			common.Logger.Sugar().Warnf("FuturesUserDataStream ping error: %v", err)
+		case listenKey := <-s.expired:
+			if listenKey != s.ListenKey() {
+				continue
+			}
+			common.Logger.Sugar().Warnf("FuturesUserDataStream listenKey %s expired", listenKey)
+		}
+		err := s.start()
+		if err != nil {
+			common.Logger.Sugar().Errorf("FuturesUserDataStream restart error: %v", err)
+		}
+	}
+}
+
+func (s *FuturesUserDataStream) handle(stream *FuturesStreamWebSocketStream) {
+	var event FuturesStreamWebSocketUserDataEvent
+	err := json.Unmarshal(stream.Data, &event)
+	if err != nil {
+		common.Logger.Sugar().Warnf("FuturesUserDataStream Unmarshal error: %v %s", err, string(stream.Data))
+		return
+	}
+	switch event.EventType {
+	case FuturesUserDataEventOrderTradeUpdate:
+		var update FuturesStreamWebSocketOrderTradeUpdate
+		if err = json.Unmarshal(stream.Data, &update); err == nil && s.OnOrderTradeUpdate != nil {
+			s.OnOrderTradeUpdate(&update)
+		}
+	case FuturesUserDataEventAccountUpdate:
+		var update FuturesStreamWebSocketAccountUpdate
+		if err = json.Unmarshal(stream.Data, &update); err == nil && s.OnAccountUpdate != nil {
+			s.OnAccountUpdate(&update)
+		}
+	case FuturesUserDataEventMarginCall:
+		var call FuturesStreamWebSocketMarginCall
+		if err = json.Unmarshal(stream.Data, &call); err == nil && s.OnMarginCall != nil {
+			s.OnMarginCall(&call)
+		}
+	case FuturesUserDataEventListenKeyExpired:
+		var expired FuturesStreamWebSocketListenKeyExpired
+		if err = json.Unmarshal(stream.Data, &expired); err == nil {
+			if expired.ListenKey == "" {
+				expired.ListenKey = stream.Stream
+			}
+			// restart on the keep alive goroutine, Call must not block the stream reader
+			select {
+			case s.expired <- expired.ListenKey:
+			default:
+			}
+		}
+	default:
+		common.Logger.Sugar().Infof("FuturesUserDataStream unhandled event: %s", string(stream.Data))
+	}
+	if err != nil {
+		common.Logger.Sugar().Warnf("FuturesUserDataStream %s Unmarshal error: %v %s", event.EventType, err, string(stream.Data))
+	}
+}
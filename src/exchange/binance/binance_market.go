@@ -0,0 +1,302 @@
+package binance
+
+import (
+	"context"
+	"strings"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+var _ market.MarketDataSource = (*Client)(nil)
+
+func (c *Client) Exchange() string {
+	return market.ExchangeBinance
+}
+
+func (c *Client) InitMarketData(ctx context.Context) error {
+	return c.InitFuturesStreamWebSocketConnection(ctx)
+}
+
+func (c *Client) OnMarketDataReconnect(handler func()) {
+	c.OnFuturesStreamWebSocketReconnect(handler)
+}
+
+func (c *Client) SubscribeMarkPrice(symbol string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewFuturesStreamWebSocketMarketPrice(symbol).Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		localTime := c.now()
+		price, err := NewFuturesStreamWebSocketMarketPrice(symbol).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeMarkPrice %s Stream error: %v", symbol, err)
+			return
+		}
+		handler(&market.Tick{
+			Exchange:     market.ExchangeBinance,
+			Symbol:       price.Symbol,
+			Type:         market.TickTypeMarkPrice,
+			Price:        price.MarkPrice,
+			ExchangeTime: price.EventTime,
+			LocalTime:    localTime,
+		})
+	})
+}
+
+func (c *Client) SubscribeBookTicker(symbol string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewFuturesStreamWebSocketBookTicker(symbol).Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		localTime := c.now()
+		book, err := NewFuturesStreamWebSocketBookTicker(symbol).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeBookTicker %s Stream error: %v", symbol, err)
+			return
+		}
+		handler(market.NewBookTick(market.ExchangeBinance, book.Symbol, book.BidPrice, book.BidQuantity, book.AskPrice, book.AskQuantity, book.EventTime, localTime))
+	})
+}
+
+func (c *Client) SubscribeTrades(symbol string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewFuturesStreamWebSocketAggTrade(symbol).Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		localTime := c.now()
+		trade, err := NewFuturesStreamWebSocketAggTrade(symbol).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeTrades %s Stream error: %v", symbol, err)
+			return
+		}
+		// the buyer is the maker when the taker sold
+		side := market.SideBuy
+		if trade.IsBuyerMaker {
+			side = market.SideSell
+		}
+		handler(&market.Tick{
+			Exchange:     market.ExchangeBinance,
+			Symbol:       trade.Symbol,
+			Type:         market.TickTypeTrade,
+			Price:        trade.Price,
+			Quantity:     trade.Quantity,
+			Side:         side,
+			ExchangeTime: trade.TradeTime,
+			LocalTime:    localTime,
+		})
+	})
+}
+
+var _ market.InstrumentSource = (*Client)(nil)
+
+// LoadInstruments lists the perpetual contracts of exchangeInfo, one order unit is one base asset
+func (c *Client) LoadInstruments(ctx context.Context) ([]*market.Instrument, error) {
+	info, err := c.GetFuturesExchangeInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instruments := []*market.Instrument{}
+	for _, symbol := range info.Symbols {
+		if symbol.ContractType != ContractTypePerpetual {
+			continue
+		}
+		status := market.InstrumentStatusSuspended
+		switch symbol.Status {
+		case SymbolStatusTrading:
+			status = market.InstrumentStatusTrading
+		case SymbolStatusPendingTrading:
+			status = market.InstrumentStatusPending
+		}
+		lotSize := symbol.Filter(FilterTypeLotSize)
+		instruments = append(instruments, &market.Instrument{
+			ID:            market.InstrumentID(symbol.BaseAsset, symbol.QuoteAsset, symbol.MarginAsset),
+			Exchange:      market.ExchangeBinance,
+			Symbol:        symbol.Symbol,
+			Base:          symbol.BaseAsset,
+			Quote:         symbol.QuoteAsset,
+			Settle:        symbol.MarginAsset,
+			TickSize:      symbol.Filter(FilterTypePrice).TickSize,
+			LotSize:       lotSize.StepSize,
+			MinQuantity:   lotSize.MinQty,
+			ContractValue: decimal.NewFromInt(1),
+			MinNotional:   symbol.Filter(FilterTypeMinNotional).Notional,
+			Status:        status,
+		})
+	}
+	return instruments, nil
+}
+
+var _ market.CandleSource = (*Client)(nil)
+
+func (c *Client) SubscribeCandles(symbol string, interval market.CandleInterval, handler func(*market.Candle)) error {
+	if err := interval.Validate(); err != nil {
+		return err
+	}
+	return c.Subscribe(NewFuturesStreamWebSocketKline(symbol, string(interval)).Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		kline, err := NewFuturesStreamWebSocketKline(symbol, string(interval)).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeCandles %s Stream error: %v", symbol, err)
+			return
+		}
+		k := kline.Kline
+		handler(&market.Candle{
+			Exchange:    market.ExchangeBinance,
+			Symbol:      kline.Symbol,
+			Interval:    interval,
+			OpenTime:    k.OpenTime,
+			CloseTime:   k.CloseTime,
+			Open:        k.Open,
+			High:        k.High,
+			Low:         k.Low,
+			Close:       k.Close,
+			Volume:      k.Volume,
+			QuoteVolume: k.QuoteVolume,
+			Closed:      k.IsClosed,
+		})
+	})
+}
+
+// LoadCandles pages through /fapi/v1/klines FuturesKlinesLimit klines at a time
+func (c *Client) LoadCandles(ctx context.Context, symbol string, interval market.CandleInterval, start int64, end int64) ([]*market.Candle, error) {
+	if err := interval.Validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	candles := []*market.Candle{}
+	for start < end {
+		klines, err := c.GetFuturesKlines(ctx, symbol, string(interval), start, end-1, FuturesKlinesLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range klines {
+			candles = append(candles, &market.Candle{
+				Exchange:    market.ExchangeBinance,
+				Symbol:      strings.ToUpper(symbol),
+				Interval:    interval,
+				OpenTime:    k.OpenTime,
+				CloseTime:   k.CloseTime,
+				Open:        k.Open,
+				High:        k.High,
+				Low:         k.Low,
+				Close:       k.Close,
+				Volume:      k.Volume,
+				QuoteVolume: k.QuoteVolume,
+				Closed:      k.CloseTime < now,
+			})
+		}
+		if len(klines) < FuturesKlinesLimit {
+			break
+		}
+		start = klines[len(klines)-1].OpenTime + 1
+	}
+	return candles, nil
+}
+
+// DefaultFundingInterval is the funding interval of the symbols missing from fundingInfo
+const DefaultFundingInterval = 8 * time.Hour
+
+var _ market.FundingSource = (*Client)(nil)
+
+// fundingSubscription is the !markPrice@arr subscription shared by SubscribeFunding,
+// done is closed once err holds its result. symbols are the handlers registered while
+// it was in flight, removed again when it fails
+type fundingSubscription struct {
+	done    chan struct{}
+	err     error
+	symbols []string
+}
+
+// SubscribeFunding streams the funding of symbol, every symbol shares one !markPrice@arr
+// subscription. Binance publishes no predicted rate, Rate is the running estimate of the
+// period settled at FundingTime. A call made while the subscription is in flight waits
+// for its result
+func (c *Client) SubscribeFunding(symbol string, handler func(*market.Funding)) error {
+	symbol = strings.ToUpper(symbol)
+	c.mu.Lock()
+	c.fundingHandlers[symbol] = handler
+	s := c.fundingSubscription
+	first := s == nil
+	if first {
+		s = &fundingSubscription{done: make(chan struct{})}
+		c.fundingSubscription = s
+	}
+	select {
+	case <-s.done:
+		c.mu.Unlock()
+		return nil
+	default:
+		s.symbols = append(s.symbols, symbol)
+	}
+	c.mu.Unlock()
+	if !first {
+		<-s.done
+		return s.err
+	}
+	c.loadFundingIntervals()
+	err := c.Subscribe(NewFuturesStreamWebSocketMarketPrices().Subscribe(), c.handleFunding)
+	c.mu.Lock()
+	if err != nil {
+		c.fundingSubscription = nil
+		for _, symbol := range s.symbols {
+			delete(c.fundingHandlers, symbol)
+		}
+	}
+	s.err = err
+	close(s.done)
+	c.mu.Unlock()
+	return err
+}
+
+// loadFundingIntervals falls back to DefaultFundingInterval for every symbol when
+// fundingInfo cannot be fetched
+func (c *Client) loadFundingIntervals() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := c.GetFuturesFundingInfo(ctx)
+	if err != nil {
+		common.Logger.Sugar().Warnf("SubscribeFunding GetFuturesFundingInfo error: %v", err)
+		return
+	}
+	intervals := make(map[string]time.Duration, len(info))
+	for _, i := range info {
+		if i.FundingIntervalHours > 0 {
+			intervals[i.Symbol] = time.Duration(i.FundingIntervalHours) * time.Hour
+		}
+	}
+	c.mu.Lock()
+	c.fundingIntervals = intervals
+	c.mu.Unlock()
+}
+
+func (c *Client) handleFunding(stream *FuturesStreamWebSocketStream) {
+	localTime := c.now()
+	prices, err := NewFuturesStreamWebSocketMarketPrices().Stream(stream)
+	if err != nil {
+		common.Logger.Sugar().Warnf("SubscribeFunding Stream error: %v", err)
+		return
+	}
+	type update struct {
+		handler func(*market.Funding)
+		funding *market.Funding
+	}
+	updates := []update{}
+	c.mu.RLock()
+	for _, price := range prices.Prices {
+		handler, ok := c.fundingHandlers[price.Symbol]
+		if !ok {
+			continue
+		}
+		interval, ok := c.fundingIntervals[price.Symbol]
+		if !ok {
+			interval = DefaultFundingInterval
+		}
+		updates = append(updates, update{handler, &market.Funding{
+			Exchange:        market.ExchangeBinance,
+			Symbol:          price.Symbol,
+			Rate:            price.FundingRate,
+			FundingTime:     price.NextFundingTime,
+			NextFundingTime: price.NextFundingTime + interval.Milliseconds(),
+			Interval:        interval,
+			ExchangeTime:    price.EventTime,
+			LocalTime:       localTime,
+		}})
+	}
+	c.mu.RUnlock()
+	for _, u := range updates {
+		u.handler(u.funding)
+	}
+}
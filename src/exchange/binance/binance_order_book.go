@@ -0,0 +1,157 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+// FuturesDepthSnapshotLimit is the number of levels per side of the REST snapshot
+// used to sync a local order book
+const FuturesDepthSnapshotLimit = 1000
+
+var _ market.OrderBookSource = (*Client)(nil)
+
+// SubscribeOrderBook maintains a local book of symbol from the @depth@100ms diff stream.
+// The book is synced with a REST snapshot and resynced whenever the pu/u chain breaks,
+// it is empty until the first sync completes. handler is called on the reader goroutine
+// after every applied diff
+func (c *Client) SubscribeOrderBook(symbol string, handler func(*market.OrderBook)) (*market.OrderBook, error) {
+	s := &futuresOrderBookSync{
+		client: c,
+		book:   market.NewOrderBook(market.ExchangeBinance, strings.ToUpper(symbol)),
+	}
+	err := c.Subscribe(NewFuturesStreamWebSocketDepth(symbol).Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		depth, err := NewFuturesStreamWebSocketDepth(symbol).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeOrderBook %s Stream error: %v", symbol, err)
+			return
+		}
+		if s.onDepth(depth) && handler != nil {
+			handler(s.book)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.book, nil
+}
+
+// futuresOrderBookSync follows the Binance procedure to manage a local order book: the
+// diffs are buffered while a snapshot is fetched outside the reader goroutine, the diffs
+// older than the snapshot are dropped and the first applied one must contain it
+type futuresOrderBookSync struct {
+	client  *Client
+	book    *market.OrderBook
+	mu      sync.Mutex
+	synced  bool
+	syncing bool
+	// first is set until the first diff after the snapshot is applied
+	first    bool
+	lastU    int64
+	buffered []*FuturesStreamWebSocketDepth
+}
+
+// onDepth applies or buffers one diff and reports whether the book changed
+func (s *futuresOrderBookSync) onDepth(depth *FuturesStreamWebSocketDepth) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.synced {
+		s.buffered = append(s.buffered, depth)
+		s.startSync()
+		return false
+	}
+	applied, err := s.apply(depth)
+	if err != nil {
+		common.Logger.Sugar().Warnf("SubscribeOrderBook %s resync: %v", s.book.Symbol, err)
+		s.synced = false
+		s.book.Clear()
+		s.buffered = []*FuturesStreamWebSocketDepth{depth}
+		s.startSync()
+		return false
+	}
+	return applied
+}
+
+// apply must be called with mu held, it returns false for a diff older than the snapshot
+func (s *futuresOrderBookSync) apply(depth *FuturesStreamWebSocketDepth) (bool, error) {
+	if s.first {
+		if depth.FinalUpdateID < s.lastU {
+			return false, nil
+		}
+		if depth.FirstUpdateID > s.lastU {
+			return false, fmt.Errorf("snapshot %d is older than diff U %d", s.lastU, depth.FirstUpdateID)
+		}
+		s.first = false
+	} else if depth.PrevFinalUpdateID != s.lastU {
+		return false, fmt.Errorf("diff pu %d does not follow u %d", depth.PrevFinalUpdateID, s.lastU)
+	}
+	s.book.Apply(levels(depth.Bids), levels(depth.Asks), depth.FinalUpdateID, depth.TransactionTime)
+	s.lastU = depth.FinalUpdateID
+	return true, nil
+}
+
+// startSync must be called with mu held
+func (s *futuresOrderBookSync) startSync() {
+	if s.syncing {
+		return
+	}
+	s.syncing = true
+	go s.sync()
+}
+
+// sync fetches snapshots until one can be followed by the buffered diffs
+func (s *futuresOrderBookSync) sync() {
+	defer common.HandlePanic()
+	for attempt := 0; !s.client.closed.Load(); attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		snapshot, err := s.client.GetFuturesDepth(ctx, s.book.Symbol, FuturesDepthSnapshotLimit)
+		cancel()
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeOrderBook %s GetFuturesDepth error: %v", s.book.Symbol, err)
+			continue
+		}
+		if err := s.applySnapshot(snapshot); err != nil {
+			common.Logger.Sugar().Warnf("SubscribeOrderBook %s snapshot error: %v", s.book.Symbol, err)
+			continue
+		}
+		common.Logger.Sugar().Infof("SubscribeOrderBook %s synced at %d", s.book.Symbol, s.book.UpdateID())
+		return
+	}
+}
+
+func (s *futuresOrderBookSync) applySnapshot(snapshot *FuturesDepth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.book.Reset(levels(snapshot.Bids), levels(snapshot.Asks), snapshot.LastUpdateID, snapshot.TransactionTime)
+	s.lastU = snapshot.LastUpdateID
+	s.first = true
+	for i, depth := range s.buffered {
+		if _, err := s.apply(depth); err != nil {
+			s.buffered = s.buffered[i:]
+			s.book.Clear()
+			return err
+		}
+	}
+	s.buffered = nil
+	s.synced = true
+	s.syncing = false
+	return nil
+}
+
+func levels(items [][2]decimal.Decimal) []market.Level {
+	result := make([]market.Level, 0, len(items))
+	for _, item := range items {
+		result = append(result, market.Level{Price: item[0], Quantity: item[1]})
+	}
+	return result
+}
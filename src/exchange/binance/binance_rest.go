@@ -0,0 +1,199 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ContractTypePerpetual = "PERPETUAL"
+
+	SymbolStatusTrading        = "TRADING"
+	SymbolStatusPendingTrading = "PENDING_TRADING"
+
+	FilterTypePrice       = "PRICE_FILTER"
+	FilterTypeLotSize     = "LOT_SIZE"
+	FilterTypeMinNotional = "MIN_NOTIONAL"
+)
+
+// get sends an unsigned GET to the futures REST API and decodes the JSON body into v,
+// error bodies are returned as *FuturesAPIWebSocketError since both APIs share the codes
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := c.futuresRESTURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &FuturesAPIWebSocketError{}
+		if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Msg == "" {
+			return fmt.Errorf("get %s error: status %d %s", path, resp.StatusCode, string(body))
+		}
+		return fmt.Errorf("get %s error: status %d %w", path, resp.StatusCode, apiErr)
+	}
+	return json.Unmarshal(body, v)
+}
+
+type FuturesExchangeInfo struct {
+	Timezone   string                       `json:"timezone"`
+	ServerTime int64                        `json:"serverTime"`
+	Symbols    []*FuturesExchangeInfoSymbol `json:"symbols"`
+}
+
+type FuturesExchangeInfoSymbol struct {
+	Symbol       string                       `json:"symbol"`
+	Pair         string                       `json:"pair"`
+	ContractType string                       `json:"contractType"`
+	Status       string                       `json:"status"`
+	BaseAsset    string                       `json:"baseAsset"`
+	QuoteAsset   string                       `json:"quoteAsset"`
+	MarginAsset  string                       `json:"marginAsset"`
+	Filters      []*FuturesExchangeInfoFilter `json:"filters"`
+}
+
+// FuturesExchangeInfoFilter holds the fields of every filter type used here, the
+// fields not belonging to FilterType are zero
+type FuturesExchangeInfoFilter struct {
+	FilterType string          `json:"filterType"`
+	TickSize   decimal.Decimal `json:"tickSize"`
+	MinPrice   decimal.Decimal `json:"minPrice"`
+	MaxPrice   decimal.Decimal `json:"maxPrice"`
+	StepSize   decimal.Decimal `json:"stepSize"`
+	MinQty     decimal.Decimal `json:"minQty"`
+	MaxQty     decimal.Decimal `json:"maxQty"`
+	Notional   decimal.Decimal `json:"notional"`
+}
+
+func (s *FuturesExchangeInfoSymbol) Filter(filterType string) *FuturesExchangeInfoFilter {
+	for _, filter := range s.Filters {
+		if filter.FilterType == filterType {
+			return filter
+		}
+	}
+	return &FuturesExchangeInfoFilter{FilterType: filterType}
+}
+
+// GetFuturesExchangeInfo fetches /fapi/v1/exchangeInfo
+func (c *Client) GetFuturesExchangeInfo(ctx context.Context) (*FuturesExchangeInfo, error) {
+	info := &FuturesExchangeInfo{}
+	err := c.get(ctx, "/fapi/v1/exchangeInfo", nil, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// FuturesDepth is an order book snapshot, every level is [price, quantity]
+type FuturesDepth struct {
+	LastUpdateID    int64                `json:"lastUpdateId"`
+	EventTime       int64                `json:"E"`
+	TransactionTime int64                `json:"T"`
+	Bids            [][2]decimal.Decimal `json:"bids"`
+	Asks            [][2]decimal.Decimal `json:"asks"`
+}
+
+// GetFuturesDepth fetches /fapi/v1/depth, limit is one of 5, 10, 20, 50, 100, 500 or 1000
+func (c *Client) GetFuturesDepth(ctx context.Context, symbol string, limit int) (*FuturesDepth, error) {
+	depth := &FuturesDepth{}
+	query := url.Values{"symbol": {strings.ToUpper(symbol)}, "limit": {strconv.Itoa(limit)}}
+	err := c.get(ctx, "/fapi/v1/depth", query, depth)
+	if err != nil {
+		return nil, err
+	}
+	return depth, nil
+}
+
+// FuturesFundingInfo lists the funding settings of the symbols whose cap, floor or
+// interval were adjusted, the other symbols settle every DefaultFundingInterval
+type FuturesFundingInfo struct {
+	Symbol                   string          `json:"symbol"`
+	AdjustedFundingRateCap   decimal.Decimal `json:"adjustedFundingRateCap"`
+	AdjustedFundingRateFloor decimal.Decimal `json:"adjustedFundingRateFloor"`
+	FundingIntervalHours     int             `json:"fundingIntervalHours"`
+}
+
+func (c *Client) GetFuturesFundingInfo(ctx context.Context) ([]*FuturesFundingInfo, error) {
+	info := []*FuturesFundingInfo{}
+	err := c.get(ctx, "/fapi/v1/fundingInfo", nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// FuturesKlinesLimit is the max number of klines returned by one /fapi/v1/klines request
+const FuturesKlinesLimit = 1500
+
+// FuturesKline is one row of /fapi/v1/klines
+type FuturesKline struct {
+	OpenTime            int64
+	Open                decimal.Decimal
+	High                decimal.Decimal
+	Low                 decimal.Decimal
+	Close               decimal.Decimal
+	Volume              decimal.Decimal
+	CloseTime           int64
+	QuoteVolume         decimal.Decimal
+	Trades              int64
+	TakerBuyVolume      decimal.Decimal
+	TakerBuyQuoteVolume decimal.Decimal
+}
+
+// UnmarshalJSON decodes the array form [openTime, open, high, low, close, volume,
+// closeTime, quoteVolume, trades, takerBuyVolume, takerBuyQuoteVolume, ignore]
+func (k *FuturesKline) UnmarshalJSON(data []byte) error {
+	var row []json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	if len(row) < 11 {
+		return fmt.Errorf("FuturesKline invalid row: %s", string(data))
+	}
+	for i, field := range []interface{}{
+		&k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume,
+		&k.CloseTime, &k.QuoteVolume, &k.Trades, &k.TakerBuyVolume, &k.TakerBuyQuoteVolume,
+	} {
+		if err := json.Unmarshal(row[i], field); err != nil {
+			return fmt.Errorf("FuturesKline field %d error: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// GetFuturesKlines fetches /fapi/v1/klines opened from startTime to endTime in
+// milliseconds, zero times are omitted and limit is at most FuturesKlinesLimit
+func (c *Client) GetFuturesKlines(ctx context.Context, symbol string, interval string, startTime int64, endTime int64, limit int) ([]*FuturesKline, error) {
+	query := url.Values{"symbol": {strings.ToUpper(symbol)}, "interval": {interval}, "limit": {strconv.Itoa(limit)}}
+	if startTime > 0 {
+		query.Set("startTime", strconv.FormatInt(startTime, 10))
+	}
+	if endTime > 0 {
+		query.Set("endTime", strconv.FormatInt(endTime, 10))
+	}
+	klines := []*FuturesKline{}
+	err := c.get(ctx, "/fapi/v1/klines", query, &klines)
+	if err != nil {
+		return nil, err
+	}
+	return klines, nil
+}
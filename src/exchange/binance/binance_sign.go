@@ -0,0 +1,148 @@
+package binance
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityType tells Call which credentials a ws-fapi method needs
+type SecurityType int
+
+const (
+	// SecurityTypeNone methods such as ticker.price are sent as is
+	SecurityTypeNone SecurityType = iota
+	// SecurityTypeUserStream methods only need the apiKey param
+	SecurityTypeUserStream
+	// SecurityTypeSigned methods need apiKey, timestamp and signature params
+	SecurityTypeSigned
+)
+
+// Signer signs the sorted query string of a request's params
+type Signer interface {
+	Sign(payload string) (string, error)
+}
+
+type hmacSigner struct {
+	secret []byte
+}
+
+// NewHMACSigner signs with HMAC-SHA256, the signature is hex encoded
+func NewHMACSigner(secret string) Signer {
+	return &hmacSigner{secret: []byte(secret)}
+}
+
+func (s *hmacSigner) Sign(payload string) (string, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer signs with an Ed25519 key, the signature is base64 encoded
+func NewEd25519Signer(key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{key: key}
+}
+
+// NewEd25519SignerFromPEM parses a PKCS#8 PEM private key as generated for Binance Ed25519 API keys
+func NewEd25519SignerFromPEM(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("NewEd25519SignerFromPEM no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("NewEd25519SignerFromPEM key is %T not ed25519", key)
+	}
+	return NewEd25519Signer(edKey), nil
+}
+
+func (s *ed25519Signer) Sign(payload string) (string, error) {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(payload))), nil
+}
+
+// signRequest adds apiKey and, for signed methods, timestamp, recvWindow and signature to the params
+func (c *Client) signRequest(request *FuturesAPIWebSocketRequest) error {
+	if c.apiKey == "" {
+		return fmt.Errorf("signRequest apiKey is empty")
+	}
+	params := make(map[string]interface{})
+	if len(request.Params) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(request.Params))
+		decoder.UseNumber()
+		err := decoder.Decode(&params)
+		if err != nil {
+			return fmt.Errorf("signRequest decode params error: %v", err)
+		}
+	}
+	params["apiKey"] = c.apiKey
+	if request.Security == SecurityTypeSigned {
+		if c.signer == nil {
+			return fmt.Errorf("signRequest signer is nil")
+		}
+		delete(params, "signature")
+		params["timestamp"] = time.Now().UnixMilli()
+		if c.recvWindow > 0 {
+			params["recvWindow"] = c.recvWindow
+		}
+		signature, err := c.signer.Sign(SignaturePayload(params))
+		if err != nil {
+			return fmt.Errorf("signRequest sign error: %v", err)
+		}
+		params["signature"] = signature
+	}
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	request.Params = paramsBytes
+	return nil
+}
+
+// SignaturePayload is the params sorted by key and formatted as key=value joined by &
+func SignaturePayload(params map[string]interface{}) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString("&")
+		}
+		sb.WriteString(key)
+		sb.WriteString("=")
+		switch v := params[key].(type) {
+		case string:
+			sb.WriteString(v)
+		case json.Number:
+			sb.WriteString(v.String())
+		case int64:
+			sb.WriteString(strconv.FormatInt(v, 10))
+		case bool:
+			sb.WriteString(strconv.FormatBool(v))
+		default:
+			sb.WriteString(fmt.Sprint(v))
+		}
+	}
+	return sb.String()
+}
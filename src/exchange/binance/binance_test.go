@@ -2,9 +2,19 @@ package binance
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"trade/src/common"
+	"trade/src/exchange/testserver"
+	"trade/src/market"
 
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,14 +22,80 @@ func TestBinance(t *testing.T) {
 	t.Run("FuturesAPI", func(t *testing.T) {
 		testFuturesAPIPriceTicker(t)
 	})
+	t.Run("FuturesAPIError", func(t *testing.T) {
+		testFuturesAPIError(t)
+	})
+	t.Run("FuturesAPIOrderHMAC", func(t *testing.T) {
+		testFuturesAPIOrder(t, func(server *testserver.Binance) Signer {
+			server.SetHMACCredentials("hmac-key", "hmac-secret")
+			return NewHMACSigner("hmac-secret")
+		}, "hmac-key")
+	})
+	t.Run("FuturesAPIOrderEd25519", func(t *testing.T) {
+		testFuturesAPIOrder(t, func(server *testserver.Binance) Signer {
+			public, private, _ := ed25519.GenerateKey(nil)
+			server.SetEd25519Credentials("ed25519-key", public)
+			return NewEd25519Signer(private)
+		}, "ed25519-key")
+	})
+	t.Run("FuturesAPIInvalidSignature", func(t *testing.T) {
+		testFuturesAPIInvalidSignature(t)
+	})
 	t.Run("FuturesStream", func(t *testing.T) {
 		testFuturesMarketPriceStream(t)
 	})
+	t.Run("FuturesStreamSubscribeError", func(t *testing.T) {
+		testFuturesStreamSubscribeError(t)
+	})
+	t.Run("FuturesStreamReconnect", func(t *testing.T) {
+		testFuturesStreamReconnect(t)
+	})
+	t.Run("FuturesUserDataStream", func(t *testing.T) {
+		testFuturesUserDataStream(t)
+	})
+	t.Run("ExecutionVenue", func(t *testing.T) {
+		testExecutionVenue(t)
+	})
+	t.Run("MarketData", func(t *testing.T) {
+		testMarketData(t)
+	})
+	t.Run("OrderBook", func(t *testing.T) {
+		testOrderBook(t)
+	})
+	t.Run("Candles", func(t *testing.T) {
+		testCandles(t)
+	})
+	t.Run("Funding", func(t *testing.T) {
+		testFunding(t)
+	})
+	t.Run("FundingSubscribeError", func(t *testing.T) {
+		testFundingSubscribeError(t)
+	})
+	t.Run("Instruments", func(t *testing.T) {
+		testInstruments(t)
+	})
+	t.Run("ReadCancel", func(t *testing.T) {
+		testReadCancel(t)
+	})
+	t.Run("RecordReplay", func(t *testing.T) {
+		testRecordReplay(t)
+	})
+}
+
+func newTestClient(t *testing.T, opts ...Option) (*Client, *testserver.Binance) {
+	server := testserver.NewBinance()
+	t.Cleanup(server.Close)
+	cli := NewClient(append([]Option{
+		WithFuturesAPIWebSocketURL(server.FuturesAPIWebSocketURL()),
+		WithFuturesStreamWebSocketURL(server.FuturesStreamWebSocketURL()),
+		WithFuturesRESTURL(server.FuturesRESTURL()),
+	}, opts...)...)
+	t.Cleanup(cli.Clean)
+	return cli, server
 }
 
 func testFuturesAPIPriceTicker(t *testing.T) {
-	cli := NewClient()
-	defer cli.Clean()
+	cli, _ := newTestClient(t)
 	err := cli.InitFuturesAPIWebSocketConnection(context.Background())
 	if err != nil {
 		t.Fatal(err)
@@ -38,29 +114,647 @@ func testFuturesAPIPriceTicker(t *testing.T) {
 	require.NotZero(t, ticker.Time)
 }
 
-func testFuturesMarketPriceStream(t *testing.T) {
-	cli := NewClient()
+func testFuturesAPIError(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.FailMethod("ticker.price", -1121, "Invalid symbol.")
+	err := cli.InitFuturesAPIWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Call(NewFuturesAPIWebSocketPriceTicker("WLFIUSDT").Request())
+	require.Error(t, err)
+}
+
+func testFuturesAPIOrder(t *testing.T, credentials func(*testserver.Binance) Signer, apiKey string) {
+	server := testserver.NewBinance()
+	defer server.Close()
+	signer := credentials(server)
+	cli := NewClient(
+		WithFuturesAPIWebSocketURL(server.FuturesAPIWebSocketURL()),
+		WithAPIKey(apiKey, signer),
+		WithRecvWindow(3000),
+	)
 	defer cli.Clean()
-	var count int
+	err := cli.InitFuturesAPIWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	place := NewFuturesAPIWebSocketOrderPlace("BTCUSDT", OrderSideBuy, decimal.RequireFromString("0.01")).
+		Limit(decimal.RequireFromString("90"), TimeInForceGTC)
+	resp, err := cli.Call(place.Request())
+	require.NoError(t, err)
+	order, err := place.Response(resp)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusNew, order.Status)
+	require.True(t, decimal.RequireFromString("0.01").Equal(order.OrigQty))
+
+	modify := NewFuturesAPIWebSocketOrderModify("BTCUSDT", order.OrderID, "", OrderSideBuy, decimal.RequireFromString("0.02"), decimal.RequireFromString("95"))
+	resp, err = cli.Call(modify.Request())
+	require.NoError(t, err)
+	order, err = modify.Response(resp)
+	require.NoError(t, err)
+	require.True(t, decimal.RequireFromString("95").Equal(order.Price))
+
+	status := NewFuturesAPIWebSocketOrderStatus("BTCUSDT", 0, order.ClientOrderID)
+	resp, err = cli.Call(status.Request())
+	require.NoError(t, err)
+	order, err = status.Response(resp)
+	require.NoError(t, err)
+	require.True(t, decimal.RequireFromString("0.02").Equal(order.OrigQty))
+
+	cancel := NewFuturesAPIWebSocketOrderCancel("BTCUSDT", order.OrderID, "")
+	resp, err = cli.Call(cancel.Request())
+	require.NoError(t, err)
+	order, err = cancel.Response(resp)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusCanceled, order.Status)
+
+	market := NewFuturesAPIWebSocketOrderPlace("BTCUSDT", OrderSideSell, decimal.RequireFromString("0.01"))
+	resp, err = cli.Call(market.Request())
+	require.NoError(t, err)
+	order, err = market.Response(resp)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusFilled, order.Status)
+	require.True(t, order.ExecutedQty.Equal(order.OrigQty))
+}
+
+func testFuturesAPIInvalidSignature(t *testing.T) {
+	cli, server := newTestClient(t, WithAPIKey("hmac-key", NewHMACSigner("wrong-secret")))
+	server.SetHMACCredentials("hmac-key", "hmac-secret")
+	err := cli.InitFuturesAPIWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Call(NewFuturesAPIWebSocketOrderStatus("BTCUSDT", 1, "").Request())
+	var apiErr *FuturesAPIWebSocketError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, int64(-1022), apiErr.Code)
+}
+
+func TestSignaturePayload(t *testing.T) {
+	payload := SignaturePayload(map[string]interface{}{
+		"symbol":     "BTCUSDT",
+		"timestamp":  int64(1702555533821),
+		"apiKey":     "key",
+		"quantity":   json.Number("0.1"),
+		"reduceOnly": true,
+	})
+	require.Equal(t, "apiKey=key&quantity=0.1&reduceOnly=true&symbol=BTCUSDT&timestamp=1702555533821", payload)
+}
+
+func testFuturesMarketPriceStream(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetMarkPrices("BTCUSDT", "100", "101", "102")
+	var count atomic.Int64
 	err := cli.InitFuturesStreamWebSocketConnection(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 	price := NewFuturesStreamWebSocketMarketPrice("BTCUSDT")
+	var (
+		mu   sync.Mutex
+		last decimal.Decimal
+	)
 	err = cli.Subscribe(price.Subscribe(), func(stream *FuturesStreamWebSocketStream) {
 		_, err := price.Stream(stream)
 		if err != nil {
 			t.Logf("FuturesMarketPriceStream Stream error: %v", err)
 			return
 		}
-		require.Equal(t, "BTCUSDT", price.Symbol)
-		require.NotZero(t, price.MarkPrice)
-		require.NotZero(t, price.EventTime)
-		count++
+		assert.Equal(t, "BTCUSDT", price.Symbol)
+		assert.NotZero(t, price.MarkPrice)
+		assert.NotZero(t, price.EventTime)
+		mu.Lock()
+		last = price.MarkPrice
+		mu.Unlock()
+		count.Add(1)
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(3 * time.Second)
-	require.Greater(t, count, 0)
+	require.Eventually(t, func() bool { return count.Load() >= 3 }, 3*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.True(t, decimal.NewFromInt(102).Equal(last))
+}
+
+func testMarketData(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetMarkPrices("BTCUSDT", "100", "101", "102")
+	err := cli.InitMarketData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		ticks = make(map[market.TickType]*market.Tick)
+	)
+	handler := func(tick *market.Tick) {
+		mu.Lock()
+		defer mu.Unlock()
+		ticks[tick.Type] = tick
+	}
+	require.NoError(t, cli.SubscribeMarkPrice("BTCUSDT", handler))
+	require.NoError(t, cli.SubscribeBookTicker("BTCUSDT", handler))
+	require.NoError(t, cli.SubscribeTrades("BTCUSDT", handler))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ticks) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, tick := range ticks {
+		assert.Equal(t, market.ExchangeBinance, tick.Exchange)
+		assert.Equal(t, "BTCUSDT", tick.Symbol)
+		assert.True(t, tick.Price.IsPositive())
+		assert.NotZero(t, tick.ExchangeTime)
+		assert.NotZero(t, tick.LocalTime)
+	}
+	book := ticks[market.TickTypeBookTicker]
+	assert.True(t, book.AskPrice.Sub(book.BidPrice).Equal(decimal.RequireFromString("0.2")))
+	assert.True(t, book.Price.Equal(book.BidPrice.Add(book.AskPrice).Div(decimal.NewFromInt(2))))
+	trade := ticks[market.TickTypeTrade]
+	assert.True(t, trade.Quantity.IsPositive())
+	assert.Contains(t, []string{market.SideBuy, market.SideSell}, trade.Side)
+}
+
+func testOrderBook(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	var updates atomic.Int64
+	book, err := cli.SubscribeOrderBook("BTCUSDT", func(*market.OrderBook) {
+		updates.Add(1)
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return updates.Load() >= 10
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, server.Requests(testserver.BinanceDepthPath))
+
+	bids, asks := book.Bids(0), book.Asks(0)
+	require.NotEmpty(t, bids)
+	require.NotEmpty(t, asks)
+	assert.LessOrEqual(t, len(bids), testserver.BookDepth)
+	assert.LessOrEqual(t, len(asks), testserver.BookDepth)
+	assert.True(t, bids[0].Price.LessThan(asks[0].Price))
+	price, ok := book.DepthAtSize(market.SideBuy, asks[0].Quantity.Add(asks[1].Quantity))
+	require.True(t, ok)
+	assert.True(t, price.Equal(asks[1].Price))
+
+	// a dropped diff breaks the pu chain and the book is synced again from a new snapshot
+	server.SkipDepthUpdate("BTCUSDT")
+	require.Eventually(t, func() bool {
+		return server.Requests(testserver.BinanceDepthPath) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	synced := updates.Load()
+	require.Eventually(t, func() bool {
+		_, ok := book.BestBid()
+		return ok && updates.Load() > synced+3
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, server.Requests(testserver.BinanceDepthPath))
+}
+
+func testCandles(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	candles := make(chan *market.Candle, 10)
+	err := cli.SubscribeCandles("BTCUSDT", market.CandleInterval1m, func(candle *market.Candle) {
+		select {
+		case candles <- candle:
+		default:
+		}
+	})
+	require.NoError(t, err)
+	select {
+	case candle := <-candles:
+		assert.Equal(t, "BTCUSDT", candle.Symbol)
+		assert.Equal(t, market.CandleInterval1m, candle.Interval)
+		assert.Zero(t, candle.OpenTime%time.Minute.Milliseconds())
+		assert.Equal(t, candle.OpenTime+time.Minute.Milliseconds()-1, candle.CloseTime)
+		assert.True(t, candle.High.GreaterThan(candle.Low))
+		assert.False(t, candle.Closed)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no candle received")
+	}
+	assert.Error(t, cli.SubscribeCandles("BTCUSDT", "7m", func(*market.Candle) {}))
+
+	// 2000 minutes take two pages of FuturesKlinesLimit
+	end := time.Now().Truncate(time.Minute).UnixMilli()
+	start := end - 2000*time.Minute.Milliseconds()
+	history, err := cli.LoadCandles(context.Background(), "BTCUSDT", market.CandleInterval1m, start, end)
+	require.NoError(t, err)
+	require.Len(t, history, 2000)
+	assert.Equal(t, 2, server.Requests(testserver.BinanceKlinesPath))
+	for i, candle := range history {
+		assert.Equal(t, start+int64(i)*time.Minute.Milliseconds(), candle.OpenTime)
+		assert.True(t, candle.Closed)
+	}
+}
+
+func testReadCancel(t *testing.T) {
+	read := func(ctx context.Context, cli *Client) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			cli.ReadFuturesStreamWebSocketMessages(ctx)
+			close(done)
+		}()
+		return done
+	}
+	wait := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("reader did not return after cancel")
+		}
+	}
+
+	// without a connection the reader stops waiting for one
+	cli := NewClient(WithFuturesStreamWebSocketURL("ws://127.0.0.1:1/stream"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := read(ctx, cli)
+	cancel()
+	wait(done)
+
+	// a blocked ReadMessage is unblocked and the reader does not reconnect
+	cli, _ = newTestClient(t)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, cli.ConnectFuturesStreamWebSocket(ctx))
+	done = read(ctx, cli)
+	require.NoError(t, cli.Subscribe(NewFuturesStreamWebSocketMarketPrice("BTCUSDT").Subscribe(), func(*FuturesStreamWebSocketStream) {}))
+	cancel()
+	wait(done)
+}
+
+func testInstruments(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetSymbols(
+		testserver.BinanceSymbol{Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", Status: "TRADING", TickSize: "0.10", StepSize: "0.001", MinQty: "0.002", MinNotional: "100"},
+		testserver.BinanceSymbol{Symbol: "WLFIUSDT", Base: "WLFI", Quote: "USDT", Status: "SETTLING", TickSize: "0.0001", StepSize: "1", MinQty: "1", MinNotional: "5"},
+	)
+	instruments, err := cli.LoadInstruments(context.Background())
+	require.NoError(t, err)
+	require.Len(t, instruments, 2)
+
+	btc := instruments[0]
+	assert.Equal(t, "BTC/USDT:USDT", btc.ID)
+	assert.Equal(t, market.ExchangeBinance, btc.Exchange)
+	assert.Equal(t, "BTCUSDT", btc.Symbol)
+	assert.True(t, btc.TickSize.Equal(decimal.RequireFromString("0.1")))
+	assert.True(t, btc.LotSize.Equal(decimal.RequireFromString("0.001")))
+	assert.True(t, btc.MinQuantity.Equal(decimal.RequireFromString("0.002")))
+	assert.True(t, btc.MinNotional.Equal(decimal.NewFromInt(100)))
+	assert.True(t, btc.ContractValue.Equal(decimal.NewFromInt(1)))
+	assert.True(t, btc.Trading())
+	assert.Equal(t, market.InstrumentStatusSuspended, instruments[1].Status)
+	assert.Equal(t, 1, server.Requests(testserver.BinanceExchangeInfoPath))
+}
+
+func testFuturesStreamSubscribeError(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.RejectStream("wlfiusdt@markPrice", 2, "Invalid request")
+	err := cli.InitFuturesStreamWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Subscribe(NewFuturesStreamWebSocketMarketPrice("WLFIUSDT").Subscribe(), func(*FuturesStreamWebSocketStream) {})
+	require.ErrorContains(t, err, "Invalid request")
+}
+
+func testFuturesStreamReconnect(t *testing.T) {
+	cli, server := newTestClient(t)
+	var count atomic.Int64
+	err := cli.InitFuturesStreamWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconnected := make(chan struct{}, 1)
+	cli.OnFuturesStreamWebSocketReconnect(func() { reconnected <- struct{}{} })
+	price := NewFuturesStreamWebSocketMarketPrice("ETHUSDT")
+	err = cli.Subscribe(price.Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Eventually(t, func() bool { return count.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+	server.DropStreamConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream connection was not re-established")
+	}
+	before := count.Load()
+	require.Eventually(t, func() bool { return count.Load() > before }, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, server.Requests("SUBSCRIBE"))
+}
+
+func testFuturesUserDataStream(t *testing.T) {
+	cli, server := newTestClient(t, WithAPIKey("hmac-key", NewHMACSigner("hmac-secret")))
+	server.SetHMACCredentials("hmac-key", "hmac-secret")
+	require.NoError(t, cli.InitFuturesAPIWebSocketConnection(context.Background()))
+	require.NoError(t, cli.InitFuturesStreamWebSocketConnection(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		orders      = make(chan *FuturesStreamWebSocketOrderTradeUpdate, 10)
+		accounts    = make(chan *FuturesStreamWebSocketAccountUpdate, 10)
+		marginCalls = make(chan *FuturesStreamWebSocketMarginCall, 10)
+	)
+	userData := NewFuturesUserDataStream(cli, 50*time.Millisecond)
+	userData.OnOrderTradeUpdate = func(update *FuturesStreamWebSocketOrderTradeUpdate) { orders <- update }
+	userData.OnAccountUpdate = func(update *FuturesStreamWebSocketAccountUpdate) { accounts <- update }
+	userData.OnMarginCall = func(call *FuturesStreamWebSocketMarginCall) { marginCalls <- call }
+	require.NoError(t, userData.Run(ctx))
+	firstKey := userData.ListenKey()
+	require.Equal(t, server.ListenKey(), firstKey)
+
+	placeOrder := func() {
+		_, err := cli.Call(NewFuturesAPIWebSocketOrderPlace("BTCUSDT", OrderSideBuy, decimal.RequireFromString("0.01")).Request())
+		require.NoError(t, err)
+		select {
+		case update := <-orders:
+			require.Equal(t, "BTCUSDT", update.Order.Symbol)
+			require.Equal(t, OrderStatusFilled, update.Order.OrderStatus)
+		case <-time.After(3 * time.Second):
+			t.Fatal("no ORDER_TRADE_UPDATE received")
+		}
+	}
+	placeOrder()
+
+	server.PushUserData(map[string]interface{}{
+		"e": "ACCOUNT_UPDATE", "E": 1, "T": 1,
+		"a": map[string]interface{}{
+			"m": "ORDER",
+			"B": []map[string]string{{"a": "USDT", "wb": "100", "cw": "100", "bc": "0"}},
+			"P": []map[string]string{{"s": "BTCUSDT", "pa": "0.01", "ep": "100", "bep": "100", "cr": "0", "up": "0", "mt": "cross", "iw": "0", "ps": "BOTH"}},
+		},
+	})
+	update := <-accounts
+	require.Equal(t, "USDT", update.Account.Balances[0].Asset)
+	require.True(t, decimal.RequireFromString("0.01").Equal(update.Account.Positions[0].PositionAmount))
+
+	server.PushUserData(map[string]interface{}{
+		"e": "MARGIN_CALL", "E": 1, "cw": "3.16",
+		"p": []map[string]string{{"s": "ETHUSDT", "ps": "LONG", "pa": "1.327", "mt": "CROSSED", "iw": "0", "mp": "187.17", "up": "-1.166", "mm": "1.614"}},
+	})
+	call := <-marginCalls
+	require.Equal(t, "ETHUSDT", call.Positions[0].Symbol)
+
+	require.Eventually(t, func() bool { return server.Requests("userDataStream.ping") > 0 }, 3*time.Second, 10*time.Millisecond)
+
+	server.ExpireListenKey()
+	require.Eventually(t, func() bool {
+		key := userData.ListenKey()
+		return key != firstKey && key == server.ListenKey()
+	}, 3*time.Second, 10*time.Millisecond)
+	placeOrder()
+}
+
+func testExecutionVenue(t *testing.T) {
+	d := decimal.RequireFromString
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, server := newTestClient(t, WithAPIKey("hmac-key", NewHMACSigner("hmac-secret")))
+	server.SetHMACCredentials("hmac-key", "hmac-secret")
+	server.SetMarkPrices("BTCUSDT", "100")
+	server.SetFillLimit("BTCUSDT", "0.004")
+	updates := make(chan *market.Order, 10)
+	cli.OnOrderUpdate(func(order *market.Order) { updates <- order })
+	require.NoError(t, cli.InitTrading(ctx))
+	require.NoError(t, cli.InitTrading(ctx), "InitTrading is idempotent")
+
+	next := func() *market.Order {
+		select {
+		case order := <-updates:
+			return order
+		case <-time.After(3 * time.Second):
+			t.Fatal("no order update received")
+			return nil
+		}
+	}
+	order, err := cli.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTCUSDT", Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: d("0.01")})
+	require.NoError(t, err)
+	require.Equal(t, market.OrderStatusPartiallyFilled, order.Status)
+	require.Equal(t, market.SideBuy, order.Side)
+	update := next()
+	require.Equal(t, order.ID, update.ID)
+	require.Equal(t, market.OrderStatusPartiallyFilled, update.Status)
+	require.True(t, d("0.004").Equal(update.FilledQuantity))
+
+	canceled, err := cli.CancelOrder(ctx, "BTCUSDT", order.ID)
+	require.NoError(t, err)
+	require.Equal(t, market.OrderStatusCanceled, canceled.Status)
+	require.Equal(t, market.OrderStatusCanceled, next().Status)
+	_, err = cli.CancelOrder(ctx, "BTCUSDT", order.ID)
+	require.Error(t, err, "a closed order can't be canceled")
+	positions, err := cli.GetPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.Equal(t, "BTCUSDT", positions[0].Symbol)
+	require.True(t, d("0.004").Equal(positions[0].Quantity))
+	require.True(t, d("100").Equal(positions[0].EntryPrice))
+
+	server.FailMethod("order.place", -2019, "Margin is insufficient.")
+	order, err = cli.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTCUSDT", Side: market.SideSell, Type: market.OrderTypeLimit, Price: d("110"), Quantity: d("0.01")})
+	require.NoError(t, err)
+	require.Equal(t, market.OrderStatusRejected, order.Status)
+	require.Contains(t, order.Reason, "-2019")
+
+	// the deadline of the caller bounds the wait for the response
+	release := make(chan struct{})
+	defer close(release)
+	server.HandleMethod("order.place", func(json.RawMessage) (interface{}, error) {
+		<-release
+		return nil, &testserver.BinanceError{Status: http.StatusBadRequest, Code: -1000, Msg: "released"}
+	})
+	deadline, cancelDeadline := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelDeadline()
+	begin := time.Now()
+	_, err = cli.PlaceOrder(deadline, &market.OrderRequest{Symbol: "BTCUSDT", Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: d("0.01")})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(begin), time.Second)
+	_, err = cli.CancelOrder(deadline, "BTCUSDT", "1")
+	require.ErrorIs(t, err, context.DeadlineExceeded, "nothing is sent after the deadline")
+}
+
+func TestClientConcurrency(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitFuturesAPIWebSocketConnection(context.Background()))
+	require.NoError(t, cli.InitFuturesStreamWebSocketConnection(context.Background()))
+
+	var (
+		wg       sync.WaitGroup
+		received atomic.Int64
+	)
+	symbols := []string{"BTCUSDT", "ETHUSDT", "SOLUSDT", "DOGEUSDT", "XRPUSDT"}
+	for _, symbol := range symbols {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				ticker := NewFuturesAPIWebSocketPriceTicker(symbol)
+				resp, err := cli.Call(ticker.Request())
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = ticker.Response(resp)
+				assert.NoError(t, err)
+				assert.Equal(t, symbol, ticker.Symbol)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			price := NewFuturesStreamWebSocketMarketPrice(symbol)
+			err := cli.Subscribe(price.Subscribe(), func(stream *FuturesStreamWebSocketStream) {
+				if _, err := price.Stream(stream); err == nil {
+					received.Add(1)
+				}
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return received.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+
+	// drop the stream connection while subscribing more streams and make sure the
+	// replayed subscriptions keep prices flowing
+	reconnected := make(chan struct{}, 1)
+	cli.OnFuturesStreamWebSocketReconnect(func() { reconnected <- struct{}{} })
+	server.DropStreamConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream connection was not re-established")
+	}
+	before := received.Load()
+	require.Eventually(t, func() bool { return received.Load() > before }, 3*time.Second, 10*time.Millisecond)
+}
+
+func testFunding(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetFunding("ETHUSDT", "-0.0002", 4*time.Hour)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	fundings := make(chan *market.Funding, 10)
+	handler := func(funding *market.Funding) {
+		select {
+		case fundings <- funding:
+		default:
+		}
+	}
+	marks := make(chan *market.Tick, 10)
+	require.NoError(t, cli.SubscribeMarkPrice("BTCUSDT", func(tick *market.Tick) {
+		select {
+		case marks <- tick:
+		default:
+		}
+	}))
+	require.NoError(t, cli.SubscribeFunding("BTCUSDT", handler))
+	require.NoError(t, cli.SubscribeFunding("ETHUSDT", handler))
+	assert.Equal(t, 1, server.Requests(testserver.BinanceFundingInfoPath))
+
+	received := map[string]*market.Funding{}
+	timeout := time.After(3 * time.Second)
+	for len(received) < 2 {
+		select {
+		case funding := <-fundings:
+			received[funding.Symbol] = funding
+		case <-timeout:
+			t.Fatalf("fundings received: %v", received)
+		}
+	}
+	btc := received["BTCUSDT"]
+	assert.True(t, btc.Rate.Equal(decimal.RequireFromString(testserver.DefaultFundingRate)))
+	assert.Equal(t, DefaultFundingInterval, btc.Interval)
+	assert.Zero(t, btc.FundingTime%btc.Interval.Milliseconds())
+	assert.Equal(t, btc.FundingTime+btc.Interval.Milliseconds(), btc.NextFundingTime)
+	assert.False(t, btc.PredictedRate.Valid)
+	eth := received["ETHUSDT"]
+	assert.True(t, eth.Rate.Equal(decimal.RequireFromString("-0.0002")))
+	assert.Equal(t, 4*time.Hour, eth.Interval)
+	assert.True(t, eth.AnnualizedRate().Equal(decimal.RequireFromString("-43.8")))
+
+	// the shared stream does not replace the per symbol mark price handler
+	select {
+	case tick := <-marks:
+		assert.Equal(t, "BTCUSDT", tick.Symbol)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no mark price received")
+	}
+}
+
+func testFundingSubscribeError(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.RejectStream("!markPrice@arr", 2, "Invalid request")
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	// the callers arriving while the subscription is in flight get its error too
+	symbols := []string{"BTCUSDT", "ETHUSDT", "SOLUSDT", "BNBUSDT"}
+	errs := make([]error, len(symbols))
+	var wg sync.WaitGroup
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = cli.SubscribeFunding(symbol, func(*market.Funding) {})
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		assert.ErrorContains(t, err, "Invalid request", symbols[i])
+	}
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	assert.Empty(t, cli.fundingHandlers)
+	assert.Nil(t, cli.fundingSubscription)
+}
+
+func testRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder := common.NewRecorder(dir)
+	cli, server := newTestClient(t, WithRecorder(recorder))
+	server.SetMarkPrices("BTCUSDT", "100", "101", "102")
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	var (
+		mu   sync.Mutex
+		live []*market.Tick
+	)
+	require.NoError(t, cli.SubscribeMarkPrice("BTCUSDT", func(tick *market.Tick) {
+		mu.Lock()
+		defer mu.Unlock()
+		live = append(live, tick)
+	}))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(live) >= 3
+	}, 3*time.Second, 10*time.Millisecond)
+	cli.Clean()
+	require.NoError(t, recorder.Close())
+
+	replay := common.NewReplay(dir, common.WithSpeed(0))
+	replayed := NewReplayClient(replay)
+	require.NoError(t, replayed.InitMarketData(context.Background()))
+	ticks := []*market.Tick{}
+	require.NoError(t, replayed.SubscribeMarkPrice("BTCUSDT", func(tick *market.Tick) {
+		ticks = append(ticks, tick)
+	}))
+	require.NoError(t, replay.Run(context.Background()))
+
+	// every dispatched frame was recorded first, the last ones may have been read after the snapshot
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(ticks), 3)
+	for i := 0; i < min(len(live), len(ticks)); i++ {
+		assert.True(t, ticks[i].Price.Equal(live[i].Price), "tick %d: %s != %s", i, ticks[i].Price, live[i].Price)
+		assert.Equal(t, live[i].ExchangeTime, ticks[i].ExchangeTime)
+		// the receive time is taken just before the live handler runs
+		assert.LessOrEqual(t, ticks[i].LocalTime, live[i].LocalTime)
+		assert.InDelta(t, live[i].LocalTime, ticks[i].LocalTime, 50)
+	}
+	assert.Equal(t, ticks[len(ticks)-1].LocalTime, replay.Now())
+	assert.True(t, ticks[0].Price.Equal(decimal.RequireFromString("100")))
 }
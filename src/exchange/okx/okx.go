@@ -2,19 +2,45 @@ package okx
 
 import (
 	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
 
-	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 )
 
 const (
 	PublicWebSocketBaseURL  = "wss://ws.okx.com:8443/ws/v5/public"
 	PrivateWebSocketBaseURL = "wss://ws.okx.com:8443/ws/v5/private"
+	// BusinessWebSocketBaseURL serves the candle channels
+	BusinessWebSocketBaseURL = "wss://ws.okx.com:8443/ws/v5/business"
+	RESTBaseURL              = "https://www.okx.com"
+	// RecordSourceBusiness is the source of the business frames in a recording, the
+	// public frames are recorded under market.ExchangeOKX
+	RecordSourceBusiness = market.ExchangeOKX + "_business"
 )
 
+// Client is safe for concurrent use, see webSocket for how each connection is guarded
 type Client struct {
-	closed                  bool
-	publicWebSocketConn     *websocket.Conn
-	publicWebSocketHandlers map[string]func(*WebSocketStream)
+	closed     atomic.Bool
+	apiKey     string
+	secretKey  string
+	passphrase string
+	restURL    string
+	// bookTickerChannel is the public channel behind SubscribeBookTicker
+	bookTickerChannel string
+	httpClient        *http.Client
+	public            *webSocket
+	private           *webSocket
+	business          *webSocket
+	// orders delivers the pushes of the orders channel once InitTrading subscribed it
+	orders           *market.OrderDispatcher
+	ordersSubscribed atomic.Bool
+	// now is the local time of the market data, the receive time of the frame being
+	// dispatched by NewReplayClient
+	now func() int64
 }
 
 type WebSocketRequest struct {
@@ -23,32 +49,166 @@ type WebSocketRequest struct {
 	Args []*WebSocketArg `json:"args"`
 }
 
+// WebSocketStream is either a data push (Arg and Data) or an event frame
+// (Event with Code/Msg) such as subscribe, error or notice. Action is set on the
+// pushes of the incremental books channel
 type WebSocketStream struct {
-	Arg  *WebSocketArg   `json:"arg"`
-	Data json.RawMessage `json:"data"`
+	ID     string          `json:"id"`
+	OP     string          `json:"op"`
+	Event  string          `json:"event"`
+	Code   string          `json:"code"`
+	Msg    string          `json:"msg"`
+	ConnID string          `json:"connId"`
+	Arg    *WebSocketArg   `json:"arg"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
 }
 
+const (
+	WebSocketEventSubscribe   = "subscribe"
+	WebSocketEventUnsubscribe = "unsubscribe"
+	WebSocketEventLogin       = "login"
+	WebSocketEventError       = "error"
+	WebSocketEventNotice      = "notice"
+)
+
 type WebSocketArg struct {
-	Channel string `json:"channel"`
-	InstID  string `json:"instId"`
+	Channel  string `json:"channel"`
+	InstType string `json:"instType,omitempty"`
+	InstID   string `json:"instId,omitempty"`
+	Ccy      string `json:"ccy,omitempty"`
 }
 
 func (a *WebSocketArg) Key() string {
 	if a == nil {
 		return ""
 	}
-	return a.Channel + "_" + a.InstID
+	key := a.Channel + "_" + a.InstID
+	if a.InstType != "" {
+		key += "_" + a.InstType
+	}
+	if a.Ccy != "" {
+		key += "_" + a.Ccy
+	}
+	return key
 }
 
-func NewClient() *Client {
-	return &Client{
-		publicWebSocketHandlers: make(map[string]func(*WebSocketStream), 100),
+// Decimal decodes the empty strings OKX sends for unset numbers (e.g. px of a market
+// order or fillPx before the first fill) as zero
+type Decimal struct {
+	decimal.Decimal
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == `""` || string(data) == "null" {
+		d.Decimal = decimal.Zero
+		return nil
 	}
+	return d.Decimal.UnmarshalJSON(data)
 }
 
-func (c *Client) Clean() {
-	c.closed = true
-	if c.publicWebSocketConn != nil {
-		c.publicWebSocketConn.Close()
+type Option func(*Client)
+
+// WithPublicWebSocketURL overrides PublicWebSocketBaseURL
+func WithPublicWebSocketURL(url string) Option {
+	return func(c *Client) {
+		c.public.url = url
+	}
+}
+
+// WithPrivateWebSocketURL overrides PrivateWebSocketBaseURL
+func WithPrivateWebSocketURL(url string) Option {
+	return func(c *Client) {
+		c.private.url = url
+	}
+}
+
+// WithBusinessWebSocketURL overrides BusinessWebSocketBaseURL
+func WithBusinessWebSocketURL(url string) Option {
+	return func(c *Client) {
+		c.business.url = url
 	}
 }
+
+// WithRESTURL overrides RESTBaseURL
+func WithRESTURL(url string) Option {
+	return func(c *Client) {
+		c.restURL = url
+	}
+}
+
+const (
+	BookTickerChannelBBO     = "bbo-tbt"
+	BookTickerChannelTickers = "tickers"
+)
+
+// WithBookTickerChannel selects the channel of SubscribeBookTicker, BookTickerChannelBBO
+// (the default) pushes every best bid/ask change while BookTickerChannelTickers is
+// throttled to 100ms
+func WithBookTickerChannel(channel string) Option {
+	return func(c *Client) {
+		c.bookTickerChannel = channel
+	}
+}
+
+// WithAPIKey sets the credentials used to login on the private connection
+func WithAPIKey(apiKey string, secretKey string, passphrase string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+		c.secretKey = secretKey
+		c.passphrase = passphrase
+	}
+}
+
+// WithRecorder writes every frame read on the public and business connections to
+// recorder, the business frames under RecordSourceBusiness
+func WithRecorder(recorder *common.Recorder) Option {
+	return func(c *Client) {
+		c.public.recorder = recorder
+		c.business.recorder = recorder
+	}
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		restURL:           RESTBaseURL,
+		bookTickerChannel: BookTickerChannelBBO,
+		httpClient:        &http.Client{Timeout: 10 * time.Second},
+		orders:            market.NewOrderDispatcher(),
+		now:               func() int64 { return time.Now().UnixMilli() },
+	}
+	c.public = newWebSocket("Public", PublicWebSocketBaseURL, &c.closed)
+	c.public.source = market.ExchangeOKX
+	c.private = newWebSocket("Private", PrivateWebSocketBaseURL, &c.closed)
+	c.business = newWebSocket("Business", BusinessWebSocketBaseURL, &c.closed)
+	c.business.source = RecordSourceBusiness
+	c.private.login = c.login
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewReplayClient dispatches the recorded frames of replay to the public and business
+// subscriptions instead of connecting their connections, the ticks are stamped
+// with the recorded receive times. The REST and private methods still reach the exchange
+func NewReplayClient(replay *common.Replay, opts ...Option) *Client {
+	c := NewClient(opts...)
+	c.now = replay.Now
+	c.public.replay = true
+	c.business.replay = true
+	replay.Handle(c.public.source, func(frame *common.Frame) {
+		c.public.handleMessage(frame.Data)
+	})
+	replay.Handle(c.business.source, func(frame *common.Frame) {
+		c.business.handleMessage(frame.Data)
+	})
+	return c
+}
+
+func (c *Client) Clean() {
+	c.closed.Store(true)
+	c.public.close()
+	c.private.close()
+	c.business.close()
+}
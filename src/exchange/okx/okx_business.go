@@ -0,0 +1,89 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+func (c *Client) InitBusinessWebSocketConnection(ctx context.Context) error {
+	return c.business.init(ctx)
+}
+
+// OnBusinessWebSocketReconnect registers a handler called after the business connection
+// is re-established and all subscriptions have been replayed
+func (c *Client) OnBusinessWebSocketReconnect(handler func()) {
+	c.business.onReconnect(handler)
+}
+
+// SubscribeBusiness is Subscribe on the business connection, which serves the candle channels
+func (c *Client) SubscribeBusiness(request *WebSocketRequest, handler func(*WebSocketStream)) error {
+	return c.business.subscribe(request, handler)
+}
+
+// Candle is one row of the candle channels and of the candles REST endpoints. For SWAP
+// Vol is in contracts, VolCcy in the base currency and VolCcyQuote in the quote currency
+type Candle struct {
+	Timestamp   int64
+	Open        Decimal
+	High        Decimal
+	Low         Decimal
+	Close       Decimal
+	Vol         Decimal
+	VolCcy      Decimal
+	VolCcyQuote Decimal
+	// Confirm is set once the candle is closed
+	Confirm bool
+}
+
+// UnmarshalJSON decodes the array form [ts, o, h, l, c, vol, volCcy, volCcyQuote, confirm]
+func (k *Candle) UnmarshalJSON(data []byte) error {
+	var row []string
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	if len(row) < 9 {
+		return fmt.Errorf("Candle invalid row: %s", string(data))
+	}
+	k.Timestamp = parseTimestamp(row[0])
+	for i, field := range []*Decimal{&k.Open, &k.High, &k.Low, &k.Close, &k.Vol, &k.VolCcy, &k.VolCcyQuote} {
+		if err := field.UnmarshalJSON([]byte(`"` + row[i+1] + `"`)); err != nil {
+			return fmt.Errorf("Candle field %d error: %w", i+1, err)
+		}
+	}
+	k.Confirm = row[8] == "1"
+	return nil
+}
+
+type BusinessWebSocketCandles struct {
+	InstID  string
+	Bar     string
+	Candles []*Candle
+}
+
+func NewBusinessWebSocketCandles(instID string, bar string) *BusinessWebSocketCandles {
+	return &BusinessWebSocketCandles{
+		InstID: instID,
+		Bar:    bar,
+	}
+}
+
+func (p *BusinessWebSocketCandles) Subscribe() *WebSocketRequest {
+	return &WebSocketRequest{
+		OP: "subscribe",
+		Args: []*WebSocketArg{
+			{
+				Channel: "candle" + p.Bar,
+				InstID:  p.InstID,
+			},
+		},
+	}
+}
+
+func (p *BusinessWebSocketCandles) Stream(response *WebSocketStream) (*BusinessWebSocketCandles, error) {
+	err := json.Unmarshal(response.Data, &p.Candles)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
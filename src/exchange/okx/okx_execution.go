@@ -0,0 +1,139 @@
+package okx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+)
+
+var (
+	_ market.ExecutionVenue = (*Client)(nil)
+	_ market.PositionSource = (*Client)(nil)
+)
+
+// InitTrading connects and logs in the private connection and subscribes the orders
+// channel of the swaps, whose pushes are delivered to the OnOrderUpdate handlers. It
+// needs WithAPIKey, and the orders channel must not be subscribed again with another
+// handler
+func (c *Client) InitTrading(ctx context.Context) error {
+	if c.private.conn.Load() == nil {
+		err := c.InitPrivateWebSocketConnection(ctx)
+		if err != nil {
+			return fmt.Errorf("InitTrading private connection error: %w", err)
+		}
+	}
+	c.orders.Start(ctx)
+	if c.ordersSubscribed.Load() {
+		return nil
+	}
+	err := c.SubscribePrivate(NewPrivateWebSocketOrders(InstTypeSwap, "").Subscribe(), c.handleOrders)
+	if err != nil {
+		return fmt.Errorf("InitTrading SubscribePrivate orders error: %w", err)
+	}
+	c.ordersSubscribed.Store(true)
+	return nil
+}
+
+// OnOrderUpdate handlers are called on their own goroutine in the order of the pushes
+func (c *Client) OnOrderUpdate(handler func(*market.Order)) {
+	c.orders.OnOrderUpdate(handler)
+}
+
+// PlaceOrder sends a cross margin order, the quantity is in contracts. OKX only
+// acknowledges the order id so the order is returned new and its fills come with the
+// updates. An order refused by OKX is returned rejected with the error as the reason
+func (c *Client) PlaceOrder(ctx context.Context, request *market.OrderRequest) (*market.Order, error) {
+	place := NewPrivateWebSocketOrderPlace(request.Symbol, request.Side, request.Quantity)
+	if request.Type == market.OrderTypeLimit {
+		place.Limit(request.Price, OrderTypeLimit)
+	}
+	place.ReduceOnly = request.ReduceOnly
+	place.ClOrdID = request.ClientOrderID
+	now := time.Now().UnixMilli()
+	resp, err := c.CallContext(ctx, place.Request())
+	var okxErr *PrivateWebSocketError
+	if errors.As(err, &okxErr) {
+		return market.NewRejectedOrder(market.ExchangeOKX, request, okxErr.Error(), now), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PlaceOrder %s error: %w", request.Symbol, err)
+	}
+	result, err := place.Response(resp)
+	if errors.As(err, &okxErr) {
+		return market.NewRejectedOrder(market.ExchangeOKX, request, okxErr.Error(), now), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PlaceOrder %s Response error: %w", request.Symbol, err)
+	}
+	order := market.NewRejectedOrder(market.ExchangeOKX, request, "", now)
+	order.ID = result.OrdID
+	order.ClientOrderID = result.ClOrdID
+	order.Status = market.OrderStatusNew
+	return order, nil
+}
+
+// CancelOrder sends cancel-order, the canceled order comes with the updates since OKX
+// only acknowledges the request
+func (c *Client) CancelOrder(ctx context.Context, symbol string, orderID string) (*market.Order, error) {
+	cancel := NewPrivateWebSocketOrderCancel(symbol, orderID, "")
+	resp, err := c.CallContext(ctx, cancel.Request())
+	if err != nil {
+		return nil, fmt.Errorf("CancelOrder %s %s error: %w", symbol, orderID, err)
+	}
+	_, err = cancel.Response(resp)
+	if err != nil {
+		return nil, fmt.Errorf("CancelOrder %s %s Response error: %w", symbol, orderID, err)
+	}
+	return nil, nil
+}
+
+func (c *Client) handleOrders(stream *WebSocketStream) {
+	orders, err := (&PrivateWebSocketOrders{}).Stream(stream)
+	if err != nil {
+		common.Logger.Sugar().Warnf("handleOrders Stream error: %v", err)
+		return
+	}
+	for _, order := range *orders {
+		c.orders.Dispatch(order.Order())
+	}
+}
+
+// Order converts a push of the orders channel, OKX charges fees as negative amounts
+func (o *PrivateWebSocketOrder) Order() *market.Order {
+	createTime, _ := strconv.ParseInt(o.CTime, 10, 64)
+	updateTime, _ := strconv.ParseInt(o.UTime, 10, 64)
+	orderType := market.OrderTypeLimit
+	if o.OrdType == OrderTypeMarket {
+		orderType = market.OrderTypeMarket
+	}
+	status := market.OrderStatusNew
+	switch o.State {
+	case OrderStatePartiallyFilled:
+		status = market.OrderStatusPartiallyFilled
+	case OrderStateFilled:
+		status = market.OrderStatusFilled
+	case OrderStateCanceled, OrderStateMMPCanceled:
+		status = market.OrderStatusCanceled
+	}
+	return &market.Order{
+		ID:             o.OrdID,
+		ClientOrderID:  o.ClOrdID,
+		Exchange:       market.ExchangeOKX,
+		Symbol:         o.InstID,
+		Side:           o.Side,
+		Type:           orderType,
+		Price:          o.Price.Decimal,
+		Quantity:       o.Size.Decimal,
+		ReduceOnly:     o.ReduceOnly == "true",
+		FilledQuantity: o.AccFillSize.Decimal,
+		AvgPrice:       o.AvgPrice.Decimal,
+		Fee:            o.Fee.Decimal.Neg(),
+		Status:         status,
+		CreateTime:     createTime,
+		UpdateTime:     updateTime,
+	}
+}
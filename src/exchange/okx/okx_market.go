@@ -0,0 +1,300 @@
+package okx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+var _ market.MarketDataSource = (*Client)(nil)
+
+func (c *Client) Exchange() string {
+	return market.ExchangeOKX
+}
+
+// InitMarketData connects the public and the business connection, the candles are only
+// served on the latter
+func (c *Client) InitMarketData(ctx context.Context) error {
+	err := c.InitPublicWebSocketConnection(ctx)
+	if err != nil {
+		return err
+	}
+	return c.InitBusinessWebSocketConnection(ctx)
+}
+
+func (c *Client) OnMarketDataReconnect(handler func()) {
+	c.OnPublicWebSocketReconnect(handler)
+	c.OnBusinessWebSocketReconnect(handler)
+}
+
+func (c *Client) SubscribeMarkPrice(instID string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewPublicWebSocketMarkPrices(instID).Subscribe(), func(stream *WebSocketStream) {
+		localTime := c.now()
+		prices, err := NewPublicWebSocketMarkPrices(instID).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeMarkPrice %s Stream error: %v", instID, err)
+			return
+		}
+		for _, price := range *prices {
+			handler(&market.Tick{
+				Exchange:     market.ExchangeOKX,
+				Symbol:       price.InstID,
+				Type:         market.TickTypeMarkPrice,
+				Price:        price.MarkPrice,
+				ExchangeTime: parseTimestamp(price.Timestamp),
+				LocalTime:    localTime,
+			})
+		}
+	})
+}
+
+func (c *Client) SubscribeBookTicker(instID string, handler func(*market.Tick)) error {
+	if c.bookTickerChannel == BookTickerChannelTickers {
+		return c.subscribeTickers(instID, handler)
+	}
+	return c.Subscribe(NewPublicWebSocketBBOs(instID).Subscribe(), func(stream *WebSocketStream) {
+		localTime := c.now()
+		bbos, err := NewPublicWebSocketBBOs(instID).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeBookTicker %s Stream error: %v", instID, err)
+			return
+		}
+		for _, bbo := range *bbos {
+			bidPrice, bidSize, err := bbo.Best(bbo.Bids)
+			if err != nil {
+				common.Logger.Sugar().Warnf("SubscribeBookTicker %s bids error: %v", instID, err)
+				continue
+			}
+			askPrice, askSize, err := bbo.Best(bbo.Asks)
+			if err != nil {
+				common.Logger.Sugar().Warnf("SubscribeBookTicker %s asks error: %v", instID, err)
+				continue
+			}
+			handler(market.NewBookTick(market.ExchangeOKX, instID, bidPrice, bidSize, askPrice, askSize, parseTimestamp(bbo.Timestamp), localTime))
+		}
+	})
+}
+
+func (c *Client) subscribeTickers(instID string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewPublicWebSocketTickers(instID).Subscribe(), func(stream *WebSocketStream) {
+		localTime := c.now()
+		tickers, err := NewPublicWebSocketTickers(instID).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeBookTicker %s tickers Stream error: %v", instID, err)
+			return
+		}
+		for _, ticker := range *tickers {
+			handler(market.NewBookTick(market.ExchangeOKX, ticker.InstID, ticker.BidPx.Decimal, ticker.BidSz.Decimal, ticker.AskPx.Decimal, ticker.AskSz.Decimal, parseTimestamp(ticker.Timestamp), localTime))
+		}
+	})
+}
+
+func (c *Client) SubscribeTrades(instID string, handler func(*market.Tick)) error {
+	return c.Subscribe(NewPublicWebSocketTrades(instID).Subscribe(), func(stream *WebSocketStream) {
+		localTime := c.now()
+		trades, err := NewPublicWebSocketTrades(instID).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeTrades %s Stream error: %v", instID, err)
+			return
+		}
+		for _, trade := range *trades {
+			handler(&market.Tick{
+				Exchange:     market.ExchangeOKX,
+				Symbol:       trade.InstID,
+				Type:         market.TickTypeTrade,
+				Price:        trade.Price,
+				Quantity:     trade.Size,
+				Side:         trade.Side,
+				ExchangeTime: parseTimestamp(trade.Timestamp),
+				LocalTime:    localTime,
+			})
+		}
+	})
+}
+
+var _ market.FundingSource = (*Client)(nil)
+
+// SubscribeFunding streams the funding-rate channel, the interval is the distance
+// between the two settlements
+func (c *Client) SubscribeFunding(instID string, handler func(*market.Funding)) error {
+	return c.Subscribe(NewPublicWebSocketFundingRates(instID).Subscribe(), func(stream *WebSocketStream) {
+		localTime := c.now()
+		rates, err := NewPublicWebSocketFundingRates(instID).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeFunding %s Stream error: %v", instID, err)
+			return
+		}
+		for _, rate := range *rates {
+			funding := &market.Funding{
+				Exchange:        market.ExchangeOKX,
+				Symbol:          rate.InstID,
+				Rate:            rate.FundingRate.Decimal,
+				FundingTime:     parseTimestamp(rate.FundingTime),
+				NextFundingTime: parseTimestamp(rate.NextFundingTime),
+				ExchangeTime:    parseTimestamp(rate.Timestamp),
+				LocalTime:       localTime,
+			}
+			funding.Interval = time.Duration(funding.NextFundingTime-funding.FundingTime) * time.Millisecond
+			if rate.NextFundingRate != "" {
+				predicted, err := decimal.NewFromString(rate.NextFundingRate)
+				if err != nil {
+					common.Logger.Sugar().Warnf("SubscribeFunding %s nextFundingRate error: %v", instID, err)
+				} else {
+					funding.PredictedRate = decimal.NewNullDecimal(predicted)
+				}
+			}
+			handler(funding)
+		}
+	})
+}
+
+// parseTimestamp converts the millisecond string timestamps of OKX, zero when unset
+func parseTimestamp(ts string) int64 {
+	value, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+var _ market.InstrumentSource = (*Client)(nil)
+
+// LoadInstruments lists the linear SWAP instruments, inverse swaps are skipped since
+// their contract value is in quote currency
+func (c *Client) LoadInstruments(ctx context.Context) ([]*market.Instrument, error) {
+	items, err := c.GetInstruments(ctx, InstTypeSwap)
+	if err != nil {
+		return nil, err
+	}
+	instruments := []*market.Instrument{}
+	for _, item := range items {
+		if item.CtType != ContractTypeLinear {
+			continue
+		}
+		parts := strings.Split(item.InstID, "-")
+		if len(parts) != 3 {
+			continue
+		}
+		status := market.InstrumentStatusSuspended
+		switch item.State {
+		case InstrumentStateLive:
+			status = market.InstrumentStatusTrading
+		case InstrumentStatePreopen:
+			status = market.InstrumentStatusPending
+		}
+		contractValue := item.CtVal.Decimal
+		if item.CtMult.IsPositive() {
+			contractValue = contractValue.Mul(item.CtMult.Decimal)
+		}
+		instruments = append(instruments, &market.Instrument{
+			ID:            market.InstrumentID(parts[0], parts[1], item.SettleCcy),
+			Exchange:      market.ExchangeOKX,
+			Symbol:        item.InstID,
+			Base:          parts[0],
+			Quote:         parts[1],
+			Settle:        item.SettleCcy,
+			TickSize:      item.TickSz.Decimal,
+			LotSize:       item.LotSz.Decimal,
+			MinQuantity:   item.MinSz.Decimal,
+			ContractValue: contractValue,
+			Status:        status,
+		})
+	}
+	return instruments, nil
+}
+
+var _ market.CandleSource = (*Client)(nil)
+
+// candleBars maps the intervals to OKX bars, 6h and longer use the UTC aligned bars
+// like Binance instead of the Hong Kong time ones
+var candleBars = map[market.CandleInterval]string{
+	market.CandleInterval1m:  "1m",
+	market.CandleInterval3m:  "3m",
+	market.CandleInterval5m:  "5m",
+	market.CandleInterval15m: "15m",
+	market.CandleInterval30m: "30m",
+	market.CandleInterval1h:  "1H",
+	market.CandleInterval2h:  "2H",
+	market.CandleInterval4h:  "4H",
+	market.CandleInterval6h:  "6Hutc",
+	market.CandleInterval12h: "12Hutc",
+	market.CandleInterval1d:  "1Dutc",
+	market.CandleInterval1w:  "1Wutc",
+}
+
+func candleBar(interval market.CandleInterval) (string, error) {
+	bar, ok := candleBars[interval]
+	if !ok {
+		return "", fmt.Errorf("unknown candle interval %q", string(interval))
+	}
+	return bar, nil
+}
+
+func newCandle(instID string, interval market.CandleInterval, candle *Candle) *market.Candle {
+	return &market.Candle{
+		Exchange:    market.ExchangeOKX,
+		Symbol:      instID,
+		Interval:    interval,
+		OpenTime:    candle.Timestamp,
+		CloseTime:   candle.Timestamp + interval.Duration().Milliseconds() - 1,
+		Open:        candle.Open.Decimal,
+		High:        candle.High.Decimal,
+		Low:         candle.Low.Decimal,
+		Close:       candle.Close.Decimal,
+		Volume:      candle.VolCcy.Decimal,
+		QuoteVolume: candle.VolCcyQuote.Decimal,
+		Closed:      candle.Confirm,
+	}
+}
+
+func (c *Client) SubscribeCandles(instID string, interval market.CandleInterval, handler func(*market.Candle)) error {
+	bar, err := candleBar(interval)
+	if err != nil {
+		return err
+	}
+	return c.SubscribeBusiness(NewBusinessWebSocketCandles(instID, bar).Subscribe(), func(stream *WebSocketStream) {
+		candles, err := NewBusinessWebSocketCandles(instID, bar).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeCandles %s Stream error: %v", instID, err)
+			return
+		}
+		for _, candle := range candles.Candles {
+			handler(newCandle(instID, interval, candle))
+		}
+	})
+}
+
+// LoadCandles pages backwards through history-candles HistoryCandlesLimit candles at a time
+func (c *Client) LoadCandles(ctx context.Context, instID string, interval market.CandleInterval, start int64, end int64) ([]*market.Candle, error) {
+	bar, err := candleBar(interval)
+	if err != nil {
+		return nil, err
+	}
+	candles := []*market.Candle{}
+	for after := end; after > start; {
+		page, err := c.GetHistoryCandles(ctx, instID, bar, after, 0, HistoryCandlesLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range page {
+			if candle.Timestamp >= start && candle.Timestamp < end {
+				candles = append(candles, newCandle(instID, interval, candle))
+			}
+		}
+		if len(page) < HistoryCandlesLimit {
+			break
+		}
+		after = page[len(page)-1].Timestamp
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].OpenTime < candles[j].OpenTime
+	})
+	return candles, nil
+}
@@ -0,0 +1,169 @@
+package okx
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"trade/src/common"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+// BooksChecksumDepth is the number of levels per side covered by the books checksum
+const BooksChecksumDepth = 25
+
+var _ market.OrderBookSource = (*Client)(nil)
+
+// SubscribeOrderBook maintains a local book of instID from BooksChannel, every update is
+// checked against seqId/prevSeqId and the checksum and the channel is resubscribed for a
+// fresh snapshot on a mismatch. The book is empty until the snapshot is received, handler
+// is called on the reader goroutine after every applied push
+func (c *Client) SubscribeOrderBook(instID string, handler func(*market.OrderBook)) (*market.OrderBook, error) {
+	return c.subscribeOrderBook(instID, BooksChannel, handler)
+}
+
+// SubscribeOrderBook5 keeps a local book of the best 5 levels of instID from Books5Channel,
+// every push replaces the whole book
+func (c *Client) SubscribeOrderBook5(instID string, handler func(*market.OrderBook)) (*market.OrderBook, error) {
+	return c.subscribeOrderBook(instID, Books5Channel, handler)
+}
+
+func (c *Client) subscribeOrderBook(instID string, channel string, handler func(*market.OrderBook)) (*market.OrderBook, error) {
+	s := &orderBookSync{
+		book: market.NewOrderBook(market.ExchangeOKX, instID),
+		bids: make(map[string][2]string),
+		asks: make(map[string][2]string),
+	}
+	request := NewPublicWebSocketBooks(instID, channel).Subscribe()
+	err := c.Subscribe(request, func(stream *WebSocketStream) {
+		books, err := NewPublicWebSocketBooks(instID, channel).Stream(stream)
+		if err != nil {
+			common.Logger.Sugar().Warnf("SubscribeOrderBook %s Stream error: %v", instID, err)
+			return
+		}
+		for _, book := range *books {
+			applied, err := s.onBook(stream.Action, book)
+			if err != nil {
+				common.Logger.Sugar().Warnf("SubscribeOrderBook %s resync: %v", instID, err)
+				s.synced = false
+				s.book.Clear()
+				if err := c.public.resync(request.Args); err != nil {
+					common.Logger.Sugar().Warnf("SubscribeOrderBook %s resync error: %v", instID, err)
+				}
+				break
+			}
+			if applied && handler != nil {
+				handler(s.book)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.book, nil
+}
+
+// orderBookSync is only used on the reader goroutine. It keeps the price and size strings
+// OKX sent for every level, keyed by the normalized price, since the checksum is computed
+// over the original strings
+type orderBookSync struct {
+	book   *market.OrderBook
+	bids   map[string][2]string
+	asks   map[string][2]string
+	seqID  int64
+	synced bool
+}
+
+// onBook applies one push and reports whether the book changed, an error means the
+// book is out of sync
+func (s *orderBookSync) onBook(action string, book *PublicWebSocketBook) (bool, error) {
+	bids, err := parseLevels(book.Bids)
+	if err != nil {
+		return false, err
+	}
+	asks, err := parseLevels(book.Asks)
+	if err != nil {
+		return false, err
+	}
+	ts := parseTimestamp(book.Timestamp)
+	switch {
+	case book.Channel == Books5Channel || action == BooksActionSnapshot:
+		clear(s.bids)
+		clear(s.asks)
+		storeLevels(book.Bids, s.bids)
+		storeLevels(book.Asks, s.asks)
+		s.book.Reset(bids, asks, book.SeqID, ts)
+		s.seqID = book.SeqID
+		s.synced = true
+		if book.Channel == Books5Channel {
+			return true, nil
+		}
+	case !s.synced:
+		// updates in flight before the snapshot of a resync
+		return false, nil
+	case book.PrevSeqID != s.seqID && book.SeqID <= s.seqID:
+		return false, nil
+	case book.PrevSeqID != s.seqID:
+		return false, fmt.Errorf("update prevSeqId %d does not follow seqId %d", book.PrevSeqID, s.seqID)
+	default:
+		storeLevels(book.Bids, s.bids)
+		storeLevels(book.Asks, s.asks)
+		s.book.Apply(bids, asks, book.SeqID, ts)
+		s.seqID = book.SeqID
+	}
+	if checksum := s.checksum(); checksum != book.Checksum {
+		return false, fmt.Errorf("checksum %d does not match %d at seqId %d", checksum, book.Checksum, book.SeqID)
+	}
+	return true, nil
+}
+
+func parseLevels(items [][]string) ([]market.Level, error) {
+	levels := make([]market.Level, 0, len(items))
+	for _, item := range items {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid level %v", item)
+		}
+		price, err := decimal.NewFromString(item[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := decimal.NewFromString(item[1])
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, market.Level{Price: price, Quantity: size})
+	}
+	return levels, nil
+}
+
+// storeLevels must follow a successful parseLevels of items
+func storeLevels(items [][]string, raw map[string][2]string) {
+	for _, item := range items {
+		key := decimal.RequireFromString(item[0]).String()
+		if decimal.RequireFromString(item[1]).IsZero() {
+			delete(raw, key)
+		} else {
+			raw[key] = [2]string{item[0], item[1]}
+		}
+	}
+}
+
+// checksum is the signed CRC32 of the best BooksChecksumDepth bids and asks interleaved
+// as bidPx:bidSz:askPx:askSz, a side with fewer levels stops contributing
+func (s *orderBookSync) checksum() int32 {
+	bids := s.book.Bids(BooksChecksumDepth)
+	asks := s.book.Asks(BooksChecksumDepth)
+	parts := make([]string, 0, 2*(len(bids)+len(asks)))
+	for i := 0; i < max(len(bids), len(asks)); i++ {
+		if i < len(bids) {
+			raw := s.bids[bids[i].Price.String()]
+			parts = append(parts, raw[0], raw[1])
+		}
+		if i < len(asks) {
+			raw := s.asks[asks[i].Price.String()]
+			parts = append(parts, raw[0], raw[1])
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(strings.Join(parts, ":"))))
+}
@@ -0,0 +1,516 @@
+package okx
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// CallTimeout is how long Call waits for the response of an op
+const CallTimeout = 10 * time.Second
+
+type PrivateWebSocketRequest struct {
+	ID   string          `json:"id"`
+	OP   string          `json:"op"`
+	Args json.RawMessage `json:"args"`
+}
+
+type PrivateWebSocketResponse struct {
+	ID      string          `json:"id"`
+	OP      string          `json:"op"`
+	Code    string          `json:"code"`
+	Msg     string          `json:"msg"`
+	Data    json.RawMessage `json:"data"`
+	InTime  string          `json:"inTime"`
+	OutTime string          `json:"outTime"`
+}
+
+// PrivateWebSocketError is a response OKX refused, SCode and SMsg are the ones of the
+// first failed item when the op has items
+type PrivateWebSocketError struct {
+	Code  string
+	Msg   string
+	SCode string
+	SMsg  string
+}
+
+func (e *PrivateWebSocketError) Error() string {
+	if e.SCode == "" {
+		return fmt.Sprintf("code %s msg %s", e.Code, e.Msg)
+	}
+	return fmt.Sprintf("code %s msg %s sCode %s sMsg %s", e.Code, e.Msg, e.SCode, e.SMsg)
+}
+
+// Err describes a failed response as a *PrivateWebSocketError
+func (r *PrivateWebSocketResponse) Err() error {
+	var results []*PrivateWebSocketOrderResult
+	_ = json.Unmarshal(r.Data, &results)
+	for _, result := range results {
+		if result.SCode != "0" {
+			return &PrivateWebSocketError{Code: r.Code, Msg: r.Msg, SCode: result.SCode, SMsg: result.SMsg}
+		}
+	}
+	return &PrivateWebSocketError{Code: r.Code, Msg: r.Msg}
+}
+
+func (c *Client) InitPrivateWebSocketConnection(ctx context.Context) error {
+	return c.private.init(ctx)
+}
+
+func (c *Client) ReadPrivateWebSocketMessages(ctx context.Context) {
+	c.private.read(ctx)
+}
+
+func (c *Client) ConnectPrivateWebSocket(ctx context.Context) error {
+	return c.private.connect(ctx)
+}
+
+func (c *Client) ReconnectPrivateWebSocket(ctx context.Context) {
+	c.private.reconnect(ctx)
+}
+
+// OnPrivateWebSocketReconnect registers a handler called after the private connection
+// is re-established, logged in again and all subscriptions have been replayed
+func (c *Client) OnPrivateWebSocketReconnect(handler func()) {
+	c.private.onReconnect(handler)
+}
+
+// SubscribePrivate works like Subscribe on the logged in private connection
+func (c *Client) SubscribePrivate(request *WebSocketRequest, handler func(*WebSocketStream)) error {
+	return c.private.subscribe(request, handler)
+}
+
+// Call sends an op such as order, cancel-order or amend-order on the private connection
+// and waits for the response with the same id at most CallTimeout
+func (c *Client) Call(request *PrivateWebSocketRequest) (*PrivateWebSocketResponse, error) {
+	return c.private.call(context.Background(), request)
+}
+
+// CallContext works like Call but gives up once ctx is done, the op may still be
+// executed by OKX
+func (c *Client) CallContext(ctx context.Context, request *PrivateWebSocketRequest) (*PrivateWebSocketResponse, error) {
+	return c.private.call(ctx, request)
+}
+
+// login authenticates conn before it is published to the reader goroutine, so the
+// login event is read here directly
+func (c *Client) login(conn *websocket.Conn) error {
+	if c.apiKey == "" || c.secretKey == "" || c.passphrase == "" {
+		return fmt.Errorf("login apiKey/secretKey/passphrase is empty")
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request := map[string]interface{}{
+		"id": newRequestID(),
+		"op": "login",
+		"args": []map[string]string{{
+			"apiKey":     c.apiKey,
+			"passphrase": c.passphrase,
+			"timestamp":  timestamp,
+			"sign":       LoginSign(c.secretKey, timestamp),
+		}},
+	}
+	err := conn.WriteJSON(request)
+	if err != nil {
+		return fmt.Errorf("login WriteJSON error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("login ReadMessage error: %v", err)
+		}
+		var event WebSocketStream
+		err = json.Unmarshal(message, &event)
+		if err != nil {
+			continue
+		}
+		switch event.Event {
+		case WebSocketEventLogin:
+			if event.Code != "0" {
+				return fmt.Errorf("login error: code %s msg %s", event.Code, event.Msg)
+			}
+			return nil
+		case WebSocketEventError:
+			return fmt.Errorf("login error: code %s msg %s", event.Code, event.Msg)
+		}
+	}
+}
+
+// LoginSign is Base64(HMAC-SHA256(secretKey, timestamp + "GET" + "/users/self/verify"))
+func LoginSign(secretKey string, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(timestamp + "GET" + "/users/self/verify"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	InstTypeSwap    = "SWAP"
+	InstTypeFutures = "FUTURES"
+	InstTypeMargin  = "MARGIN"
+	InstTypeSpot    = "SPOT"
+	InstTypeAny     = "ANY"
+
+	OrderSideBuy  = "buy"
+	OrderSideSell = "sell"
+
+	OrderTypeMarket   = "market"
+	OrderTypeLimit    = "limit"
+	OrderTypePostOnly = "post_only"
+	OrderTypeFOK      = "fok"
+	OrderTypeIOC      = "ioc"
+
+	TradeModeCross    = "cross"
+	TradeModeIsolated = "isolated"
+	TradeModeCash     = "cash"
+
+	PosSideNet   = "net"
+	PosSideLong  = "long"
+	PosSideShort = "short"
+
+	OrderStateLive            = "live"
+	OrderStatePartiallyFilled = "partially_filled"
+	OrderStateFilled          = "filled"
+	OrderStateCanceled        = "canceled"
+	OrderStateMMPCanceled     = "mmp_canceled"
+)
+
+type PrivateWebSocketOrders []*PrivateWebSocketOrder
+
+type PrivateWebSocketOrder struct {
+	InstType    string  `json:"instType"`
+	InstID      string  `json:"instId"`
+	OrdID       string  `json:"ordId"`
+	ClOrdID     string  `json:"clOrdId"`
+	Price       Decimal `json:"px"`
+	Size        Decimal `json:"sz"`
+	OrdType     string  `json:"ordType"`
+	Side        string  `json:"side"`
+	PosSide     string  `json:"posSide"`
+	TdMode      string  `json:"tdMode"`
+	State       string  `json:"state"`
+	AccFillSize Decimal `json:"accFillSz"`
+	AvgPrice    Decimal `json:"avgPx"`
+	FillPrice   Decimal `json:"fillPx"`
+	FillSize    Decimal `json:"fillSz"`
+	Fee         Decimal `json:"fee"`
+	FeeCcy      string  `json:"feeCcy"`
+	ReduceOnly  string  `json:"reduceOnly"`
+	CTime       string  `json:"cTime"`
+	UTime       string  `json:"uTime"`
+}
+
+// NewPrivateWebSocketOrders subscribes order updates of instType, instID is optional
+func NewPrivateWebSocketOrders(instType string, instID string) *PrivateWebSocketOrders {
+	return &PrivateWebSocketOrders{
+		{
+			InstType: instType,
+			InstID:   instID,
+		},
+	}
+}
+
+func (p *PrivateWebSocketOrders) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		request.Args = append(request.Args, &WebSocketArg{
+			Channel:  "orders",
+			InstType: item.InstType,
+			InstID:   item.InstID,
+		})
+	}
+	return request
+}
+
+func (p *PrivateWebSocketOrders) Stream(response *WebSocketStream) (*PrivateWebSocketOrders, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type PrivateWebSocketPositions []*PrivateWebSocketPosition
+
+type PrivateWebSocketPosition struct {
+	InstType  string  `json:"instType"`
+	InstID    string  `json:"instId"`
+	PosID     string  `json:"posId"`
+	PosSide   string  `json:"posSide"`
+	Pos       Decimal `json:"pos"`
+	AvgPrice  Decimal `json:"avgPx"`
+	Upl       Decimal `json:"upl"`
+	Lever     Decimal `json:"lever"`
+	LiqPrice  Decimal `json:"liqPx"`
+	MarkPrice Decimal `json:"markPx"`
+	Margin    Decimal `json:"margin"`
+	MgnMode   string  `json:"mgnMode"`
+	Ccy       string  `json:"ccy"`
+	UTime     string  `json:"uTime"`
+}
+
+// NewPrivateWebSocketPositions subscribes position updates of instType, instID is optional
+func NewPrivateWebSocketPositions(instType string, instID string) *PrivateWebSocketPositions {
+	return &PrivateWebSocketPositions{
+		{
+			InstType: instType,
+			InstID:   instID,
+		},
+	}
+}
+
+func (p *PrivateWebSocketPositions) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		request.Args = append(request.Args, &WebSocketArg{
+			Channel:  "positions",
+			InstType: item.InstType,
+			InstID:   item.InstID,
+		})
+	}
+	return request
+}
+
+func (p *PrivateWebSocketPositions) Stream(response *WebSocketStream) (*PrivateWebSocketPositions, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type PrivateWebSocketAccounts []*PrivateWebSocketAccount
+
+type PrivateWebSocketAccount struct {
+	UTime   string                           `json:"uTime"`
+	TotalEq Decimal                          `json:"totalEq"`
+	Details []*PrivateWebSocketAccountDetail `json:"details"`
+}
+
+type PrivateWebSocketAccountDetail struct {
+	Ccy       string  `json:"ccy"`
+	Eq        Decimal `json:"eq"`
+	CashBal   Decimal `json:"cashBal"`
+	AvailBal  Decimal `json:"availBal"`
+	FrozenBal Decimal `json:"frozenBal"`
+	Upl       Decimal `json:"upl"`
+	UTime     string  `json:"uTime"`
+}
+
+func NewPrivateWebSocketAccounts() *PrivateWebSocketAccounts {
+	return &PrivateWebSocketAccounts{}
+}
+
+func (p *PrivateWebSocketAccounts) Subscribe() *WebSocketRequest {
+	return &WebSocketRequest{
+		OP: "subscribe",
+		Args: []*WebSocketArg{
+			{
+				Channel: "account",
+			},
+		},
+	}
+}
+
+func (p *PrivateWebSocketAccounts) Stream(response *WebSocketStream) (*PrivateWebSocketAccounts, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type PrivateWebSocketBalanceAndPositions []*PrivateWebSocketBalanceAndPosition
+
+type PrivateWebSocketBalanceAndPosition struct {
+	PTime     string                     `json:"pTime"`
+	EventType string                     `json:"eventType"`
+	BalData   []*PrivateWebSocketBalData `json:"balData"`
+	PosData   []*PrivateWebSocketPosData `json:"posData"`
+}
+
+type PrivateWebSocketBalData struct {
+	Ccy     string  `json:"ccy"`
+	CashBal Decimal `json:"cashBal"`
+	UTime   string  `json:"uTime"`
+}
+
+type PrivateWebSocketPosData struct {
+	PosID    string  `json:"posId"`
+	InstID   string  `json:"instId"`
+	InstType string  `json:"instType"`
+	MgnMode  string  `json:"mgnMode"`
+	PosSide  string  `json:"posSide"`
+	Pos      Decimal `json:"pos"`
+	AvgPrice Decimal `json:"avgPx"`
+	Ccy      string  `json:"ccy"`
+	UTime    string  `json:"uTime"`
+}
+
+func NewPrivateWebSocketBalanceAndPositions() *PrivateWebSocketBalanceAndPositions {
+	return &PrivateWebSocketBalanceAndPositions{}
+}
+
+func (p *PrivateWebSocketBalanceAndPositions) Subscribe() *WebSocketRequest {
+	return &WebSocketRequest{
+		OP: "subscribe",
+		Args: []*WebSocketArg{
+			{
+				Channel: "balance_and_position",
+			},
+		},
+	}
+}
+
+func (p *PrivateWebSocketBalanceAndPositions) Stream(response *WebSocketStream) (*PrivateWebSocketBalanceAndPositions, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PrivateWebSocketOrderResult is one item of the data of order, cancel-order and amend-order
+type PrivateWebSocketOrderResult struct {
+	ClOrdID string `json:"clOrdId"`
+	OrdID   string `json:"ordId"`
+	ReqID   string `json:"reqId"`
+	Tag     string `json:"tag"`
+	SCode   string `json:"sCode"`
+	SMsg    string `json:"sMsg"`
+	Ts      string `json:"ts"`
+}
+
+func orderResult(resp *PrivateWebSocketResponse) (*PrivateWebSocketOrderResult, error) {
+	var results []*PrivateWebSocketOrderResult
+	err := json.Unmarshal(resp.Data, &results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("orderResult empty data")
+	}
+	if results[0].SCode != "0" {
+		return nil, fmt.Errorf("orderResult error: %w", &PrivateWebSocketError{Code: resp.Code, Msg: resp.Msg, SCode: results[0].SCode, SMsg: results[0].SMsg})
+	}
+	return results[0], nil
+}
+
+type PrivateWebSocketOrderPlace struct {
+	InstID     string          `json:"instId"`
+	TdMode     string          `json:"tdMode"`
+	Side       string          `json:"side"`
+	PosSide    string          `json:"posSide,omitempty"`
+	OrdType    string          `json:"ordType"`
+	Size       decimal.Decimal `json:"sz"`
+	Price      string          `json:"px,omitempty"`
+	ClOrdID    string          `json:"clOrdId,omitempty"`
+	ReduceOnly bool            `json:"reduceOnly,omitempty"`
+}
+
+// NewPrivateWebSocketOrderPlace builds a cross margin market order, size is in contracts.
+// Call Limit for a limit order
+func NewPrivateWebSocketOrderPlace(instID string, side string, size decimal.Decimal) *PrivateWebSocketOrderPlace {
+	return &PrivateWebSocketOrderPlace{
+		InstID:  instID,
+		TdMode:  TradeModeCross,
+		Side:    side,
+		OrdType: OrderTypeMarket,
+		Size:    size,
+	}
+}
+
+func (p *PrivateWebSocketOrderPlace) Limit(price decimal.Decimal, ordType string) *PrivateWebSocketOrderPlace {
+	p.OrdType = ordType
+	p.Price = price.String()
+	return p
+}
+
+func (p *PrivateWebSocketOrderPlace) Request() *PrivateWebSocketRequest {
+	args, _ := json.Marshal([]*PrivateWebSocketOrderPlace{p})
+	return &PrivateWebSocketRequest{
+		OP:   "order",
+		Args: args,
+	}
+}
+
+func (p *PrivateWebSocketOrderPlace) Response(resp *PrivateWebSocketResponse) (*PrivateWebSocketOrderResult, error) {
+	return orderResult(resp)
+}
+
+type PrivateWebSocketOrderCancel struct {
+	InstID  string `json:"instId"`
+	OrdID   string `json:"ordId,omitempty"`
+	ClOrdID string `json:"clOrdId,omitempty"`
+}
+
+// NewPrivateWebSocketOrderCancel cancels by ordID or clOrdID, one of them is required
+func NewPrivateWebSocketOrderCancel(instID string, ordID string, clOrdID string) *PrivateWebSocketOrderCancel {
+	return &PrivateWebSocketOrderCancel{
+		InstID:  instID,
+		OrdID:   ordID,
+		ClOrdID: clOrdID,
+	}
+}
+
+func (p *PrivateWebSocketOrderCancel) Request() *PrivateWebSocketRequest {
+	args, _ := json.Marshal([]*PrivateWebSocketOrderCancel{p})
+	return &PrivateWebSocketRequest{
+		OP:   "cancel-order",
+		Args: args,
+	}
+}
+
+func (p *PrivateWebSocketOrderCancel) Response(resp *PrivateWebSocketResponse) (*PrivateWebSocketOrderResult, error) {
+	return orderResult(resp)
+}
+
+type PrivateWebSocketOrderAmend struct {
+	InstID   string `json:"instId"`
+	OrdID    string `json:"ordId,omitempty"`
+	ClOrdID  string `json:"clOrdId,omitempty"`
+	NewSize  string `json:"newSz,omitempty"`
+	NewPrice string `json:"newPx,omitempty"`
+}
+
+// NewPrivateWebSocketOrderAmend changes size and/or price of an open order, zero values are left unchanged
+func NewPrivateWebSocketOrderAmend(instID string, ordID string, clOrdID string, newSize decimal.Decimal, newPrice decimal.Decimal) *PrivateWebSocketOrderAmend {
+	amend := &PrivateWebSocketOrderAmend{
+		InstID:  instID,
+		OrdID:   ordID,
+		ClOrdID: clOrdID,
+	}
+	if !newSize.IsZero() {
+		amend.NewSize = newSize.String()
+	}
+	if !newPrice.IsZero() {
+		amend.NewPrice = newPrice.String()
+	}
+	return amend
+}
+
+func (p *PrivateWebSocketOrderAmend) Request() *PrivateWebSocketRequest {
+	args, _ := json.Marshal([]*PrivateWebSocketOrderAmend{p})
+	return &PrivateWebSocketRequest{
+		OP:   "amend-order",
+		Args: args,
+	}
+}
+
+func (p *PrivateWebSocketOrderAmend) Response(resp *PrivateWebSocketResponse) (*PrivateWebSocketOrderResult, error) {
+	return orderResult(resp)
+}
@@ -4,90 +4,37 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
-	"time"
-	"trade/src/common"
 
-	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/shopspring/decimal"
 )
 
 func (c *Client) InitPublicWebSocketConnection(ctx context.Context) error {
-	go c.ReadPublicWebSocketMessages(ctx)
-	return c.ConnectPublicWebSocket(ctx)
+	return c.public.init(ctx)
 }
 
 func (c *Client) ReadPublicWebSocketMessages(ctx context.Context) {
-	defer common.HandlePanic()
-	for {
-		if c.publicWebSocketConn == nil {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		_, message, err := c.publicWebSocketConn.ReadMessage()
-		if err != nil {
-			if c.closed {
-				return
-			}
-			common.Logger.Sugar().Warnf("ReadPublicWebSocketMessages ReadMessage error: %v", err)
-			c.ReconnectPublicWebSocket(ctx)
-			continue
-		}
-		var stream WebSocketStream
-		err = json.Unmarshal(message, &stream)
-		if err != nil {
-			common.Logger.Sugar().Warnf("ReadPublicWebSocketMessages Unmarshal error: %v %s", err, string(message))
-			continue
-		}
-		if hand, ok := c.publicWebSocketHandlers[stream.Arg.Key()]; ok {
-			hand(&stream)
-		} else {
-			common.Logger.Sugar().Warnf("ReadPublicWebSocketMessages No handler for message: %s", string(message))
-		}
-	}
+	c.public.read(ctx)
 }
 
 func (c *Client) ConnectPublicWebSocket(ctx context.Context) error {
-	var err error
-	c.publicWebSocketConn, _, err = websocket.DefaultDialer.DialContext(ctx, PublicWebSocketBaseURL, nil)
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.public.connect(ctx)
 }
 
 func (c *Client) ReconnectPublicWebSocket(ctx context.Context) {
-	for i := 0; i < 5; i++ {
-		time.Sleep(time.Second)
-		err := c.ConnectPublicWebSocket(ctx)
-		if err != nil {
-			common.Logger.Sugar().Warnf("ReconnectPublicWebSocket error %v", err)
-			continue
-		} else {
-			common.Logger.Sugar().Infof("ReconnectPublicWebSocket success after %d attempts", i+1)
-			break
-		}
-	}
+	c.public.reconnect(ctx)
+}
+
+// OnPublicWebSocketReconnect registers a handler called after the public connection
+// is re-established and all subscriptions have been replayed
+func (c *Client) OnPublicWebSocketReconnect(handler func()) {
+	c.public.onReconnect(handler)
 }
 
+// Subscribe sends the subscribe request and waits until every arg is acknowledged.
+// An error event from OKX (e.g. an unknown instId) is returned to the caller and the
+// handlers registered for the request are removed
 func (c *Client) Subscribe(request *WebSocketRequest, handler func(*WebSocketStream)) error {
-	if c.publicWebSocketConn == nil {
-		return fmt.Errorf("SubscribePublicWebSocket publicWebSocketConn is nil")
-	}
-	if request == nil || handler == nil {
-		return fmt.Errorf("SubscribePublicWebSocket request/handler is empty")
-	}
-	for _, arg := range request.Args {
-		c.publicWebSocketHandlers[arg.Key()] = handler
-	}
-	id := strings.Replace(uuid.New().String(), "-", "", -1)
-	request.ID = id
-	err := c.publicWebSocketConn.WriteJSON(request)
-	if err != nil {
-		return fmt.Errorf("SubscribePublicWebSocket WriteJSON error: %v", err)
-	}
-	return nil
+	return c.public.subscribe(request, handler)
 }
 
 type PublicWebSocketMarkPrices []*PublicWebSocketMarkPrice
@@ -129,3 +76,275 @@ func (p *PublicWebSocketMarkPrices) Stream(response *WebSocketStream) (*PublicWe
 	}
 	return p, nil
 }
+
+type PublicWebSocketBBOs []*PublicWebSocketBBO
+
+// PublicWebSocketBBO is the best bid/ask from the bbo-tbt channel, every level is
+// [price, size, deprecated, order count]
+type PublicWebSocketBBO struct {
+	InstID    string     `json:"-"`
+	Asks      [][]string `json:"asks"`
+	Bids      [][]string `json:"bids"`
+	Timestamp string     `json:"ts"`
+	SeqID     int64      `json:"seqId"`
+}
+
+func NewPublicWebSocketBBOs(instID string) *PublicWebSocketBBOs {
+	return &PublicWebSocketBBOs{
+		{
+			InstID: instID,
+		},
+	}
+}
+
+func (p *PublicWebSocketBBOs) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		arg := &WebSocketArg{
+			Channel: "bbo-tbt",
+			InstID:  item.InstID,
+		}
+		request.Args = append(request.Args, arg)
+	}
+	return request
+}
+
+func (p *PublicWebSocketBBOs) Stream(response *WebSocketStream) (*PublicWebSocketBBOs, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	if response.Arg != nil {
+		for _, item := range *p {
+			item.InstID = response.Arg.InstID
+		}
+	}
+	return p, nil
+}
+
+// Best returns the first level of a side as price and size
+func (p *PublicWebSocketBBO) Best(levels [][]string) (decimal.Decimal, decimal.Decimal, error) {
+	if len(levels) == 0 || len(levels[0]) < 2 {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Best %s error: empty book", p.InstID)
+	}
+	price, err := decimal.NewFromString(levels[0][0])
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	size, err := decimal.NewFromString(levels[0][1])
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	return price, size, nil
+}
+
+type PublicWebSocketTrades []*PublicWebSocketTrade
+
+type PublicWebSocketTrade struct {
+	InstID    string          `json:"instId"`
+	TradeID   string          `json:"tradeId"`
+	Price     decimal.Decimal `json:"px"`
+	Size      decimal.Decimal `json:"sz"`
+	Side      string          `json:"side"`
+	Timestamp string          `json:"ts"`
+	Count     string          `json:"count"`
+}
+
+func NewPublicWebSocketTrades(instID string) *PublicWebSocketTrades {
+	return &PublicWebSocketTrades{
+		{
+			InstID: instID,
+		},
+	}
+}
+
+func (p *PublicWebSocketTrades) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		arg := &WebSocketArg{
+			Channel: "trades",
+			InstID:  item.InstID,
+		}
+		request.Args = append(request.Args, arg)
+	}
+	return request
+}
+
+func (p *PublicWebSocketTrades) Stream(response *WebSocketStream) (*PublicWebSocketTrades, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type PublicWebSocketTickers []*PublicWebSocketTicker
+
+// PublicWebSocketTicker is pushed every 100ms on the tickers channel, it carries the best
+// bid/ask together with the last trade and 24h statistics
+type PublicWebSocketTicker struct {
+	InstType  string  `json:"instType"`
+	InstID    string  `json:"instId"`
+	Last      Decimal `json:"last"`
+	LastSz    Decimal `json:"lastSz"`
+	AskPx     Decimal `json:"askPx"`
+	AskSz     Decimal `json:"askSz"`
+	BidPx     Decimal `json:"bidPx"`
+	BidSz     Decimal `json:"bidSz"`
+	Open24h   Decimal `json:"open24h"`
+	High24h   Decimal `json:"high24h"`
+	Low24h    Decimal `json:"low24h"`
+	VolCcy24h Decimal `json:"volCcy24h"`
+	Vol24h    Decimal `json:"vol24h"`
+	SodUtc0   Decimal `json:"sodUtc0"`
+	SodUtc8   Decimal `json:"sodUtc8"`
+	Timestamp string  `json:"ts"`
+}
+
+func NewPublicWebSocketTickers(instID string) *PublicWebSocketTickers {
+	return &PublicWebSocketTickers{
+		{
+			InstID: instID,
+		},
+	}
+}
+
+func (p *PublicWebSocketTickers) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		arg := &WebSocketArg{
+			Channel: "tickers",
+			InstID:  item.InstID,
+		}
+		request.Args = append(request.Args, arg)
+	}
+	return request
+}
+
+func (p *PublicWebSocketTickers) Stream(response *WebSocketStream) (*PublicWebSocketTickers, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type PublicWebSocketFundingRates []*PublicWebSocketFundingRate
+
+// PublicWebSocketFundingRate is pushed every 30 to 90 seconds on the funding-rate
+// channel. FundingRate is settled at FundingTime, NextFundingRate is the predicted rate
+// of the following period and is empty when the method is current_period
+type PublicWebSocketFundingRate struct {
+	InstType        string  `json:"instType"`
+	InstID          string  `json:"instId"`
+	Method          string  `json:"method"`
+	FundingRate     Decimal `json:"fundingRate"`
+	NextFundingRate string  `json:"nextFundingRate"`
+	FundingTime     string  `json:"fundingTime"`
+	NextFundingTime string  `json:"nextFundingTime"`
+	Timestamp       string  `json:"ts"`
+}
+
+func NewPublicWebSocketFundingRates(instID string) *PublicWebSocketFundingRates {
+	return &PublicWebSocketFundingRates{
+		{
+			InstID: instID,
+		},
+	}
+}
+
+func (p *PublicWebSocketFundingRates) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		arg := &WebSocketArg{
+			Channel: "funding-rate",
+			InstID:  item.InstID,
+		}
+		request.Args = append(request.Args, arg)
+	}
+	return request
+}
+
+func (p *PublicWebSocketFundingRates) Stream(response *WebSocketStream) (*PublicWebSocketFundingRates, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+const (
+	// BooksChannel pushes a 400 level snapshot followed by incremental updates every 100ms
+	BooksChannel = "books"
+	// Books5Channel pushes a 5 level snapshot every 100ms
+	Books5Channel = "books5"
+
+	BooksActionSnapshot = "snapshot"
+	BooksActionUpdate   = "update"
+)
+
+type PublicWebSocketBooks []*PublicWebSocketBook
+
+// PublicWebSocketBook is a snapshot or an update of the books channels, every level is
+// [price, size, deprecated, order count] and a zero size removes the level. PrevSeqID is
+// -1 on a snapshot and Checksum is only set on the books channel
+type PublicWebSocketBook struct {
+	InstID    string     `json:"-"`
+	Channel   string     `json:"-"`
+	Asks      [][]string `json:"asks"`
+	Bids      [][]string `json:"bids"`
+	Timestamp string     `json:"ts"`
+	Checksum  int32      `json:"checksum"`
+	PrevSeqID int64      `json:"prevSeqId"`
+	SeqID     int64      `json:"seqId"`
+}
+
+func NewPublicWebSocketBooks(instID string, channel string) *PublicWebSocketBooks {
+	return &PublicWebSocketBooks{
+		{
+			InstID:  instID,
+			Channel: channel,
+		},
+	}
+}
+
+func (p *PublicWebSocketBooks) Subscribe() *WebSocketRequest {
+	request := &WebSocketRequest{
+		OP:   "subscribe",
+		Args: []*WebSocketArg{},
+	}
+	for _, item := range *p {
+		arg := &WebSocketArg{
+			Channel: item.Channel,
+			InstID:  item.InstID,
+		}
+		request.Args = append(request.Args, arg)
+	}
+	return request
+}
+
+func (p *PublicWebSocketBooks) Stream(response *WebSocketStream) (*PublicWebSocketBooks, error) {
+	err := json.Unmarshal(response.Data, p)
+	if err != nil {
+		return nil, err
+	}
+	if response.Arg != nil {
+		for _, item := range *p {
+			item.InstID = response.Arg.InstID
+			item.Channel = response.Arg.Channel
+		}
+	}
+	return p, nil
+}
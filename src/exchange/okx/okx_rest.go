@@ -0,0 +1,168 @@
+package okx
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	InstrumentStateLive    = "live"
+	InstrumentStateSuspend = "suspend"
+	InstrumentStatePreopen = "preopen"
+	InstrumentStateTest    = "test"
+)
+
+// RESTResponse is the envelope of every v5 REST response
+type RESTResponse struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// get sends an unsigned GET to the v5 REST API and decodes the data field into v
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.send(ctx, path, query, false, v)
+}
+
+// getSigned sends a GET signed with the api key of WithAPIKey
+func (c *Client) getSigned(ctx context.Context, path string, query url.Values, v interface{}) error {
+	if c.apiKey == "" || c.secretKey == "" || c.passphrase == "" {
+		return fmt.Errorf("get %s error: apiKey/secretKey/passphrase is empty", path)
+	}
+	return c.send(ctx, path, query, true, v)
+}
+
+func (c *Client) send(ctx context.Context, path string, query url.Values, signed bool, v interface{}) error {
+	requestPath := path
+	if len(query) > 0 {
+		requestPath += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.restURL+requestPath, nil)
+	if err != nil {
+		return err
+	}
+	if signed {
+		timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+		req.Header.Set("OK-ACCESS-KEY", c.apiKey)
+		req.Header.Set("OK-ACCESS-SIGN", RESTSign(c.secretKey, timestamp, http.MethodGet, requestPath, ""))
+		req.Header.Set("OK-ACCESS-TIMESTAMP", timestamp)
+		req.Header.Set("OK-ACCESS-PASSPHRASE", c.passphrase)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	response := &RESTResponse{}
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("get %s error: status %d %s", path, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK || response.Code != "0" {
+		return fmt.Errorf("get %s error: status %d code %s msg %s", path, resp.StatusCode, response.Code, response.Msg)
+	}
+	return json.Unmarshal(response.Data, v)
+}
+
+// RESTSign is Base64(HMAC-SHA256(secretKey, timestamp + method + requestPath + body)),
+// requestPath includes the query string
+func RESTSign(secretKey string, timestamp string, method string, requestPath string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(timestamp + method + requestPath + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type Instrument struct {
+	InstType   string  `json:"instType"`
+	InstID     string  `json:"instId"`
+	Uly        string  `json:"uly"`
+	InstFamily string  `json:"instFamily"`
+	SettleCcy  string  `json:"settleCcy"`
+	CtVal      Decimal `json:"ctVal"`
+	CtMult     Decimal `json:"ctMult"`
+	CtValCcy   string  `json:"ctValCcy"`
+	CtType     string  `json:"ctType"`
+	TickSz     Decimal `json:"tickSz"`
+	LotSz      Decimal `json:"lotSz"`
+	MinSz      Decimal `json:"minSz"`
+	State      string  `json:"state"`
+}
+
+// GetInstruments fetches /api/v5/public/instruments of one instType
+func (c *Client) GetInstruments(ctx context.Context, instType string) ([]*Instrument, error) {
+	instruments := []*Instrument{}
+	err := c.get(ctx, "/api/v5/public/instruments", url.Values{"instType": {instType}}, &instruments)
+	if err != nil {
+		return nil, err
+	}
+	return instruments, nil
+}
+
+const (
+	ContractTypeLinear  = "linear"
+	ContractTypeInverse = "inverse"
+)
+
+// HistoryCandlesLimit is the max number of candles returned by one history-candles request
+const HistoryCandlesLimit = 100
+
+// GetHistoryCandles fetches /api/v5/market/history-candles newest first, after and before
+// are exclusive millisecond bounds of the candle timestamps and are omitted when zero
+func (c *Client) GetHistoryCandles(ctx context.Context, instID string, bar string, after int64, before int64, limit int) ([]*Candle, error) {
+	query := url.Values{"instId": {instID}, "bar": {bar}, "limit": {strconv.Itoa(limit)}}
+	if after > 0 {
+		query.Set("after", strconv.FormatInt(after, 10))
+	}
+	if before > 0 {
+		query.Set("before", strconv.FormatInt(before, 10))
+	}
+	candles := []*Candle{}
+	err := c.get(ctx, "/api/v5/market/history-candles", query, &candles)
+	if err != nil {
+		return nil, err
+	}
+	return candles, nil
+}
+
+// GetPositions fetches /api/v5/account/positions of the swaps, the quantities are in
+// contracts and negative when short
+func (c *Client) GetPositions(ctx context.Context) ([]market.Position, error) {
+	items := []*PrivateWebSocketPosition{}
+	err := c.getSigned(ctx, "/api/v5/account/positions", url.Values{"instType": {InstTypeSwap}}, &items)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositions error: %w", err)
+	}
+	positions := []market.Position{}
+	for _, item := range items {
+		quantity := item.Pos.Decimal
+		if item.PosSide == PosSideShort {
+			quantity = quantity.Abs().Neg()
+		}
+		if quantity.IsZero() {
+			continue
+		}
+		positions = append(positions, market.Position{
+			Exchange:    market.ExchangeOKX,
+			Symbol:      item.InstID,
+			Quantity:    quantity,
+			EntryPrice:  item.AvgPrice.Decimal,
+			RealizedPnL: decimal.Zero,
+		})
+	}
+	return positions, nil
+}
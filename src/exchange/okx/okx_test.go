@@ -2,9 +2,20 @@ package okx
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"trade/src/common"
+	"trade/src/exchange/testserver"
+	"trade/src/market"
 
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,12 +23,71 @@ func TestOKX(t *testing.T) {
 	t.Run("FuturesStream", func(t *testing.T) {
 		testPublicWebSocketMarkPrices(t)
 	})
+	t.Run("SubscribeError", func(t *testing.T) {
+		testPublicWebSocketSubscribeError(t)
+	})
+	t.Run("Reconnect", func(t *testing.T) {
+		testPublicWebSocketReconnect(t)
+	})
+	t.Run("PrivateLogin", func(t *testing.T) {
+		testPrivateWebSocketLogin(t)
+	})
+	t.Run("PrivateOrders", func(t *testing.T) {
+		testPrivateWebSocketOrders(t)
+	})
+	t.Run("PrivateReconnect", func(t *testing.T) {
+		testPrivateWebSocketReconnect(t)
+	})
+	t.Run("ExecutionVenue", func(t *testing.T) {
+		testExecutionVenue(t)
+	})
+	t.Run("MarketData", func(t *testing.T) {
+		testMarketData(t)
+	})
+	t.Run("BookTickerTickers", func(t *testing.T) {
+		testBookTickerTickers(t)
+	})
+	t.Run("OrderBook", func(t *testing.T) {
+		testOrderBook(t)
+	})
+	t.Run("OrderBook5", func(t *testing.T) {
+		testOrderBook5(t)
+	})
+	t.Run("Funding", func(t *testing.T) {
+		testFunding(t)
+	})
+	t.Run("Candles", func(t *testing.T) {
+		testCandles(t)
+	})
+	t.Run("Instruments", func(t *testing.T) {
+		testInstruments(t)
+	})
+	t.Run("ReadCancel", func(t *testing.T) {
+		testReadCancel(t)
+	})
+	t.Run("RecordReplay", func(t *testing.T) {
+		testRecordReplay(t)
+	})
+}
+
+func newTestClient(t *testing.T, opts ...Option) (*Client, *testserver.OKX) {
+	server := testserver.NewOKX()
+	server.SetCredentials("key", "secret", "passphrase")
+	t.Cleanup(server.Close)
+	cli := NewClient(append([]Option{
+		WithPublicWebSocketURL(server.PublicWebSocketURL()),
+		WithPrivateWebSocketURL(server.PrivateWebSocketURL()),
+		WithBusinessWebSocketURL(server.BusinessWebSocketURL()),
+		WithRESTURL(server.RESTURL()),
+	}, opts...)...)
+	t.Cleanup(cli.Clean)
+	return cli, server
 }
 
 func testPublicWebSocketMarkPrices(t *testing.T) {
-	cli := NewClient()
-	defer cli.Clean()
-	var count int
+	cli, server := newTestClient(t)
+	server.SetMarkPrices("BTC-USDT-SWAP", "100.1", "100.2")
+	var count atomic.Int64
 	err := cli.InitPublicWebSocketConnection(context.Background())
 	if err != nil {
 		t.Fatal(err)
@@ -30,15 +100,593 @@ func testPublicWebSocketMarkPrices(t *testing.T) {
 			return
 		}
 		for _, p := range *price {
-			require.Equal(t, "BTC-USDT-SWAP", p.InstID)
-			require.NotZero(t, p.MarkPrice)
-			require.NotEmpty(t, p.Timestamp)
-			count++
+			assert.Equal(t, "BTC-USDT-SWAP", p.InstID)
+			assert.NotZero(t, p.MarkPrice)
+			assert.NotEmpty(t, p.Timestamp)
+			count.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Eventually(t, func() bool { return count.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func testMarketData(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetMarkPrices("BTC-USDT-SWAP", "100", "101", "102")
+	err := cli.InitMarketData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		ticks = make(map[market.TickType]*market.Tick)
+	)
+	handler := func(tick *market.Tick) {
+		mu.Lock()
+		defer mu.Unlock()
+		ticks[tick.Type] = tick
+	}
+	require.NoError(t, cli.SubscribeMarkPrice("BTC-USDT-SWAP", handler))
+	require.NoError(t, cli.SubscribeBookTicker("BTC-USDT-SWAP", handler))
+	require.NoError(t, cli.SubscribeTrades("BTC-USDT-SWAP", handler))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ticks) == 3
+	}, 3*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, tick := range ticks {
+		assert.Equal(t, market.ExchangeOKX, tick.Exchange)
+		assert.Equal(t, "BTC-USDT-SWAP", tick.Symbol)
+		assert.True(t, tick.Price.IsPositive())
+		assert.NotZero(t, tick.ExchangeTime)
+		assert.NotZero(t, tick.LocalTime)
+	}
+	book := ticks[market.TickTypeBookTicker]
+	assert.True(t, book.AskPrice.Sub(book.BidPrice).Equal(decimal.RequireFromString("0.2")))
+	assert.True(t, book.Price.Equal(book.BidPrice.Add(book.AskPrice).Div(decimal.NewFromInt(2))))
+	trade := ticks[market.TickTypeTrade]
+	assert.True(t, trade.Quantity.IsPositive())
+	assert.Contains(t, []string{market.SideBuy, market.SideSell}, trade.Side)
+}
+
+func testReadCancel(t *testing.T) {
+	read := func(ctx context.Context, cli *Client) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			cli.ReadPublicWebSocketMessages(ctx)
+			close(done)
+		}()
+		return done
+	}
+	wait := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("reader did not return after cancel")
+		}
+	}
+
+	// without a connection the reader stops waiting for one
+	cli := NewClient(WithPublicWebSocketURL("ws://127.0.0.1:1/ws/v5/public"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := read(ctx, cli)
+	cancel()
+	wait(done)
+
+	// a blocked ReadMessage is unblocked and the reader does not reconnect
+	cli, _ = newTestClient(t)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, cli.ConnectPublicWebSocket(ctx))
+	done = read(ctx, cli)
+	require.NoError(t, cli.Subscribe(NewPublicWebSocketMarkPrices("BTC-USDT-SWAP").Subscribe(), func(*WebSocketStream) {}))
+	cancel()
+	wait(done)
+}
+
+func testBookTickerTickers(t *testing.T) {
+	cli, server := newTestClient(t, WithBookTickerChannel(BookTickerChannelTickers))
+	server.SetMarkPrices("ETH-USDT-SWAP", "2000")
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	ticks := make(chan *market.Tick, 16)
+	require.NoError(t, cli.SubscribeBookTicker("ETH-USDT-SWAP", func(tick *market.Tick) {
+		select {
+		case ticks <- tick:
+		default:
+		}
+	}))
+	select {
+	case tick := <-ticks:
+		assert.Equal(t, market.TickTypeBookTicker, tick.Type)
+		assert.Equal(t, "ETH-USDT-SWAP", tick.Symbol)
+		assert.True(t, tick.BidPrice.Equal(decimal.RequireFromString("1999.9")))
+		assert.True(t, tick.AskPrice.Equal(decimal.RequireFromString("2000.1")))
+		assert.True(t, tick.Price.Equal(decimal.NewFromInt(2000)))
+		assert.NotZero(t, tick.ExchangeTime)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no tickers push")
+	}
+	assert.Equal(t, 1, server.Requests("subscribe"))
+}
+
+func testOrderBook(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	var updates atomic.Int64
+	book, err := cli.SubscribeOrderBook("BTC-USDT-SWAP", func(*market.OrderBook) {
+		updates.Add(1)
+	})
+	require.NoError(t, err)
+	// every update is verified against the checksum so no resync means the book is right
+	require.Eventually(t, func() bool {
+		return updates.Load() >= 20
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, server.Requests("unsubscribe"))
+	bid, ok := book.BestBid()
+	require.True(t, ok)
+	ask, ok := book.BestAsk()
+	require.True(t, ok)
+	assert.True(t, bid.Price.LessThan(ask.Price))
+	vwap, ok := book.VWAP(market.SideSell, bid.Quantity)
+	require.True(t, ok)
+	assert.True(t, vwap.Equal(bid.Price))
+
+	resynced := func(count int) {
+		require.Eventually(t, func() bool {
+			return server.Requests("unsubscribe") == count
+		}, 3*time.Second, 10*time.Millisecond)
+		current := updates.Load()
+		require.Eventually(t, func() bool {
+			_, ok := book.BestAsk()
+			return ok && updates.Load() > current+3
+		}, 3*time.Second, 10*time.Millisecond)
+	}
+	// a dropped update breaks the seqId chain
+	server.SkipBookUpdate("BTC-USDT-SWAP")
+	resynced(1)
+	// a wrong checksum
+	server.CorruptBookChecksum("BTC-USDT-SWAP")
+	resynced(2)
+	assert.Equal(t, 2, server.Requests("unsubscribe"))
+}
+
+func testOrderBook5(t *testing.T) {
+	cli, _ := newTestClient(t)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	var updates atomic.Int64
+	book, err := cli.SubscribeOrderBook5("BTC-USDT-SWAP", func(*market.OrderBook) {
+		updates.Add(1)
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return updates.Load() >= 3
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.LessOrEqual(t, len(book.Bids(0)), 5)
+	assert.LessOrEqual(t, len(book.Asks(0)), 5)
+	assert.NotEmpty(t, book.Asks(0))
+}
+
+func testCandles(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	candles := make(chan *market.Candle, 10)
+	err := cli.SubscribeCandles("BTC-USDT-SWAP", market.CandleInterval1h, func(candle *market.Candle) {
+		select {
+		case candles <- candle:
+		default:
+		}
+	})
+	require.NoError(t, err)
+	select {
+	case candle := <-candles:
+		assert.Equal(t, "BTC-USDT-SWAP", candle.Symbol)
+		assert.Zero(t, candle.OpenTime%time.Hour.Milliseconds())
+		assert.Equal(t, candle.OpenTime+time.Hour.Milliseconds()-1, candle.CloseTime)
+		assert.True(t, candle.Volume.IsPositive())
+		assert.False(t, candle.Closed)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no candle received")
+	}
+
+	// 250 minutes take three pages of HistoryCandlesLimit
+	end := time.Now().Truncate(time.Minute).UnixMilli()
+	start := end - 250*time.Minute.Milliseconds()
+	history, err := cli.LoadCandles(context.Background(), "BTC-USDT-SWAP", market.CandleInterval1m, start, end)
+	require.NoError(t, err)
+	require.Len(t, history, 250)
+	assert.Equal(t, 3, server.Requests(testserver.OKXHistoryCandlesPath))
+	for i, candle := range history {
+		assert.Equal(t, start+int64(i)*time.Minute.Milliseconds(), candle.OpenTime)
+		assert.True(t, candle.Closed)
+	}
+}
+
+func testInstruments(t *testing.T) {
+	cli, server := newTestClient(t)
+	instruments, err := cli.LoadInstruments(context.Background())
+	require.NoError(t, err)
+	// the inverse BTC-USD-SWAP is skipped
+	require.Len(t, instruments, 3)
+
+	btc := instruments[0]
+	assert.Equal(t, "BTC/USDT:USDT", btc.ID)
+	assert.Equal(t, market.ExchangeOKX, btc.Exchange)
+	assert.Equal(t, "BTC-USDT-SWAP", btc.Symbol)
+	assert.True(t, btc.ContractValue.Equal(decimal.RequireFromString("0.01")))
+	assert.True(t, btc.LotSize.Equal(decimal.RequireFromString("0.01")))
+	assert.True(t, btc.TickSize.Equal(decimal.RequireFromString("0.1")))
+	assert.True(t, btc.MinNotional.IsZero())
+	assert.True(t, btc.Trading())
+	assert.Equal(t, 1, server.Requests(testserver.OKXInstrumentsPath))
+
+	_, err = cli.GetInstruments(context.Background(), InstTypeSpot)
+	require.ErrorContains(t, err, "51000")
+}
+
+func testPublicWebSocketSubscribeError(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.RejectInstrument("WLFI-USDT-SWAP", "60018", "Invalid args: instId")
+	err := cli.InitPublicWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Subscribe(NewPublicWebSocketMarkPrices("WLFI-USDT-SWAP").Subscribe(), func(*WebSocketStream) {})
+	require.ErrorContains(t, err, "60018")
+}
+
+func testPublicWebSocketReconnect(t *testing.T) {
+	cli, server := newTestClient(t)
+	var count atomic.Int64
+	err := cli.InitPublicWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconnected := make(chan struct{}, 1)
+	cli.OnPublicWebSocketReconnect(func() { reconnected <- struct{}{} })
+	err = cli.Subscribe(NewPublicWebSocketMarkPrices("ETH-USDT-SWAP").Subscribe(), func(*WebSocketStream) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.DropConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("public connection was not re-established")
+	}
+	before := count.Load()
+	require.Eventually(t, func() bool { return count.Load() > before }, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, server.Requests("subscribe"))
+}
+
+func testPrivateWebSocketLogin(t *testing.T) {
+	cli, _ := newTestClient(t, WithAPIKey("key", "wrong-secret", "passphrase"))
+	err := cli.InitPrivateWebSocketConnection(context.Background())
+	require.ErrorContains(t, err, "60007")
+
+	cli, _ = newTestClient(t, WithAPIKey("key", "secret", "passphrase"))
+	err = cli.InitPrivateWebSocketConnection(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, cli.SubscribePrivate(NewPrivateWebSocketAccounts().Subscribe(), func(*WebSocketStream) {}))
+	require.NoError(t, cli.SubscribePrivate(NewPrivateWebSocketPositions(InstTypeSwap, "").Subscribe(), func(*WebSocketStream) {}))
+	require.NoError(t, cli.SubscribePrivate(NewPrivateWebSocketBalanceAndPositions().Subscribe(), func(*WebSocketStream) {}))
+}
+
+func testPrivateWebSocketOrders(t *testing.T) {
+	cli, server := newTestClient(t, WithAPIKey("key", "secret", "passphrase"))
+	server.SetMarkPrices("BTC-USDT-SWAP", "100")
+	err := cli.InitPrivateWebSocketConnection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan PrivateWebSocketOrder, 10)
+	orders := NewPrivateWebSocketOrders(InstTypeSwap, "")
+	err = cli.SubscribePrivate(orders.Subscribe(), func(stream *WebSocketStream) {
+		_, err := orders.Stream(stream)
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, order := range *orders {
+			updates <- *order
 		}
 	})
+	require.NoError(t, err)
+
+	place := NewPrivateWebSocketOrderPlace("BTC-USDT-SWAP", OrderSideBuy, decimal.NewFromInt(2)).
+		Limit(decimal.NewFromInt(90), OrderTypeLimit)
+	resp, err := cli.Call(place.Request())
+	require.NoError(t, err)
+	result, err := place.Response(resp)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.OrdID)
+	update := nextOrder(t, updates)
+	require.Equal(t, OrderStateLive, update.State)
+	require.Equal(t, result.OrdID, update.OrdID)
+
+	amend := NewPrivateWebSocketOrderAmend("BTC-USDT-SWAP", result.OrdID, "", decimal.NewFromInt(3), decimal.Zero)
+	resp, err = cli.Call(amend.Request())
+	require.NoError(t, err)
+	_, err = amend.Response(resp)
+	require.NoError(t, err)
+	update = nextOrder(t, updates)
+	require.True(t, decimal.NewFromInt(3).Equal(update.Size.Decimal))
+
+	cancel := NewPrivateWebSocketOrderCancel("BTC-USDT-SWAP", result.OrdID, "")
+	resp, err = cli.Call(cancel.Request())
+	require.NoError(t, err)
+	_, err = cancel.Response(resp)
+	require.NoError(t, err)
+	update = nextOrder(t, updates)
+	require.Equal(t, OrderStateCanceled, update.State)
+
+	_, err = cli.Call(cancel.Request())
+	require.ErrorContains(t, err, "51400")
+
+	market := NewPrivateWebSocketOrderPlace("BTC-USDT-SWAP", OrderSideSell, decimal.NewFromInt(1))
+	resp, err = cli.Call(market.Request())
+	require.NoError(t, err)
+	_, err = market.Response(resp)
+	require.NoError(t, err)
+	update = nextOrder(t, updates)
+	require.Equal(t, OrderStateFilled, update.State)
+	require.True(t, decimal.NewFromInt(100).Equal(update.AvgPrice.Decimal))
+}
+
+func nextOrder(t *testing.T, updates chan PrivateWebSocketOrder) PrivateWebSocketOrder {
+	select {
+	case order := <-updates:
+		return order
+	case <-time.After(3 * time.Second):
+		t.Fatal("no order update received")
+		return PrivateWebSocketOrder{}
+	}
+}
+
+func testPrivateWebSocketReconnect(t *testing.T) {
+	cli, server := newTestClient(t, WithAPIKey("key", "secret", "passphrase"))
+	err := cli.InitPrivateWebSocketConnection(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(3 * time.Second)
-	require.Greater(t, count, 0)
+	reconnected := make(chan struct{}, 1)
+	cli.OnPrivateWebSocketReconnect(func() { reconnected <- struct{}{} })
+	var count atomic.Int64
+	err = cli.SubscribePrivate(NewPrivateWebSocketOrders(InstTypeSwap, "").Subscribe(), func(*WebSocketStream) {
+		count.Add(1)
+	})
+	require.NoError(t, err)
+	server.DropConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("private connection was not re-established")
+	}
+	require.Equal(t, 2, server.Requests("login"))
+	_, err = cli.Call(NewPrivateWebSocketOrderPlace("ETH-USDT-SWAP", OrderSideBuy, decimal.NewFromInt(1)).Request())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return count.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func testExecutionVenue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, server := newTestClient(t, WithAPIKey("key", "secret", "passphrase"))
+	server.SetMarkPrices("BTC-USDT-SWAP", "100")
+	server.SetFillLimit("BTC-USDT-SWAP", "1")
+	updates := make(chan *market.Order, 10)
+	cli.OnOrderUpdate(func(order *market.Order) { updates <- order })
+	require.NoError(t, cli.InitTrading(ctx))
+	require.NoError(t, cli.InitTrading(ctx), "InitTrading is idempotent")
+	require.Equal(t, 1, server.Requests("subscribe"))
+
+	next := func() *market.Order {
+		select {
+		case order := <-updates:
+			return order
+		case <-time.After(3 * time.Second):
+			t.Fatal("no order update received")
+			return nil
+		}
+	}
+	// the quantity is in contracts
+	order, err := cli.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: decimal.NewFromInt(3)})
+	require.NoError(t, err)
+	require.Equal(t, market.OrderStatusNew, order.Status)
+	require.NotEmpty(t, order.ID)
+	update := next()
+	require.Equal(t, order.ID, update.ID)
+	require.Equal(t, market.OrderStatusPartiallyFilled, update.Status)
+	require.True(t, decimal.NewFromInt(1).Equal(update.FilledQuantity))
+	require.True(t, decimal.NewFromInt(100).Equal(update.AvgPrice))
+
+	canceled, err := cli.CancelOrder(ctx, "BTC-USDT-SWAP", order.ID)
+	require.NoError(t, err)
+	require.Nil(t, canceled, "OKX only acknowledges the cancel")
+	require.Equal(t, market.OrderStatusCanceled, next().Status)
+	positions, err := cli.GetPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.Equal(t, "BTC-USDT-SWAP", positions[0].Symbol)
+	require.True(t, decimal.NewFromInt(-1).Equal(positions[0].Quantity))
+	require.True(t, decimal.NewFromInt(100).Equal(positions[0].EntryPrice))
+	_, err = NewClient(WithRESTURL(server.RESTURL()), WithAPIKey("key", "wrong", "passphrase")).GetPositions(ctx)
+	require.ErrorContains(t, err, "50113")
+
+	server.FailOp("order", "51008", "Order failed. Insufficient margin.")
+	order, err = cli.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeLimit, Price: decimal.NewFromInt(90), Quantity: decimal.NewFromInt(1)})
+	require.NoError(t, err)
+	require.Equal(t, market.OrderStatusRejected, order.Status)
+	require.Contains(t, order.Reason, "51008")
+
+	// the deadline of the caller bounds the wait for the response
+	release := make(chan struct{})
+	defer close(release)
+	server.HandleOp("order", func([]json.RawMessage) []map[string]string {
+		<-release
+		return nil
+	})
+	deadline, cancelDeadline := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelDeadline()
+	begin := time.Now()
+	_, err = cli.PlaceOrder(deadline, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: decimal.NewFromInt(1)})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(begin), time.Second)
+	_, err = cli.CancelOrder(deadline, "BTC-USDT-SWAP", order.ID)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "nothing is sent after the deadline")
+}
+
+func TestLoginSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1538054050GET/users/self/verify"))
+	require.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), LoginSign("secret", "1538054050"))
+}
+
+func TestClientConcurrency(t *testing.T) {
+	cli, server := newTestClient(t)
+	require.NoError(t, cli.InitPublicWebSocketConnection(context.Background()))
+
+	var (
+		wg       sync.WaitGroup
+		received atomic.Int64
+	)
+	for _, instID := range []string{"BTC-USDT-SWAP", "ETH-USDT-SWAP", "SOL-USDT-SWAP", "DOGE-USDT-SWAP", "XRP-USDT-SWAP"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			price := NewPublicWebSocketMarkPrices(instID)
+			err := cli.Subscribe(price.Subscribe(), func(stream *WebSocketStream) {
+				if _, err := price.Stream(stream); err == nil {
+					received.Add(1)
+				}
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return received.Load() > 0 }, 3*time.Second, 10*time.Millisecond)
+
+	reconnected := make(chan struct{}, 1)
+	cli.OnPublicWebSocketReconnect(func() { reconnected <- struct{}{} })
+	server.DropConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("public connection was not re-established")
+	}
+	before := received.Load()
+	require.Eventually(t, func() bool { return received.Load() > before }, 3*time.Second, 10*time.Millisecond)
+}
+
+func testFunding(t *testing.T) {
+	cli, server := newTestClient(t)
+	server.SetFunding("ETH-USDT-SWAP", "0.0003", "0.0005", 4*time.Hour)
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	fundings := make(chan *market.Funding, 10)
+	handler := func(funding *market.Funding) {
+		select {
+		case fundings <- funding:
+		default:
+		}
+	}
+	require.NoError(t, cli.SubscribeFunding("BTC-USDT-SWAP", handler))
+	require.NoError(t, cli.SubscribeFunding("ETH-USDT-SWAP", handler))
+
+	received := map[string]*market.Funding{}
+	timeout := time.After(3 * time.Second)
+	for len(received) < 2 {
+		select {
+		case funding := <-fundings:
+			received[funding.Symbol] = funding
+		case <-timeout:
+			t.Fatalf("fundings received: %v", received)
+		}
+	}
+	btc := received["BTC-USDT-SWAP"]
+	assert.True(t, btc.Rate.Equal(decimal.RequireFromString(testserver.DefaultFundingRate)))
+	assert.Equal(t, 8*time.Hour, btc.Interval)
+	assert.False(t, btc.PredictedRate.Valid, "an empty nextFundingRate is no prediction")
+	eth := received["ETH-USDT-SWAP"]
+	assert.Equal(t, 4*time.Hour, eth.Interval)
+	assert.Zero(t, eth.FundingTime%eth.Interval.Milliseconds())
+	require.True(t, eth.PredictedRate.Valid)
+	assert.True(t, eth.PredictedRate.Decimal.Equal(decimal.RequireFromString("0.0005")))
+	assert.True(t, eth.AnnualizedRate().Equal(decimal.RequireFromString("65.7")))
+}
+
+func testRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder := common.NewRecorder(dir)
+	cli, server := newTestClient(t, WithRecorder(recorder))
+	server.SetMarkPrices("BTC-USDT-SWAP", "100", "101", "102")
+	require.NoError(t, cli.InitMarketData(context.Background()))
+	var (
+		mu   sync.Mutex
+		live []*market.Tick
+	)
+	require.NoError(t, cli.SubscribeMarkPrice("BTC-USDT-SWAP", func(tick *market.Tick) {
+		mu.Lock()
+		defer mu.Unlock()
+		live = append(live, tick)
+	}))
+	require.NoError(t, cli.SubscribeBookTicker("BTC-USDT-SWAP", func(tick *market.Tick) {
+		mu.Lock()
+		defer mu.Unlock()
+		live = append(live, tick)
+	}))
+	// the candles come on the business connection, recorded under their own source
+	var liveCandles []*market.Candle
+	require.NoError(t, cli.SubscribeCandles("BTC-USDT-SWAP", market.CandleInterval1h, func(candle *market.Candle) {
+		mu.Lock()
+		defer mu.Unlock()
+		liveCandles = append(liveCandles, candle)
+	}))
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(live) >= 6 && len(liveCandles) >= 1
+	}, 3*time.Second, 10*time.Millisecond)
+	cli.Clean()
+	require.NoError(t, recorder.Close())
+
+	replay := common.NewReplay(dir, common.WithSpeed(0))
+	replayed := NewReplayClient(replay)
+	require.NoError(t, replayed.InitMarketData(context.Background()))
+	ticks := []*market.Tick{}
+	handler := func(tick *market.Tick) {
+		ticks = append(ticks, tick)
+	}
+	require.NoError(t, replayed.SubscribeMarkPrice("BTC-USDT-SWAP", handler))
+	require.NoError(t, replayed.SubscribeBookTicker("BTC-USDT-SWAP", handler))
+	candles := []*market.Candle{}
+	require.NoError(t, replayed.SubscribeCandles("BTC-USDT-SWAP", market.CandleInterval1h, func(candle *market.Candle) {
+		candles = append(candles, candle)
+	}))
+	require.NoError(t, replay.Run(context.Background()))
+
+	// both channels are replayed in the order they were received, the last frames may
+	// have been read after the snapshot
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(ticks), 6)
+	for i := 0; i < min(len(live), len(ticks)); i++ {
+		assert.Equal(t, live[i].Type, ticks[i].Type)
+		assert.True(t, ticks[i].Price.Equal(live[i].Price), "tick %d: %s != %s", i, ticks[i].Price, live[i].Price)
+		assert.True(t, ticks[i].BidPrice.Equal(live[i].BidPrice))
+		assert.Equal(t, live[i].ExchangeTime, ticks[i].ExchangeTime)
+		assert.LessOrEqual(t, ticks[i].LocalTime, live[i].LocalTime)
+		assert.InDelta(t, live[i].LocalTime, ticks[i].LocalTime, 50)
+	}
+	require.GreaterOrEqual(t, len(candles), 1)
+	for i := 0; i < min(len(liveCandles), len(candles)); i++ {
+		assert.Equal(t, liveCandles[i].OpenTime, candles[i].OpenTime)
+		assert.True(t, candles[i].Close.Equal(liveCandles[i].Close), "candle %d: %s != %s", i, candles[i].Close, liveCandles[i].Close)
+	}
+	// the last frame replayed may be a candle
+	assert.LessOrEqual(t, ticks[len(ticks)-1].LocalTime, replay.Now())
 }
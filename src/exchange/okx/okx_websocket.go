@@ -0,0 +1,391 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"trade/src/common"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// WebSocketSubscribeBatchSize is the max number of args sent in one subscribe
+	// request when replaying subscriptions after a reconnect
+	WebSocketSubscribeBatchSize = 50
+)
+
+// webSocket is one OKX v5 connection (public or private). It is safe for concurrent use:
+// writes are serialized by writeMu since gorilla websocket supports one concurrent writer,
+// the registries are guarded by mu and the connection is swapped atomically on reconnect
+type webSocket struct {
+	name     string
+	url      string
+	closed   *atomic.Bool
+	conn     atomic.Pointer[websocket.Conn]
+	writeMu  sync.Mutex
+	mu       sync.RWMutex
+	handlers map[string]func(*WebSocketStream)
+	// args keeps every acknowledged subscription so it can be replayed after a reconnect
+	args              map[string]*WebSocketArg
+	events            map[string]chan *WebSocketStream
+	responses         map[string]chan *PrivateWebSocketResponse
+	reconnectHandlers []func()
+	// login authenticates a freshly dialed connection before it is used, nil for public
+	login func(conn *websocket.Conn) error
+	// recorder keeps every frame read under source, replay leaves the connection
+	// closed and only registers the subscriptions for the frames of a common.Replay
+	recorder *common.Recorder
+	source   string
+	replay   bool
+}
+
+func newWebSocket(name string, url string, closed *atomic.Bool) *webSocket {
+	return &webSocket{
+		name:      name,
+		url:       url,
+		closed:    closed,
+		handlers:  make(map[string]func(*WebSocketStream), 100),
+		args:      make(map[string]*WebSocketArg, 100),
+		events:    make(map[string]chan *WebSocketStream, 100),
+		responses: make(map[string]chan *PrivateWebSocketResponse, 100),
+	}
+}
+
+func (w *webSocket) init(ctx context.Context) error {
+	if w.replay {
+		return nil
+	}
+	go w.read(ctx)
+	return w.connect(ctx)
+}
+
+func (w *webSocket) close() {
+	if conn := w.conn.Load(); conn != nil {
+		conn.Close()
+	}
+}
+
+func (w *webSocket) writeJSON(conn *websocket.Conn, v interface{}) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return conn.WriteJSON(v)
+}
+
+func (w *webSocket) read(ctx context.Context) {
+	defer common.HandlePanic()
+	// unblock ReadMessage once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		if conn := w.conn.Load(); conn != nil {
+			conn.Close()
+		}
+	})
+	defer stop()
+	for {
+		if w.closed.Load() || ctx.Err() != nil {
+			return
+		}
+		conn := w.conn.Load()
+		if conn == nil {
+			if !common.Sleep(ctx, 100*time.Millisecond) {
+				return
+			}
+			continue
+		}
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if w.closed.Load() || ctx.Err() != nil {
+				return
+			}
+			common.Logger.Sugar().Warnf("Read%sWebSocketMessages ReadMessage error: %v", w.name, err)
+			w.reconnect(ctx)
+			continue
+		}
+		if w.recorder != nil {
+			w.recorder.Record(w.source, time.Now().UnixMilli(), message)
+		}
+		w.handleMessage(message)
+	}
+}
+
+// handleMessage dispatches a frame, read or replayed, to the waiting request or to the
+// handler of its arg
+func (w *webSocket) handleMessage(message []byte) {
+	var stream WebSocketStream
+	err := json.Unmarshal(message, &stream)
+	if err != nil {
+		common.Logger.Sugar().Warnf("Read%sWebSocketMessages Unmarshal error: %v %s", w.name, err, string(message))
+		return
+	}
+	if stream.OP != "" {
+		w.handleResponse(message)
+		return
+	}
+	if stream.Event != "" {
+		w.handleEvent(&stream)
+		return
+	}
+	w.mu.RLock()
+	hand, ok := w.handlers[stream.Arg.Key()]
+	w.mu.RUnlock()
+	if ok {
+		hand(&stream)
+	} else {
+		common.Logger.Sugar().Warnf("Read%sWebSocketMessages No handler for message: %s", w.name, string(message))
+	}
+}
+
+func (w *webSocket) handleEvent(stream *WebSocketStream) {
+	w.mu.RLock()
+	ch, ok := w.events[stream.ID]
+	w.mu.RUnlock()
+	if ok {
+		select {
+		case ch <- stream:
+		default:
+			common.Logger.Sugar().Warnf("Read%sWebSocketMessages event channel full: %+v", w.name, stream)
+		}
+		return
+	}
+	switch stream.Event {
+	case WebSocketEventError:
+		common.Logger.Sugar().Errorf("Read%sWebSocketMessages error event: %s %s %s", w.name, stream.ID, stream.Code, stream.Msg)
+	case WebSocketEventNotice:
+		common.Logger.Sugar().Warnf("Read%sWebSocketMessages notice event: %s %s", w.name, stream.Code, stream.Msg)
+	default:
+		common.Logger.Sugar().Infof("Read%sWebSocketMessages %s event: %s", w.name, stream.Event, stream.Arg.Key())
+	}
+}
+
+func (w *webSocket) handleResponse(message []byte) {
+	var response PrivateWebSocketResponse
+	err := json.Unmarshal(message, &response)
+	if err != nil {
+		common.Logger.Sugar().Warnf("Read%sWebSocketMessages Unmarshal response error: %v %s", w.name, err, string(message))
+		return
+	}
+	w.mu.RLock()
+	ch, ok := w.responses[response.ID]
+	w.mu.RUnlock()
+	if ok {
+		ch <- &response
+	} else {
+		common.Logger.Sugar().Warnf("Read%sWebSocketMessages No handler for response: %s", w.name, string(message))
+	}
+}
+
+func (w *webSocket) connect(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, w.url, nil)
+	if err != nil {
+		return err
+	}
+	if w.login != nil {
+		err = w.login(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	w.conn.Store(conn)
+	return nil
+}
+
+func (w *webSocket) reconnect(ctx context.Context) {
+	w.close()
+	for i := 0; i < 5; i++ {
+		if !common.Sleep(ctx, time.Second) {
+			return
+		}
+		err := w.connect(ctx)
+		if err != nil {
+			common.Logger.Sugar().Warnf("Reconnect%sWebSocket error %v", w.name, err)
+			continue
+		}
+		common.Logger.Sugar().Infof("Reconnect%sWebSocket success after %d attempts", w.name, i+1)
+		err = w.resubscribe()
+		if err != nil {
+			common.Logger.Sugar().Warnf("Reconnect%sWebSocket resubscribe error %v", w.name, err)
+		}
+		w.mu.RLock()
+		handlers := w.reconnectHandlers
+		w.mu.RUnlock()
+		for _, handler := range handlers {
+			handler()
+		}
+		break
+	}
+}
+
+// resubscribe replays every acknowledged subscription on the current connection in
+// batches of WebSocketSubscribeBatchSize args. It runs on the reader goroutine so it
+// does not wait for the acks, failures are logged by handleEvent
+func (w *webSocket) resubscribe() error {
+	w.mu.RLock()
+	args := make([]*WebSocketArg, 0, len(w.args))
+	for _, arg := range w.args {
+		args = append(args, arg)
+	}
+	w.mu.RUnlock()
+	conn := w.conn.Load()
+	for start := 0; start < len(args); start += WebSocketSubscribeBatchSize {
+		end := min(start+WebSocketSubscribeBatchSize, len(args))
+		request := &WebSocketRequest{
+			ID:   newRequestID(),
+			OP:   "subscribe",
+			Args: args[start:end],
+		}
+		err := w.writeJSON(conn, request)
+		if err != nil {
+			return err
+		}
+	}
+	common.Logger.Sugar().Infof("resubscribe%sWebSocket %d args", w.name, len(args))
+	return nil
+}
+
+// resync unsubscribes and subscribes args again so OKX restarts their data with a fresh
+// snapshot. Like resubscribe it runs on the reader goroutine and does not wait for the acks
+func (w *webSocket) resync(args []*WebSocketArg) error {
+	conn := w.conn.Load()
+	if conn == nil {
+		return fmt.Errorf("Resync%sWebSocket conn is nil", w.name)
+	}
+	for _, op := range []string{"unsubscribe", "subscribe"} {
+		err := w.writeJSON(conn, &WebSocketRequest{ID: newRequestID(), OP: op, Args: args})
+		if err != nil {
+			return fmt.Errorf("Resync%sWebSocket WriteJSON error: %v", w.name, err)
+		}
+	}
+	return nil
+}
+
+func (w *webSocket) onReconnect(handler func()) {
+	if handler == nil {
+		return
+	}
+	w.mu.Lock()
+	w.reconnectHandlers = append(w.reconnectHandlers, handler)
+	w.mu.Unlock()
+}
+
+func (w *webSocket) subscribe(request *WebSocketRequest, handler func(*WebSocketStream)) error {
+	if w.replay {
+		return w.subscribeReplay(request, handler)
+	}
+	conn := w.conn.Load()
+	if conn == nil {
+		return fmt.Errorf("Subscribe%sWebSocket conn is nil", w.name)
+	}
+	if request == nil || handler == nil {
+		return fmt.Errorf("Subscribe%sWebSocket request/handler is empty", w.name)
+	}
+	id := newRequestID()
+	request.ID = id
+	events := make(chan *WebSocketStream, len(request.Args)+1)
+	w.mu.Lock()
+	for _, arg := range request.Args {
+		w.handlers[arg.Key()] = handler
+	}
+	w.events[id] = events
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.events, id)
+		w.mu.Unlock()
+	}()
+	removeHandlers := func() {
+		w.mu.Lock()
+		for _, arg := range request.Args {
+			delete(w.handlers, arg.Key())
+			delete(w.args, arg.Key())
+		}
+		w.mu.Unlock()
+	}
+	err := w.writeJSON(conn, request)
+	if err != nil {
+		removeHandlers()
+		return fmt.Errorf("Subscribe%sWebSocket WriteJSON error: %v", w.name, err)
+	}
+	timeout := time.After(time.Second * 10)
+	for acked := 0; acked < len(request.Args); {
+		select {
+		case event := <-events:
+			switch event.Event {
+			case WebSocketEventSubscribe:
+				w.mu.Lock()
+				w.args[event.Arg.Key()] = event.Arg
+				w.mu.Unlock()
+				acked++
+			case WebSocketEventError:
+				removeHandlers()
+				return fmt.Errorf("Subscribe%sWebSocket error: code %s msg %s", w.name, event.Code, event.Msg)
+			}
+		case <-timeout:
+			removeHandlers()
+			return fmt.Errorf("Subscribe%sWebSocket timeout waiting for subscribe event", w.name)
+		}
+	}
+	return nil
+}
+
+// subscribeReplay only registers the handler, the replayed frames hold the events of
+// the recorded subscriptions
+func (w *webSocket) subscribeReplay(request *WebSocketRequest, handler func(*WebSocketStream)) error {
+	if request == nil || handler == nil {
+		return fmt.Errorf("Subscribe%sWebSocket request/handler is empty", w.name)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, arg := range request.Args {
+		w.handlers[arg.Key()] = handler
+	}
+	return nil
+}
+
+func (w *webSocket) call(ctx context.Context, request *PrivateWebSocketRequest) (*PrivateWebSocketResponse, error) {
+	conn := w.conn.Load()
+	if conn == nil {
+		return nil, fmt.Errorf("Call %sWebSocket conn is nil", w.name)
+	}
+	if request == nil {
+		return nil, fmt.Errorf("Call request is nil")
+	}
+	id := newRequestID()
+	request.ID = id
+	ch := make(chan *PrivateWebSocketResponse, 1)
+	w.mu.Lock()
+	w.responses[id] = ch
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.responses, id)
+		w.mu.Unlock()
+	}()
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Call %s error: %w", request.OP, err)
+	}
+	err := w.writeJSON(conn, request)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case response := <-ch:
+		if response.Code != "0" {
+			return nil, fmt.Errorf("Call %s error: %w", request.OP, response.Err())
+		}
+		return response, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Call %s error: %w", request.OP, ctx.Err())
+	case <-time.After(CallTimeout):
+		return nil, fmt.Errorf("Call timeout waiting for response")
+	}
+}
+
+func newRequestID() string {
+	return strings.Replace(uuid.New().String(), "-", "", -1)
+}
@@ -0,0 +1,503 @@
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BinanceFuturesAPIPath    = "/ws-fapi/v1"
+	BinanceFuturesStreamPath = "/stream"
+)
+
+// BinanceError is returned by a MethodHandler to reply with a ws-fapi error frame
+type BinanceError struct {
+	Status int    `json:"-"`
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e *BinanceError) Error() string {
+	return fmt.Sprintf("binance error %d: %s", e.Code, e.Msg)
+}
+
+// MethodHandler answers one ws-fapi method, the returned value is marshalled into result
+type MethodHandler func(params json.RawMessage) (interface{}, error)
+
+// StreamHandler builds the data of the next event pushed on a subscribed stream,
+// a nil value skips the push
+type StreamHandler func(symbol string) interface{}
+
+type binanceRequest struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type binanceResponse struct {
+	ID     interface{}   `json:"id"`
+	Status int           `json:"status"`
+	Result interface{}   `json:"result,omitempty"`
+	Error  *BinanceError `json:"error,omitempty"`
+}
+
+type binanceStreamRequest struct {
+	ID     interface{} `json:"id"`
+	Method string      `json:"method"`
+	Params []string    `json:"params"`
+}
+
+type binanceStreamResponse struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *BinanceError `json:"error,omitempty"`
+}
+
+type binanceStreamData struct {
+	Stream string      `json:"stream"`
+	Data   interface{} `json:"data"`
+}
+
+// Binance speaks the ws-fapi request/response protocol on BinanceFuturesAPIPath and the
+// combined stream SUBSCRIBE protocol on BinanceFuturesStreamPath
+type Binance struct {
+	*server
+	mu               sync.Mutex
+	methods          map[string]MethodHandler
+	streams          map[string]StreamHandler
+	markPrices       map[string]*feed
+	rejectStreams    map[string]*BinanceError
+	apiConns         hub
+	streamConns      hub
+	requestCounter   map[string]int
+	credentials      *binanceCredentials
+	orders           map[int64]*BinanceOrder
+	orderCounter     int64
+	listenKey        string
+	listenKeyCounter int
+	updateID         int64
+	tradeID          int64
+	symbols          []BinanceSymbol
+	books            map[string]*book
+	fundings         map[string]funding
+	fillLimits       map[string]decimal.Decimal
+}
+
+// NewBinance starts a server answering ticker.price, the signed order.* and
+// v2/account.position methods and the userDataStream.* methods and pushing markPrice, !markPrice@arr, bookTicker, aggTrade,
+// depth@100ms and kline_<interval> events
+func NewBinance() *Binance {
+	b := &Binance{
+		methods:        make(map[string]MethodHandler),
+		streams:        make(map[string]StreamHandler),
+		markPrices:     make(map[string]*feed),
+		rejectStreams:  make(map[string]*BinanceError),
+		requestCounter: make(map[string]int),
+		orders:         make(map[int64]*BinanceOrder),
+		symbols:        defaultBinanceSymbols(),
+		books:          make(map[string]*book),
+		fundings:       make(map[string]funding),
+		fillLimits:     make(map[string]decimal.Decimal),
+	}
+	b.HandleMethod("ticker.price", b.tickerPrice)
+	b.handleOrders()
+	b.handleUserDataStream()
+	b.HandleStream("markPrice", b.markPrice)
+	b.HandleStream("!markPrice@arr", b.allMarkPrices)
+	b.HandleStream("bookTicker", b.bookTicker)
+	b.HandleStream("aggTrade", b.aggTrade)
+	b.HandleStream("depth@100ms", b.depth)
+	for interval, duration := range binanceKlineIntervals {
+		b.HandleStream("kline_"+interval, b.kline(interval, duration))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(BinanceFuturesAPIPath, b.serveAPI)
+	mux.HandleFunc(BinanceFuturesStreamPath, b.serveStream)
+	mux.HandleFunc(BinanceExchangeInfoPath, b.serveExchangeInfo)
+	mux.HandleFunc(BinanceDepthPath, b.serveDepth)
+	mux.HandleFunc(BinanceKlinesPath, b.serveKlines)
+	mux.HandleFunc(BinanceFundingInfoPath, b.serveFundingInfo)
+	b.server = newServer(mux, DefaultInterval, b.push)
+	return b
+}
+
+func (b *Binance) FuturesAPIWebSocketURL() string {
+	return b.wsURL(BinanceFuturesAPIPath)
+}
+
+func (b *Binance) FuturesStreamWebSocketURL() string {
+	return b.wsURL(BinanceFuturesStreamPath)
+}
+
+// HandleMethod registers or replaces the handler of a ws-fapi method
+func (b *Binance) HandleMethod(method string, handler MethodHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.methods[method] = handler
+}
+
+// HandleStream registers the generator of a stream type, the name is the part after
+// the '@' such as markPrice in btcusdt@markPrice, or the whole name of the all market
+// streams such as !markPrice@arr which get an empty symbol
+func (b *Binance) HandleStream(name string, handler StreamHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streams[name] = handler
+}
+
+// SetMarkPrices scripts the mark prices pushed for symbol, one per interval
+func (b *Binance) SetMarkPrices(symbol string, prices ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.markPrices[strings.ToUpper(symbol)] = &feed{values: prices}
+}
+
+// FailMethod makes every following call of method reply with the given error
+func (b *Binance) FailMethod(method string, code int, msg string) {
+	b.HandleMethod(method, func(json.RawMessage) (interface{}, error) {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: code, Msg: msg}
+	})
+}
+
+// RejectStream makes SUBSCRIBE requests containing stream reply with an error
+func (b *Binance) RejectStream(stream string, code int, msg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejectStreams[stream] = &BinanceError{Code: code, Msg: msg}
+}
+
+// Requests returns how many times method was called, REST requests are counted by path
+func (b *Binance) Requests(method string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.requestCounter[method]
+}
+
+// Push sends data to every connection subscribed to stream
+func (b *Binance) Push(stream string, data interface{}) {
+	for _, c := range b.streamConns.list() {
+		for _, topic := range c.subscribed() {
+			if topic == stream {
+				_ = c.write(binanceStreamData{Stream: stream, Data: data})
+			}
+		}
+	}
+}
+
+// DropConnections closes every api and stream connection
+func (b *Binance) DropConnections() {
+	b.apiConns.drop()
+	b.streamConns.drop()
+}
+
+// DropStreamConnections closes every stream connection
+func (b *Binance) DropStreamConnections() {
+	b.streamConns.drop()
+}
+
+func (b *Binance) serveAPI(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := newConn(ws)
+	b.apiConns.add(c)
+	defer b.apiConns.remove(c)
+	defer ws.Close()
+	for {
+		var request binanceRequest
+		if err := ws.ReadJSON(&request); err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.requestCounter[request.Method]++
+		handler, ok := b.methods[request.Method]
+		b.mu.Unlock()
+		response := binanceResponse{ID: request.ID, Status: http.StatusOK}
+		if !ok {
+			response.Status = http.StatusBadRequest
+			response.Error = &BinanceError{Code: -1000, Msg: "Unknown method " + request.Method}
+		} else if result, err := handler(request.Params); err != nil {
+			response.Status = http.StatusBadRequest
+			response.Error = &BinanceError{Code: -1000, Msg: err.Error()}
+			if bErr, ok := err.(*BinanceError); ok {
+				response.Status = bErr.Status
+				response.Error = bErr
+			}
+		} else {
+			response.Result = result
+		}
+		if err := c.write(response); err != nil {
+			return
+		}
+	}
+}
+
+func (b *Binance) serveStream(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := newConn(ws)
+	b.streamConns.add(c)
+	defer b.streamConns.remove(c)
+	defer ws.Close()
+	for {
+		var request binanceStreamRequest
+		if err := ws.ReadJSON(&request); err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.requestCounter[request.Method]++
+		var rejected *BinanceError
+		for _, stream := range request.Params {
+			if e, ok := b.rejectStreams[stream]; ok {
+				rejected = e
+			}
+		}
+		b.mu.Unlock()
+		response := binanceStreamResponse{ID: request.ID}
+		switch {
+		case rejected != nil:
+			response.Error = rejected
+		case request.Method == "SUBSCRIBE" || request.Method == "UNSUBSCRIBE":
+			for _, stream := range request.Params {
+				c.subscribe(stream, request.Method == "SUBSCRIBE")
+			}
+		default:
+			response.Error = &BinanceError{Code: 2, Msg: "Invalid request: unknown method " + request.Method}
+		}
+		if err := c.write(response); err != nil {
+			return
+		}
+	}
+}
+
+// SkipDepthUpdate drops the next depth event of symbol so the clients see a gap in pu
+func (b *Binance) SkipDepthUpdate(symbol string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.book(strings.ToUpper(symbol)).skip = true
+}
+
+// push drives every subscribed stream that has a registered StreamHandler, the books
+// change once per push so every connection sees the same depth events
+func (b *Binance) push() {
+	b.mu.Lock()
+	for _, book := range b.books {
+		book.tick()
+	}
+	b.mu.Unlock()
+	for _, c := range b.streamConns.list() {
+		for _, stream := range c.subscribed() {
+			symbol, name, ok := strings.Cut(stream, "@")
+			if !ok {
+				continue
+			}
+			if strings.HasPrefix(stream, "!") {
+				symbol, name = "", stream
+			}
+			b.mu.Lock()
+			handler := b.streams[name]
+			b.mu.Unlock()
+			if handler == nil {
+				continue
+			}
+			data := handler(strings.ToUpper(symbol))
+			if data == nil {
+				continue
+			}
+			_ = c.write(binanceStreamData{Stream: stream, Data: data})
+		}
+	}
+}
+
+func (b *Binance) nextMarkPrice(symbol string, advance bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.markPrices[symbol]
+	if !ok {
+		return DefaultPrice
+	}
+	if !advance {
+		return f.current()
+	}
+	return f.pop()
+}
+
+func (b *Binance) tickerPrice(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.Symbol == "" {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'symbol' was not sent"}
+	}
+	return map[string]interface{}{
+		"symbol": p.Symbol,
+		"price":  b.nextMarkPrice(p.Symbol, false),
+		"time":   time.Now().UnixMilli(),
+	}, nil
+}
+
+// SetFunding scripts the funding rate of symbol and its interval, the intervals other
+// than 8 hours are listed by fundingInfo
+func (b *Binance) SetFunding(symbol string, rate string, interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fundings[strings.ToUpper(symbol)] = funding{rate: rate, interval: interval}
+}
+
+func (b *Binance) funding(symbol string) funding {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.fundings[symbol]; ok {
+		return f
+	}
+	return defaultFunding
+}
+
+func (b *Binance) markPriceUpdate(symbol string, price string) map[string]interface{} {
+	f := b.funding(symbol)
+	return map[string]interface{}{
+		"e": "markPriceUpdate",
+		"E": time.Now().UnixMilli(),
+		"s": symbol,
+		"p": price,
+		"i": DefaultPrice,
+		"P": DefaultPrice,
+		"r": f.rate,
+		"T": f.next(time.Now()),
+	}
+}
+
+func (b *Binance) markPrice(symbol string) interface{} {
+	return b.markPriceUpdate(symbol, b.nextMarkPrice(symbol, true))
+}
+
+// allMarkPrices sends the update of every listed symbol without advancing the mark prices
+func (b *Binance) allMarkPrices(string) interface{} {
+	b.mu.Lock()
+	symbols := make([]string, 0, len(b.symbols))
+	for _, s := range b.symbols {
+		symbols = append(symbols, s.Symbol)
+	}
+	b.mu.Unlock()
+	updates := make([]interface{}, 0, len(symbols))
+	for _, symbol := range symbols {
+		updates = append(updates, b.markPriceUpdate(symbol, b.nextMarkPrice(symbol, false)))
+	}
+	return updates
+}
+
+// bookTicker quotes DefaultSpread around the current mark price without advancing it
+func (b *Binance) bookTicker(symbol string) interface{} {
+	b.mu.Lock()
+	b.updateID++
+	updateID := b.updateID
+	b.mu.Unlock()
+	bid, ask := quote(b.nextMarkPrice(symbol, false))
+	return map[string]interface{}{
+		"e": "bookTicker",
+		"u": updateID,
+		"E": time.Now().UnixMilli(),
+		"T": time.Now().UnixMilli(),
+		"s": symbol,
+		"b": bid,
+		"B": DefaultQuantity,
+		"a": ask,
+		"A": DefaultQuantity,
+	}
+}
+
+// aggTrade alternates taker buys and sells at the current mark price
+func (b *Binance) aggTrade(symbol string) interface{} {
+	b.mu.Lock()
+	b.tradeID++
+	tradeID := b.tradeID
+	b.mu.Unlock()
+	return map[string]interface{}{
+		"e": "aggTrade",
+		"E": time.Now().UnixMilli(),
+		"s": symbol,
+		"a": tradeID,
+		"p": b.nextMarkPrice(symbol, false),
+		"q": DefaultQuantity,
+		"f": tradeID,
+		"l": tradeID,
+		"T": time.Now().UnixMilli(),
+		"m": tradeID%2 == 0,
+	}
+}
+
+// book must be called with mu held, it creates the book of symbol around its mark price
+func (b *Binance) book(symbol string) *book {
+	bk, ok := b.books[symbol]
+	if !ok {
+		mid := DefaultPrice
+		if f, ok := b.markPrices[symbol]; ok && f.current() != "" {
+			mid = f.current()
+		}
+		bk = newBook(mid)
+		b.books[symbol] = bk
+	}
+	return bk
+}
+
+// depth sends the level changed by the last book tick as a one update diff
+func (b *Binance) depth(symbol string) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk := b.book(symbol)
+	if !bk.updated() {
+		return nil
+	}
+	return map[string]interface{}{
+		"e":  "depthUpdate",
+		"E":  time.Now().UnixMilli(),
+		"T":  time.Now().UnixMilli(),
+		"s":  symbol,
+		"U":  bk.seq,
+		"u":  bk.seq,
+		"pu": bk.prevSeq,
+		"b":  bk.lastBids,
+		"a":  bk.lastAsks,
+	}
+}
+
+// kline updates the open candle of interval at the current mark price
+func (b *Binance) kline(interval string, duration time.Duration) StreamHandler {
+	return func(symbol string) interface{} {
+		now := time.Now().UnixMilli()
+		k := newCandle(b.nextMarkPrice(symbol, false), candleOpenTime(now, duration), duration)
+		return map[string]interface{}{
+			"e": "kline",
+			"E": now,
+			"s": symbol,
+			"k": map[string]interface{}{
+				"t": k.OpenTime,
+				"T": k.OpenTime + duration.Milliseconds() - 1,
+				"s": symbol,
+				"i": interval,
+				"f": 1,
+				"L": 1,
+				"o": k.Open,
+				"c": k.Close,
+				"h": k.High,
+				"l": k.Low,
+				"v": k.Volume,
+				"n": 1,
+				"x": k.Closed,
+				"q": k.Volume,
+				"V": "0",
+				"Q": "0",
+				"B": "0",
+			},
+		}
+	}
+}
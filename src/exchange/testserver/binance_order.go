@@ -0,0 +1,365 @@
+package testserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BinanceOrder is the order state kept by the fake ws-fapi, it is returned as the result
+// of every order.* method
+type BinanceOrder struct {
+	OrderID       int64           `json:"orderId"`
+	Symbol        string          `json:"symbol"`
+	Status        string          `json:"status"`
+	ClientOrderID string          `json:"clientOrderId"`
+	Price         decimal.Decimal `json:"price"`
+	AvgPrice      decimal.Decimal `json:"avgPrice"`
+	OrigQty       decimal.Decimal `json:"origQty"`
+	ExecutedQty   decimal.Decimal `json:"executedQty"`
+	CumQuote      decimal.Decimal `json:"cumQuote"`
+	TimeInForce   string          `json:"timeInForce"`
+	Type          string          `json:"type"`
+	ReduceOnly    bool            `json:"reduceOnly"`
+	Side          string          `json:"side"`
+	PositionSide  string          `json:"positionSide"`
+	Time          int64           `json:"time"`
+	UpdateTime    int64           `json:"updateTime"`
+}
+
+type binanceCredentials struct {
+	apiKey string
+	verify func(payload string, signature string) bool
+}
+
+// SetHMACCredentials makes signed methods accept apiKey with HMAC-SHA256 signatures of secret
+func (b *Binance) SetHMACCredentials(apiKey string, secret string) {
+	b.setCredentials(apiKey, func(payload string, signature string) bool {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		return hex.EncodeToString(mac.Sum(nil)) == signature
+	})
+}
+
+// SetEd25519Credentials makes signed methods accept apiKey with Ed25519 signatures of key
+func (b *Binance) SetEd25519Credentials(apiKey string, key ed25519.PublicKey) {
+	b.setCredentials(apiKey, func(payload string, signature string) bool {
+		sig, err := base64.StdEncoding.DecodeString(signature)
+		return err == nil && ed25519.Verify(key, []byte(payload), sig)
+	})
+}
+
+func (b *Binance) setCredentials(apiKey string, verify func(string, string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.credentials = &binanceCredentials{apiKey: apiKey, verify: verify}
+}
+
+// Order returns a copy of the order stored by the fake exchange
+func (b *Binance) Order(orderID int64) (BinanceOrder, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, ok := b.orders[orderID]
+	if !ok {
+		return BinanceOrder{}, false
+	}
+	return *order, true
+}
+
+func (b *Binance) handleOrders() {
+	b.HandleMethod("order.place", b.signed(b.notify(b.orderPlace, "NEW")))
+	b.HandleMethod("order.cancel", b.signed(b.notify(b.orderCancel, "CANCELED")))
+	b.HandleMethod("order.modify", b.signed(b.notify(b.orderModify, "AMENDMENT")))
+	b.HandleMethod("order.status", b.signed(b.orderStatus))
+	b.HandleMethod("v2/account.position", b.signed(b.accountPosition))
+}
+
+// notify pushes ORDER_TRADE_UPDATE on the user data stream for the order returned by handler
+func (b *Binance) notify(handler func(params map[string]string) (interface{}, error), executionType string) func(params map[string]string) (interface{}, error) {
+	return func(params map[string]string) (interface{}, error) {
+		result, err := handler(params)
+		if order, ok := result.(BinanceOrder); ok && err == nil {
+			if order.Status == "FILLED" || order.Status == "PARTIALLY_FILLED" {
+				b.pushOrderTradeUpdate(order, "TRADE")
+			} else {
+				b.pushOrderTradeUpdate(order, executionType)
+			}
+		}
+		return result, err
+	}
+}
+
+// userStream checks the apiKey param of USER_STREAM methods
+func (b *Binance) userStream(handler func(params map[string]string) (interface{}, error)) MethodHandler {
+	return func(raw json.RawMessage) (interface{}, error) {
+		params, err := decodeParams(raw)
+		if err != nil {
+			return nil, err
+		}
+		b.mu.Lock()
+		credentials := b.credentials
+		b.mu.Unlock()
+		if credentials == nil || params["apiKey"] != credentials.apiKey {
+			return nil, &BinanceError{Status: http.StatusUnauthorized, Code: -2014, Msg: "API-key format invalid."}
+		}
+		return handler(params)
+	}
+}
+
+// signed checks the apiKey, timestamp/recvWindow and signature params of SIGNED methods
+func (b *Binance) signed(handler func(params map[string]string) (interface{}, error)) MethodHandler {
+	return b.userStream(func(params map[string]string) (interface{}, error) {
+		signature := params["signature"]
+		delete(params, "signature")
+		b.mu.Lock()
+		verify := b.credentials.verify
+		b.mu.Unlock()
+		if !verify(signaturePayload(params), signature) {
+			return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1022, Msg: "Signature for this request is not valid."}
+		}
+		timestamp, err := strconv.ParseInt(params["timestamp"], 10, 64)
+		if err != nil {
+			return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'timestamp' was not sent"}
+		}
+		recvWindow := int64(5000)
+		if params["recvWindow"] != "" {
+			recvWindow, _ = strconv.ParseInt(params["recvWindow"], 10, 64)
+		}
+		if now := time.Now().UnixMilli(); timestamp > now+1000 || now-timestamp > recvWindow {
+			return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1021, Msg: "Timestamp for this request is outside of the recvWindow."}
+		}
+		return handler(params)
+	})
+}
+
+func decodeParams(raw json.RawMessage) (map[string]string, error) {
+	values := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1000, Msg: err.Error()}
+	}
+	params := make(map[string]string, len(values))
+	for key, value := range values {
+		params[key] = fmt.Sprint(value)
+	}
+	return params, nil
+}
+
+func signaturePayload(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params[key])
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (b *Binance) findOrder(params map[string]string) (*BinanceOrder, error) {
+	if id, err := strconv.ParseInt(params["orderId"], 10, 64); err == nil {
+		if order, ok := b.orders[id]; ok && order.Symbol == params["symbol"] {
+			return order, nil
+		}
+	}
+	for _, order := range b.orders {
+		if params["origClientOrderId"] != "" && order.ClientOrderID == params["origClientOrderId"] && order.Symbol == params["symbol"] {
+			return order, nil
+		}
+	}
+	return nil, &BinanceError{Status: http.StatusBadRequest, Code: -2013, Msg: "Order does not exist."}
+}
+
+// SetFillLimit caps the quantity a MARKET order of symbol fills, the rest of a larger
+// order stays PARTIALLY_FILLED until it is canceled
+func (b *Binance) SetFillLimit(symbol string, quantity string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fillLimits[symbol] = decimal.RequireFromString(quantity)
+}
+
+// orderPlace fills MARKET orders at the current mark price, up to SetFillLimit, and
+// rests LIMIT orders as NEW
+func (b *Binance) orderPlace(params map[string]string) (interface{}, error) {
+	quantity, err := decimal.NewFromString(params["quantity"])
+	if err != nil || !quantity.IsPositive() {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'quantity' was not sent, was empty/null, or malformed."}
+	}
+	markPrice, _ := decimal.NewFromString(b.nextMarkPrice(params["symbol"], false))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.orderCounter++
+	now := time.Now().UnixMilli()
+	order := &BinanceOrder{
+		OrderID:       b.orderCounter,
+		Symbol:        params["symbol"],
+		Status:        "NEW",
+		ClientOrderID: params["newClientOrderId"],
+		OrigQty:       quantity,
+		TimeInForce:   params["timeInForce"],
+		Type:          params["type"],
+		ReduceOnly:    params["reduceOnly"] == "true",
+		Side:          params["side"],
+		PositionSide:  "BOTH",
+		Time:          now,
+		UpdateTime:    now,
+	}
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = "testserver" + strconv.FormatInt(order.OrderID, 10)
+	}
+	if params["positionSide"] != "" {
+		order.PositionSide = params["positionSide"]
+	}
+	switch order.Type {
+	case "MARKET":
+		order.Status = "FILLED"
+		order.AvgPrice = markPrice
+		order.ExecutedQty = quantity
+		if limit, ok := b.fillLimits[order.Symbol]; ok && limit.LessThan(quantity) {
+			order.Status = "PARTIALLY_FILLED"
+			order.ExecutedQty = limit
+		}
+		order.CumQuote = markPrice.Mul(order.ExecutedQty)
+	case "LIMIT":
+		order.Price, err = decimal.NewFromString(params["price"])
+		if err != nil {
+			return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+		}
+	default:
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1116, Msg: "Invalid orderType."}
+	}
+	b.orders[order.OrderID] = order
+	copied := *order
+	return copied, nil
+}
+
+func (b *Binance) orderCancel(params map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.findOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "NEW" && order.Status != "PARTIALLY_FILLED" {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -2011, Msg: "Unknown order sent."}
+	}
+	order.Status = "CANCELED"
+	order.UpdateTime = time.Now().UnixMilli()
+	return *order, nil
+}
+
+func (b *Binance) orderModify(params map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.findOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != "NEW" || order.Type != "LIMIT" {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -5027, Msg: "No need to modify the order."}
+	}
+	quantity, err := decimal.NewFromString(params["quantity"])
+	if err != nil {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'quantity' was not sent, was empty/null, or malformed."}
+	}
+	price, err := decimal.NewFromString(params["price"])
+	if err != nil {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1102, Msg: "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+	}
+	order.OrigQty = quantity
+	order.Price = price
+	order.UpdateTime = time.Now().UnixMilli()
+	return *order, nil
+}
+
+func (b *Binance) orderStatus(params map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.findOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return *order, nil
+}
+
+// accountPosition nets the fills of every order by symbol, the entry price is the
+// average price of the fills opening the position
+func (b *Binance) accountPosition(params map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	type position struct {
+		amount   decimal.Decimal
+		cost     decimal.Decimal
+		quantity decimal.Decimal
+		update   int64
+	}
+	positions := make(map[string]*position)
+	ids := make([]int64, 0, len(b.orders))
+	for id := range b.orders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		order := b.orders[id]
+		if order.ExecutedQty.IsZero() || (params["symbol"] != "" && order.Symbol != params["symbol"]) {
+			continue
+		}
+		p, ok := positions[order.Symbol]
+		if !ok {
+			p = &position{}
+			positions[order.Symbol] = p
+		}
+		signed := order.ExecutedQty
+		if order.Side == "SELL" {
+			signed = signed.Neg()
+		}
+		previous := p.amount
+		p.amount = p.amount.Add(signed)
+		switch {
+		case previous.IsZero() || previous.Sign() == signed.Sign():
+			p.cost = p.cost.Add(order.AvgPrice.Mul(order.ExecutedQty))
+			p.quantity = p.quantity.Add(order.ExecutedQty)
+		case p.amount.Sign() == signed.Sign():
+			// the fill crossed zero, the rest opens at its price
+			p.quantity = p.amount.Abs()
+			p.cost = order.AvgPrice.Mul(p.quantity)
+		}
+		p.update = order.UpdateTime
+	}
+	symbols := make([]string, 0, len(positions))
+	for symbol := range positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	result := []map[string]interface{}{}
+	for _, symbol := range symbols {
+		p := positions[symbol]
+		if p.amount.IsZero() {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"symbol":       symbol,
+			"positionSide": "BOTH",
+			"positionAmt":  p.amount.String(),
+			"entryPrice":   p.cost.Div(p.quantity).String(),
+			"marginAsset":  "USDT",
+			"updateTime":   p.update,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BinanceExchangeInfoPath = "/fapi/v1/exchangeInfo"
+	BinanceDepthPath        = "/fapi/v1/depth"
+	BinanceKlinesPath       = "/fapi/v1/klines"
+	BinanceFundingInfoPath  = "/fapi/v1/fundingInfo"
+)
+
+// BinanceSymbol is one perpetual listed by the fake exchangeInfo endpoint
+type BinanceSymbol struct {
+	Symbol      string
+	Base        string
+	Quote       string
+	Status      string
+	TickSize    string
+	StepSize    string
+	MinQty      string
+	MinNotional string
+}
+
+func defaultBinanceSymbols() []BinanceSymbol {
+	return []BinanceSymbol{
+		{Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", Status: "TRADING", TickSize: "0.10", StepSize: "0.001", MinQty: "0.001", MinNotional: "100"},
+		{Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT", Status: "TRADING", TickSize: "0.01", StepSize: "0.001", MinQty: "0.001", MinNotional: "20"},
+		{Symbol: "BNBUSDT", Base: "BNB", Quote: "USDT", Status: "TRADING", TickSize: "0.010", StepSize: "0.01", MinQty: "0.01", MinNotional: "5"},
+	}
+}
+
+// FuturesRESTURL is the base url of the fake futures REST API
+func (b *Binance) FuturesRESTURL() string {
+	return b.URL
+}
+
+// SetSymbols replaces the perpetuals listed by exchangeInfo
+func (b *Binance) SetSymbols(symbols ...BinanceSymbol) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.symbols = symbols
+}
+
+func (b *Binance) serveExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	b.requestCounter[BinanceExchangeInfoPath]++
+	symbols := make([]interface{}, 0, len(b.symbols))
+	for _, s := range b.symbols {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":       s.Symbol,
+			"pair":         s.Symbol,
+			"contractType": "PERPETUAL",
+			"status":       s.Status,
+			"baseAsset":    s.Base,
+			"quoteAsset":   s.Quote,
+			"marginAsset":  s.Quote,
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "tickSize": s.TickSize, "minPrice": s.TickSize, "maxPrice": "1000000"},
+				{"filterType": "LOT_SIZE", "stepSize": s.StepSize, "minQty": s.MinQty, "maxQty": "1000"},
+				{"filterType": "MIN_NOTIONAL", "notional": s.MinNotional},
+			},
+		})
+	}
+	b.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"timezone":   "UTC",
+		"serverTime": time.Now().UnixMilli(),
+		"symbols":    symbols,
+	})
+}
+
+func (b *Binance) serveDepth(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(r.URL.Query().Get("symbol"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	w.Header().Set("Content-Type", "application/json")
+	b.mu.Lock()
+	b.requestCounter[BinanceDepthPath]++
+	if symbol == "" {
+		b.mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&BinanceError{Code: -1102, Msg: "Mandatory parameter 'symbol' was not sent"})
+		return
+	}
+	bk := b.book(symbol)
+	depth := map[string]interface{}{
+		"lastUpdateId": bk.seq,
+		"E":            time.Now().UnixMilli(),
+		"T":            time.Now().UnixMilli(),
+		"bids":         bk.levels(true, limit),
+		"asks":         bk.levels(false, limit),
+	}
+	b.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(depth)
+}
+
+// serveKlines lists the fake klines opened from startTime, or the latest limit ones, up
+// to endTime and now
+func (b *Binance) serveKlines(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	b.mu.Lock()
+	b.requestCounter[BinanceKlinesPath]++
+	b.mu.Unlock()
+	interval, ok := binanceKlineIntervals[query.Get("interval")]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&BinanceError{Code: -1120, Msg: "Invalid interval."})
+		return
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 500
+	}
+	end := time.Now().UnixMilli()
+	if endTime, err := strconv.ParseInt(query.Get("endTime"), 10, 64); err == nil {
+		end = min(end, endTime)
+	}
+	start := candleOpenTime(end, interval) - int64(limit-1)*interval.Milliseconds()
+	if startTime, err := strconv.ParseInt(query.Get("startTime"), 10, 64); err == nil {
+		start = candleOpenTime(startTime, interval)
+		if start < startTime {
+			start += interval.Milliseconds()
+		}
+	}
+	price := b.nextMarkPrice(strings.ToUpper(query.Get("symbol")), false)
+	klines := [][]interface{}{}
+	for openTime := start; openTime <= end && len(klines) < limit; openTime += interval.Milliseconds() {
+		k := newCandle(price, openTime, interval)
+		klines = append(klines, []interface{}{
+			k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume,
+			k.OpenTime + interval.Milliseconds() - 1, k.Volume, 1, "0", "0", "0",
+		})
+	}
+	_ = json.NewEncoder(w).Encode(klines)
+}
+
+// serveFundingInfo lists the symbols scripted by SetFunding with an interval other than
+// 8 hours
+func (b *Binance) serveFundingInfo(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	b.requestCounter[BinanceFundingInfoPath]++
+	info := []interface{}{}
+	for symbol, f := range b.fundings {
+		if f.interval == 8*time.Hour {
+			continue
+		}
+		info = append(info, map[string]interface{}{
+			"symbol":                   symbol,
+			"adjustedFundingRateCap":   "0.02",
+			"adjustedFundingRateFloor": "-0.02",
+			"fundingIntervalHours":     int(f.interval.Hours()),
+			"disclaimer":               false,
+		})
+	}
+	b.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
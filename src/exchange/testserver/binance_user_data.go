@@ -0,0 +1,106 @@
+package testserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (b *Binance) handleUserDataStream() {
+	b.HandleMethod("userDataStream.start", b.userStream(b.userDataStreamStart))
+	b.HandleMethod("userDataStream.ping", b.userStream(b.userDataStreamPing))
+	b.HandleMethod("userDataStream.stop", b.userStream(b.userDataStreamStop))
+}
+
+// ListenKey returns the active listenKey, empty when none was started
+func (b *Binance) ListenKey() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.listenKey
+}
+
+// ExpireListenKey invalidates the active listenKey and pushes listenKeyExpired on it
+func (b *Binance) ExpireListenKey() {
+	b.mu.Lock()
+	listenKey := b.listenKey
+	b.listenKey = ""
+	b.mu.Unlock()
+	if listenKey == "" {
+		return
+	}
+	b.Push(listenKey, map[string]interface{}{
+		"e":         "listenKeyExpired",
+		"E":         time.Now().UnixMilli(),
+		"listenKey": listenKey,
+	})
+}
+
+// PushUserData sends event on the active listenKey stream
+func (b *Binance) PushUserData(event interface{}) {
+	listenKey := b.ListenKey()
+	if listenKey == "" {
+		return
+	}
+	b.Push(listenKey, event)
+}
+
+// userDataStreamStart returns the active listenKey or creates a new one like Binance does
+func (b *Binance) userDataStreamStart(map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listenKey == "" {
+		b.listenKeyCounter++
+		b.listenKey = "testserverListenKey" + strconv.Itoa(b.listenKeyCounter)
+	}
+	return map[string]string{"listenKey": b.listenKey}, nil
+}
+
+func (b *Binance) userDataStreamPing(map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listenKey == "" {
+		return nil, &BinanceError{Status: http.StatusBadRequest, Code: -1125, Msg: "This listenKey does not exist."}
+	}
+	return map[string]string{"listenKey": b.listenKey}, nil
+}
+
+func (b *Binance) userDataStreamStop(map[string]string) (interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listenKey = ""
+	return map[string]string{}, nil
+}
+
+// pushOrderTradeUpdate reports an order change on the user data stream
+func (b *Binance) pushOrderTradeUpdate(order BinanceOrder, executionType string) {
+	b.PushUserData(map[string]interface{}{
+		"e": "ORDER_TRADE_UPDATE",
+		"E": time.Now().UnixMilli(),
+		"T": order.UpdateTime,
+		"o": map[string]interface{}{
+			"s":  order.Symbol,
+			"c":  order.ClientOrderID,
+			"S":  order.Side,
+			"o":  order.Type,
+			"f":  order.TimeInForce,
+			"q":  order.OrigQty.String(),
+			"p":  order.Price.String(),
+			"ap": order.AvgPrice.String(),
+			"sp": "0",
+			"x":  executionType,
+			"X":  order.Status,
+			"i":  order.OrderID,
+			"l":  order.ExecutedQty.String(),
+			"z":  order.ExecutedQty.String(),
+			"L":  order.AvgPrice.String(),
+			"N":  "USDT",
+			"n":  "0",
+			"T":  order.UpdateTime,
+			"t":  order.OrderID,
+			"m":  false,
+			"R":  order.ReduceOnly,
+			"ps": order.PositionSide,
+			"rp": "0",
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package testserver
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// BookDepth is the number of levels per side of the fake order books
+const BookDepth = 10
+
+// book is the fake L2 book of one symbol, BookDepth levels per side DefaultSpread apart
+// around the mark price. Every tick changes one level, alternating the sides, and every
+// 4th change of a side removes the level
+type book struct {
+	mid     decimal.Decimal
+	bids    map[string]string
+	asks    map[string]string
+	seq     int64
+	prevSeq int64
+	ticks   int64
+	// last holds the levels changed by the last tick as [price, quantity]
+	lastBids [][]string
+	lastAsks [][]string
+	// skip and corrupt mark the next update to be dropped or sent with a wrong checksum,
+	// skipping and corrupting apply them to the update of the last tick
+	skip       bool
+	corrupt    bool
+	skipping   bool
+	corrupting bool
+}
+
+func newBook(mid string) *book {
+	b := &book{
+		mid:  decimal.RequireFromString(mid),
+		bids: make(map[string]string),
+		asks: make(map[string]string),
+		seq:  1,
+	}
+	for i := 0; i < BookDepth; i++ {
+		b.bids[b.price(true, i)] = DefaultQuantity
+		b.asks[b.price(false, i)] = DefaultQuantity
+	}
+	return b
+}
+
+func (b *book) price(bid bool, level int) string {
+	offset := decimal.RequireFromString(DefaultSpread).Mul(decimal.NewFromInt(int64(level + 1)))
+	if bid {
+		return b.mid.Sub(offset).String()
+	}
+	return b.mid.Add(offset).String()
+}
+
+func (b *book) tick() {
+	b.skipping, b.skip = b.skip, false
+	b.corrupting, b.corrupt = b.corrupt, false
+	b.ticks++
+	bid := b.ticks%2 == 0
+	price := b.price(bid, int(b.ticks/2%BookDepth))
+	quantity := strconv.FormatInt(b.ticks%5+1, 10)
+	if b.ticks/2%4 == 3 {
+		quantity = "0"
+	}
+	side := b.asks
+	if bid {
+		side = b.bids
+	}
+	if quantity == "0" {
+		delete(side, price)
+	} else {
+		side[price] = quantity
+	}
+	b.lastBids, b.lastAsks = [][]string{}, [][]string{}
+	if bid {
+		b.lastBids = [][]string{{price, quantity}}
+	} else {
+		b.lastAsks = [][]string{{price, quantity}}
+	}
+	b.prevSeq = b.seq
+	b.seq++
+}
+
+// updated reports whether the last tick has an update to send
+func (b *book) updated() bool {
+	return b.ticks > 0 && !b.skipping
+}
+
+// levels returns the best depth levels of one side as [price, quantity], every level when depth is 0
+func (b *book) levels(bid bool, depth int) [][]string {
+	side := b.asks
+	if bid {
+		side = b.bids
+	}
+	prices := make([]decimal.Decimal, 0, len(side))
+	for price := range side {
+		prices = append(prices, decimal.RequireFromString(price))
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if bid {
+			return prices[i].GreaterThan(prices[j])
+		}
+		return prices[i].LessThan(prices[j])
+	})
+	if depth > 0 && depth < len(prices) {
+		prices = prices[:depth]
+	}
+	levels := make([][]string, 0, len(prices))
+	for _, price := range prices {
+		levels = append(levels, []string{price.String(), side[price.String()]})
+	}
+	return levels
+}
+
+// checksum follows the OKX books checksum over the best 25 levels per side
+func (b *book) checksum() int32 {
+	bids, asks := b.levels(true, 25), b.levels(false, 25)
+	parts := []string{}
+	for i := 0; i < max(len(bids), len(asks)); i++ {
+		if i < len(bids) {
+			parts = append(parts, bids[i]...)
+		}
+		if i < len(asks) {
+			parts = append(parts, asks[i]...)
+		}
+	}
+	return int32(crc32.ChecksumIEEE([]byte(strings.Join(parts, ":"))))
+}
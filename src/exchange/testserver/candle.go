@@ -0,0 +1,76 @@
+package testserver
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// binanceKlineIntervals are the kline intervals served by the fake Binance
+var binanceKlineIntervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"3m":  3 * time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"2h":  2 * time.Hour,
+	"4h":  4 * time.Hour,
+	"6h":  6 * time.Hour,
+	"12h": 12 * time.Hour,
+	"1d":  24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+}
+
+// okxBars are the candle bars served by the fake OKX
+var okxBars = map[string]time.Duration{
+	"1m":     time.Minute,
+	"3m":     3 * time.Minute,
+	"5m":     5 * time.Minute,
+	"15m":    15 * time.Minute,
+	"30m":    30 * time.Minute,
+	"1H":     time.Hour,
+	"2H":     2 * time.Hour,
+	"4H":     4 * time.Hour,
+	"6Hutc":  6 * time.Hour,
+	"12Hutc": 12 * time.Hour,
+	"1Dutc":  24 * time.Hour,
+	"1Wutc":  7 * 24 * time.Hour,
+}
+
+// candle is a fake candle quoting DefaultSpread around price, Volume is DefaultQuantity
+// per second of the interval
+type candle struct {
+	OpenTime int64
+	Open     string
+	High     string
+	Low      string
+	Close    string
+	Volume   string
+	Closed   bool
+}
+
+func newCandle(price string, openTime int64, interval time.Duration) candle {
+	low, high := quote(price)
+	closeTime := openTime + interval.Milliseconds() - 1
+	return candle{
+		OpenTime: openTime,
+		Open:     price,
+		High:     high,
+		Low:      low,
+		Close:    price,
+		Volume:   decimal.RequireFromString(DefaultQuantity).Mul(decimal.NewFromFloat(interval.Seconds())).String(),
+		Closed:   closeTime < time.Now().UnixMilli(),
+	}
+}
+
+// candleOpenTime is the open time of the interval containing t in milliseconds, the
+// weeks start on Monday like on Binance
+func candleOpenTime(t int64, interval time.Duration) int64 {
+	ms := interval.Milliseconds()
+	if interval == 7*24*time.Hour {
+		monday := 4 * 24 * time.Hour.Milliseconds()
+		return t - (t-monday)%ms
+	}
+	return t - t%ms
+}
@@ -0,0 +1,18 @@
+package testserver
+
+import "time"
+
+// funding is the scripted funding of an instrument, nextRate is the predicted rate of
+// the following period and is sent empty when unset
+type funding struct {
+	rate     string
+	nextRate string
+	interval time.Duration
+}
+
+var defaultFunding = funding{rate: DefaultFundingRate, interval: 8 * time.Hour}
+
+// next is the next settlement after t in milliseconds
+func (f funding) next(t time.Time) int64 {
+	return t.Truncate(f.interval).Add(f.interval).UnixMilli()
+}
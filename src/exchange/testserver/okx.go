@@ -0,0 +1,612 @@
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OKXPublicPath   = "/ws/v5/public"
+	OKXPrivatePath  = "/ws/v5/private"
+	OKXBusinessPath = "/ws/v5/business"
+)
+
+// OKXArg mirrors the arg object of the OKX v5 websocket protocol
+type OKXArg struct {
+	Channel  string `json:"channel"`
+	InstType string `json:"instType,omitempty"`
+	InstID   string `json:"instId,omitempty"`
+	Ccy      string `json:"ccy,omitempty"`
+}
+
+func (a OKXArg) key() string {
+	return a.Channel + "_" + a.InstType + "_" + a.InstID + "_" + a.Ccy
+}
+
+// ChannelHandler builds the data array of the next push for a subscribed arg,
+// a nil value skips the push
+type ChannelHandler func(arg OKXArg) interface{}
+
+// OpHandler answers one private op such as order, it returns one data item per arg
+// and every item carries its own sCode/sMsg
+type OpHandler func(args []json.RawMessage) []map[string]string
+
+type okxRequest struct {
+	ID   string          `json:"id"`
+	OP   string          `json:"op"`
+	Args json.RawMessage `json:"args"`
+}
+
+type okxEvent struct {
+	ID     string  `json:"id,omitempty"`
+	Event  string  `json:"event"`
+	Code   string  `json:"code,omitempty"`
+	Msg    string  `json:"msg,omitempty"`
+	Arg    *OKXArg `json:"arg,omitempty"`
+	ConnID string  `json:"connId"`
+}
+
+type okxResponse struct {
+	ID      string              `json:"id"`
+	OP      string              `json:"op"`
+	Code    string              `json:"code"`
+	Msg     string              `json:"msg"`
+	Data    []map[string]string `json:"data"`
+	InTime  string              `json:"inTime"`
+	OutTime string              `json:"outTime"`
+}
+
+type okxPush struct {
+	Arg    OKXArg      `json:"arg"`
+	Action string      `json:"action,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+// okxAction is returned by a ChannelHandler for a push that carries an action, such as
+// the updates of the books channel
+type okxAction struct {
+	action string
+	data   interface{}
+}
+
+type okxError struct {
+	code string
+	msg  string
+}
+
+type okxConn struct {
+	*conn
+	id       string
+	private  bool
+	loggedIn bool
+	args     map[string]OKXArg
+}
+
+// OKX speaks the OKX v5 websocket protocol, public channels on OKXPublicPath and
+// login, private channels and order ops on OKXPrivatePath
+type OKX struct {
+	*server
+	mu             sync.Mutex
+	channels       map[string]ChannelHandler
+	ops            map[string]OpHandler
+	markPrices     map[string]*feed
+	rejects        map[string]okxError
+	conns          map[*conn]*okxConn
+	hub            hub
+	requestCounter map[string]int
+	credentials    *okxCredentials
+	orders         map[string]*OKXOrder
+	orderCounter   int64
+	seqID          int64
+	tradeID        int64
+	instruments    []OKXInstrument
+	books          map[string]*book
+	fundings       map[string]funding
+	fillLimits     map[string]decimal.Decimal
+}
+
+// NewOKX starts a server acknowledging subscribe ops, pushing mark-price, bbo-tbt,
+// tickers, trades, books, books5 and funding-rate data, the candle<bar> data on OKXBusinessPath and
+// answering the order, cancel-order and amend-order ops and the signed positions endpoint
+func NewOKX() *OKX {
+	o := &OKX{
+		channels:       make(map[string]ChannelHandler),
+		ops:            make(map[string]OpHandler),
+		markPrices:     make(map[string]*feed),
+		rejects:        make(map[string]okxError),
+		conns:          make(map[*conn]*okxConn),
+		requestCounter: make(map[string]int),
+		orders:         make(map[string]*OKXOrder),
+		instruments:    defaultOKXInstruments(),
+		books:          make(map[string]*book),
+		fundings:       make(map[string]funding),
+		fillLimits:     make(map[string]decimal.Decimal),
+	}
+	o.HandleChannel("mark-price", o.markPrice)
+	o.HandleChannel("bbo-tbt", o.bbo)
+	o.HandleChannel("trades", o.trades)
+	o.HandleChannel("tickers", o.tickers)
+	o.HandleChannel("books", o.bookUpdate)
+	o.HandleChannel("books5", o.books5)
+	o.HandleChannel("funding-rate", o.fundingRate)
+	for bar, duration := range okxBars {
+		o.HandleChannel("candle"+bar, o.candle(duration))
+	}
+	o.handleOrders()
+	mux := http.NewServeMux()
+	mux.HandleFunc(OKXPublicPath, func(w http.ResponseWriter, r *http.Request) { o.serve(w, r, false) })
+	mux.HandleFunc(OKXPrivatePath, func(w http.ResponseWriter, r *http.Request) { o.serve(w, r, true) })
+	mux.HandleFunc(OKXBusinessPath, func(w http.ResponseWriter, r *http.Request) { o.serve(w, r, false) })
+	mux.HandleFunc(OKXInstrumentsPath, o.serveInstruments)
+	mux.HandleFunc(OKXHistoryCandlesPath, o.serveHistoryCandles)
+	mux.HandleFunc(OKXPositionsPath, o.servePositions)
+	o.server = newServer(mux, DefaultInterval, o.push)
+	return o
+}
+
+func (o *OKX) PublicWebSocketURL() string {
+	return o.wsURL(OKXPublicPath)
+}
+
+func (o *OKX) PrivateWebSocketURL() string {
+	return o.wsURL(OKXPrivatePath)
+}
+
+func (o *OKX) BusinessWebSocketURL() string {
+	return o.wsURL(OKXBusinessPath)
+}
+
+// HandleChannel registers or replaces the data generator of a channel
+func (o *OKX) HandleChannel(channel string, handler ChannelHandler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.channels[channel] = handler
+}
+
+// HandleOp registers or replaces the handler of a private op
+func (o *OKX) HandleOp(op string, handler OpHandler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ops[op] = handler
+}
+
+// SetMarkPrices scripts the mark prices pushed for instID, one per interval
+func (o *OKX) SetMarkPrices(instID string, prices ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.markPrices[instID] = &feed{values: prices}
+}
+
+// SetFunding scripts the funding of instID, nextRate is the predicted rate and is sent
+// empty when ""
+func (o *OKX) SetFunding(instID string, rate string, nextRate string, interval time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fundings[instID] = funding{rate: rate, nextRate: nextRate, interval: interval}
+}
+
+// RejectInstrument makes subscriptions to instID reply with an error event, the way
+// OKX answers an unknown or delisted instrument
+func (o *OKX) RejectInstrument(instID string, code string, msg string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rejects[instID] = okxError{code: code, msg: msg}
+}
+
+// Requests returns how many requests with op were received, REST requests are
+// counted by path
+func (o *OKX) Requests(op string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requestCounter[op]
+}
+
+// Push sends data to every connection subscribed to arg
+func (o *OKX) Push(arg OKXArg, data interface{}) {
+	for _, c := range o.hub.list() {
+		o.mu.Lock()
+		_, ok := o.conns[c].args[arg.key()]
+		o.mu.Unlock()
+		if ok {
+			_ = c.write(okxPush{Arg: arg, Data: data})
+		}
+	}
+}
+
+// pushMatching sends data to every connection with a subscription of channel that
+// matches instType and instID, the subscription arg is echoed like OKX does
+func (o *OKX) pushMatching(channel string, instType string, instID string, data interface{}) {
+	for _, c := range o.hub.list() {
+		o.mu.Lock()
+		var args []OKXArg
+		for _, arg := range o.conns[c].args {
+			if arg.Channel == channel && (arg.InstType == "" || arg.InstType == "ANY" || arg.InstType == instType) && (arg.InstID == "" || arg.InstID == instID) {
+				args = append(args, arg)
+			}
+		}
+		o.mu.Unlock()
+		for _, arg := range args {
+			_ = c.write(okxPush{Arg: arg, Data: data})
+		}
+	}
+}
+
+// DropConnections closes every connection
+func (o *OKX) DropConnections() {
+	o.hub.drop()
+}
+
+func (o *OKX) serve(w http.ResponseWriter, r *http.Request, private bool) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &okxConn{
+		conn:    newConn(ws),
+		id:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		private: private,
+		args:    make(map[string]OKXArg),
+	}
+	o.hub.add(c.conn)
+	o.mu.Lock()
+	o.conns[c.conn] = c
+	o.mu.Unlock()
+	defer func() {
+		o.hub.remove(c.conn)
+		o.mu.Lock()
+		delete(o.conns, c.conn)
+		o.mu.Unlock()
+		ws.Close()
+	}()
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if string(message) == "ping" {
+			if err := c.writeText("pong"); err != nil {
+				return
+			}
+			continue
+		}
+		var request okxRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			_ = c.write(okxEvent{Event: "error", Code: "60012", Msg: "Invalid request: " + string(message), ConnID: c.id})
+			continue
+		}
+		o.mu.Lock()
+		o.requestCounter[request.OP]++
+		o.mu.Unlock()
+		switch request.OP {
+		case "login":
+			err = o.handleLogin(c, &request)
+		case "subscribe", "unsubscribe":
+			err = o.handleSubscribe(c, &request)
+		default:
+			err = o.handleOp(c, &request)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (o *OKX) handleSubscribe(c *okxConn, request *okxRequest) error {
+	var args []*OKXArg
+	if err := json.Unmarshal(request.Args, &args); err != nil {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60012", Msg: "Invalid request: " + err.Error(), ConnID: c.id})
+	}
+	if c.private && !c.loggedIn {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60011", Msg: "Please log in", ConnID: c.id})
+	}
+	if rejected := o.rejected(args); rejected != nil {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: rejected.code, Msg: rejected.msg, ConnID: c.id})
+	}
+	for _, arg := range args {
+		o.mu.Lock()
+		if request.OP == "subscribe" {
+			c.args[arg.key()] = *arg
+		} else {
+			delete(c.args, arg.key())
+		}
+		o.mu.Unlock()
+		if err := c.write(okxEvent{ID: request.ID, Event: request.OP, Arg: arg, ConnID: c.id}); err != nil {
+			return err
+		}
+		// like OKX the books channel starts with a snapshot right after the ack
+		if request.OP == "subscribe" && arg.Channel == "books" {
+			if err := c.write(okxPush{Arg: *arg, Action: "snapshot", Data: o.bookSnapshot(arg.InstID)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *OKX) handleOp(c *okxConn, request *okxRequest) error {
+	o.mu.Lock()
+	handler, ok := o.ops[request.OP]
+	o.mu.Unlock()
+	if !c.private || !ok {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60012", Msg: "Invalid request: unknown op " + request.OP, ConnID: c.id})
+	}
+	inTime := strconv.FormatInt(time.Now().UnixMicro(), 10)
+	if !c.loggedIn {
+		return c.write(okxResponse{ID: request.ID, OP: request.OP, Code: "60011", Msg: "Please log in", Data: []map[string]string{}, InTime: inTime})
+	}
+	var args []json.RawMessage
+	if err := json.Unmarshal(request.Args, &args); err != nil {
+		return c.write(okxResponse{ID: request.ID, OP: request.OP, Code: "60012", Msg: "Invalid request", Data: []map[string]string{}, InTime: inTime})
+	}
+	response := okxResponse{ID: request.ID, OP: request.OP, Code: "0", Data: handler(args), InTime: inTime}
+	for _, item := range response.Data {
+		if item["sCode"] != "0" {
+			response.Code = "1"
+			response.Msg = "Operation failed."
+		}
+	}
+	response.OutTime = strconv.FormatInt(time.Now().UnixMicro(), 10)
+	return c.write(response)
+}
+
+func (o *OKX) rejected(args []*OKXArg) *okxError {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, arg := range args {
+		if e, ok := o.rejects[arg.InstID]; ok {
+			return &e
+		}
+	}
+	return nil
+}
+
+// SkipBookUpdate drops the next books update of instID so the clients see a gap in prevSeqId
+func (o *OKX) SkipBookUpdate(instID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.book(instID).skip = true
+}
+
+// CorruptBookChecksum sends the next books update of instID with a wrong checksum
+func (o *OKX) CorruptBookChecksum(instID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.book(instID).corrupt = true
+}
+
+// push drives every subscribed arg whose channel has a registered ChannelHandler, the
+// books change once per push so every connection sees the same updates
+func (o *OKX) push() {
+	o.mu.Lock()
+	for _, book := range o.books {
+		book.tick()
+	}
+	o.mu.Unlock()
+	for _, c := range o.hub.list() {
+		o.mu.Lock()
+		args := make([]OKXArg, 0, len(o.conns[c].args))
+		for _, arg := range o.conns[c].args {
+			args = append(args, arg)
+		}
+		o.mu.Unlock()
+		for _, arg := range args {
+			o.mu.Lock()
+			handler := o.channels[arg.Channel]
+			o.mu.Unlock()
+			if handler == nil {
+				continue
+			}
+			data := handler(arg)
+			if data == nil {
+				continue
+			}
+			if action, ok := data.(okxAction); ok {
+				_ = c.write(okxPush{Arg: arg, Action: action.action, Data: action.data})
+				continue
+			}
+			_ = c.write(okxPush{Arg: arg, Data: data})
+		}
+	}
+}
+
+func (o *OKX) nextMarkPrice(instID string, advance bool) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f, ok := o.markPrices[instID]
+	if !ok {
+		return DefaultPrice
+	}
+	if !advance {
+		return f.current()
+	}
+	return f.pop()
+}
+
+func (o *OKX) markPrice(arg OKXArg) interface{} {
+	return []map[string]string{{
+		"instType": "SWAP",
+		"instId":   arg.InstID,
+		"markPx":   o.nextMarkPrice(arg.InstID, true),
+		"ts":       strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}}
+}
+
+func (o *OKX) fundingRate(arg OKXArg) interface{} {
+	o.mu.Lock()
+	f, ok := o.fundings[arg.InstID]
+	o.mu.Unlock()
+	if !ok {
+		f = defaultFunding
+	}
+	fundingTime := f.next(time.Now())
+	method := "current_period"
+	if f.nextRate != "" {
+		method = "next_period"
+	}
+	return []map[string]string{{
+		"instType":        "SWAP",
+		"instId":          arg.InstID,
+		"method":          method,
+		"fundingRate":     f.rate,
+		"nextFundingRate": f.nextRate,
+		"fundingTime":     strconv.FormatInt(fundingTime, 10),
+		"nextFundingTime": strconv.FormatInt(fundingTime+f.interval.Milliseconds(), 10),
+		"ts":              strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}}
+}
+
+// bbo quotes DefaultSpread around the current mark price without advancing it
+func (o *OKX) bbo(arg OKXArg) interface{} {
+	o.mu.Lock()
+	o.seqID++
+	seqID := o.seqID
+	o.mu.Unlock()
+	bid, ask := quote(o.nextMarkPrice(arg.InstID, false))
+	return []map[string]interface{}{{
+		"asks":  [][]string{{ask, DefaultQuantity, "0", "1"}},
+		"bids":  [][]string{{bid, DefaultQuantity, "0", "1"}},
+		"ts":    strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"seqId": seqID,
+	}}
+}
+
+// trades alternates taker buys and sells at the current mark price
+func (o *OKX) trades(arg OKXArg) interface{} {
+	o.mu.Lock()
+	o.tradeID++
+	tradeID := o.tradeID
+	o.mu.Unlock()
+	side := "buy"
+	if tradeID%2 == 0 {
+		side = "sell"
+	}
+	return []map[string]string{{
+		"instId":  arg.InstID,
+		"tradeId": strconv.FormatInt(tradeID, 10),
+		"px":      o.nextMarkPrice(arg.InstID, false),
+		"sz":      DefaultQuantity,
+		"side":    side,
+		"ts":      strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"count":   "1",
+	}}
+}
+
+// tickers quotes DefaultSpread around the current mark price without advancing it
+func (o *OKX) tickers(arg OKXArg) interface{} {
+	price := o.nextMarkPrice(arg.InstID, false)
+	bid, ask := quote(price)
+	return []map[string]string{{
+		"instType":  "SWAP",
+		"instId":    arg.InstID,
+		"last":      price,
+		"lastSz":    DefaultQuantity,
+		"askPx":     ask,
+		"askSz":     DefaultQuantity,
+		"bidPx":     bid,
+		"bidSz":     DefaultQuantity,
+		"open24h":   price,
+		"high24h":   price,
+		"low24h":    price,
+		"volCcy24h": "0",
+		"vol24h":    "0",
+		"sodUtc0":   price,
+		"sodUtc8":   price,
+		"ts":        strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}}
+}
+
+// book must be called with mu held, it creates the book of instID around its mark price
+func (o *OKX) book(instID string) *book {
+	b, ok := o.books[instID]
+	if !ok {
+		mid := DefaultPrice
+		if f, ok := o.markPrices[instID]; ok && f.current() != "" {
+			mid = f.current()
+		}
+		b = newBook(mid)
+		o.books[instID] = b
+	}
+	return b
+}
+
+// okxLevels appends the deprecated and order count fields to [price, size] levels
+func okxLevels(levels [][]string) [][]string {
+	result := make([][]string, 0, len(levels))
+	for _, level := range levels {
+		result = append(result, []string{level[0], level[1], "0", "1"})
+	}
+	return result
+}
+
+func (o *OKX) bookSnapshot(instID string) interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.book(instID)
+	return []map[string]interface{}{{
+		"asks":      okxLevels(b.levels(false, 0)),
+		"bids":      okxLevels(b.levels(true, 0)),
+		"ts":        strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"checksum":  b.checksum(),
+		"prevSeqId": -1,
+		"seqId":     b.seq,
+	}}
+}
+
+// bookUpdate sends the level changed by the last book tick
+func (o *OKX) bookUpdate(arg OKXArg) interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.book(arg.InstID)
+	if !b.updated() {
+		return nil
+	}
+	checksum := b.checksum()
+	if b.corrupting {
+		checksum++
+	}
+	return okxAction{action: "update", data: []map[string]interface{}{{
+		"asks":      okxLevels(b.lastAsks),
+		"bids":      okxLevels(b.lastBids),
+		"ts":        strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"checksum":  checksum,
+		"prevSeqId": b.prevSeq,
+		"seqId":     b.seq,
+	}}}
+}
+
+// books5 sends the best 5 levels of the book on every push
+func (o *OKX) books5(arg OKXArg) interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.book(arg.InstID)
+	return []map[string]interface{}{{
+		"asks":   okxLevels(b.levels(false, 5)),
+		"bids":   okxLevels(b.levels(true, 5)),
+		"instId": arg.InstID,
+		"ts":     strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"seqId":  b.seq,
+	}}
+}
+
+// okxCandleRow is the array form [ts, o, h, l, c, vol, volCcy, volCcyQuote, confirm], vol
+// is reported in contracts of one base unit
+func okxCandleRow(k candle) []string {
+	confirm := "0"
+	if k.Closed {
+		confirm = "1"
+	}
+	return []string{strconv.FormatInt(k.OpenTime, 10), k.Open, k.High, k.Low, k.Close, k.Volume, k.Volume, k.Volume, confirm}
+}
+
+// candle updates the open candle of the bar at the current mark price
+func (o *OKX) candle(duration time.Duration) ChannelHandler {
+	return func(arg OKXArg) interface{} {
+		openTime := candleOpenTime(time.Now().UnixMilli(), duration)
+		return [][]string{okxCandleRow(newCandle(o.nextMarkPrice(arg.InstID, false), openTime, duration))}
+	}
+}
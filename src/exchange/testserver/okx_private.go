@@ -0,0 +1,260 @@
+package testserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// OKXOrder is the order state kept by the fake private connection, it is pushed on the
+// orders channel whenever it changes
+type OKXOrder struct {
+	InstType    string          `json:"instType"`
+	InstID      string          `json:"instId"`
+	OrdID       string          `json:"ordId"`
+	ClOrdID     string          `json:"clOrdId"`
+	Price       string          `json:"px"`
+	Size        decimal.Decimal `json:"sz"`
+	OrdType     string          `json:"ordType"`
+	Side        string          `json:"side"`
+	PosSide     string          `json:"posSide"`
+	TdMode      string          `json:"tdMode"`
+	State       string          `json:"state"`
+	AccFillSize decimal.Decimal `json:"accFillSz"`
+	AvgPrice    string          `json:"avgPx"`
+	FillPrice   string          `json:"fillPx"`
+	FillSize    decimal.Decimal `json:"fillSz"`
+	ReduceOnly  string          `json:"reduceOnly"`
+	CTime       string          `json:"cTime"`
+	UTime       string          `json:"uTime"`
+}
+
+type okxCredentials struct {
+	apiKey     string
+	secretKey  string
+	passphrase string
+}
+
+type okxOrderArgs struct {
+	InstID     string          `json:"instId"`
+	TdMode     string          `json:"tdMode"`
+	Side       string          `json:"side"`
+	PosSide    string          `json:"posSide"`
+	OrdType    string          `json:"ordType"`
+	Size       decimal.Decimal `json:"sz"`
+	Price      string          `json:"px"`
+	ClOrdID    string          `json:"clOrdId"`
+	ReduceOnly bool            `json:"reduceOnly"`
+	OrdID      string          `json:"ordId"`
+	NewSize    string          `json:"newSz"`
+	NewPrice   string          `json:"newPx"`
+}
+
+// SetCredentials makes login accept apiKey/passphrase signed with secretKey
+func (o *OKX) SetCredentials(apiKey string, secretKey string, passphrase string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.credentials = &okxCredentials{apiKey: apiKey, secretKey: secretKey, passphrase: passphrase}
+}
+
+// Order returns a copy of the order stored by the fake exchange
+func (o *OKX) Order(ordID string) (OKXOrder, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	order, ok := o.orders[ordID]
+	if !ok {
+		return OKXOrder{}, false
+	}
+	return *order, true
+}
+
+func (o *OKX) handleLogin(c *okxConn, request *okxRequest) error {
+	var args []struct {
+		APIKey     string `json:"apiKey"`
+		Passphrase string `json:"passphrase"`
+		Timestamp  string `json:"timestamp"`
+		Sign       string `json:"sign"`
+	}
+	if err := json.Unmarshal(request.Args, &args); err != nil || len(args) != 1 {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60012", Msg: "Invalid request", ConnID: c.id})
+	}
+	o.mu.Lock()
+	credentials := o.credentials
+	o.mu.Unlock()
+	arg := args[0]
+	if credentials == nil || arg.APIKey != credentials.apiKey {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60005", Msg: "Invalid OK-ACCESS-KEY", ConnID: c.id})
+	}
+	if arg.Passphrase != credentials.passphrase {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60024", Msg: "Wrong passphrase", ConnID: c.id})
+	}
+	timestamp, err := strconv.ParseInt(arg.Timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)).Abs() > 30*time.Second {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60004", Msg: "Invalid timestamp", ConnID: c.id})
+	}
+	mac := hmac.New(sha256.New, []byte(credentials.secretKey))
+	mac.Write([]byte(arg.Timestamp + "GET" + "/users/self/verify"))
+	if base64.StdEncoding.EncodeToString(mac.Sum(nil)) != arg.Sign {
+		return c.write(okxEvent{ID: request.ID, Event: "error", Code: "60007", Msg: "Invalid sign", ConnID: c.id})
+	}
+	o.mu.Lock()
+	c.loggedIn = true
+	o.mu.Unlock()
+	return c.write(okxEvent{ID: request.ID, Event: "login", Code: "0", ConnID: c.id})
+}
+
+func (o *OKX) handleOrders() {
+	o.HandleOp("order", o.eachOrder(o.orderPlace))
+	o.HandleOp("cancel-order", o.eachOrder(o.orderCancel))
+	o.HandleOp("amend-order", o.eachOrder(o.orderAmend))
+}
+
+func (o *OKX) eachOrder(handler func(args *okxOrderArgs) map[string]string) OpHandler {
+	return func(raw []json.RawMessage) []map[string]string {
+		data := make([]map[string]string, 0, len(raw))
+		for _, item := range raw {
+			var args okxOrderArgs
+			if err := json.Unmarshal(item, &args); err != nil {
+				data = append(data, orderItem(nil, "51000", "Parameter error"))
+				continue
+			}
+			data = append(data, handler(&args))
+		}
+		return data
+	}
+}
+
+func orderItem(order *OKXOrder, sCode string, sMsg string) map[string]string {
+	item := map[string]string{
+		"sCode": sCode,
+		"sMsg":  sMsg,
+		"ts":    strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}
+	if order != nil {
+		item["ordId"] = order.OrdID
+		item["clOrdId"] = order.ClOrdID
+	}
+	return item
+}
+
+// SetFillLimit caps the size a market order of instID fills, the rest of a larger order
+// stays partially_filled until it is canceled
+func (o *OKX) SetFillLimit(instID string, size string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fillLimits[instID] = decimal.RequireFromString(size)
+}
+
+// FailOp makes every item of the following op requests fail with sCode and sMsg
+func (o *OKX) FailOp(op string, sCode string, sMsg string) {
+	o.HandleOp(op, o.eachOrder(func(*okxOrderArgs) map[string]string {
+		return orderItem(nil, sCode, sMsg)
+	}))
+}
+
+// orderPlace fills market orders at the current mark price, up to SetFillLimit, and
+// rests the other types as live
+func (o *OKX) orderPlace(args *okxOrderArgs) map[string]string {
+	if args.InstID == "" || !args.Size.IsPositive() {
+		return orderItem(nil, "51000", "Parameter sz error")
+	}
+	markPrice := o.nextMarkPrice(args.InstID, false)
+	o.mu.Lock()
+	o.orderCounter++
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	order := &OKXOrder{
+		InstType:   "SWAP",
+		InstID:     args.InstID,
+		OrdID:      strconv.FormatInt(o.orderCounter, 10),
+		ClOrdID:    args.ClOrdID,
+		Price:      args.Price,
+		Size:       args.Size,
+		OrdType:    args.OrdType,
+		Side:       args.Side,
+		PosSide:    "net",
+		TdMode:     args.TdMode,
+		State:      "live",
+		ReduceOnly: strconv.FormatBool(args.ReduceOnly),
+		CTime:      now,
+		UTime:      now,
+	}
+	if args.PosSide != "" {
+		order.PosSide = args.PosSide
+	}
+	if order.OrdType == "market" {
+		order.State = "filled"
+		order.AvgPrice = markPrice
+		order.FillPrice = markPrice
+		order.FillSize = order.Size
+		if limit, ok := o.fillLimits[order.InstID]; ok && limit.LessThan(order.Size) {
+			order.State = "partially_filled"
+			order.FillSize = limit
+		}
+		order.AccFillSize = order.FillSize
+	} else if _, err := decimal.NewFromString(args.Price); err != nil {
+		o.mu.Unlock()
+		return orderItem(nil, "51000", "Parameter px error")
+	}
+	o.orders[order.OrdID] = order
+	copied := *order
+	o.mu.Unlock()
+	o.pushMatching("orders", copied.InstType, copied.InstID, []OKXOrder{copied})
+	return orderItem(&copied, "0", "Order placed")
+}
+
+func (o *OKX) findOrder(args *okxOrderArgs) *OKXOrder {
+	if order, ok := o.orders[args.OrdID]; ok && order.InstID == args.InstID {
+		return order
+	}
+	for _, order := range o.orders {
+		if args.ClOrdID != "" && order.ClOrdID == args.ClOrdID && order.InstID == args.InstID {
+			return order
+		}
+	}
+	return nil
+}
+
+func (o *OKX) orderCancel(args *okxOrderArgs) map[string]string {
+	o.mu.Lock()
+	order := o.findOrder(args)
+	if order == nil || (order.State != "live" && order.State != "partially_filled") {
+		o.mu.Unlock()
+		return orderItem(nil, "51400", "Order cancellation failed as the order has been filled, canceled or does not exist")
+	}
+	order.State = "canceled"
+	order.UTime = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	copied := *order
+	o.mu.Unlock()
+	o.pushMatching("orders", copied.InstType, copied.InstID, []OKXOrder{copied})
+	return orderItem(&copied, "0", "")
+}
+
+func (o *OKX) orderAmend(args *okxOrderArgs) map[string]string {
+	o.mu.Lock()
+	order := o.findOrder(args)
+	if order == nil || order.State != "live" {
+		o.mu.Unlock()
+		return orderItem(nil, "51503", "Order modification failed as the order has been filled, canceled or does not exist")
+	}
+	if args.NewSize != "" {
+		size, err := decimal.NewFromString(args.NewSize)
+		if err != nil {
+			o.mu.Unlock()
+			return orderItem(nil, "51000", "Parameter newSz error")
+		}
+		order.Size = size
+	}
+	if args.NewPrice != "" {
+		order.Price = args.NewPrice
+	}
+	order.UTime = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	copied := *order
+	o.mu.Unlock()
+	o.pushMatching("orders", copied.InstType, copied.InstID, []OKXOrder{copied})
+	return orderItem(&copied, "0", "")
+}
@@ -0,0 +1,226 @@
+package testserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OKXInstrumentsPath    = "/api/v5/public/instruments"
+	OKXHistoryCandlesPath = "/api/v5/market/history-candles"
+	OKXPositionsPath      = "/api/v5/account/positions"
+)
+
+// OKXInstrument is one SWAP listed by the fake instruments endpoint
+type OKXInstrument struct {
+	InstID    string
+	SettleCcy string
+	CtType    string
+	CtVal     string
+	TickSz    string
+	LotSz     string
+	MinSz     string
+	State     string
+}
+
+func defaultOKXInstruments() []OKXInstrument {
+	return []OKXInstrument{
+		{InstID: "BTC-USDT-SWAP", SettleCcy: "USDT", CtType: "linear", CtVal: "0.01", TickSz: "0.1", LotSz: "0.01", MinSz: "0.01", State: "live"},
+		{InstID: "ETH-USDT-SWAP", SettleCcy: "USDT", CtType: "linear", CtVal: "0.1", TickSz: "0.01", LotSz: "0.01", MinSz: "0.01", State: "live"},
+		{InstID: "OKB-USDT-SWAP", SettleCcy: "USDT", CtType: "linear", CtVal: "0.1", TickSz: "0.001", LotSz: "1", MinSz: "1", State: "live"},
+		{InstID: "BTC-USD-SWAP", SettleCcy: "BTC", CtType: "inverse", CtVal: "100", TickSz: "0.1", LotSz: "1", MinSz: "1", State: "live"},
+	}
+}
+
+// RESTURL is the base url of the fake v5 REST API
+func (o *OKX) RESTURL() string {
+	return o.URL
+}
+
+// SetInstruments replaces the SWAP instruments listed by the instruments endpoint
+func (o *OKX) SetInstruments(instruments ...OKXInstrument) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.instruments = instruments
+}
+
+func (o *OKX) serveInstruments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if instType := r.URL.Query().Get("instType"); instType != "SWAP" {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "51000",
+			"msg":  "Parameter instType error",
+			"data": []interface{}{},
+		})
+		return
+	}
+	o.mu.Lock()
+	o.requestCounter[OKXInstrumentsPath]++
+	data := make([]map[string]string, 0, len(o.instruments))
+	for _, i := range o.instruments {
+		data = append(data, map[string]string{
+			"instType":  "SWAP",
+			"instId":    i.InstID,
+			"settleCcy": i.SettleCcy,
+			"ctType":    i.CtType,
+			"ctVal":     i.CtVal,
+			"ctMult":    "1",
+			"tickSz":    i.TickSz,
+			"lotSz":     i.LotSz,
+			"minSz":     i.MinSz,
+			"state":     i.State,
+		})
+	}
+	o.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": "0",
+		"msg":  "",
+		"data": data,
+	})
+}
+
+// serveHistoryCandles lists the fake candles older than after, or the latest ones, newest first
+func (o *OKX) serveHistoryCandles(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	o.mu.Lock()
+	o.requestCounter[OKXHistoryCandlesPath]++
+	o.mu.Unlock()
+	duration, ok := okxBars[query.Get("bar")]
+	if !ok {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "51000",
+			"msg":  "Parameter bar error",
+			"data": []interface{}{},
+		})
+		return
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	openTime := candleOpenTime(time.Now().UnixMilli(), duration)
+	if after, err := strconv.ParseInt(query.Get("after"), 10, 64); err == nil {
+		openTime = min(openTime, candleOpenTime(after-1, duration))
+	}
+	price := o.nextMarkPrice(query.Get("instId"), false)
+	data := [][]string{}
+	for ; len(data) < limit; openTime -= duration.Milliseconds() {
+		data = append(data, okxCandleRow(newCandle(price, openTime, duration)))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": "0",
+		"msg":  "",
+		"data": data,
+	})
+}
+
+// servePositions checks the OK-ACCESS-* headers against SetCredentials and nets the
+// fills of every order by instrument, in net mode
+func (o *OKX) servePositions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code string, msg string) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": code,
+			"msg":  msg,
+			"data": []interface{}{},
+		})
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requestCounter[OKXPositionsPath]++
+	credentials := o.credentials
+	if credentials == nil || r.Header.Get("OK-ACCESS-KEY") != credentials.apiKey {
+		fail("50111", "Invalid OK-ACCESS-KEY")
+		return
+	}
+	if r.Header.Get("OK-ACCESS-PASSPHRASE") != credentials.passphrase {
+		fail("50105", "Invalid OK-ACCESS-PASSPHRASE")
+		return
+	}
+	timestamp := r.Header.Get("OK-ACCESS-TIMESTAMP")
+	mac := hmac.New(sha256.New, []byte(credentials.secretKey))
+	mac.Write([]byte(timestamp + r.Method + r.URL.RequestURI()))
+	if base64.StdEncoding.EncodeToString(mac.Sum(nil)) != r.Header.Get("OK-ACCESS-SIGN") {
+		fail("50113", "Invalid Sign")
+		return
+	}
+	type position struct {
+		pos   decimal.Decimal
+		cost  decimal.Decimal
+		size  decimal.Decimal
+		uTime string
+	}
+	positions := make(map[string]*position)
+	orders := make([]*OKXOrder, 0, len(o.orders))
+	for _, order := range o.orders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		a, _ := strconv.ParseInt(orders[i].OrdID, 10, 64)
+		b, _ := strconv.ParseInt(orders[j].OrdID, 10, 64)
+		return a < b
+	})
+	for _, order := range orders {
+		if order.AccFillSize.IsZero() {
+			continue
+		}
+		p, ok := positions[order.InstID]
+		if !ok {
+			p = &position{}
+			positions[order.InstID] = p
+		}
+		price, _ := decimal.NewFromString(order.AvgPrice)
+		signed := order.AccFillSize
+		if order.Side == "sell" {
+			signed = signed.Neg()
+		}
+		previous := p.pos
+		p.pos = p.pos.Add(signed)
+		switch {
+		case previous.IsZero() || previous.Sign() == signed.Sign():
+			p.cost = p.cost.Add(price.Mul(order.AccFillSize))
+			p.size = p.size.Add(order.AccFillSize)
+		case p.pos.Sign() == signed.Sign():
+			// the fill crossed zero, the rest opens at its price
+			p.size = p.pos.Abs()
+			p.cost = price.Mul(p.size)
+		}
+		p.uTime = order.UTime
+	}
+	instIDs := make([]string, 0, len(positions))
+	for instID := range positions {
+		instIDs = append(instIDs, instID)
+	}
+	sort.Strings(instIDs)
+	data := []map[string]string{}
+	for _, instID := range instIDs {
+		p := positions[instID]
+		if p.pos.IsZero() {
+			continue
+		}
+		data = append(data, map[string]string{
+			"instType": "SWAP",
+			"instId":   instID,
+			"posSide":  "net",
+			"pos":      p.pos.String(),
+			"avgPx":    p.cost.Div(p.size).String(),
+			"mgnMode":  "cross",
+			"uTime":    p.uTime,
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": "0",
+		"msg":  "",
+		"data": data,
+	})
+}
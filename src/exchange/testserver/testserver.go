@@ -0,0 +1,188 @@
+// Package testserver provides local fake Binance and OKX websocket servers so the
+// exchange clients can be tested without network access
+package testserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultInterval is how often scripted feeds push a new event to subscribers
+	DefaultInterval = 20 * time.Millisecond
+	// DefaultPrice is used for instruments without a scripted feed
+	DefaultPrice = "100"
+	// DefaultSpread is the distance of the fake best bid and ask from the mark price
+	DefaultSpread = "0.1"
+	// DefaultQuantity is the size of the fake best bid/ask levels and trades
+	DefaultQuantity = "1"
+	// DefaultFundingRate is the funding rate of the instruments without a scripted funding
+	DefaultFundingRate = "0.0001"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// conn is one accepted client connection, gorilla websocket supports a single
+// concurrent writer so every write goes through write
+type conn struct {
+	ws     *websocket.Conn
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+func newConn(ws *websocket.Conn) *conn {
+	return &conn{
+		ws:     ws,
+		topics: make(map[string]bool),
+	}
+}
+
+func (c *conn) write(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+func (c *conn) writeText(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, []byte(text))
+}
+
+func (c *conn) subscribe(topic string, subscribed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if subscribed {
+		c.topics[topic] = true
+	} else {
+		delete(c.topics, topic)
+	}
+}
+
+func (c *conn) subscribed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// hub keeps the connections accepted on one endpoint
+type hub struct {
+	mu    sync.Mutex
+	conns map[*conn]bool
+}
+
+func (h *hub) add(c *conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns == nil {
+		h.conns = make(map[*conn]bool)
+	}
+	h.conns[c] = true
+}
+
+func (h *hub) remove(c *conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c)
+}
+
+func (h *hub) list() []*conn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns := make([]*conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// drop closes every connection to force the clients to reconnect
+func (h *hub) drop() {
+	for _, c := range h.list() {
+		c.ws.Close()
+	}
+}
+
+// feed is a scripted sequence of values, the last one repeats once the script is exhausted
+type feed struct {
+	values []string
+	next   int
+}
+
+func (f *feed) pop() string {
+	if len(f.values) == 0 {
+		return ""
+	}
+	value := f.values[min(f.next, len(f.values)-1)]
+	f.next++
+	return value
+}
+
+// current returns the last popped value without advancing the script
+func (f *feed) current() string {
+	if len(f.values) == 0 {
+		return ""
+	}
+	return f.values[min(max(f.next-1, 0), len(f.values)-1)]
+}
+
+// server wraps httptest.Server with a ticker that drives the scripted feeds
+type server struct {
+	*httptest.Server
+	done chan struct{}
+	once sync.Once
+}
+
+func newServer(handler http.Handler, interval time.Duration, tick func()) *server {
+	s := &server{
+		Server: httptest.NewServer(handler),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				tick()
+			}
+		}
+	}()
+	return s
+}
+
+func (s *server) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.Server.CloseClientConnections()
+		s.Server.Close()
+	})
+}
+
+func (s *server) wsURL(path string) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + path
+}
+
+// quote returns the fake best bid and ask around price
+func quote(price string) (string, string) {
+	p, err := decimal.NewFromString(price)
+	if err != nil {
+		return price, price
+	}
+	spread := decimal.RequireFromString(DefaultSpread)
+	return p.Sub(spread).String(), p.Add(spread).String()
+}
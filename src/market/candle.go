@@ -0,0 +1,157 @@
+package market
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CandleInterval uses the Binance notation, the venues map it to their own bar names
+type CandleInterval string
+
+const (
+	CandleInterval1m  CandleInterval = "1m"
+	CandleInterval3m  CandleInterval = "3m"
+	CandleInterval5m  CandleInterval = "5m"
+	CandleInterval15m CandleInterval = "15m"
+	CandleInterval30m CandleInterval = "30m"
+	CandleInterval1h  CandleInterval = "1h"
+	CandleInterval2h  CandleInterval = "2h"
+	CandleInterval4h  CandleInterval = "4h"
+	CandleInterval6h  CandleInterval = "6h"
+	CandleInterval12h CandleInterval = "12h"
+	CandleInterval1d  CandleInterval = "1d"
+	CandleInterval1w  CandleInterval = "1w"
+)
+
+var candleIntervals = map[CandleInterval]time.Duration{
+	CandleInterval1m:  time.Minute,
+	CandleInterval3m:  3 * time.Minute,
+	CandleInterval5m:  5 * time.Minute,
+	CandleInterval15m: 15 * time.Minute,
+	CandleInterval30m: 30 * time.Minute,
+	CandleInterval1h:  time.Hour,
+	CandleInterval2h:  2 * time.Hour,
+	CandleInterval4h:  4 * time.Hour,
+	CandleInterval6h:  6 * time.Hour,
+	CandleInterval12h: 12 * time.Hour,
+	CandleInterval1d:  24 * time.Hour,
+	CandleInterval1w:  7 * 24 * time.Hour,
+}
+
+// Duration is zero for an unknown interval
+func (i CandleInterval) Duration() time.Duration {
+	return candleIntervals[i]
+}
+
+func (i CandleInterval) Validate() error {
+	if i.Duration() == 0 {
+		return fmt.Errorf("unknown candle interval %q", string(i))
+	}
+	return nil
+}
+
+// Candle times are in milliseconds, CloseTime is the last millisecond of the interval.
+// Volume is in the base asset and QuoteVolume in the quote currency on every venue
+type Candle struct {
+	Exchange    string
+	Symbol      string
+	Interval    CandleInterval
+	OpenTime    int64
+	CloseTime   int64
+	Open        decimal.Decimal
+	High        decimal.Decimal
+	Low         decimal.Decimal
+	Close       decimal.Decimal
+	Volume      decimal.Decimal
+	QuoteVolume decimal.Decimal
+	// Closed is false while the interval is still updating
+	Closed bool
+}
+
+// CandleSource is implemented by the exchange clients that stream and backfill candles
+type CandleSource interface {
+	Exchange() string
+	// SubscribeCandles streams the updates of the current candle of symbol
+	SubscribeCandles(symbol string, interval CandleInterval, handler func(*Candle)) error
+	// LoadCandles returns the candles opened in [start, end) in milliseconds, oldest first
+	LoadCandles(ctx context.Context, symbol string, interval CandleInterval, start int64, end int64) ([]*Candle, error)
+}
+
+// CandleSeries keeps the last size candles of one symbol, backfilled from history and
+// then updated by the live stream. It is safe for concurrent use
+type CandleSeries struct {
+	mu       sync.RWMutex
+	symbol   string
+	interval CandleInterval
+	size     int
+	candles  []*Candle
+}
+
+func NewCandleSeries(symbol string, interval CandleInterval, size int) *CandleSeries {
+	return &CandleSeries{
+		symbol:   symbol,
+		interval: interval,
+		size:     size,
+	}
+}
+
+// Start subscribes the live candles before backfilling the last size intervals, so no
+// candle is missed in between. A live candle is never replaced by the history
+func (s *CandleSeries) Start(ctx context.Context, source CandleSource) error {
+	if err := s.interval.Validate(); err != nil {
+		return err
+	}
+	err := source.SubscribeCandles(s.symbol, s.interval, s.Update)
+	if err != nil {
+		return err
+	}
+	end := time.Now().UnixMilli()
+	start := end - int64(s.size)*s.interval.Duration().Milliseconds()
+	history, err := source.LoadCandles(ctx, s.symbol, s.interval, start, end)
+	if err != nil {
+		return err
+	}
+	s.merge(history, false)
+	return nil
+}
+
+// Update inserts a candle or replaces the one with the same open time
+func (s *CandleSeries) Update(candle *Candle) {
+	s.merge([]*Candle{candle}, true)
+}
+
+func (s *CandleSeries) merge(candles []*Candle, replace bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, candle := range candles {
+		i := sort.Search(len(s.candles), func(i int) bool {
+			return s.candles[i].OpenTime >= candle.OpenTime
+		})
+		if i < len(s.candles) && s.candles[i].OpenTime == candle.OpenTime {
+			if replace {
+				s.candles[i] = candle
+			}
+			continue
+		}
+		s.candles = append(s.candles, nil)
+		copy(s.candles[i+1:], s.candles[i:])
+		s.candles[i] = candle
+	}
+	if len(s.candles) > s.size {
+		s.candles = s.candles[len(s.candles)-s.size:]
+	}
+}
+
+// Candles returns the candles oldest first, the last one may still be open
+func (s *CandleSeries) Candles() []*Candle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	candles := make([]*Candle, len(s.candles))
+	copy(candles, s.candles)
+	return candles
+}
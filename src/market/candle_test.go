@@ -0,0 +1,65 @@
+package market
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCandleSource struct {
+	live    []*Candle
+	history []*Candle
+	start   int64
+	end     int64
+}
+
+func (s *testCandleSource) Exchange() string {
+	return ExchangeBinance
+}
+
+func (s *testCandleSource) SubscribeCandles(symbol string, interval CandleInterval, handler func(*Candle)) error {
+	for _, candle := range s.live {
+		handler(candle)
+	}
+	return nil
+}
+
+func (s *testCandleSource) LoadCandles(ctx context.Context, symbol string, interval CandleInterval, start int64, end int64) ([]*Candle, error) {
+	s.start, s.end = start, end
+	return s.history, nil
+}
+
+func TestCandleSeries(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	candle := func(openTime int64, price int64, closed bool) *Candle {
+		return &Candle{OpenTime: openTime * minute, Close: decimal.NewFromInt(price), Closed: closed}
+	}
+	source := &testCandleSource{
+		// the live candle of minute 5 arrives before the history that still has it open
+		live:    []*Candle{candle(5, 105, false)},
+		history: []*Candle{candle(1, 1, true), candle(2, 2, true), candle(3, 3, true), candle(4, 4, true), candle(5, 5, false)},
+	}
+	series := NewCandleSeries("BTCUSDT", CandleInterval1m, 3)
+	require.NoError(t, series.Start(context.Background(), source))
+	assert.Equal(t, 3*minute, source.end-source.start)
+
+	candles := series.Candles()
+	require.Len(t, candles, 3)
+	assert.Equal(t, 3*minute, candles[0].OpenTime)
+	assert.True(t, candles[2].Close.Equal(decimal.NewFromInt(105)), "history must not replace a live candle")
+
+	series.Update(candle(5, 106, true))
+	series.Update(candle(6, 107, false))
+	candles = series.Candles()
+	require.Len(t, candles, 3)
+	assert.Equal(t, 4*minute, candles[0].OpenTime)
+	assert.True(t, candles[1].Closed)
+	assert.True(t, candles[2].Close.Equal(decimal.NewFromInt(107)))
+
+	assert.Error(t, NewCandleSeries("BTCUSDT", "2d", 3).Start(context.Background(), source))
+	assert.Equal(t, 7*24*time.Hour, CandleInterval1w.Duration())
+}
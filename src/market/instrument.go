@@ -0,0 +1,140 @@
+package market
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	InstrumentStatusTrading   = "trading"
+	InstrumentStatusPending   = "pending"
+	InstrumentStatusSuspended = "suspended"
+)
+
+// Instrument is the metadata of a perpetual swap on one exchange. TickSize is in quote
+// currency, LotSize and MinQuantity are in the exchange's order unit (base currency on
+// Binance, contracts on OKX) and ContractValue converts one order unit to base currency
+type Instrument struct {
+	// ID is the canonical BASE/QUOTE:SETTLE id shared by every exchange
+	ID            string
+	Exchange      string
+	Symbol        string
+	Base          string
+	Quote         string
+	Settle        string
+	TickSize      decimal.Decimal
+	LotSize       decimal.Decimal
+	MinQuantity   decimal.Decimal
+	ContractValue decimal.Decimal
+	// MinNotional is zero when the exchange has no minimum notional
+	MinNotional decimal.Decimal
+	Status      string
+}
+
+// InstrumentID builds the canonical perpetual id such as BTC/USDT:USDT
+func InstrumentID(base string, quote string, settle string) string {
+	return strings.ToUpper(base) + "/" + strings.ToUpper(quote) + ":" + strings.ToUpper(settle)
+}
+
+func (i *Instrument) Trading() bool {
+	return i.Status == InstrumentStatusTrading
+}
+
+// InstrumentSource is implemented by every exchange client that can list its perpetual swaps
+type InstrumentSource interface {
+	Exchange() string
+	LoadInstruments(ctx context.Context) ([]*Instrument, error)
+}
+
+// Registry indexes the instruments of several exchanges by canonical id and by exchange
+// symbol, it is safe for concurrent use
+type Registry struct {
+	mu       sync.RWMutex
+	byID     map[string]map[string]*Instrument
+	bySymbol map[string]map[string]*Instrument
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byID:     make(map[string]map[string]*Instrument),
+		bySymbol: make(map[string]map[string]*Instrument),
+	}
+}
+
+// Load replaces the instruments of every source, a failing source leaves the
+// previously loaded instruments of that exchange untouched
+func (r *Registry) Load(ctx context.Context, sources ...InstrumentSource) error {
+	for _, source := range sources {
+		instruments, err := source.LoadInstruments(ctx)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		delete(r.byID, source.Exchange())
+		delete(r.bySymbol, source.Exchange())
+		r.mu.Unlock()
+		r.Add(instruments...)
+	}
+	return nil
+}
+
+func (r *Registry) Add(instruments ...*Instrument) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, instrument := range instruments {
+		if r.byID[instrument.Exchange] == nil {
+			r.byID[instrument.Exchange] = make(map[string]*Instrument)
+			r.bySymbol[instrument.Exchange] = make(map[string]*Instrument)
+		}
+		r.byID[instrument.Exchange][instrument.ID] = instrument
+		r.bySymbol[instrument.Exchange][instrument.Symbol] = instrument
+	}
+}
+
+// Instrument looks up an instrument by canonical id
+func (r *Registry) Instrument(exchange string, id string) (*Instrument, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	instrument, ok := r.byID[exchange][id]
+	return instrument, ok
+}
+
+// Symbol looks up an instrument by the exchange's own symbol
+func (r *Registry) Symbol(exchange string, symbol string) (*Instrument, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	instrument, ok := r.bySymbol[exchange][symbol]
+	return instrument, ok
+}
+
+// Common returns the sorted canonical ids trading on every given exchange
+func (r *Registry) Common(exchanges ...string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(exchanges) == 0 {
+		return nil
+	}
+	ids := []string{}
+	for id, instrument := range r.byID[exchanges[0]] {
+		if !instrument.Trading() {
+			continue
+		}
+		listed := true
+		for _, exchange := range exchanges[1:] {
+			other, ok := r.byID[exchange][id]
+			if !ok || !other.Trading() {
+				listed = false
+				break
+			}
+		}
+		if listed {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
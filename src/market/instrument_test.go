@@ -0,0 +1,61 @@
+package market
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testInstrumentSource struct {
+	exchange    string
+	instruments []*Instrument
+	err         error
+}
+
+func (s *testInstrumentSource) Exchange() string {
+	return s.exchange
+}
+
+func (s *testInstrumentSource) LoadInstruments(context.Context) ([]*Instrument, error) {
+	return s.instruments, s.err
+}
+
+func TestRegistry(t *testing.T) {
+	instrument := func(exchange string, symbol string, base string, status string) *Instrument {
+		return &Instrument{
+			ID:       InstrumentID(base, "usdt", "usdt"),
+			Exchange: exchange,
+			Symbol:   symbol,
+			Status:   status,
+		}
+	}
+	a := &testInstrumentSource{exchange: "a", instruments: []*Instrument{
+		instrument("a", "BTCUSDT", "BTC", InstrumentStatusTrading),
+		instrument("a", "ETHUSDT", "ETH", InstrumentStatusTrading),
+		instrument("a", "SOLUSDT", "SOL", InstrumentStatusSuspended),
+		instrument("a", "BNBUSDT", "BNB", InstrumentStatusTrading),
+	}}
+	b := &testInstrumentSource{exchange: "b", instruments: []*Instrument{
+		instrument("b", "ETH-USDT-SWAP", "ETH", InstrumentStatusTrading),
+		instrument("b", "BTC-USDT-SWAP", "BTC", InstrumentStatusTrading),
+		instrument("b", "SOL-USDT-SWAP", "SOL", InstrumentStatusTrading),
+	}}
+	registry := NewRegistry()
+	require.NoError(t, registry.Load(context.Background(), a, b))
+	assert.Equal(t, []string{"BTC/USDT:USDT", "ETH/USDT:USDT"}, registry.Common("a", "b"))
+
+	btc, ok := registry.Instrument("b", "BTC/USDT:USDT")
+	require.True(t, ok)
+	assert.Equal(t, "BTC-USDT-SWAP", btc.Symbol)
+	eth, ok := registry.Symbol("a", "ETHUSDT")
+	require.True(t, ok)
+	assert.Equal(t, "ETH/USDT:USDT", eth.ID)
+
+	// a failing reload keeps the previous instruments
+	b.err = errors.New("unavailable")
+	require.Error(t, registry.Load(context.Background(), b))
+	assert.Len(t, registry.Common("a", "b"), 2)
+}
@@ -0,0 +1,106 @@
+// Package market holds the exchange agnostic market data types shared by the exchange
+// clients and the strategies
+package market
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ExchangeBinance = "binance"
+	ExchangeOKX     = "okx"
+)
+
+type TickType string
+
+const (
+	TickTypeMarkPrice  TickType = "mark_price"
+	TickTypeBookTicker TickType = "book_ticker"
+	TickTypeTrade      TickType = "trade"
+)
+
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// Tick is one market data update of a symbol on an exchange. Price is the mark price,
+// the trade price or the mid of the best bid/ask depending on Type
+type Tick struct {
+	Exchange string
+	Symbol   string
+	Type     TickType
+	Price    decimal.Decimal
+	// Quantity and Side (the taker side) are set for trades
+	Quantity decimal.Decimal
+	Side     string
+	// BidPrice, BidQuantity, AskPrice and AskQuantity are set for book tickers
+	BidPrice    decimal.Decimal
+	BidQuantity decimal.Decimal
+	AskPrice    decimal.Decimal
+	AskQuantity decimal.Decimal
+	// ExchangeTime is the exchange event time and LocalTime the local receive time, both in milliseconds
+	ExchangeTime int64
+	LocalTime    int64
+}
+
+// MarketDataSource is implemented by every exchange client, symbols are in the
+// exchange's own format such as BTCUSDT on Binance and BTC-USDT-SWAP on OKX
+type MarketDataSource interface {
+	Exchange() string
+	InitMarketData(ctx context.Context) error
+	OnMarketDataReconnect(handler func())
+	SubscribeMarkPrice(symbol string, handler func(*Tick)) error
+	SubscribeBookTicker(symbol string, handler func(*Tick)) error
+	SubscribeTrades(symbol string, handler func(*Tick)) error
+	// Clean closes every connection of the source
+	Clean()
+}
+
+// NewBookTick builds a book ticker tick with Price set to the mid price
+func NewBookTick(exchange string, symbol string, bidPrice, bidQuantity, askPrice, askQuantity decimal.Decimal, exchangeTime int64, localTime int64) *Tick {
+	return &Tick{
+		Exchange:     exchange,
+		Symbol:       symbol,
+		Type:         TickTypeBookTicker,
+		Price:        bidPrice.Add(askPrice).Div(decimal.NewFromInt(2)),
+		BidPrice:     bidPrice,
+		BidQuantity:  bidQuantity,
+		AskPrice:     askPrice,
+		AskQuantity:  askQuantity,
+		ExchangeTime: exchangeTime,
+		LocalTime:    localTime,
+	}
+}
+
+// Funding is the funding state of a perpetual, times are in milliseconds. Rate is settled
+// at FundingTime, PredictedRate is the rate of the following period settled at
+// NextFundingTime and is only valid when the venue publishes it
+type Funding struct {
+	Exchange        string
+	Symbol          string
+	Rate            decimal.Decimal
+	FundingTime     int64
+	PredictedRate   decimal.NullDecimal
+	NextFundingTime int64
+	Interval        time.Duration
+	ExchangeTime    int64
+	LocalTime       int64
+}
+
+// AnnualizedRate is Rate compounded linearly over a year of Interval periods, in percent
+func (f *Funding) AnnualizedRate() decimal.Decimal {
+	if f.Interval <= 0 {
+		return decimal.Zero
+	}
+	periods := decimal.NewFromInt(int64(365 * 24 * time.Hour / f.Interval))
+	return f.Rate.Mul(periods).Mul(decimal.NewFromInt(100))
+}
+
+// FundingSource is implemented by the exchange clients that stream funding rates
+type FundingSource interface {
+	SubscribeFunding(symbol string, handler func(*Funding)) error
+}
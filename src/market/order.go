@@ -0,0 +1,168 @@
+package market
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"trade/src/common"
+
+	"github.com/shopspring/decimal"
+)
+
+type OrderType string
+
+const (
+	OrderTypeMarket OrderType = "market"
+	OrderTypeLimit  OrderType = "limit"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusNew             OrderStatus = "new"
+	OrderStatusPartiallyFilled OrderStatus = "partially_filled"
+	OrderStatusFilled          OrderStatus = "filled"
+	OrderStatusCanceled        OrderStatus = "canceled"
+	OrderStatusRejected        OrderStatus = "rejected"
+)
+
+// Final is true once the order can't fill any more
+func (s OrderStatus) Final() bool {
+	return s == OrderStatusFilled || s == OrderStatusCanceled || s == OrderStatusRejected
+}
+
+// OrderRequest is in the venue's own symbol format and order unit, Price is ignored for
+// market orders
+type OrderRequest struct {
+	Symbol        string
+	Side          string
+	Type          OrderType
+	Quantity      decimal.Decimal
+	Price         decimal.Decimal
+	ReduceOnly    bool
+	ClientOrderID string
+}
+
+// Order is a snapshot of an order, FilledQuantity and Fee are cumulative and AvgPrice is
+// the average fill price. Times are in milliseconds
+type Order struct {
+	ID             string
+	ClientOrderID  string
+	Exchange       string
+	Symbol         string
+	Side           string
+	Type           OrderType
+	Price          decimal.Decimal
+	Quantity       decimal.Decimal
+	ReduceOnly     bool
+	FilledQuantity decimal.Decimal
+	AvgPrice       decimal.Decimal
+	Fee            decimal.Decimal
+	Status         OrderStatus
+	// Reason explains a rejected or canceled order
+	Reason     string
+	CreateTime int64
+	UpdateTime int64
+}
+
+// Position is signed, negative when short. RealizedPnL excludes the fees
+type Position struct {
+	Exchange    string
+	Symbol      string
+	Quantity    decimal.Decimal
+	EntryPrice  decimal.Decimal
+	RealizedPnL decimal.Decimal
+}
+
+// ExecutionVenue is implemented by the venues the strategies trade on. A rejected order
+// is returned with OrderStatusRejected rather than an error, the errors are for requests
+// that could not be sent. CancelOrder returns a nil order when the venue only
+// acknowledges the cancel. The handlers of OnOrderUpdate get every later change of the
+// orders
+type ExecutionVenue interface {
+	Exchange() string
+	PlaceOrder(ctx context.Context, request *OrderRequest) (*Order, error)
+	CancelOrder(ctx context.Context, symbol string, orderID string) (*Order, error)
+	OnOrderUpdate(handler func(*Order))
+}
+
+// PositionSource is implemented by the venues that can list the positions held on the
+// account, including the ones opened outside of the process. The quantities are in the
+// unit of the orders
+type PositionSource interface {
+	GetPositions(ctx context.Context) ([]Position, error)
+}
+
+// NewRejectedOrder is the order returned by a venue rejecting request
+func NewRejectedOrder(exchange string, request *OrderRequest, reason string, now int64) *Order {
+	return &Order{
+		ClientOrderID:  request.ClientOrderID,
+		Exchange:       exchange,
+		Symbol:         request.Symbol,
+		Side:           request.Side,
+		Type:           request.Type,
+		Price:          request.Price,
+		Quantity:       request.Quantity,
+		ReduceOnly:     request.ReduceOnly,
+		FilledQuantity: decimal.Zero,
+		AvgPrice:       decimal.Zero,
+		Fee:            decimal.Zero,
+		Status:         OrderStatusRejected,
+		Reason:         reason,
+		CreateTime:     now,
+		UpdateTime:     now,
+	}
+}
+
+// OrderDispatcher calls the OnOrderUpdate handlers of a live venue on its own goroutine,
+// in the order of Dispatch, so a handler may wait for a lock held while an order is sent
+// without blocking the connection reader that receives both the responses and updates
+type OrderDispatcher struct {
+	mu       sync.RWMutex
+	handlers []func(*Order)
+	updates  chan *Order
+	started  atomic.Bool
+}
+
+func NewOrderDispatcher() *OrderDispatcher {
+	return &OrderDispatcher{updates: make(chan *Order, 1024)}
+}
+
+func (d *OrderDispatcher) OnOrderUpdate(handler func(*Order)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers = append(d.handlers, handler)
+}
+
+// Dispatch blocks once 1024 updates wait for the handlers
+func (d *OrderDispatcher) Dispatch(order *Order) {
+	d.updates <- order
+}
+
+// Start runs the handlers until ctx is cancelled, the calls after the first are ignored
+func (d *OrderDispatcher) Start(ctx context.Context) {
+	if d.started.Swap(true) {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case order := <-d.updates:
+				d.dispatch(order)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// dispatch recovers a panicking handler so the later updates are still delivered
+func (d *OrderDispatcher) dispatch(order *Order) {
+	defer common.HandlePanic()
+	d.mu.RLock()
+	handlers := d.handlers
+	d.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(order)
+	}
+}
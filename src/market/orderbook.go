@@ -0,0 +1,192 @@
+package market
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+type Level struct {
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// OrderBook is an L2 book with bids sorted by descending and asks by ascending price.
+// It is written by the exchange reader goroutine and safe for concurrent queries
+type OrderBook struct {
+	Exchange string
+	Symbol   string
+	mu       sync.RWMutex
+	bids     []Level
+	asks     []Level
+	updateID int64
+	time     int64
+}
+
+// OrderBookSource is implemented by the exchange clients that maintain local L2 books,
+// handler is called on the reader goroutine after every applied update
+type OrderBookSource interface {
+	SubscribeOrderBook(symbol string, handler func(*OrderBook)) (*OrderBook, error)
+}
+
+func NewOrderBook(exchange string, symbol string) *OrderBook {
+	return &OrderBook{
+		Exchange: exchange,
+		Symbol:   symbol,
+	}
+}
+
+// Reset replaces the whole book with a snapshot
+func (b *OrderBook) Reset(bids []Level, asks []Level, updateID int64, time int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bids = b.bids[:0]
+	b.asks = b.asks[:0]
+	for _, level := range bids {
+		b.bids = setLevel(b.bids, level, true)
+	}
+	for _, level := range asks {
+		b.asks = setLevel(b.asks, level, false)
+	}
+	b.updateID = updateID
+	b.time = time
+}
+
+// Clear empties the book while it is resynced, so queries fail instead of using stale levels
+func (b *OrderBook) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bids = b.bids[:0]
+	b.asks = b.asks[:0]
+	b.updateID = 0
+}
+
+// Apply sets the quantity of every level in one step, a zero quantity removes the level
+func (b *OrderBook) Apply(bids []Level, asks []Level, updateID int64, time int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, level := range bids {
+		b.bids = setLevel(b.bids, level, true)
+	}
+	for _, level := range asks {
+		b.asks = setLevel(b.asks, level, false)
+	}
+	b.updateID = updateID
+	b.time = time
+}
+
+func setLevel(levels []Level, level Level, descending bool) []Level {
+	i := sort.Search(len(levels), func(i int) bool {
+		if descending {
+			return levels[i].Price.LessThanOrEqual(level.Price)
+		}
+		return levels[i].Price.GreaterThanOrEqual(level.Price)
+	})
+	found := i < len(levels) && levels[i].Price.Equal(level.Price)
+	switch {
+	case level.Quantity.IsZero() && found:
+		return append(levels[:i], levels[i+1:]...)
+	case level.Quantity.IsZero():
+		return levels
+	case found:
+		levels[i].Quantity = level.Quantity
+		return levels
+	}
+	levels = append(levels, Level{})
+	copy(levels[i+1:], levels[i:])
+	levels[i] = level
+	return levels
+}
+
+// UpdateID is the exchange sequence of the last applied update
+func (b *OrderBook) UpdateID() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.updateID
+}
+
+// Time is the exchange time of the last applied update in milliseconds
+func (b *OrderBook) Time() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.time
+}
+
+func (b *OrderBook) BestBid() (Level, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.bids) == 0 {
+		return Level{}, false
+	}
+	return b.bids[0], true
+}
+
+func (b *OrderBook) BestAsk() (Level, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.asks) == 0 {
+		return Level{}, false
+	}
+	return b.asks[0], true
+}
+
+// Bids returns a copy of the best depth bids, every bid when depth is 0
+func (b *OrderBook) Bids(depth int) []Level {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return top(b.bids, depth)
+}
+
+// Asks returns a copy of the best depth asks, every ask when depth is 0
+func (b *OrderBook) Asks(depth int) []Level {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return top(b.asks, depth)
+}
+
+func top(levels []Level, depth int) []Level {
+	if depth <= 0 || depth > len(levels) {
+		depth = len(levels)
+	}
+	result := make([]Level, depth)
+	copy(result, levels[:depth])
+	return result
+}
+
+// DepthAtSize returns the worst price reached by a taker order of quantity, side is the
+// taker side so SideBuy walks the asks. It is false when the book is too thin
+func (b *OrderBook) DepthAtSize(side string, quantity decimal.Decimal) (decimal.Decimal, bool) {
+	price, _, ok := b.walk(side, quantity)
+	return price, ok
+}
+
+// VWAP returns the average fill price of a taker order of quantity, side is the taker
+// side so SideBuy walks the asks. It is false when the book is too thin
+func (b *OrderBook) VWAP(side string, quantity decimal.Decimal) (decimal.Decimal, bool) {
+	_, vwap, ok := b.walk(side, quantity)
+	return vwap, ok
+}
+
+func (b *OrderBook) walk(side string, quantity decimal.Decimal) (decimal.Decimal, decimal.Decimal, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	levels := b.asks
+	if side == SideSell {
+		levels = b.bids
+	}
+	if !quantity.IsPositive() {
+		return decimal.Zero, decimal.Zero, false
+	}
+	remaining := quantity
+	notional := decimal.Zero
+	for _, level := range levels {
+		fill := decimal.Min(remaining, level.Quantity)
+		notional = notional.Add(fill.Mul(level.Price))
+		remaining = remaining.Sub(fill)
+		if remaining.IsZero() {
+			return level.Price, notional.Div(quantity), true
+		}
+	}
+	return decimal.Zero, decimal.Zero, false
+}
@@ -0,0 +1,69 @@
+package market
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderBook(t *testing.T) {
+	d := decimal.RequireFromString
+	level := func(price string, quantity string) Level {
+		return Level{Price: d(price), Quantity: d(quantity)}
+	}
+	book := NewOrderBook(ExchangeBinance, "BTCUSDT")
+	_, ok := book.BestBid()
+	assert.False(t, ok)
+
+	book.Reset(
+		[]Level{level("99", "2"), level("99.5", "1"), level("98", "5")},
+		[]Level{level("101", "2"), level("100.5", "1"), level("102", "5")},
+		10, 1000,
+	)
+	bid, ok := book.BestBid()
+	require.True(t, ok)
+	assert.True(t, bid.Price.Equal(d("99.5")))
+	ask, ok := book.BestAsk()
+	require.True(t, ok)
+	assert.True(t, ask.Price.Equal(d("100.5")))
+	assert.Equal(t, int64(10), book.UpdateID())
+	assert.Equal(t, int64(1000), book.Time())
+
+	// update a level, remove one, add one and ignore the removal of a missing one
+	book.Apply(
+		[]Level{level("99", "3"), level("99.5", "0"), level("97", "0")},
+		[]Level{level("100.8", "4")},
+		11, 1001,
+	)
+	assert.Equal(t, []Level{level("99", "3"), level("98", "5")}, book.Bids(0))
+	assert.Equal(t, []Level{level("100.5", "1"), level("100.8", "4")}, book.Asks(2))
+	assert.Equal(t, int64(11), book.UpdateID())
+
+	// buying 3 takes 1 at 100.5 and 2 at 100.8
+	price, ok := book.DepthAtSize(SideBuy, d("3"))
+	require.True(t, ok)
+	assert.True(t, price.Equal(d("100.8")), price.String())
+	vwap, ok := book.VWAP(SideBuy, d("3"))
+	require.True(t, ok)
+	assert.True(t, vwap.Equal(d("100.7")), vwap.String())
+
+	// selling 4 takes 3 at 99 and 1 at 98
+	price, ok = book.DepthAtSize(SideSell, d("4"))
+	require.True(t, ok)
+	assert.True(t, price.Equal(d("98")), price.String())
+	vwap, ok = book.VWAP(SideSell, d("4"))
+	require.True(t, ok)
+	assert.True(t, vwap.Equal(d("98.75")), vwap.String())
+
+	_, ok = book.VWAP(SideSell, d("9"))
+	assert.False(t, ok, "book too thin")
+	_, ok = book.VWAP(SideBuy, decimal.Zero)
+	assert.False(t, ok)
+
+	book.Clear()
+	_, ok = book.BestAsk()
+	assert.False(t, ok)
+	assert.Empty(t, book.Bids(0))
+}
@@ -0,0 +1,131 @@
+package market
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// VolumeBucket is the resolution of VolumeAggregator, windows are rounded to whole buckets
+const VolumeBucket = time.Second
+
+// TradeStats sums the trades of one venue and symbol over a window. Volumes are in the
+// venue's order unit (base asset on Binance, contracts on OKX) while Notional is in the
+// quote currency
+type TradeStats struct {
+	Count      int
+	Volume     decimal.Decimal
+	BuyVolume  decimal.Decimal
+	SellVolume decimal.Decimal
+	Notional   decimal.Decimal
+}
+
+// Imbalance is (buy - sell) / volume of the taker sides, from -1 to 1 and zero without trades
+func (s TradeStats) Imbalance() decimal.Decimal {
+	if !s.Volume.IsPositive() {
+		return decimal.Zero
+	}
+	return s.BuyVolume.Sub(s.SellVolume).Div(s.Volume)
+}
+
+func (s *TradeStats) add(other TradeStats) {
+	s.Count += other.Count
+	s.Volume = s.Volume.Add(other.Volume)
+	s.BuyVolume = s.BuyVolume.Add(other.BuyVolume)
+	s.SellVolume = s.SellVolume.Add(other.SellVolume)
+	s.Notional = s.Notional.Add(other.Notional)
+}
+
+type volumeBucket struct {
+	start int64
+	stats TradeStats
+}
+
+// VolumeAggregator keeps per venue and symbol buckets of trades for the last retention,
+// OnTrade is meant to be the handler of MarketDataSource.SubscribeTrades. It is safe
+// for concurrent use
+type VolumeAggregator struct {
+	mu             sync.Mutex
+	retention      time.Duration
+	contractValues map[string]decimal.Decimal
+	buckets        map[string][]*volumeBucket
+}
+
+func NewVolumeAggregator(retention time.Duration) *VolumeAggregator {
+	return &VolumeAggregator{
+		retention:      retention,
+		contractValues: make(map[string]decimal.Decimal),
+		buckets:        make(map[string][]*volumeBucket),
+	}
+}
+
+func volumeKey(exchange string, symbol string) string {
+	return exchange + "_" + symbol
+}
+
+// SetContractValue sets the base quantity of one order unit of symbol, Notional assumes 1 otherwise
+func (a *VolumeAggregator) SetContractValue(exchange string, symbol string, value decimal.Decimal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.contractValues[volumeKey(exchange, symbol)] = value
+}
+
+// OnTrade adds a trade tick to the bucket of its exchange time, other ticks are ignored
+func (a *VolumeAggregator) OnTrade(tick *Tick) {
+	if tick.Type != TickTypeTrade {
+		return
+	}
+	key := volumeKey(tick.Exchange, tick.Symbol)
+	bucketMillis := VolumeBucket.Milliseconds()
+	start := tick.ExchangeTime - tick.ExchangeTime%bucketMillis
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	contractValue, ok := a.contractValues[key]
+	if !ok {
+		contractValue = decimal.NewFromInt(1)
+	}
+	trade := TradeStats{
+		Count:    1,
+		Volume:   tick.Quantity,
+		Notional: tick.Price.Mul(tick.Quantity).Mul(contractValue),
+	}
+	if tick.Side == SideSell {
+		trade.SellVolume = tick.Quantity
+	} else {
+		trade.BuyVolume = tick.Quantity
+	}
+
+	buckets := a.buckets[key]
+	// trades mostly arrive in order, a late one goes to its own bucket if still retained
+	i := sort.Search(len(buckets), func(i int) bool {
+		return buckets[i].start >= start
+	})
+	if i == len(buckets) || buckets[i].start != start {
+		buckets = append(buckets, nil)
+		copy(buckets[i+1:], buckets[i:])
+		buckets[i] = &volumeBucket{start: start}
+	}
+	buckets[i].stats.add(trade)
+
+	oldest := buckets[len(buckets)-1].start - a.retention.Milliseconds()
+	expired := sort.Search(len(buckets), func(i int) bool {
+		return buckets[i].start >= oldest
+	})
+	a.buckets[key] = buckets[expired:]
+}
+
+// Stats sums the buckets of exchange and symbol starting within window before now in milliseconds
+func (a *VolumeAggregator) Stats(exchange string, symbol string, window time.Duration, now int64) TradeStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := TradeStats{}
+	from := now - window.Milliseconds()
+	for _, bucket := range a.buckets[volumeKey(exchange, symbol)] {
+		if bucket.start >= from && bucket.start <= now {
+			stats.add(bucket.stats)
+		}
+	}
+	return stats
+}
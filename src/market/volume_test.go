@@ -0,0 +1,57 @@
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeAggregator(t *testing.T) {
+	d := decimal.RequireFromString
+	trade := func(exchange string, side string, price string, quantity string, time int64) *Tick {
+		return &Tick{
+			Exchange:     exchange,
+			Symbol:       "BTC",
+			Type:         TickTypeTrade,
+			Price:        d(price),
+			Quantity:     d(quantity),
+			Side:         side,
+			ExchangeTime: time,
+		}
+	}
+	aggregator := NewVolumeAggregator(time.Minute)
+	aggregator.SetContractValue(ExchangeOKX, "BTC", d("0.01"))
+
+	aggregator.OnTrade(trade(ExchangeBinance, SideBuy, "100", "3", 10_000))
+	aggregator.OnTrade(trade(ExchangeBinance, SideSell, "100", "1", 12_500))
+	// late trade goes to its own bucket
+	aggregator.OnTrade(trade(ExchangeBinance, SideBuy, "100", "2", 11_200))
+	aggregator.OnTrade(&Tick{Exchange: ExchangeBinance, Symbol: "BTC", Type: TickTypeMarkPrice, Price: d("100"), ExchangeTime: 12_000})
+	aggregator.OnTrade(trade(ExchangeOKX, SideSell, "100", "50", 12_000))
+
+	stats := aggregator.Stats(ExchangeBinance, "BTC", 5*time.Second, 13_000)
+	assert.Equal(t, 3, stats.Count)
+	assert.True(t, stats.Volume.Equal(d("6")))
+	assert.True(t, stats.BuyVolume.Equal(d("5")))
+	assert.True(t, stats.SellVolume.Equal(d("1")))
+	assert.True(t, stats.Notional.Equal(d("600")))
+	assert.True(t, stats.Imbalance().Equal(d("4").Div(d("6"))))
+
+	// the window starts at 11s
+	stats = aggregator.Stats(ExchangeBinance, "BTC", 2*time.Second, 13_000)
+	assert.Equal(t, 2, stats.Count)
+	assert.True(t, stats.Volume.Equal(d("3")))
+
+	okx := aggregator.Stats(ExchangeOKX, "BTC", 5*time.Second, 13_000)
+	assert.Equal(t, 1, okx.Count)
+	assert.True(t, okx.Notional.Equal(d("50")), "contracts are converted with the contract value")
+	assert.True(t, okx.Imbalance().Equal(d("-1")))
+
+	// buckets older than the retention are dropped
+	aggregator.OnTrade(trade(ExchangeBinance, SideBuy, "100", "1", 80_000))
+	stats = aggregator.Stats(ExchangeBinance, "BTC", time.Hour, 80_000)
+	assert.Equal(t, 1, stats.Count)
+	assert.True(t, aggregator.Stats(ExchangeBinance, "ETH", time.Hour, 80_000).Imbalance().IsZero())
+}
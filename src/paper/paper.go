@@ -0,0 +1,505 @@
+// Package paper simulates the execution of orders against live market data so the
+// strategies can be evaluated without trading
+package paper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+const DefaultLatency = 50 * time.Millisecond
+
+var (
+	DefaultMakerFee = decimal.RequireFromString("0.0002")
+	DefaultTakerFee = decimal.RequireFromString("0.0005")
+	DefaultBalance  = decimal.NewFromInt(10000)
+	DefaultLeverage = decimal.NewFromInt(5)
+)
+
+var (
+	_ market.ExecutionVenue = (*Venue)(nil)
+	_ market.PositionSource = (*Venue)(nil)
+)
+
+// Venue matches the orders of one exchange against the book tickers fed to OnTick. The
+// quantities are in the venue's own unit like the book tickers, contracts on OKX, and
+// are converted with the contract value for the notional, fees and PnL in the quote
+// currency. The clock is the LocalTime of the ticks so a replay is simulated the same
+// way as live data. It is safe for concurrent use
+type Venue struct {
+	mu             sync.Mutex
+	exchange       string
+	makerFee       decimal.Decimal
+	takerFee       decimal.Decimal
+	latency        time.Duration
+	leverage       decimal.Decimal
+	initialBalance decimal.Decimal
+	// balance is the initial balance plus the realized PnL minus the fees
+	balance        decimal.Decimal
+	realizedPnL    decimal.Decimal
+	fees           decimal.Decimal
+	contractValues map[string]decimal.Decimal
+	books          map[string]*market.Tick
+	marks          map[string]decimal.Decimal
+	now            int64
+	orders         []*order
+	positions      map[string]*market.Position
+	handlers       []func(*market.Order)
+	orderCounter   int64
+}
+
+type order struct {
+	market.Order
+	// activeTime is when the order reaches the simulated matching engine
+	activeTime int64
+	// resting is set when the order was not marketable once active, its fills are maker
+	// fills at the limit price
+	resting bool
+	checked bool
+}
+
+type Option func(*Venue)
+
+// WithFees overrides DefaultMakerFee and DefaultTakerFee, the rates of the notional
+func WithFees(maker decimal.Decimal, taker decimal.Decimal) Option {
+	return func(v *Venue) {
+		v.makerFee = maker
+		v.takerFee = taker
+	}
+}
+
+// WithLatency overrides DefaultLatency, the delay before an order can match
+func WithLatency(latency time.Duration) Option {
+	return func(v *Venue) {
+		v.latency = latency
+	}
+}
+
+// WithBalance overrides DefaultBalance, the initial margin balance in the quote currency
+func WithBalance(balance decimal.Decimal) Option {
+	return func(v *Venue) {
+		v.initialBalance = balance
+	}
+}
+
+// WithLeverage overrides DefaultLeverage, the initial margin is the notional divided by it
+func WithLeverage(leverage decimal.Decimal) Option {
+	return func(v *Venue) {
+		v.leverage = leverage
+	}
+}
+
+func NewVenue(exchange string, opts ...Option) *Venue {
+	v := &Venue{
+		exchange:       exchange,
+		makerFee:       DefaultMakerFee,
+		takerFee:       DefaultTakerFee,
+		latency:        DefaultLatency,
+		leverage:       DefaultLeverage,
+		initialBalance: DefaultBalance,
+		contractValues: make(map[string]decimal.Decimal),
+		books:          make(map[string]*market.Tick),
+		marks:          make(map[string]decimal.Decimal),
+		positions:      make(map[string]*market.Position),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.balance = v.initialBalance
+	return v
+}
+
+func (v *Venue) Exchange() string {
+	return v.exchange
+}
+
+// SetContractValue sets the base quantity of one order unit of symbol, 1 when unset
+func (v *Venue) SetContractValue(symbol string, value decimal.Decimal) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.contractValues[symbol] = value
+}
+
+// OnOrderUpdate handlers are only called from OnTick, never from PlaceOrder or
+// CancelOrder whose result is the update
+func (v *Venue) OnOrderUpdate(handler func(*market.Order)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.handlers = append(v.handlers, handler)
+}
+
+// PlaceOrder accepts the order after the margin check, it can match from the first book
+// ticker received latency after the last tick
+func (v *Venue) PlaceOrder(ctx context.Context, request *market.OrderRequest) (*market.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.orderCounter++
+	o := &order{
+		Order: market.Order{
+			ID:             strconv.FormatInt(v.orderCounter, 10),
+			ClientOrderID:  request.ClientOrderID,
+			Exchange:       v.exchange,
+			Symbol:         request.Symbol,
+			Side:           request.Side,
+			Type:           request.Type,
+			Price:          request.Price,
+			Quantity:       request.Quantity,
+			ReduceOnly:     request.ReduceOnly,
+			FilledQuantity: decimal.Zero,
+			Status:         market.OrderStatusNew,
+			CreateTime:     v.now,
+			UpdateTime:     v.now,
+		},
+		activeTime: v.now + v.latency.Milliseconds(),
+	}
+	if reason := v.check(request); reason != "" {
+		o.Status = market.OrderStatusRejected
+		o.Reason = reason
+		snapshot := o.Order
+		return &snapshot, nil
+	}
+	v.orders = append(v.orders, o)
+	snapshot := o.Order
+	return &snapshot, nil
+}
+
+// check must be called with mu held, it returns why the request is rejected
+func (v *Venue) check(request *market.OrderRequest) string {
+	if request.Side != market.SideBuy && request.Side != market.SideSell {
+		return fmt.Sprintf("invalid side %q", request.Side)
+	}
+	if !request.Quantity.IsPositive() {
+		return "quantity must be positive"
+	}
+	switch request.Type {
+	case market.OrderTypeMarket:
+	case market.OrderTypeLimit:
+		if !request.Price.IsPositive() {
+			return "limit price must be positive"
+		}
+	default:
+		return fmt.Sprintf("invalid order type %q", request.Type)
+	}
+	if request.ReduceOnly {
+		return ""
+	}
+	price := request.Price
+	if request.Type == market.OrderTypeMarket {
+		price = v.price(request.Symbol)
+	}
+	if price.IsZero() {
+		return "no market data for " + request.Symbol
+	}
+	required := request.Quantity.Mul(v.contractValue(request.Symbol)).Mul(price).Div(v.leverage)
+	if available := v.available(); required.GreaterThan(available) {
+		return fmt.Sprintf("insufficient margin: required %s, available %s", required.StringFixed(2), available.StringFixed(2))
+	}
+	return ""
+}
+
+// CancelOrder cancels an open order, the filled part stays
+func (v *Venue) CancelOrder(ctx context.Context, symbol string, orderID string) (*market.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i, o := range v.orders {
+		if o.ID != orderID || o.Symbol != symbol {
+			continue
+		}
+		v.orders = append(v.orders[:i], v.orders[i+1:]...)
+		o.Status = market.OrderStatusCanceled
+		o.UpdateTime = v.now
+		snapshot := o.Order
+		return &snapshot, nil
+	}
+	return nil, fmt.Errorf("paper CancelOrder %s %s error: unknown order %s", v.exchange, symbol, orderID)
+}
+
+// OnTick advances the clock, keeps the mark prices for the margin and matches the open
+// orders of the symbol against a book ticker
+func (v *Venue) OnTick(tick *market.Tick) {
+	v.mu.Lock()
+	if tick.LocalTime > v.now {
+		v.now = tick.LocalTime
+	}
+	updates := []*market.Order{}
+	switch tick.Type {
+	case market.TickTypeMarkPrice:
+		v.marks[tick.Symbol] = tick.Price
+	case market.TickTypeBookTicker:
+		v.books[tick.Symbol] = tick
+		updates = v.match(tick)
+	}
+	handlers := v.handlers
+	v.mu.Unlock()
+	for _, update := range updates {
+		for _, handler := range handlers {
+			handler(update)
+		}
+	}
+}
+
+// match must be called with mu held. The orders fill in placement order and share the
+// quantity of the best bid or ask, what remains fills on the following book tickers
+func (v *Venue) match(book *market.Tick) []*market.Order {
+	updates := []*market.Order{}
+	askQuantity, bidQuantity := book.AskQuantity, book.BidQuantity
+	open := v.orders[:0]
+	for _, o := range v.orders {
+		if o.Symbol != book.Symbol || o.activeTime > v.now {
+			open = append(open, o)
+			continue
+		}
+		price, available := book.AskPrice, &askQuantity
+		if o.Side == market.SideSell {
+			price, available = book.BidPrice, &bidQuantity
+		}
+		if o.Type == market.OrderTypeLimit {
+			marketable := price.LessThanOrEqual(o.Price)
+			if o.Side == market.SideSell {
+				marketable = price.GreaterThanOrEqual(o.Price)
+			}
+			if !o.checked && !marketable {
+				o.resting = true
+			}
+			o.checked = true
+			if !marketable {
+				open = append(open, o)
+				continue
+			}
+			if o.resting {
+				price = o.Price
+			}
+		}
+		quantity := decimal.Min(o.Quantity.Sub(o.FilledQuantity), *available)
+		if o.ReduceOnly {
+			reducible := v.reducible(o.Symbol, o.Side)
+			if !reducible.IsPositive() {
+				o.Status = market.OrderStatusCanceled
+				o.Reason = "reduce only order would increase the position"
+				o.UpdateTime = v.now
+				snapshot := o.Order
+				updates = append(updates, &snapshot)
+				continue
+			}
+			quantity = decimal.Min(quantity, reducible)
+		}
+		if !quantity.IsPositive() {
+			open = append(open, o)
+			continue
+		}
+		*available = available.Sub(quantity)
+		v.fill(o, quantity, price)
+		snapshot := o.Order
+		updates = append(updates, &snapshot)
+		if !o.Status.Final() {
+			open = append(open, o)
+		}
+	}
+	v.orders = open
+	return updates
+}
+
+// fill must be called with mu held
+func (v *Venue) fill(o *order, quantity decimal.Decimal, price decimal.Decimal) {
+	contractValue := v.contractValue(o.Symbol)
+	rate := v.takerFee
+	if o.resting {
+		rate = v.makerFee
+	}
+	fee := quantity.Mul(contractValue).Mul(price).Mul(rate)
+	filled := o.FilledQuantity.Add(quantity)
+	o.AvgPrice = o.AvgPrice.Mul(o.FilledQuantity).Add(price.Mul(quantity)).Div(filled)
+	o.FilledQuantity = filled
+	o.Fee = o.Fee.Add(fee)
+	o.Status = market.OrderStatusPartiallyFilled
+	if filled.Equal(o.Quantity) {
+		o.Status = market.OrderStatusFilled
+	}
+	o.UpdateTime = v.now
+
+	signed := quantity
+	if o.Side == market.SideSell {
+		signed = quantity.Neg()
+	}
+	realized := v.position(o.Symbol).apply(signed, price, contractValue)
+	v.realizedPnL = v.realizedPnL.Add(realized)
+	v.fees = v.fees.Add(fee)
+	v.balance = v.balance.Add(realized).Sub(fee)
+}
+
+// reducible must be called with mu held, it is the quantity side can close
+func (v *Venue) reducible(symbol string, side string) decimal.Decimal {
+	position, ok := v.positions[symbol]
+	if !ok {
+		return decimal.Zero
+	}
+	if side == market.SideBuy && position.Quantity.IsNegative() {
+		return position.Quantity.Neg()
+	}
+	if side == market.SideSell && position.Quantity.IsPositive() {
+		return position.Quantity
+	}
+	return decimal.Zero
+}
+
+// position must be called with mu held
+func (v *Venue) position(symbol string) *paperPosition {
+	position, ok := v.positions[symbol]
+	if !ok {
+		position = &market.Position{Exchange: v.exchange, Symbol: symbol}
+		v.positions[symbol] = position
+	}
+	return (*paperPosition)(position)
+}
+
+type paperPosition market.Position
+
+// apply adds a signed fill and returns the PnL realized by the part that reduces the
+// position, a fill crossing zero opens the rest at price
+func (p *paperPosition) apply(signed decimal.Decimal, price decimal.Decimal, contractValue decimal.Decimal) decimal.Decimal {
+	realized := decimal.Zero
+	if p.Quantity.IsZero() || p.Quantity.Sign() == signed.Sign() {
+		total := p.Quantity.Add(signed)
+		p.EntryPrice = p.EntryPrice.Mul(p.Quantity.Abs()).Add(price.Mul(signed.Abs())).Div(total.Abs())
+		p.Quantity = total
+		return realized
+	}
+	closed := decimal.Min(signed.Abs(), p.Quantity.Abs())
+	realized = closed.Mul(contractValue).Mul(price.Sub(p.EntryPrice))
+	if p.Quantity.IsNegative() {
+		realized = realized.Neg()
+	}
+	p.RealizedPnL = p.RealizedPnL.Add(realized)
+	p.Quantity = p.Quantity.Add(signed)
+	switch {
+	case p.Quantity.IsZero():
+		p.EntryPrice = decimal.Zero
+	case p.Quantity.Sign() == signed.Sign():
+		p.EntryPrice = price
+	}
+	return realized
+}
+
+// contractValue must be called with mu held
+func (v *Venue) contractValue(symbol string) decimal.Decimal {
+	if value, ok := v.contractValues[symbol]; ok {
+		return value
+	}
+	return decimal.NewFromInt(1)
+}
+
+// price must be called with mu held, it is the mark price or else the book mid
+func (v *Venue) price(symbol string) decimal.Decimal {
+	if mark, ok := v.marks[symbol]; ok {
+		return mark
+	}
+	if book, ok := v.books[symbol]; ok {
+		return book.Price
+	}
+	return decimal.Zero
+}
+
+// Account is the state of the venue in the quote currency. Equity is Balance plus the
+// unrealized PnL at the mark prices and PnL its change since the start, fees included.
+// Margin is the initial margin of the positions and Available also deducts the margin
+// of the open orders
+type Account struct {
+	Exchange      string
+	PnL           decimal.Decimal
+	Balance       decimal.Decimal
+	RealizedPnL   decimal.Decimal
+	Fees          decimal.Decimal
+	UnrealizedPnL decimal.Decimal
+	Equity        decimal.Decimal
+	Margin        decimal.Decimal
+	Available     decimal.Decimal
+}
+
+func (v *Venue) Account() Account {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	unrealized, margin := v.exposure()
+	equity := v.balance.Add(unrealized)
+	return Account{
+		Exchange:      v.exchange,
+		PnL:           equity.Sub(v.initialBalance),
+		Balance:       v.balance,
+		RealizedPnL:   v.realizedPnL,
+		Fees:          v.fees,
+		UnrealizedPnL: unrealized,
+		Equity:        equity,
+		Margin:        margin,
+		Available:     v.available(),
+	}
+}
+
+// exposure must be called with mu held
+func (v *Venue) exposure() (unrealized decimal.Decimal, margin decimal.Decimal) {
+	for symbol, position := range v.positions {
+		if position.Quantity.IsZero() {
+			continue
+		}
+		price := v.price(symbol)
+		if price.IsZero() {
+			price = position.EntryPrice
+		}
+		base := position.Quantity.Mul(v.contractValue(symbol))
+		unrealized = unrealized.Add(base.Mul(price.Sub(position.EntryPrice)))
+		margin = margin.Add(base.Abs().Mul(price).Div(v.leverage))
+	}
+	return unrealized, margin
+}
+
+// available must be called with mu held
+func (v *Venue) available() decimal.Decimal {
+	unrealized, margin := v.exposure()
+	available := v.balance.Add(unrealized).Sub(margin)
+	for _, o := range v.orders {
+		if o.ReduceOnly {
+			continue
+		}
+		price := o.Price
+		if o.Type == market.OrderTypeMarket {
+			price = v.price(o.Symbol)
+		}
+		remaining := o.Quantity.Sub(o.FilledQuantity)
+		available = available.Sub(remaining.Mul(v.contractValue(o.Symbol)).Mul(price).Div(v.leverage))
+	}
+	return available
+}
+
+// Position returns a copy, the zero position when symbol was never traded
+func (v *Venue) Position(symbol string) market.Position {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if position, ok := v.positions[symbol]; ok {
+		return *position
+	}
+	return market.Position{Exchange: v.exchange, Symbol: symbol}
+}
+
+// Positions returns a copy of every position ever opened sorted by symbol
+func (v *Venue) Positions() []market.Position {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	positions := make([]market.Position, 0, len(v.positions))
+	for _, position := range v.positions {
+		positions = append(positions, *position)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Symbol < positions[j].Symbol
+	})
+	return positions
+}
+
+// GetPositions implements market.PositionSource with Positions
+func (v *Venue) GetPositions(ctx context.Context) ([]market.Position, error) {
+	return v.Positions(), nil
+}
@@ -0,0 +1,107 @@
+package paper
+
+import (
+	"context"
+	"testing"
+	"time"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVenue(t *testing.T) {
+	d := decimal.RequireFromString
+	ctx := context.Background()
+	book := func(bid string, ask string, quantity string, localTime int64) *market.Tick {
+		return market.NewBookTick(market.ExchangeOKX, "BTC-USDT-SWAP", d(bid), d(quantity), d(ask), d(quantity), localTime, localTime)
+	}
+	venue := NewVenue(market.ExchangeOKX,
+		WithFees(d("0.0002"), d("0.0005")),
+		WithLatency(100*time.Millisecond),
+		WithBalance(d("1000")),
+		WithLeverage(d("10")),
+	)
+	venue.SetContractValue("BTC-USDT-SWAP", d("0.01"))
+	updates := []*market.Order{}
+	venue.OnOrderUpdate(func(order *market.Order) {
+		updates = append(updates, order)
+	})
+
+	order, err := venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: d("10")})
+	require.NoError(t, err)
+	assert.Equal(t, market.OrderStatusRejected, order.Status, "no market data yet")
+
+	venue.OnTick(book("99", "101", "4", 1_000))
+	order, err = venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: d("10")})
+	require.NoError(t, err)
+	assert.Equal(t, market.OrderStatusNew, order.Status)
+
+	// the latency has not elapsed
+	venue.OnTick(book("99", "101", "4", 1_050))
+	assert.Empty(t, updates)
+	// partial fills on the best ask of each book ticker
+	venue.OnTick(book("99", "101", "4", 1_100))
+	venue.OnTick(book("100", "102", "100", 1_200))
+	require.Len(t, updates, 2)
+	assert.Equal(t, market.OrderStatusPartiallyFilled, updates[0].Status)
+	assert.True(t, updates[0].FilledQuantity.Equal(d("4")))
+	filled := updates[1]
+	assert.Equal(t, market.OrderStatusFilled, filled.Status)
+	assert.True(t, filled.AvgPrice.Equal(d("101.6")))
+	// taker fee of 10 contracts of 0.01 at 101.6
+	assert.True(t, filled.Fee.Equal(d("0.00508")), filled.Fee.String())
+
+	position := venue.Position("BTC-USDT-SWAP")
+	assert.True(t, position.Quantity.Equal(d("10")))
+	assert.True(t, position.EntryPrice.Equal(d("101.6")))
+
+	// a resting limit sell fills as maker at its price
+	order, err = venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeLimit, Price: d("105"), Quantity: d("10"), ReduceOnly: true})
+	require.NoError(t, err)
+	venue.OnTick(book("100", "102", "100", 1_300))
+	assert.Len(t, updates, 2)
+	venue.OnTick(book("106", "107", "100", 1_400))
+	require.Len(t, updates, 3)
+	assert.Equal(t, order.ID, updates[2].ID)
+	assert.Equal(t, market.OrderStatusFilled, updates[2].Status)
+	assert.True(t, updates[2].AvgPrice.Equal(d("105")))
+	assert.True(t, updates[2].Fee.Equal(d("0.0021")), updates[2].Fee.String())
+
+	account := venue.Account()
+	// (105 - 101.6) * 10 * 0.01
+	assert.True(t, account.RealizedPnL.Equal(d("0.34")), account.RealizedPnL.String())
+	assert.True(t, account.Fees.Equal(d("0.00718")))
+	assert.True(t, account.PnL.Equal(d("0.33282")), account.PnL.String())
+	assert.True(t, account.Margin.IsZero())
+
+	// a reduce only order without a position is canceled once it matches
+	_, err = venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("1"), ReduceOnly: true})
+	require.NoError(t, err)
+	venue.OnTick(book("106", "107", "100", 1_500))
+	require.Len(t, updates, 4)
+	assert.Equal(t, market.OrderStatusCanceled, updates[3].Status)
+
+	// 1000 of balance at 10x leverage carries 10000 of notional
+	order, err = venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("10000")})
+	require.NoError(t, err)
+	assert.Equal(t, market.OrderStatusRejected, order.Status)
+	assert.Contains(t, order.Reason, "insufficient margin")
+
+	// a short realizes the PnL of the other direction
+	order, err = venue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeLimit, Price: d("100"), Quantity: d("100")})
+	require.NoError(t, err)
+	venue.OnTick(book("106", "107", "100", 1_600))
+	position = venue.Position("BTC-USDT-SWAP")
+	assert.True(t, position.Quantity.Equal(d("-100")))
+	assert.True(t, position.EntryPrice.Equal(d("106")), "a marketable limit order takes the book")
+	venue.OnTick(&market.Tick{Exchange: market.ExchangeOKX, Symbol: "BTC-USDT-SWAP", Type: market.TickTypeMarkPrice, Price: d("104"), LocalTime: 1_700})
+	account = venue.Account()
+	assert.True(t, account.UnrealizedPnL.Equal(d("2")), account.UnrealizedPnL.String())
+	assert.True(t, account.Margin.Equal(d("10.4")))
+
+	canceled, err := venue.CancelOrder(ctx, "BTC-USDT-SWAP", order.ID)
+	assert.Error(t, err, "a filled order can't be canceled")
+	assert.Nil(t, canceled)
+}
@@ -0,0 +1,139 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+	"trade/src/common"
+)
+
+// Run starts the kill switch watchers and the HTTP server, it kills at once when the
+// kill file is left over from a previous run
+func (m *Manager) Run(ctx context.Context) error {
+	common.Logger.Sugar().Info("Risk Run")
+	if m.httpAddr != "" {
+		listener, err := net.Listen("tcp", m.httpAddr)
+		if err != nil {
+			return fmt.Errorf("Risk Run Listen %s error: %w", m.httpAddr, err)
+		}
+		m.server = &http.Server{Handler: m.Handler(), ReadHeaderTimeout: 5 * time.Second}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := m.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				common.Logger.Sugar().Errorf("Risk Serve %s error: %v", m.httpAddr, err)
+			}
+		}()
+	}
+	if len(m.killSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, m.killSignals...)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer signal.Stop(signals)
+			for {
+				select {
+				case sig := <-signals:
+					m.kill(ctx, "signal "+sig.String())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	if m.killFile != "" {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			for {
+				if _, err := os.Stat(m.killFile); err == nil && !m.Killed() {
+					m.kill(ctx, "file "+m.killFile)
+				}
+				if !common.Sleep(ctx, DefaultKillFileInterval) {
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// kill runs Kill within DefaultKillTimeout, its errors are logged by Kill
+func (m *Manager) kill(ctx context.Context, reason string) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultKillTimeout)
+	defer cancel()
+	_ = m.Kill(ctx, reason)
+}
+
+// Close stops the HTTP server and waits for the watchers after the Run context is
+// cancelled, then logs the stats
+func (m *Manager) Close(ctx context.Context) error {
+	var err error
+	if m.server != nil {
+		err = m.server.Shutdown(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+	stats := m.Stats()
+	common.Logger.Sugar().Infof("Risk Close killed: %t, open orders: %d, daily pnl: %s, fees: %s, rejections: %v",
+		stats.Killed, stats.OpenOrders, stats.DailyPnL.StringFixed(4), stats.Fees.StringFixed(4), stats.Rejections)
+	return err
+}
+
+// Handler serves GET /status with the Stats and POST /kill?reason=... which triggers
+// the kill switch and answers the Stats after the cancels and the flatten orders
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m.writeStats(w, http.StatusOK)
+	})
+	mux.HandleFunc("/kill", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reason := "http"
+		if query := r.URL.Query().Get("reason"); query != "" {
+			reason += " " + query
+		}
+		// a client hanging up doesn't stop the kill half way
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultKillTimeout)
+		defer cancel()
+		status := http.StatusOK
+		if err := m.Kill(ctx, reason); err != nil {
+			status = http.StatusInternalServerError
+		}
+		m.writeStats(w, status)
+	})
+	return mux
+}
+
+func (m *Manager) writeStats(w http.ResponseWriter, status int) {
+	data, err := json.Marshal(m.Stats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,586 @@
+// Package risk checks the orders of the strategies against the limits of the account
+// before they reach the venues and runs the kill switch that cancels every order and
+// flattens every position
+package risk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+// The reasons of the rejected orders, prefixed with "risk: " in market.Order.Reason
+const (
+	RejectKillSwitch     = "kill_switch"
+	RejectOrderRate      = "order_rate"
+	RejectOpenOrders     = "open_orders"
+	RejectDailyLoss      = "daily_loss"
+	RejectSymbolNotional = "symbol_notional"
+	RejectTotalNotional  = "total_notional"
+	RejectPriceBand      = "price_band"
+	// RejectNoPrice is an order checked against a notional or price band limit before
+	// the first mark price of its symbol
+	RejectNoPrice = "no_price"
+)
+
+const (
+	// DefaultKillFileInterval is how often the kill file is looked for
+	DefaultKillFileInterval = time.Second
+	// DefaultKillTimeout bounds the cancels and the flatten orders of a kill
+	DefaultKillTimeout = 10 * time.Second
+	day                = 24 * 60 * 60 * 1000
+)
+
+// Limits are in the quote currency, 0 disables a limit. The notional of a symbol is its
+// position plus its open orders and the order checked, at the mark price. MaxDailyLoss
+// is the fall of the PnL since the start of the UTC day, fees and unrealized PnL
+// included, MaxOrderRate counts the orders per second and PriceBand is the largest
+// distance of a limit price, or of the best price a market order takes, to the mark
+// price as a fraction
+type Limits struct {
+	MaxSymbolNotional decimal.Decimal
+	MaxTotalNotional  decimal.Decimal
+	MaxOpenOrders     int
+	MaxDailyLoss      decimal.Decimal
+	MaxOrderRate      int
+	PriceBand         decimal.Decimal
+}
+
+type Option func(*Manager)
+
+// WithClock overrides the wall clock of the order rate and of the daily loss, e.g. with
+// common.Replay.Now
+func WithClock(now func() int64) Option {
+	return func(m *Manager) {
+		m.now = now
+	}
+}
+
+// WithKillFile kills once path exists, it is looked for every DefaultKillFileInterval
+// by Run
+func WithKillFile(path string) Option {
+	return func(m *Manager) {
+		m.killFile = path
+	}
+}
+
+// WithKillSignal kills on any of signals once Run started
+func WithKillSignal(signals ...os.Signal) Option {
+	return func(m *Manager) {
+		m.killSignals = append(m.killSignals, signals...)
+	}
+}
+
+// WithHTTP serves Handler on addr once Run started
+func WithHTTP(addr string) Option {
+	return func(m *Manager) {
+		m.httpAddr = addr
+	}
+}
+
+// Manager sits between the strategies and the venues wrapped by Wrap. It tracks the
+// orders and the positions sent through them and rejects the orders breaking a limit
+// with a market.OrderStatusRejected order, logged and counted by reason. The reduce only
+// orders are only rejected by the kill switch so a strategy can always unwind. It is
+// safe for concurrent use, mu is held while an order is sent so its updates wait until
+// it is tracked
+type Manager struct {
+	limits      Limits
+	mu          sync.Mutex
+	now         func() int64
+	venues      map[string]*Venue
+	symbols     map[string]*symbol
+	orders      map[string]*order
+	sent        []int64
+	day         int64
+	dayStartPnL decimal.Decimal
+	fees        decimal.Decimal
+	rejections  map[string]int64
+	killed      bool
+	killReason  string
+	killFile    string
+	killSignals []os.Signal
+	httpAddr    string
+	server      *http.Server
+	// wg waits for the watchers and the server started by Run
+	wg sync.WaitGroup
+}
+
+// symbol is the position of an exchange/symbol, in the venue's own unit, and its prices
+type symbol struct {
+	exchange      string
+	name          string
+	position      decimal.Decimal
+	entryPrice    decimal.Decimal
+	realizedPnL   decimal.Decimal
+	contractValue decimal.Decimal
+	mark          decimal.Decimal
+	bid           decimal.Decimal
+	ask           decimal.Decimal
+}
+
+// order is an order sent through a Venue, done once final. The final orders are kept
+// a while since a venue may return an order after its last update
+type order struct {
+	venue      *Venue
+	symbol     string
+	side       string
+	quantity   decimal.Decimal
+	filled     decimal.Decimal
+	avgPrice   decimal.Decimal
+	fee        decimal.Decimal
+	reduceOnly bool
+	kill       bool
+	done       bool
+	updated    int64
+}
+
+func NewManager(limits Limits, opts ...Option) *Manager {
+	m := &Manager{
+		limits:     limits,
+		now:        func() int64 { return time.Now().UnixMilli() },
+		venues:     make(map[string]*Venue),
+		symbols:    make(map[string]*symbol),
+		orders:     make(map[string]*order),
+		rejections: make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Wrap returns venue behind the checks of m, the venue of an exchange wrapped before is
+// replaced for the kill switch
+func (m *Manager) Wrap(venue market.ExecutionVenue) *Venue {
+	v := &Venue{manager: m, venue: venue}
+	m.mu.Lock()
+	m.venues[venue.Exchange()] = v
+	m.mu.Unlock()
+	// registered before the handlers of the strategy, which see the positions updated
+	venue.OnOrderUpdate(m.onOrderUpdate)
+	return v
+}
+
+// Killed reports whether the kill switch was triggered
+func (m *Manager) Killed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.killed
+}
+
+// Kill rejects every later order, cancels the open orders and flattens the positions
+// of every venue with reduce only market orders, as reported by the venue when it is a
+// market.PositionSource. It may be called again to retry the
+// cancels and flatten orders that failed, the errors are joined
+func (m *Manager) Kill(ctx context.Context, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.killed {
+		m.killed = true
+		m.killReason = reason
+		common.Logger.Sugar().Errorf("Risk kill switch triggered: %s", reason)
+	}
+	var errs []error
+	for _, key := range sortedKeys(m.orders) {
+		o := m.orders[key]
+		if o.done || o.kill {
+			continue
+		}
+		id := key[len(o.venue.Exchange())+1:]
+		canceled, err := o.venue.venue.CancelOrder(ctx, o.symbol, id)
+		if err != nil {
+			errs = append(errs, err)
+			common.Logger.Sugar().Errorf("Risk Kill %s %s CancelOrder %s error: %v", o.venue.Exchange(), o.symbol, id, err)
+			continue
+		}
+		if canceled != nil {
+			m.track(o.venue, canceled, false)
+		}
+	}
+	flattening := make(map[string]bool)
+	for _, o := range m.orders {
+		if o.kill && !o.done {
+			flattening[o.venue.Exchange()+"/"+o.symbol] = true
+		}
+	}
+	for _, key := range sortedKeys(m.positions(ctx, &errs)) {
+		s := m.symbols[key]
+		v, ok := m.venues[s.exchange]
+		if !ok || s.position.IsZero() || flattening[key] {
+			continue
+		}
+		side := market.SideSell
+		if s.position.IsNegative() {
+			side = market.SideBuy
+		}
+		request := &market.OrderRequest{Symbol: s.name, Side: side, Type: market.OrderTypeMarket, Quantity: s.position.Abs(), ReduceOnly: true}
+		placed, err := v.venue.PlaceOrder(ctx, request)
+		if err != nil {
+			errs = append(errs, err)
+			common.Logger.Sugar().Errorf("Risk Kill %s %s flatten %s error: %v", s.exchange, s.name, s.position, err)
+			continue
+		}
+		if placed.Status == market.OrderStatusRejected {
+			errs = append(errs, fmt.Errorf("Risk Kill %s %s flatten rejected: %s", s.exchange, s.name, placed.Reason))
+			common.Logger.Sugar().Errorf("Risk Kill %s %s flatten %s rejected: %s", s.exchange, s.name, s.position, placed.Reason)
+			continue
+		}
+		common.Logger.Sugar().Warnf("Risk Kill %s %s flatten %s %s", s.exchange, s.name, side, s.position.Abs())
+		m.track(v, placed, true)
+	}
+	return errors.Join(errs...)
+}
+
+// positions must be called with mu held, it returns the symbols to flatten. The venues
+// implementing market.PositionSource are queried so the positions opened outside of the
+// manager are flattened too, the tracked position of a symbol is replaced by the one of
+// its venue. The tracked positions of a venue that can't be queried are used instead
+func (m *Manager) positions(ctx context.Context, errs *[]error) map[string]*symbol {
+	positions := make(map[string]*symbol)
+	queried := make(map[string]bool)
+	for _, exchange := range sortedKeys(m.venues) {
+		source, ok := m.venues[exchange].venue.(market.PositionSource)
+		if !ok {
+			continue
+		}
+		venuePositions, err := source.GetPositions(ctx)
+		if err != nil {
+			*errs = append(*errs, err)
+			common.Logger.Sugar().Errorf("Risk Kill %s GetPositions error: %v", exchange, err)
+			continue
+		}
+		queried[exchange] = true
+		for _, position := range venuePositions {
+			s := m.symbol(exchange, position.Symbol)
+			if !s.position.Equal(position.Quantity) {
+				common.Logger.Sugar().Warnf("Risk Kill %s %s position %s tracked %s", exchange, position.Symbol, position.Quantity, s.position)
+				s.position = position.Quantity
+				s.entryPrice = position.EntryPrice
+			}
+			positions[exchange+"/"+position.Symbol] = s
+		}
+	}
+	for key, s := range m.symbols {
+		if !queried[s.exchange] {
+			positions[key] = s
+		}
+	}
+	return positions
+}
+
+// Stats is a snapshot of the state of the manager
+type Stats struct {
+	Killed     bool             `json:"killed"`
+	KillReason string           `json:"kill_reason,omitempty"`
+	OpenOrders int              `json:"open_orders"`
+	DailyPnL   decimal.Decimal  `json:"daily_pnl"`
+	Fees       decimal.Decimal  `json:"fees"`
+	Rejections map[string]int64 `json:"rejections"`
+	Positions  []Position       `json:"positions"`
+}
+
+// Position is signed in the venue's own unit, Notional is in the quote currency at the
+// mark price
+type Position struct {
+	Exchange string          `json:"exchange"`
+	Symbol   string          `json:"symbol"`
+	Quantity decimal.Decimal `json:"quantity"`
+	Notional decimal.Decimal `json:"notional"`
+}
+
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollDay()
+	stats := Stats{
+		Killed:     m.killed,
+		KillReason: m.killReason,
+		OpenOrders: m.openOrders(),
+		DailyPnL:   m.pnl().Sub(m.dayStartPnL),
+		Fees:       m.fees,
+		Rejections: make(map[string]int64, len(m.rejections)),
+		Positions:  []Position{},
+	}
+	for reason, count := range m.rejections {
+		stats.Rejections[reason] = count
+	}
+	for _, key := range sortedKeys(m.symbols) {
+		s := m.symbols[key]
+		if s.position.IsZero() {
+			continue
+		}
+		stats.Positions = append(stats.Positions, Position{
+			Exchange: s.exchange,
+			Symbol:   s.name,
+			Quantity: s.position,
+			Notional: s.position.Abs().Mul(s.contractValue).Mul(s.price()),
+		})
+	}
+	return stats
+}
+
+// check must be called with mu held, it returns why request is rejected or ""
+func (m *Manager) check(exchange string, request *market.OrderRequest) string {
+	if m.killed {
+		return RejectKillSwitch
+	}
+	if request.ReduceOnly {
+		return ""
+	}
+	now := m.now()
+	sent := m.sent[:0]
+	for _, at := range m.sent {
+		if now-at < 1000 {
+			sent = append(sent, at)
+		}
+	}
+	m.sent = sent
+	if m.limits.MaxOrderRate > 0 && len(m.sent) >= m.limits.MaxOrderRate {
+		return RejectOrderRate
+	}
+	if m.limits.MaxOpenOrders > 0 && m.openOrders() >= m.limits.MaxOpenOrders {
+		return RejectOpenOrders
+	}
+	if m.limits.MaxDailyLoss.IsPositive() {
+		m.rollDay()
+		if m.dayStartPnL.Sub(m.pnl()).GreaterThanOrEqual(m.limits.MaxDailyLoss) {
+			return RejectDailyLoss
+		}
+	}
+	s := m.symbol(exchange, request.Symbol)
+	checkNotional := m.limits.MaxSymbolNotional.IsPositive() || m.limits.MaxTotalNotional.IsPositive()
+	if (checkNotional || m.limits.PriceBand.IsPositive()) && !s.mark.IsPositive() {
+		return RejectNoPrice
+	}
+	if checkNotional {
+		signed := request.Quantity
+		if request.Side == market.SideSell {
+			signed = signed.Neg()
+		}
+		exposure := m.exposure(s, signed)
+		if m.limits.MaxSymbolNotional.IsPositive() && exposure.GreaterThan(m.limits.MaxSymbolNotional) {
+			return RejectSymbolNotional
+		}
+		total := exposure
+		for _, other := range m.symbols {
+			if other != s {
+				total = total.Add(m.exposure(other, decimal.Zero))
+			}
+		}
+		if m.limits.MaxTotalNotional.IsPositive() && total.GreaterThan(m.limits.MaxTotalNotional) {
+			return RejectTotalNotional
+		}
+	}
+	if m.limits.PriceBand.IsPositive() {
+		price := request.Price
+		if request.Type == market.OrderTypeMarket {
+			price = s.ask
+			if request.Side == market.SideSell {
+				price = s.bid
+			}
+		}
+		if price.IsPositive() && price.Sub(s.mark).Abs().Div(s.mark).GreaterThan(m.limits.PriceBand) {
+			return RejectPriceBand
+		}
+	}
+	return ""
+}
+
+// exposure must be called with mu held, it is the notional of the position of s plus its
+// open orders and signed
+func (m *Manager) exposure(s *symbol, signed decimal.Decimal) decimal.Decimal {
+	quantity := s.position.Add(signed)
+	for _, o := range m.orders {
+		if o.done || o.symbol != s.name || o.venue.Exchange() != s.exchange {
+			continue
+		}
+		remaining := o.quantity.Sub(o.filled)
+		if o.side == market.SideSell {
+			remaining = remaining.Neg()
+		}
+		quantity = quantity.Add(remaining)
+	}
+	return quantity.Abs().Mul(s.contractValue).Mul(s.price())
+}
+
+// reject must be called with mu held
+func (m *Manager) reject(exchange string, request *market.OrderRequest, reason string) *market.Order {
+	m.rejections[reason]++
+	common.Logger.Sugar().Warnf("Risk %s %s %s %s rejected: %s", exchange, request.Symbol, request.Side, request.Quantity, reason)
+	return market.NewRejectedOrder(exchange, request, "risk: "+reason, m.now())
+}
+
+// openOrders must be called with mu held
+func (m *Manager) openOrders() int {
+	count := 0
+	for _, o := range m.orders {
+		if !o.done {
+			count++
+		}
+	}
+	return count
+}
+
+// symbol must be called with mu held
+func (m *Manager) symbol(exchange string, name string) *symbol {
+	key := exchange + "/" + name
+	s, ok := m.symbols[key]
+	if !ok {
+		s = &symbol{exchange: exchange, name: name, contractValue: decimal.NewFromInt(1)}
+		m.symbols[key] = s
+	}
+	return s
+}
+
+// onOrderUpdate tracks the updates of every order of the venues, the kill orders included
+func (m *Manager) onOrderUpdate(update *market.Order) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.venues[update.Exchange]
+	if !ok {
+		return
+	}
+	m.track(v, update, false)
+}
+
+// track must be called with mu held, it adds the new fills of update to the position of
+// its symbol. The updates may come out of order with the snapshots returned by the venue
+// so the filled quantity only grows and a final order is never reopened
+func (m *Manager) track(v *Venue, update *market.Order, kill bool) {
+	if update.ID == "" {
+		return
+	}
+	now := m.now()
+	key := update.Exchange + "/" + update.ID
+	o, ok := m.orders[key]
+	if !ok {
+		o = &order{
+			venue:      v,
+			symbol:     update.Symbol,
+			side:       update.Side,
+			quantity:   update.Quantity,
+			filled:     decimal.Zero,
+			avgPrice:   decimal.Zero,
+			fee:        decimal.Zero,
+			reduceOnly: update.ReduceOnly,
+			kill:       kill,
+		}
+		m.orders[key] = o
+	}
+	o.updated = now
+	if update.FilledQuantity.GreaterThan(o.filled) {
+		delta := update.FilledQuantity.Sub(o.filled)
+		// the price of the new fills from the change of the average price
+		price := update.AvgPrice.Mul(update.FilledQuantity).Sub(o.avgPrice.Mul(o.filled)).Div(delta)
+		if update.Side == market.SideSell {
+			delta = delta.Neg()
+		}
+		m.symbol(update.Exchange, update.Symbol).apply(delta, price)
+		o.filled = update.FilledQuantity
+		o.avgPrice = update.AvgPrice
+	}
+	if update.Fee.GreaterThan(o.fee) {
+		m.fees = m.fees.Add(update.Fee.Sub(o.fee))
+		o.fee = update.Fee
+	}
+	if update.Status.Final() {
+		o.done = true
+	}
+	for key, o := range m.orders {
+		if o.done && now-o.updated > time.Minute.Milliseconds() {
+			delete(m.orders, key)
+		}
+	}
+}
+
+// apply adds a signed fill at price, a fill crossing zero opens the rest at price
+func (s *symbol) apply(signed decimal.Decimal, price decimal.Decimal) {
+	if s.position.IsZero() || s.position.Sign() == signed.Sign() {
+		total := s.position.Add(signed)
+		s.entryPrice = s.entryPrice.Mul(s.position.Abs()).Add(price.Mul(signed.Abs())).Div(total.Abs())
+		s.position = total
+		return
+	}
+	closed := decimal.Min(signed.Abs(), s.position.Abs())
+	realized := closed.Mul(s.contractValue).Mul(price.Sub(s.entryPrice))
+	if s.position.IsNegative() {
+		realized = realized.Neg()
+	}
+	s.realizedPnL = s.realizedPnL.Add(realized)
+	s.position = s.position.Add(signed)
+	switch {
+	case s.position.IsZero():
+		s.entryPrice = decimal.Zero
+	case s.position.Sign() == signed.Sign():
+		s.entryPrice = price
+	}
+}
+
+// price is the mark price or else the book mid
+func (s *symbol) price() decimal.Decimal {
+	if s.mark.IsPositive() || !s.bid.IsPositive() || !s.ask.IsPositive() {
+		return s.mark
+	}
+	return s.bid.Add(s.ask).Div(decimal.NewFromInt(2))
+}
+
+// pnl must be called with mu held, it is the realized and unrealized PnL of every symbol
+// minus the fees
+func (m *Manager) pnl() decimal.Decimal {
+	pnl := m.fees.Neg()
+	for _, s := range m.symbols {
+		pnl = pnl.Add(s.realizedPnL)
+		if price := s.price(); !s.position.IsZero() && price.IsPositive() {
+			pnl = pnl.Add(s.position.Mul(s.contractValue).Mul(price.Sub(s.entryPrice)))
+		}
+	}
+	return pnl
+}
+
+// rollDay must be called with mu held, the daily loss is measured from the PnL at the
+// first check of the UTC day
+func (m *Manager) rollDay() {
+	now := m.now()
+	if start := now - now%day; start != m.day {
+		m.day = start
+		m.dayStartPnL = m.pnl()
+	}
+}
+
+// onTick keeps the mark prices and the best prices of the symbols
+func (m *Manager) onTick(tick *market.Tick) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.symbol(tick.Exchange, tick.Symbol)
+	switch tick.Type {
+	case market.TickTypeMarkPrice:
+		s.mark = tick.Price
+	case market.TickTypeBookTicker:
+		s.bid, s.ask = tick.BidPrice, tick.AskPrice
+	}
+}
+
+func (m *Manager) setContractValue(exchange string, name string, value decimal.Decimal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.symbol(exchange, name).contractValue = value
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
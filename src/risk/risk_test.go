@@ -0,0 +1,219 @@
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trade/src/market"
+	"trade/src/paper"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var d = decimal.RequireFromString
+
+func TestRisk(t *testing.T) {
+	t.Run("Limits", func(t *testing.T) {
+		testLimits(t)
+	})
+	t.Run("Kill", func(t *testing.T) {
+		testKill(t)
+	})
+	t.Run("KillSwitches", func(t *testing.T) {
+		testKillSwitches(t)
+	})
+}
+
+func mark(exchange string, symbol string, price string) *market.Tick {
+	return &market.Tick{Exchange: exchange, Symbol: symbol, Type: market.TickTypeMarkPrice, Price: d(price)}
+}
+
+func book(exchange string, symbol string, bid string, ask string) *market.Tick {
+	return market.NewBookTick(exchange, symbol, d(bid), d("100"), d(ask), d("100"), 0, 0)
+}
+
+func buy(symbol string, quantity string) *market.OrderRequest {
+	return &market.OrderRequest{Symbol: symbol, Side: market.SideBuy, Type: market.OrderTypeMarket, Quantity: d(quantity)}
+}
+
+func testLimits(t *testing.T) {
+	ctx := context.Background()
+	now := int64(1_700_000_000_000)
+	m := NewManager(Limits{
+		MaxSymbolNotional: d("1000"),
+		MaxTotalNotional:  d("1500"),
+		MaxOpenOrders:     2,
+		MaxDailyLoss:      d("5"),
+		MaxOrderRate:      3,
+		PriceBand:         d("0.01"),
+	}, WithClock(func() int64 { return now }))
+	okx := m.Wrap(paper.NewVenue(market.ExchangeOKX, paper.WithLatency(0), paper.WithFees(decimal.Zero, d("0.001"))))
+	binance := m.Wrap(paper.NewVenue(market.ExchangeBinance, paper.WithLatency(0), paper.WithFees(decimal.Zero, decimal.Zero)))
+	updates := []*market.Order{}
+	okx.OnOrderUpdate(func(order *market.Order) {
+		updates = append(updates, order)
+	})
+	place := func(venue *Venue, request *market.OrderRequest) *market.Order {
+		order, err := venue.PlaceOrder(ctx, request)
+		require.NoError(t, err)
+		return order
+	}
+
+	order := place(okx, buy("BTC-USDT-SWAP", "1"))
+	assert.Equal(t, market.OrderStatusRejected, order.Status)
+	assert.Equal(t, "risk: "+RejectNoPrice, order.Reason)
+	assert.Empty(t, order.ID)
+
+	okx.OnTick(mark(market.ExchangeOKX, "BTC-USDT-SWAP", "100"))
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99.9", "100.1"))
+	assert.Equal(t, "risk: "+RejectSymbolNotional, place(okx, buy("BTC-USDT-SWAP", "11")).Reason)
+	assert.Equal(t, "risk: "+RejectPriceBand, place(okx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeLimit, Price: d("98"), Quantity: d("1")}).Reason)
+	assert.Equal(t, market.OrderStatusNew, place(okx, buy("BTC-USDT-SWAP", "5")).Status)
+	// the open order counts in the notional of the symbol
+	assert.Equal(t, "risk: "+RejectSymbolNotional, place(okx, buy("BTC-USDT-SWAP", "5.1")).Reason)
+	assert.Equal(t, market.OrderStatusNew, place(okx, buy("BTC-USDT-SWAP", "5")).Status)
+	assert.Equal(t, "risk: "+RejectOpenOrders, place(okx, buy("BTC-USDT-SWAP", "0.1")).Reason)
+
+	// both orders fill at 100.1, the updates reach the strategy after the manager
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99.9", "100.1"))
+	require.Len(t, updates, 2)
+	stats := m.Stats()
+	assert.Zero(t, stats.OpenOrders)
+	require.Len(t, stats.Positions, 1)
+	assert.True(t, stats.Positions[0].Quantity.Equal(d("10")))
+	assert.True(t, stats.Positions[0].Notional.Equal(d("1000")))
+	assert.True(t, stats.Fees.Equal(d("1.001")), stats.Fees.String())
+
+	binance.OnTick(mark(market.ExchangeBinance, "BTCUSDT", "100"))
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99.9", "100.1"))
+	assert.Equal(t, "risk: "+RejectTotalNotional, place(binance, buy("BTCUSDT", "6")).Reason)
+	assert.Equal(t, market.OrderStatusNew, place(binance, buy("BTCUSDT", "1")).Status)
+	// 3 orders sent within a second
+	assert.Equal(t, "risk: "+RejectOrderRate, place(binance, buy("BTCUSDT", "1")).Reason)
+	now += 1000
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99.9", "100.1"))
+	assert.Equal(t, market.OrderStatusNew, place(binance, buy("BTCUSDT", "1")).Status)
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99.9", "100.1"))
+
+	// (99.5 - 100.1) * 10 and the fees lose more than 5 since the start of the day
+	okx.OnTick(mark(market.ExchangeOKX, "BTC-USDT-SWAP", "99.5"))
+	assert.Equal(t, "risk: "+RejectDailyLoss, place(binance, buy("BTCUSDT", "1")).Reason)
+	// a reduce only order always passes
+	sell := &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("4"), ReduceOnly: true}
+	assert.Equal(t, market.OrderStatusNew, place(okx, sell).Status)
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99.4", "99.6"))
+	stats = m.Stats()
+	assert.True(t, stats.DailyPnL.LessThan(d("-5")), stats.DailyPnL.String())
+	require.Len(t, stats.Positions, 2)
+	assert.True(t, stats.Positions[1].Quantity.Equal(d("6")), stats.Positions[1].Quantity.String())
+
+	// the loss of the previous day doesn't count
+	now += day
+	assert.Equal(t, market.OrderStatusNew, place(binance, buy("BTCUSDT", "1")).Status)
+
+	assert.Equal(t, map[string]int64{
+		RejectNoPrice:        1,
+		RejectSymbolNotional: 2,
+		RejectPriceBand:      1,
+		RejectOpenOrders:     1,
+		RejectTotalNotional:  1,
+		RejectOrderRate:      1,
+		RejectDailyLoss:      1,
+	}, m.Stats().Rejections)
+}
+
+func testKill(t *testing.T) {
+	ctx := context.Background()
+	okxVenue := paper.NewVenue(market.ExchangeOKX, paper.WithLatency(0))
+	binanceVenue := paper.NewVenue(market.ExchangeBinance, paper.WithLatency(0))
+	// a position opened before the manager is only known to the venue
+	okxVenue.OnTick(book(market.ExchangeOKX, "ETH-USDT-SWAP", "9", "11"))
+	_, err := okxVenue.PlaceOrder(ctx, &market.OrderRequest{Symbol: "ETH-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("4")})
+	require.NoError(t, err)
+	okxVenue.OnTick(book(market.ExchangeOKX, "ETH-USDT-SWAP", "9", "11"))
+	m := NewManager(Limits{})
+	okx, binance := m.Wrap(okxVenue), m.Wrap(binanceVenue)
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99", "101"))
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99", "101"))
+	_, err = okx.PlaceOrder(ctx, buy("BTC-USDT-SWAP", "2"))
+	require.NoError(t, err)
+	_, err = binance.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTCUSDT", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("3")})
+	require.NoError(t, err)
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99", "101"))
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99", "101"))
+	resting, err := okx.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideBuy, Type: market.OrderTypeLimit, Price: d("90"), Quantity: d("1")})
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.Stats().OpenOrders)
+
+	require.NoError(t, m.Kill(ctx, "test"))
+	assert.True(t, m.Killed())
+	stats := m.Stats()
+	assert.Equal(t, "test", stats.KillReason)
+	// the resting order is canceled and the flatten orders are pending
+	assert.Equal(t, 3, stats.OpenOrders)
+	_, err = okxVenue.CancelOrder(ctx, "BTC-USDT-SWAP", resting.ID)
+	assert.Error(t, err, "already canceled")
+	// a second kill doesn't send the flatten orders again
+	require.NoError(t, m.Kill(ctx, "again"))
+	assert.Equal(t, 3, m.Stats().OpenOrders)
+	assert.Equal(t, "test", m.Stats().KillReason)
+
+	okx.OnTick(book(market.ExchangeOKX, "BTC-USDT-SWAP", "99", "101"))
+	okx.OnTick(book(market.ExchangeOKX, "ETH-USDT-SWAP", "9", "11"))
+	binance.OnTick(book(market.ExchangeBinance, "BTCUSDT", "99", "101"))
+	assert.True(t, okxVenue.Position("BTC-USDT-SWAP").Quantity.IsZero())
+	assert.True(t, okxVenue.Position("ETH-USDT-SWAP").Quantity.IsZero())
+	assert.True(t, binanceVenue.Position("BTCUSDT").Quantity.IsZero())
+	stats = m.Stats()
+	assert.Empty(t, stats.Positions)
+	assert.Zero(t, stats.OpenOrders)
+
+	order, err := okx.PlaceOrder(ctx, &market.OrderRequest{Symbol: "BTC-USDT-SWAP", Side: market.SideSell, Type: market.OrderTypeMarket, Quantity: d("1"), ReduceOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, "risk: "+RejectKillSwitch, order.Reason)
+	assert.Equal(t, int64(1), m.Stats().Rejections[RejectKillSwitch])
+}
+
+func testKillSwitches(t *testing.T) {
+	m := NewManager(Limits{})
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	stats := func(resp *http.Response) Stats {
+		defer resp.Body.Close()
+		var stats Stats
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		return stats
+	}
+	resp, err := http.Get(server.URL + "/status")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, stats(resp).Killed)
+	resp, err = http.Get(server.URL + "/kill")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, err = http.Post(server.URL+"/kill?reason=test", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	killed := stats(resp)
+	assert.True(t, killed.Killed)
+	assert.Equal(t, "http test", killed.KillReason)
+
+	path := filepath.Join(t.TempDir(), "kill")
+	m = NewManager(Limits{}, WithKillFile(path))
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, m.Run(ctx))
+	assert.False(t, m.Killed())
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	assert.Eventually(t, m.Killed, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, m.Close(context.Background()))
+	assert.Equal(t, "file "+path, m.Stats().KillReason)
+}
@@ -0,0 +1,94 @@
+package risk
+
+import (
+	"context"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+)
+
+// Venue is an execution venue behind the checks of its Manager. It forwards the ticks,
+// the contract values and InitTrading to the wrapped venue when it implements them so
+// the strategies drive it as they would drive the venue itself
+type Venue struct {
+	manager *Manager
+	venue   market.ExecutionVenue
+}
+
+func (v *Venue) Exchange() string {
+	return v.venue.Exchange()
+}
+
+// Unwrap returns the wrapped venue
+func (v *Venue) Unwrap() market.ExecutionVenue {
+	return v.venue
+}
+
+// Killed reports whether the kill switch of the manager was triggered
+func (v *Venue) Killed() bool {
+	return v.manager.Killed()
+}
+
+// PlaceOrder rejects request when it breaks a limit of the manager and otherwise sends it
+// to the wrapped venue, the orders of every venue wait for each other so two orders
+// can't pass the same limit together
+func (v *Venue) PlaceOrder(ctx context.Context, request *market.OrderRequest) (*market.Order, error) {
+	m := v.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reason := m.check(v.Exchange(), request); reason != "" {
+		return m.reject(v.Exchange(), request, reason), nil
+	}
+	m.sent = append(m.sent, m.now())
+	order, err := v.venue.PlaceOrder(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	m.track(v, order, false)
+	return order, nil
+}
+
+// CancelOrder is never checked, it only lowers the risk
+func (v *Venue) CancelOrder(ctx context.Context, symbol string, orderID string) (*market.Order, error) {
+	order, err := v.venue.CancelOrder(ctx, symbol, orderID)
+	if err != nil || order == nil {
+		return order, err
+	}
+	v.manager.mu.Lock()
+	defer v.manager.mu.Unlock()
+	v.manager.track(v, order, false)
+	return order, nil
+}
+
+// OnOrderUpdate handlers see the updates after the manager tracked them
+func (v *Venue) OnOrderUpdate(handler func(*market.Order)) {
+	v.venue.OnOrderUpdate(handler)
+}
+
+// OnTick keeps the mark and best prices of the manager, then forwards tick to the
+// wrapped venue when it simulates the matching
+func (v *Venue) OnTick(tick *market.Tick) {
+	v.manager.onTick(tick)
+	if consumer, ok := v.venue.(interface{ OnTick(*market.Tick) }); ok {
+		consumer.OnTick(tick)
+	}
+}
+
+// SetContractValue converts the positions of symbol to base quantities for the
+// notional limits
+func (v *Venue) SetContractValue(symbol string, value decimal.Decimal) {
+	v.manager.setContractValue(v.Exchange(), symbol, value)
+	if venue, ok := v.venue.(interface {
+		SetContractValue(string, decimal.Decimal)
+	}); ok {
+		venue.SetContractValue(symbol, value)
+	}
+}
+
+// InitTrading initializes the wrapped venue when it is a live client
+func (v *Venue) InitTrading(ctx context.Context) error {
+	if trading, ok := v.venue.(interface{ InitTrading(context.Context) error }); ok {
+		return trading.InitTrading(ctx)
+	}
+	return nil
+}
@@ -2,152 +2,809 @@ package strategy
 
 import (
 	"context"
-	"strconv"
-	"strings"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 	"trade/src/common"
 	"trade/src/exchange/binance"
 	"trade/src/exchange/okx"
+	"trade/src/market"
+	"trade/src/risk"
 
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
+// Pair prices are written by the client reader goroutines and read by RunPair, mu guards
+// both and the tick trigger state
 type Pair struct {
-	BinancePrice *ExchangePrice
-	OKXPrice     *ExchangePrice
+	mu sync.Mutex
+	A  *ExchangePrice
+	B  *ExchangePrice
+	// updated wakes RunPair in PriceGapTriggerTick, trigger is the last tick since the
+	// previous sample and triggers how many ticks it coalesces
+	updated  chan struct{}
+	trigger  *market.Tick
+	triggers int
 }
 
 type ExchangePrice struct {
 	Symbol    string
 	MarkPrice decimal.Decimal
 	Time      int64
+	// LocalTime is when the mark price was received, LocalTime - Time is the latency
+	LocalTime int64
+	// BidPrice, AskPrice and BookTime are only updated in PriceGapModeExecutable
+	BidPrice decimal.Decimal
+	AskPrice decimal.Decimal
+	BookTime int64
+	// Funding is only updated with WithFunding
+	Funding *market.Funding
 }
 
+// PriceGap logs the mark price gap of the same instrument on two venues, sourceA is
+// the venue the gap is measured from
 type PriceGap struct {
-	bCli     *binance.Client
-	oCli     *okx.Client
-	chartLog *zap.Logger
-	pairs    []*Pair
+	sourceA market.MarketDataSource
+	sourceB market.MarketDataSource
+	// exchangeA and exchangeB name the sources in the samples, now is the sample clock
+	// in milliseconds which a backtest replaces with the replay time
+	exchangeA     string
+	exchangeB     string
+	now           func() int64
+	chartLog      *zap.Logger
+	chartAge      time.Duration
+	checkInterval time.Duration
+	startInterval time.Duration
+	mode          string
+	trigger       string
+	coalesce      time.Duration
+	// maxStaleness is keyed by exchange, maxSkew bounds the difference of the exchange
+	// times of both legs and staleAction is applied to the samples failing either
+	maxStaleness map[string]time.Duration
+	maxSkew      time.Duration
+	staleAction  string
+	counters     priceGapCounters
+	supervisor   *common.Supervisor
+	pairs        []*Pair
+	// flow aggregates the trades of both legs when WithTradeFlow is set
+	flow       *market.VolumeAggregator
+	flowWindow time.Duration
+	// fundingLog is the chart log of the funding differential when WithFunding is set
+	fundingLog      *zap.Logger
+	fundingInterval time.Duration
+	// trader hedges the pairs on the venues of WithTrading
+	trading *PriceGapTrading
+	trader  *priceGapTrader
+	// risk checks the orders of the trader when WithRisk is set
+	risk *risk.Manager
+	// registry is loaded once for discovery and the contract values of flow and trader
+	registry *market.Registry
+	// initialized marks the sources whose connection is up so a restarted Run skips them
+	initialized map[market.MarketDataSource]bool
+	mu          sync.Mutex
+	dones       []<-chan struct{}
 }
 
+const (
+	DefaultPriceGapCheckInterval = time.Second
+	// DefaultPriceGapStartInterval staggers the subscriptions of the pairs, Binance
+	// accepts 10 incoming messages per second on a stream connection
+	DefaultPriceGapStartInterval = 500 * time.Millisecond
+	DefaultPriceGapChartAge      = 7 * 24 * time.Hour
+	// DefaultPriceGapMaxStaleness applies to the venues without WithMaxStaleness, OKX
+	// pushes an unchanged mark price every 10 seconds and Binance every 3 seconds
+	DefaultPriceGapMaxStaleness = 30 * time.Second
+)
+
+const (
+	// PriceGapModeMarkPrice logs the mark price ratio only
+	PriceGapModeMarkPrice = "mark_price"
+	// PriceGapModeExecutable also subscribes the book tickers and logs the executable
+	// cross spreads next to the mark price ratio
+	PriceGapModeExecutable = "executable"
+)
+
+const (
+	// PriceGapStaleFlag logs the stale samples with the reasons in the stale field
+	PriceGapStaleFlag = "flag"
+	// PriceGapStaleSuppress drops the stale samples, they are only counted
+	PriceGapStaleSuppress = "suppress"
+)
+
+// Reasons of a stale sample, a sample may have several
+const (
+	PriceGapStaleA    = "stale_a"
+	PriceGapStaleB    = "stale_b"
+	PriceGapStaleSkew = "skew"
+)
+
+// PriceGapStats counts the gap samples since NewPriceGap, a stale sample is counted
+// once per reason and once as flagged or suppressed
+type PriceGapStats struct {
+	Samples    int64
+	StaleA     int64
+	StaleB     int64
+	Skewed     int64
+	Flagged    int64
+	Suppressed int64
+}
+
+type priceGapCounters struct {
+	samples    atomic.Int64
+	staleA     atomic.Int64
+	staleB     atomic.Int64
+	skewed     atomic.Int64
+	flagged    atomic.Int64
+	suppressed atomic.Int64
+}
+
+const (
+	// PriceGapTriggerPeriodic samples every pair each check interval
+	PriceGapTriggerPeriodic = "periodic"
+	// PriceGapTriggerTick samples a pair on every mark price, and book ticker in
+	// PriceGapModeExecutable, of either leg
+	PriceGapTriggerTick = "tick"
+)
+
+type PriceGapOption func(*PriceGap)
+
+// WithMode selects PriceGapModeMarkPrice (the default) or PriceGapModeExecutable
+func WithMode(mode string) PriceGapOption {
+	return func(p *PriceGap) {
+		p.mode = mode
+	}
+}
+
+// WithCheckInterval overrides DefaultPriceGapCheckInterval, how often the gap of each pair is logged
+func WithCheckInterval(interval time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.checkInterval = interval
+	}
+}
+
+// WithTrigger selects PriceGapTriggerPeriodic (the default) or PriceGapTriggerTick
+func WithTrigger(trigger string) PriceGapOption {
+	return func(p *PriceGap) {
+		p.trigger = trigger
+	}
+}
+
+// WithCoalesce throttles PriceGapTriggerTick to one sample per window per pair, the
+// ticks received in between are coalesced into the sample at the end of the window.
+// 0 (the default) samples every tick
+func WithCoalesce(window time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.coalesce = window
+	}
+}
+
+// WithStartInterval overrides DefaultPriceGapStartInterval
+func WithStartInterval(interval time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.startInterval = interval
+	}
+}
+
+// WithChartAge overrides DefaultPriceGapChartAge, how long the chart log is retained
+func WithChartAge(age time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.chartAge = age
+	}
+}
+
+// WithTradeFlow subscribes the trades of both legs and appends the trade count, quote
+// notional and taker imbalance of each venue over window to every gap line, 0 disables it
+func WithTradeFlow(window time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.flowWindow = window
+	}
+}
+
+// WithFunding subscribes the funding of both legs and logs their differential to the
+// price_gap_funding chart log every interval, 0 disables it. Both sources must implement
+// market.FundingSource
+func WithFunding(interval time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.fundingInterval = interval
+	}
+}
+
+// WithMaxStaleness overrides DefaultPriceGapMaxStaleness for exchange, a sample whose
+// mark price or book ticker on that venue is older is stale, 0 disables the check
+func WithMaxStaleness(exchange string, age time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.maxStaleness[exchange] = age
+	}
+}
+
+// WithMaxSkew makes a sample stale when the exchange times of both mark prices differ
+// by more than skew, 0 (the default) disables it
+func WithMaxSkew(skew time.Duration) PriceGapOption {
+	return func(p *PriceGap) {
+		p.maxSkew = skew
+	}
+}
+
+// WithStaleAction selects PriceGapStaleFlag (the default) or PriceGapStaleSuppress
+func WithStaleAction(action string) PriceGapOption {
+	return func(p *PriceGap) {
+		p.staleAction = action
+	}
+}
+
+// WithSupervisor runs the pairs under the given supervisor instead of a private one
+func WithSupervisor(supervisor *common.Supervisor) PriceGapOption {
+	return func(p *PriceGap) {
+		p.supervisor = supervisor
+	}
+}
+
+// WithClock overrides the wall clock of the records and of the staleness checks, e.g.
+// with common.Replay.Now to re-run the strategy on recorded market data
+func WithClock(now func() int64) PriceGapOption {
+	return func(p *PriceGap) {
+		p.now = now
+	}
+}
+
+// NewPriceGap compares every perpetual listed on both Binance and OKX
 func NewPriceGap() *PriceGap {
-	return &PriceGap{
-		bCli:     binance.NewClient(),
-		oCli:     okx.NewClient(),
-		chartLog: common.NewChart("price_gap", time.Hour*24*7),
-		pairs: []*Pair{
-			{
-				BinancePrice: &ExchangePrice{Symbol: "BTCUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "BTC-USDT-SWAP"},
-			},
-			{
-				BinancePrice: &ExchangePrice{Symbol: "ETHUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "ETH-USDT-SWAP"},
-			},
-			{
-				BinancePrice: &ExchangePrice{Symbol: "SOLUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "SOL-USDT-SWAP"},
-			},
-			{
-				BinancePrice: &ExchangePrice{Symbol: "DOGEUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "DOGE-USDT-SWAP"},
-			},
-			{
-				BinancePrice: &ExchangePrice{Symbol: "XRPUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "XRP-USDT-SWAP"},
-			},
-			{
-				BinancePrice: &ExchangePrice{Symbol: "WLFIUSDT"},
-				OKXPrice:     &ExchangePrice{Symbol: "WLFI-USDT-SWAP"},
-			},
-		},
+	return NewPriceGapWithSources(binance.NewClient(), okx.NewClient(), nil)
+}
+
+// NewPriceGapWithSources compares any two venues, the pair symbols are in each venue's
+// own format. Without pairs Run discovers every instrument trading on both venues,
+// which requires both sources to implement market.InstrumentSource
+func NewPriceGapWithSources(sourceA, sourceB market.MarketDataSource, pairs []*Pair, opts ...PriceGapOption) *PriceGap {
+	p := newPriceGap(sourceA.Exchange(), sourceB.Exchange(), pairs, opts...)
+	p.sourceA = sourceA
+	p.sourceB = sourceB
+	p.chartLog = common.NewChart("price_gap", p.chartAge)
+	if p.fundingInterval > 0 {
+		p.fundingLog = common.NewChart("price_gap_funding", p.chartAge)
 	}
+	if p.trading != nil {
+		trading := *p.trading
+		if trading.VenueA == nil {
+			trading.VenueA, _ = sourceA.(market.ExecutionVenue)
+		}
+		if trading.VenueB == nil {
+			trading.VenueB, _ = sourceB.(market.ExecutionVenue)
+		}
+		if trading.VenueA != nil && trading.VenueB != nil {
+			if p.risk != nil {
+				trading.VenueA, trading.VenueB = p.risk.Wrap(trading.VenueA), p.risk.Wrap(trading.VenueB)
+			}
+			p.trader = newPriceGapTrader(trading, common.NewChart("price_gap_trades", p.chartAge))
+		}
+	}
+	return p
+}
+
+// newPriceGap applies the options without sources, chart logs nor trader
+func newPriceGap(exchangeA string, exchangeB string, pairs []*Pair, opts ...PriceGapOption) *PriceGap {
+	p := &PriceGap{
+		exchangeA:     exchangeA,
+		exchangeB:     exchangeB,
+		now:           func() int64 { return time.Now().UnixMilli() },
+		chartAge:      DefaultPriceGapChartAge,
+		checkInterval: DefaultPriceGapCheckInterval,
+		startInterval: DefaultPriceGapStartInterval,
+		mode:          PriceGapModeMarkPrice,
+		trigger:       PriceGapTriggerPeriodic,
+		maxStaleness:  make(map[string]time.Duration),
+		staleAction:   PriceGapStaleFlag,
+		supervisor:    common.NewSupervisor(),
+		pairs:         pairs,
+		initialized:   make(map[market.MarketDataSource]bool),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.flowWindow > 0 {
+		p.flow = market.NewVolumeAggregator(p.flowWindow)
+	}
+	return p
 }
 
+// Run may be called again after it failed, e.g. by a common.Supervisor, the sources
+// already connected are kept
 func (p *PriceGap) Run(ctx context.Context) error {
-	err := p.bCli.InitFuturesStreamWebSocketConnection(ctx)
-	if err != nil {
-		return err
+	if p.fundingInterval > 0 {
+		_, okA := p.sourceA.(market.FundingSource)
+		_, okB := p.sourceB.(market.FundingSource)
+		if !okA || !okB {
+			return fmt.Errorf("PriceGap Run error: %s or %s can't stream funding", p.sourceA.Exchange(), p.sourceB.Exchange())
+		}
 	}
-	err = p.oCli.InitPublicWebSocketConnection(ctx)
-	if err != nil {
-		return err
+	if p.trading != nil && p.trader == nil {
+		return fmt.Errorf("PriceGap Run error: %s or %s can't execute orders", p.sourceA.Exchange(), p.sourceB.Exchange())
 	}
-	for _, pair := range p.pairs {
-		go p.RunPair(ctx, pair)
-		time.Sleep(5 * time.Second)
+	if p.trader != nil && p.mode != PriceGapModeExecutable {
+		return fmt.Errorf("PriceGap Run error: trading requires the %s mode", PriceGapModeExecutable)
+	}
+	if len(p.pairs) == 0 {
+		pairs, err := p.discoverPairs(ctx)
+		if err != nil {
+			return err
+		}
+		p.pairs = pairs
+	}
+	if p.flow != nil || p.trader != nil {
+		p.setContractValues(ctx)
+	}
+	for _, source := range []market.MarketDataSource{p.sourceA, p.sourceB} {
+		if p.initialized[source] {
+			continue
+		}
+		exchange := source.Exchange()
+		err := source.InitMarketData(ctx)
+		if err != nil {
+			return err
+		}
+		source.OnMarketDataReconnect(func() {
+			common.Logger.Sugar().Infof("PriceGap %s market data reconnected", exchange)
+		})
+		p.initialized[source] = true
+	}
+	if p.trader != nil {
+		err := p.trader.initVenues(ctx)
+		if err != nil {
+			return fmt.Errorf("PriceGap Run InitTrading error: %w", err)
+		}
 	}
+	p.track(p.supervisor.Go(ctx, common.Task{
+		Name:   "price_gap",
+		Run:    p.runPairs,
+		Policy: &common.RestartPolicy{Restart: common.RestartNever},
+	}))
 	return nil
 }
 
-func (p *PriceGap) RunPair(ctx context.Context, pair *Pair) {
-	defer common.HandlePanic()
-	common.Logger.Sugar().Infof("PriceGap RunPair %s %s", pair.BinancePrice.Symbol, pair.OKXPrice.Symbol)
+func (p *PriceGap) track(done <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dones = append(p.dones, done)
+}
 
-	bPrice := binance.NewFuturesStreamWebSocketMarketPrice(pair.BinancePrice.Symbol)
-	err := p.bCli.Subscribe(bPrice.Subscribe(), func(stream *binance.FuturesStreamWebSocketStream) {
-		_, err := bPrice.Stream(stream)
-		if err != nil {
-			common.Logger.Sugar().Warnf("PriceGap RunPair %s Binance Stream error: %v", pair.BinancePrice.Symbol, err)
-			return
+// Close waits for every RunPair to return after the Run context is cancelled, then
+// closes both sources and flushes the chart logs
+func (p *PriceGap) Close(ctx context.Context) error {
+	p.mu.Lock()
+	dones := p.dones
+	p.mu.Unlock()
+	err := common.Wait(ctx, dones...)
+	p.sourceA.Clean()
+	p.sourceB.Clean()
+	stats := p.Stats()
+	common.Logger.Sugar().Infof("PriceGap Close samples: %d, stale %s: %d, stale %s: %d, skewed: %d, flagged: %d, suppressed: %d",
+		stats.Samples, p.sourceA.Exchange(), stats.StaleA, p.sourceB.Exchange(), stats.StaleB, stats.Skewed, stats.Flagged, stats.Suppressed)
+	err = errors.Join(err, p.chartLog.Sync())
+	if p.fundingLog != nil {
+		err = errors.Join(err, p.fundingLog.Sync())
+	}
+	if p.trader != nil {
+		p.trader.logAccounts()
+		err = errors.Join(err, p.trader.tradeLog.Sync())
+	}
+	return err
+}
+
+// Stats is safe to call while the pairs run
+func (p *PriceGap) Stats() PriceGapStats {
+	return PriceGapStats{
+		Samples:    p.counters.samples.Load(),
+		StaleA:     p.counters.staleA.Load(),
+		StaleB:     p.counters.staleB.Load(),
+		Skewed:     p.counters.skewed.Load(),
+		Flagged:    p.counters.flagged.Load(),
+		Suppressed: p.counters.suppressed.Load(),
+	}
+}
+
+// runPairs starts every pair as a supervised task, a pair whose subscription fails is
+// restarted with backoff and given up without stopping the other pairs
+func (p *PriceGap) runPairs(ctx context.Context) error {
+	for _, pair := range p.pairs {
+		p.track(p.supervisor.Go(ctx, common.Task{
+			Name: "price_gap " + pair.A.Symbol + " " + pair.B.Symbol,
+			Run: func(ctx context.Context) error {
+				return p.RunPair(ctx, pair)
+			},
+		}))
+		if !common.Sleep(ctx, p.startInterval) {
+			return nil
 		}
-		pair.BinancePrice.MarkPrice = bPrice.MarkPrice
-		pair.BinancePrice.Time = bPrice.EventTime
-	})
+	}
+	return nil
+}
+
+// loadRegistry loads the instruments of both venues once
+func (p *PriceGap) loadRegistry(ctx context.Context) (*market.Registry, error) {
+	if p.registry != nil {
+		return p.registry, nil
+	}
+	sourceA, okA := p.sourceA.(market.InstrumentSource)
+	sourceB, okB := p.sourceB.(market.InstrumentSource)
+	if !okA || !okB {
+		return nil, fmt.Errorf("PriceGap loadRegistry error: %s or %s can't list instruments", p.sourceA.Exchange(), p.sourceB.Exchange())
+	}
+	registry := market.NewRegistry()
+	err := registry.Load(ctx, sourceA, sourceB)
 	if err != nil {
-		common.Logger.Sugar().Errorf("PriceGap RunPair %s Subscribe Binance error: %v", pair.BinancePrice.Symbol, err)
+		return nil, err
+	}
+	p.registry = registry
+	return registry, nil
+}
+
+// discoverPairs pairs the instruments trading on both venues by canonical id
+func (p *PriceGap) discoverPairs(ctx context.Context) ([]*Pair, error) {
+	registry, err := p.loadRegistry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sourceA, sourceB := p.sourceA, p.sourceB
+	pairs := []*Pair{}
+	for _, id := range registry.Common(sourceA.Exchange(), sourceB.Exchange()) {
+		a, _ := registry.Instrument(sourceA.Exchange(), id)
+		b, _ := registry.Instrument(sourceB.Exchange(), id)
+		pairs = append(pairs, &Pair{
+			A: &ExchangePrice{Symbol: a.Symbol},
+			B: &ExchangePrice{Symbol: b.Symbol},
+		})
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("PriceGap discoverPairs error: no instrument listed on both %s and %s", sourceA.Exchange(), sourceB.Exchange())
+	}
+	common.Logger.Sugar().Infof("PriceGap discovered %d pairs", len(pairs))
+	return pairs, nil
+}
+
+// setContractValues converts the OKX contracts of the trade flow and the paper venues to
+// base quantities and lets the trader size its orders in contracts, both assume one base
+// unit per contract when the instruments can't be loaded
+func (p *PriceGap) setContractValues(ctx context.Context) {
+	registry, err := p.loadRegistry(ctx)
+	if err != nil {
+		common.Logger.Sugar().Warnf("PriceGap setContractValues error: %v", err)
 		return
 	}
+	if p.trader != nil {
+		p.trader.mu.Lock()
+		p.trader.registry = registry
+		p.trader.mu.Unlock()
+	}
+	for _, pair := range p.pairs {
+		for _, leg := range []struct {
+			source market.MarketDataSource
+			price  *ExchangePrice
+			venue  market.ExecutionVenue
+		}{
+			{p.sourceA, pair.A, p.venueA()},
+			{p.sourceB, pair.B, p.venueB()},
+		} {
+			instrument, ok := registry.Symbol(leg.source.Exchange(), leg.price.Symbol)
+			if !ok || !instrument.ContractValue.IsPositive() {
+				continue
+			}
+			if p.flow != nil {
+				p.flow.SetContractValue(leg.source.Exchange(), leg.price.Symbol, instrument.ContractValue)
+			}
+			if venue, ok := leg.venue.(interface {
+				SetContractValue(string, decimal.Decimal)
+			}); ok {
+				venue.SetContractValue(leg.price.Symbol, instrument.ContractValue)
+			}
+		}
+	}
+}
+
+// venueA is nil without WithTrading
+func (p *PriceGap) venueA() market.ExecutionVenue {
+	if p.trader == nil {
+		return nil
+	}
+	return p.trader.VenueA
+}
 
-	oPrice := okx.NewPublicWebSocketMarkPrices(pair.OKXPrice.Symbol)
-	err = p.oCli.Subscribe(oPrice.Subscribe(), func(stream *okx.WebSocketStream) {
-		_, err := oPrice.Stream(stream)
+// venueB is nil without WithTrading
+func (p *PriceGap) venueB() market.ExecutionVenue {
+	if p.trader == nil {
+		return nil
+	}
+	return p.trader.VenueB
+}
+
+// RunPair subscribes both legs and logs the gap until ctx is cancelled
+func (p *PriceGap) RunPair(ctx context.Context, pair *Pair) error {
+	common.Logger.Sugar().Infof("PriceGap RunPair %s %s", pair.A.Symbol, pair.B.Symbol)
+	pair.mu.Lock()
+	pair.updated = make(chan struct{}, 1)
+	pair.mu.Unlock()
+
+	for _, leg := range []struct {
+		source market.MarketDataSource
+		price  *ExchangePrice
+		venue  market.ExecutionVenue
+	}{
+		{p.sourceA, pair.A, p.venueA()},
+		{p.sourceB, pair.B, p.venueB()},
+	} {
+		price, venue := leg.price, leg.venue
+		err := leg.source.SubscribeMarkPrice(price.Symbol, func(tick *market.Tick) {
+			// the venue calls the order handlers, which wait for the trader, outside pair.mu
+			if venue != nil {
+				feed(venue, tick)
+			}
+			pair.mu.Lock()
+			defer pair.mu.Unlock()
+			// OKX may batch several updates in one push, keep the latest
+			if tick.ExchangeTime < price.Time {
+				return
+			}
+			price.MarkPrice = tick.Price
+			price.Time = tick.ExchangeTime
+			price.LocalTime = tick.LocalTime
+			p.notify(pair, tick)
+		})
 		if err != nil {
-			common.Logger.Sugar().Warnf("PriceGap RunPair %s OKX Stream error: %v", pair.OKXPrice.Symbol, err)
-			return
+			return fmt.Errorf("PriceGap RunPair %s Subscribe %s error: %w", price.Symbol, leg.source.Exchange(), err)
 		}
-		var maxTime *okx.PublicWebSocketMarkPrice
-		for _, p := range *oPrice {
-			if maxTime == nil || p.Timestamp > maxTime.Timestamp {
-				maxTime = p
+		if p.flow != nil {
+			err = leg.source.SubscribeTrades(price.Symbol, p.flow.OnTrade)
+			if err != nil {
+				return fmt.Errorf("PriceGap RunPair %s SubscribeTrades %s error: %w", price.Symbol, leg.source.Exchange(), err)
 			}
 		}
-		if maxTime != nil {
-			pair.OKXPrice.MarkPrice = maxTime.MarkPrice
-			pair.OKXPrice.Time, _ = strconv.ParseInt(maxTime.Timestamp, 10, 64)
+		if p.fundingLog != nil {
+			err = leg.source.(market.FundingSource).SubscribeFunding(price.Symbol, func(funding *market.Funding) {
+				pair.mu.Lock()
+				defer pair.mu.Unlock()
+				if price.Funding != nil && funding.ExchangeTime < price.Funding.ExchangeTime {
+					return
+				}
+				price.Funding = funding
+			})
+			if err != nil {
+				return fmt.Errorf("PriceGap RunPair %s SubscribeFunding %s error: %w", price.Symbol, leg.source.Exchange(), err)
+			}
+		}
+		if p.mode != PriceGapModeExecutable {
+			continue
+		}
+		err = leg.source.SubscribeBookTicker(price.Symbol, func(tick *market.Tick) {
+			if venue != nil {
+				feed(venue, tick)
+			}
+			pair.mu.Lock()
+			defer pair.mu.Unlock()
+			if tick.ExchangeTime < price.BookTime {
+				return
+			}
+			price.BidPrice = tick.BidPrice
+			price.AskPrice = tick.AskPrice
+			price.BookTime = tick.ExchangeTime
+			p.notify(pair, tick)
+		})
+		if err != nil {
+			return fmt.Errorf("PriceGap RunPair %s SubscribeBookTicker %s error: %w", price.Symbol, leg.source.Exchange(), err)
 		}
-	})
-	if err != nil {
-		common.Logger.Sugar().Errorf("PriceGap RunPair %s Subscribe OKX error: %v", pair.OKXPrice.Symbol, err)
-		return
 	}
 
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	var check <-chan time.Time
+	if p.trigger == PriceGapTriggerPeriodic {
+		ticker := time.NewTicker(p.checkInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+	var funding <-chan time.Time
+	if p.fundingLog != nil {
+		fundingTicker := time.NewTicker(p.fundingInterval)
+		defer fundingTicker.Stop()
+		funding = fundingTicker.C
+	}
+	var sampled time.Time
 	for {
 		select {
-		case <-ticker.C:
-			p.checkPriceGap(pair)
+		case <-check:
+			p.sample(pair)
+		case <-pair.updated:
+			// the ticks received while waiting are coalesced by the buffer of one
+			if wait := p.coalesce - time.Since(sampled); wait > 0 && !common.Sleep(ctx, wait) {
+				return nil
+			}
+			sampled = time.Now()
+			p.sample(pair)
+		case <-funding:
+			p.checkFunding(pair)
 		case <-ctx.Done():
-			return
+			return nil
 		}
 	}
 }
 
-func (p *PriceGap) checkPriceGap(pair *Pair) {
-	if pair.BinancePrice.MarkPrice.IsZero() || pair.OKXPrice.MarkPrice.IsZero() {
+// notify must be called with pair.mu held, it wakes RunPair in PriceGapTriggerTick
+func (p *PriceGap) notify(pair *Pair, tick *market.Tick) {
+	if p.trigger != PriceGapTriggerTick {
 		return
 	}
-	if pair.BinancePrice.Time == 0 || pair.OKXPrice.Time == 0 {
-		return
+	pair.trigger = tick
+	pair.triggers++
+	select {
+	case pair.updated <- struct{}{}:
+	default:
+	}
+}
+
+// sample hands the logged record to the trader outside pair.mu
+func (p *PriceGap) sample(pair *Pair) {
+	record := p.checkPriceGap(pair)
+	if record != nil && p.trader != nil {
+		p.trader.onSample(pair, record)
+	}
+}
+
+// checkPriceGap logs a PriceGapRecord with the mark price ratio (A-B)/mid in percent.
+// PriceGapModeExecutable adds the cross spreads, selling at one venue's bid and buying
+// at the other's ask in percent of the mark mid, a positive spread is executable before
+// fees. WithTradeFlow adds the trade count, notional and imbalance over the flow window
+// and PriceGapTriggerTick the tick that triggered the sample. The record is nil when
+// not logged
+func (p *PriceGap) checkPriceGap(pair *Pair) *PriceGapRecord {
+	pair.mu.Lock()
+	defer pair.mu.Unlock()
+	trigger, triggers := pair.trigger, pair.triggers
+	pair.trigger, pair.triggers = nil, 0
+	if pair.A.MarkPrice.IsZero() || pair.B.MarkPrice.IsZero() {
+		return nil
+	}
+	if pair.A.Time == 0 || pair.B.Time == 0 {
+		return nil
+	}
+	gap := pair.A.MarkPrice.Sub(pair.B.MarkPrice)
+	avg := pair.A.MarkPrice.Add(pair.B.MarkPrice).Div(decimal.NewFromInt(2))
+	now := p.now()
+	record := &PriceGapRecord{
+		Schema:     PriceGapSchemaVersion,
+		Symbol:     pair.A.Symbol,
+		LocalTime:  now,
+		Ratio:      gap.Div(avg).Mul(decimal.NewFromInt(100)),
+		ExchangeA:  p.exchangeA,
+		SymbolA:    pair.A.Symbol,
+		PriceA:     pair.A.MarkPrice,
+		TimeA:      pair.A.Time,
+		StalenessA: now - pair.A.Time,
+		ExchangeB:  p.exchangeB,
+		SymbolB:    pair.B.Symbol,
+		PriceB:     pair.B.MarkPrice,
+		TimeB:      pair.B.Time,
+		StalenessB: now - pair.B.Time,
+		LatencyA:   pair.A.LocalTime - pair.A.Time,
+		LatencyB:   pair.B.LocalTime - pair.B.Time,
+		Skew:       pair.A.Time - pair.B.Time,
+	}
+	if p.mode == PriceGapModeExecutable {
+		if pair.A.BookTime == 0 || pair.B.BookTime == 0 {
+			return nil
+		}
+		record.Executable = &PriceGapExecutable{
+			BidA:      pair.A.BidPrice,
+			AskA:      pair.A.AskPrice,
+			BookTimeA: pair.A.BookTime,
+			BidB:      pair.B.BidPrice,
+			AskB:      pair.B.AskPrice,
+			BookTimeB: pair.B.BookTime,
+			SellABuyB: pair.A.BidPrice.Sub(pair.B.AskPrice).Div(avg).Mul(decimal.NewFromInt(100)),
+			SellBBuyA: pair.B.BidPrice.Sub(pair.A.AskPrice).Div(avg).Mul(decimal.NewFromInt(100)),
+		}
+	}
+	if p.flow != nil {
+		statsA := p.flow.Stats(p.exchangeA, pair.A.Symbol, p.flowWindow, now)
+		statsB := p.flow.Stats(p.exchangeB, pair.B.Symbol, p.flowWindow, now)
+		record.Flow = &PriceGapFlow{
+			TradesA:    statsA.Count,
+			NotionalA:  statsA.Notional,
+			ImbalanceA: statsA.Imbalance(),
+			TradesB:    statsB.Count,
+			NotionalB:  statsB.Notional,
+			ImbalanceB: statsB.Imbalance(),
+		}
+	}
+	if trigger != nil {
+		record.Trigger = &PriceGapTrigger{
+			Exchange:     trigger.Exchange,
+			Symbol:       trigger.Symbol,
+			Type:         string(trigger.Type),
+			ExchangeTime: trigger.ExchangeTime,
+			LocalTime:    trigger.LocalTime,
+			Coalesced:    triggers,
+		}
+	}
+	record.Stale = p.staleReasons(pair, now)
+	p.counters.samples.Add(1)
+	if len(record.Stale) > 0 {
+		if p.staleAction == PriceGapStaleSuppress {
+			p.counters.suppressed.Add(1)
+			return nil
+		}
+		p.counters.flagged.Add(1)
+	}
+	p.chartLog.Info(PriceGapMessage, zap.Inline(record))
+	return record
+}
+
+// staleReasons must be called with pair.mu held, the book tickers are only checked in
+// PriceGapModeExecutable
+func (p *PriceGap) staleReasons(pair *Pair, now int64) []string {
+	reasons := []string{}
+	for _, leg := range []struct {
+		exchange string
+		price    *ExchangePrice
+		reason   string
+		counter  *atomic.Int64
+	}{
+		{p.exchangeA, pair.A, PriceGapStaleA, &p.counters.staleA},
+		{p.exchangeB, pair.B, PriceGapStaleB, &p.counters.staleB},
+	} {
+		maxStaleness, ok := p.maxStaleness[leg.exchange]
+		if !ok {
+			maxStaleness = DefaultPriceGapMaxStaleness
+		}
+		if maxStaleness <= 0 {
+			continue
+		}
+		oldest := leg.price.Time
+		if p.mode == PriceGapModeExecutable {
+			oldest = min(oldest, leg.price.BookTime)
+		}
+		if now-oldest > maxStaleness.Milliseconds() {
+			reasons = append(reasons, leg.reason)
+			leg.counter.Add(1)
+		}
+	}
+	if p.maxSkew > 0 {
+		skew := pair.A.Time - pair.B.Time
+		if skew > p.maxSkew.Milliseconds() || -skew > p.maxSkew.Milliseconds() {
+			reasons = append(reasons, PriceGapStaleSkew)
+			p.counters.skewed.Add(1)
+		}
+	}
+	return reasons
+}
+
+// checkFunding logs a PriceGapFundingRecord once the funding of both legs is known,
+// the record is nil when not logged
+func (p *PriceGap) checkFunding(pair *Pair) *PriceGapFundingRecord {
+	pair.mu.Lock()
+	defer pair.mu.Unlock()
+	if pair.A.Funding == nil || pair.B.Funding == nil {
+		return nil
+	}
+	fundingA, fundingB := pair.A.Funding, pair.B.Funding
+	record := &PriceGapFundingRecord{
+		Schema:         PriceGapSchemaVersion,
+		Symbol:         pair.A.Symbol,
+		LocalTime:      p.now(),
+		ExchangeA:      p.exchangeA,
+		SymbolA:        pair.A.Symbol,
+		RateA:          fundingA.Rate,
+		PredictedRateA: fundingA.PredictedRate,
+		AnnualizedA:    fundingA.AnnualizedRate(),
+		FundingTimeA:   fundingA.FundingTime,
+		ExchangeB:      p.exchangeB,
+		SymbolB:        pair.B.Symbol,
+		RateB:          fundingB.Rate,
+		PredictedRateB: fundingB.PredictedRate,
+		AnnualizedB:    fundingB.AnnualizedRate(),
+		FundingTimeB:   fundingB.FundingTime,
 	}
-	gap := pair.BinancePrice.MarkPrice.Sub(pair.OKXPrice.MarkPrice)
-	avg := pair.BinancePrice.MarkPrice.Add(pair.OKXPrice.MarkPrice).Div(decimal.NewFromInt(2))
-	ratio := gap.Div(avg).Mul(decimal.NewFromInt(100))
-	p.chartLog.Info(strings.Join([]string{
-		strconv.FormatInt(time.Now().Unix(), 10),
-		pair.BinancePrice.Symbol,
-		ratio.String(),
-	}, ","))
+	record.Differential = record.AnnualizedA.Sub(record.AnnualizedB)
+	record.TimeToFundingA = record.FundingTimeA - record.LocalTime
+	record.TimeToFundingB = record.FundingTimeB - record.LocalTime
+	p.fundingLog.Info(PriceGapFundingMessage, zap.Inline(record))
+	return record
 }
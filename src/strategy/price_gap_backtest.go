@@ -0,0 +1,110 @@
+package strategy
+
+import (
+	"fmt"
+	"trade/src/market"
+
+	"go.uber.org/zap"
+)
+
+// PriceGapBacktest replays PriceGap and its trader on recorded ticks, it implements
+// backtest.Strategy. Every mark price and book ticker of a leg samples its pair, like
+// PriceGapTriggerTick without coalescing, at the LocalTime of the tick. The samples are
+// not logged and the leg timeouts run on the replay time
+type PriceGapBacktest struct {
+	gap  *PriceGap
+	legs map[string]*priceGapBacktestLeg
+	// now is the LocalTime of the last tick
+	now int64
+}
+
+type priceGapBacktestLeg struct {
+	pair  *Pair
+	price *ExchangePrice
+}
+
+// NewPriceGapBacktest takes the options of PriceGap, WithTrading is required and its
+// venues are replaced by the venues of Init. It always samples in
+// PriceGapModeExecutable since the trader enters on the book tickers
+func NewPriceGapBacktest(exchangeA string, exchangeB string, pairs []*Pair, opts ...PriceGapOption) *PriceGapBacktest {
+	b := &PriceGapBacktest{
+		gap:  newPriceGap(exchangeA, exchangeB, pairs, opts...),
+		legs: make(map[string]*priceGapBacktestLeg),
+	}
+	b.gap.mode = PriceGapModeExecutable
+	b.gap.chartLog = zap.NewNop()
+	b.gap.now = func() int64 { return b.now }
+	for _, pair := range pairs {
+		b.legs[exchangeA+"/"+pair.A.Symbol] = &priceGapBacktestLeg{pair: pair, price: pair.A}
+		b.legs[exchangeB+"/"+pair.B.Symbol] = &priceGapBacktestLeg{pair: pair, price: pair.B}
+	}
+	return b
+}
+
+// Init trades on the venues of exchange A and B
+func (b *PriceGapBacktest) Init(venues map[string]market.ExecutionVenue) error {
+	if b.gap.trading == nil {
+		return fmt.Errorf("PriceGapBacktest Init error: WithTrading is required")
+	}
+	trading := *b.gap.trading
+	trading.VenueA, trading.VenueB = venues[b.gap.exchangeA], venues[b.gap.exchangeB]
+	if trading.VenueA == nil || trading.VenueB == nil {
+		return fmt.Errorf("PriceGapBacktest Init error: no ticks of %s or %s", b.gap.exchangeA, b.gap.exchangeB)
+	}
+	trading.DryRun = false
+	b.gap.trader = newPriceGapTrader(trading, zap.NewNop())
+	b.gap.trader.replay = true
+	return nil
+}
+
+// OnTick updates the leg of tick and samples its pair, the ticks of other symbols only
+// advance the replay time
+func (b *PriceGapBacktest) OnTick(tick *market.Tick) {
+	b.now = tick.LocalTime
+	b.gap.trader.expireDue(tick.LocalTime)
+	leg, ok := b.legs[tick.Exchange+"/"+tick.Symbol]
+	if !ok {
+		return
+	}
+	price := leg.price
+	leg.pair.mu.Lock()
+	switch tick.Type {
+	case market.TickTypeMarkPrice:
+		if tick.ExchangeTime < price.Time {
+			leg.pair.mu.Unlock()
+			return
+		}
+		price.MarkPrice = tick.Price
+		price.Time = tick.ExchangeTime
+		price.LocalTime = tick.LocalTime
+	case market.TickTypeBookTicker:
+		if tick.ExchangeTime < price.BookTime {
+			leg.pair.mu.Unlock()
+			return
+		}
+		price.BidPrice = tick.BidPrice
+		price.AskPrice = tick.AskPrice
+		price.BookTime = tick.ExchangeTime
+	case market.TickTypeTrade:
+		leg.pair.mu.Unlock()
+		if b.gap.flow != nil {
+			b.gap.flow.OnTrade(tick)
+		}
+		return
+	}
+	leg.pair.mu.Unlock()
+	b.gap.sample(leg.pair)
+}
+
+// Group makes both legs of a pair one position, named after the symbol of A
+func (b *PriceGapBacktest) Group(exchange string, symbol string) string {
+	if leg, ok := b.legs[exchange+"/"+symbol]; ok {
+		return leg.pair.A.Symbol
+	}
+	return exchange + "/" + symbol
+}
+
+// Stats counts the samples of the replay
+func (b *PriceGapBacktest) Stats() PriceGapStats {
+	return b.gap.Stats()
+}
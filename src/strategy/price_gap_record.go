@@ -0,0 +1,219 @@
+package strategy
+
+import (
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// PriceGapSchemaVersion is logged as "schema" with every PriceGapRecord, the lines
+	// without it are version 1 whose message is "ts,symbol,ratio[,spreads][,flow]"
+	PriceGapSchemaVersion = 2
+	// PriceGapMessage is the message of the version 2 lines, the data is in the fields
+	PriceGapMessage = "price_gap"
+	// PriceGapFundingMessage is the message of the price_gap_funding lines
+	PriceGapFundingMessage = "price_gap_funding"
+)
+
+// PriceGapRecord is one line of the price_gap chart log. Times are in milliseconds,
+// the staleness of a leg is LocalTime minus its exchange time, the latency is the
+// receive time of its mark price minus its exchange time, Skew is TimeA - TimeB and the
+// percentages are of the mark price mid. Symbol is the symbol of A which names the
+// chart series
+type PriceGapRecord struct {
+	Schema     int             `json:"schema"`
+	Symbol     string          `json:"symbol"`
+	LocalTime  int64           `json:"local_time"`
+	Ratio      decimal.Decimal `json:"ratio"`
+	ExchangeA  string          `json:"exchange_a"`
+	SymbolA    string          `json:"symbol_a"`
+	PriceA     decimal.Decimal `json:"price_a"`
+	TimeA      int64           `json:"time_a"`
+	StalenessA int64           `json:"staleness_a"`
+	ExchangeB  string          `json:"exchange_b"`
+	SymbolB    string          `json:"symbol_b"`
+	PriceB     decimal.Decimal `json:"price_b"`
+	TimeB      int64           `json:"time_b"`
+	StalenessB int64           `json:"staleness_b"`
+	LatencyA   int64           `json:"latency_a"`
+	LatencyB   int64           `json:"latency_b"`
+	Skew       int64           `json:"skew"`
+	// Stale lists the PriceGapStale reasons of a flagged sample, it is empty when the
+	// sample is fresh
+	Stale []string `json:"stale,omitempty"`
+	// Executable is only set in PriceGapModeExecutable
+	Executable *PriceGapExecutable `json:"executable,omitempty"`
+	// Flow is only set with WithTradeFlow
+	Flow *PriceGapFlow `json:"flow,omitempty"`
+	// Trigger is only set in PriceGapTriggerTick
+	Trigger *PriceGapTrigger `json:"trigger,omitempty"`
+}
+
+// PriceGapExecutable holds the book tickers of both legs and the cross spreads, selling
+// at one venue's bid and buying at the other's ask
+type PriceGapExecutable struct {
+	BidA      decimal.Decimal `json:"bid_a"`
+	AskA      decimal.Decimal `json:"ask_a"`
+	BookTimeA int64           `json:"book_time_a"`
+	BidB      decimal.Decimal `json:"bid_b"`
+	AskB      decimal.Decimal `json:"ask_b"`
+	BookTimeB int64           `json:"book_time_b"`
+	SellABuyB decimal.Decimal `json:"sell_a_buy_b"`
+	SellBBuyA decimal.Decimal `json:"sell_b_buy_a"`
+}
+
+// PriceGapFlow is the trade flow of both legs over the flow window, Notional is in the
+// quote currency and Imbalance is the taker buy minus sell share of the volume
+type PriceGapFlow struct {
+	TradesA    int             `json:"trades_a"`
+	NotionalA  decimal.Decimal `json:"notional_a"`
+	ImbalanceA decimal.Decimal `json:"imbalance_a"`
+	TradesB    int             `json:"trades_b"`
+	NotionalB  decimal.Decimal `json:"notional_b"`
+	ImbalanceB decimal.Decimal `json:"imbalance_b"`
+}
+
+// PriceGapTrigger is the last tick received before a tick triggered sample, Coalesced
+// counts the ticks of both legs the sample covers
+type PriceGapTrigger struct {
+	Exchange     string `json:"exchange"`
+	Symbol       string `json:"symbol"`
+	Type         string `json:"type"`
+	ExchangeTime int64  `json:"exchange_time"`
+	LocalTime    int64  `json:"local_time"`
+	Coalesced    int    `json:"coalesced"`
+}
+
+// PriceGapFundingRecord is one line of the price_gap_funding chart log. The annualized
+// rates are over each venue's own interval in percent and Differential is AnnualizedA -
+// AnnualizedB, positive when shorting A against a long B collects funding. A predicted
+// rate is only set when the venue publishes one. The funding times are of the next
+// funding in milliseconds like LocalTime, and the times to funding are the milliseconds
+// left until them at LocalTime
+type PriceGapFundingRecord struct {
+	Schema         int                 `json:"schema"`
+	Symbol         string              `json:"symbol"`
+	LocalTime      int64               `json:"local_time"`
+	Differential   decimal.Decimal     `json:"differential"`
+	ExchangeA      string              `json:"exchange_a"`
+	SymbolA        string              `json:"symbol_a"`
+	RateA          decimal.Decimal     `json:"rate_a"`
+	PredictedRateA decimal.NullDecimal `json:"predicted_rate_a"`
+	AnnualizedA    decimal.Decimal     `json:"annualized_a"`
+	FundingTimeA   int64               `json:"funding_time_a"`
+	TimeToFundingA int64               `json:"time_to_funding_a"`
+	ExchangeB      string              `json:"exchange_b"`
+	SymbolB        string              `json:"symbol_b"`
+	RateB          decimal.Decimal     `json:"rate_b"`
+	PredictedRateB decimal.NullDecimal `json:"predicted_rate_b"`
+	AnnualizedB    decimal.Decimal     `json:"annualized_b"`
+	FundingTimeB   int64               `json:"funding_time_b"`
+	TimeToFundingB int64               `json:"time_to_funding_b"`
+}
+
+// MarshalLogObject writes the record as flat zap fields, the decimals as strings so
+// no precision is lost
+func (r *PriceGapRecord) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("schema", r.Schema)
+	enc.AddString("symbol", r.Symbol)
+	enc.AddInt64("local_time", r.LocalTime)
+	enc.AddString("ratio", r.Ratio.String())
+	enc.AddString("exchange_a", r.ExchangeA)
+	enc.AddString("symbol_a", r.SymbolA)
+	enc.AddString("price_a", r.PriceA.String())
+	enc.AddInt64("time_a", r.TimeA)
+	enc.AddInt64("staleness_a", r.StalenessA)
+	enc.AddString("exchange_b", r.ExchangeB)
+	enc.AddString("symbol_b", r.SymbolB)
+	enc.AddString("price_b", r.PriceB.String())
+	enc.AddInt64("time_b", r.TimeB)
+	enc.AddInt64("staleness_b", r.StalenessB)
+	enc.AddInt64("latency_a", r.LatencyA)
+	enc.AddInt64("latency_b", r.LatencyB)
+	enc.AddInt64("skew", r.Skew)
+	if len(r.Stale) > 0 {
+		err := enc.AddArray("stale", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			for _, reason := range r.Stale {
+				arr.AppendString(reason)
+			}
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	if r.Executable != nil {
+		if err := enc.AddObject("executable", r.Executable); err != nil {
+			return err
+		}
+	}
+	if r.Flow != nil {
+		if err := enc.AddObject("flow", r.Flow); err != nil {
+			return err
+		}
+	}
+	if r.Trigger != nil {
+		return enc.AddObject("trigger", r.Trigger)
+	}
+	return nil
+}
+
+func (e *PriceGapExecutable) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("bid_a", e.BidA.String())
+	enc.AddString("ask_a", e.AskA.String())
+	enc.AddInt64("book_time_a", e.BookTimeA)
+	enc.AddString("bid_b", e.BidB.String())
+	enc.AddString("ask_b", e.AskB.String())
+	enc.AddInt64("book_time_b", e.BookTimeB)
+	enc.AddString("sell_a_buy_b", e.SellABuyB.String())
+	enc.AddString("sell_b_buy_a", e.SellBBuyA.String())
+	return nil
+}
+
+func (f *PriceGapFlow) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("trades_a", f.TradesA)
+	enc.AddString("notional_a", f.NotionalA.StringFixed(2))
+	enc.AddString("imbalance_a", f.ImbalanceA.StringFixed(4))
+	enc.AddInt("trades_b", f.TradesB)
+	enc.AddString("notional_b", f.NotionalB.StringFixed(2))
+	enc.AddString("imbalance_b", f.ImbalanceB.StringFixed(4))
+	return nil
+}
+
+func (t *PriceGapTrigger) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("exchange", t.Exchange)
+	enc.AddString("symbol", t.Symbol)
+	enc.AddString("type", t.Type)
+	enc.AddInt64("exchange_time", t.ExchangeTime)
+	enc.AddInt64("local_time", t.LocalTime)
+	enc.AddInt("coalesced", t.Coalesced)
+	return nil
+}
+
+// MarshalLogObject writes the annualized rates with 4 decimals and omits the missing
+// predicted rates
+func (r *PriceGapFundingRecord) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("schema", r.Schema)
+	enc.AddString("symbol", r.Symbol)
+	enc.AddInt64("local_time", r.LocalTime)
+	enc.AddString("differential", r.Differential.StringFixed(4))
+	enc.AddString("exchange_a", r.ExchangeA)
+	enc.AddString("symbol_a", r.SymbolA)
+	enc.AddString("rate_a", r.RateA.String())
+	if r.PredictedRateA.Valid {
+		enc.AddString("predicted_rate_a", r.PredictedRateA.Decimal.String())
+	}
+	enc.AddString("annualized_a", r.AnnualizedA.StringFixed(4))
+	enc.AddInt64("funding_time_a", r.FundingTimeA)
+	enc.AddInt64("time_to_funding_a", r.TimeToFundingA)
+	enc.AddString("exchange_b", r.ExchangeB)
+	enc.AddString("symbol_b", r.SymbolB)
+	enc.AddString("rate_b", r.RateB.String())
+	if r.PredictedRateB.Valid {
+		enc.AddString("predicted_rate_b", r.PredictedRateB.Decimal.String())
+	}
+	enc.AddString("annualized_b", r.AnnualizedB.StringFixed(4))
+	enc.AddInt64("funding_time_b", r.FundingTimeB)
+	enc.AddInt64("time_to_funding_b", r.TimeToFundingB)
+	return nil
+}
@@ -0,0 +1,53 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+	"trade/src/market"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestPriceGapFunding(t *testing.T) {
+	now := int64(1_700_000_000_000)
+	p := newPriceGap(market.ExchangeOKX, market.ExchangeBinance, nil, WithFunding(time.Minute), WithClock(func() int64 { return now }))
+	var buf bytes.Buffer
+	p.fundingLog = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.InfoLevel))
+	pair := newTestPair()
+
+	assert.Nil(t, p.checkFunding(pair), "nothing is logged before the funding of both legs")
+	pair.A.Funding = &market.Funding{Rate: d("0.0001"), FundingTime: now + 60_000, PredictedRate: decimal.NewNullDecimal(d("0.0002")), Interval: 8 * time.Hour}
+	pair.B.Funding = &market.Funding{Rate: d("-0.00005"), FundingTime: now + 120_000, Interval: 4 * time.Hour}
+	record := p.checkFunding(pair)
+	require.NotNil(t, record)
+	// 0.01% over 1095 periods and -0.005% over 2190 periods a year
+	assert.True(t, record.AnnualizedA.Equal(d("10.95")), record.AnnualizedA.String())
+	assert.True(t, record.AnnualizedB.Equal(d("-10.95")), record.AnnualizedB.String())
+	assert.True(t, record.Differential.Equal(d("21.9")), record.Differential.String())
+	assert.Equal(t, int64(60_000), record.TimeToFundingA)
+	assert.Equal(t, int64(120_000), record.TimeToFundingB)
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, PriceGapFundingMessage, line["msg"])
+	assert.Equal(t, float64(PriceGapSchemaVersion), line["schema"])
+	assert.Equal(t, testSymbolA, line["symbol"])
+	assert.Equal(t, float64(now), line["local_time"])
+	assert.Equal(t, "21.9000", line["differential"])
+	assert.Equal(t, market.ExchangeOKX, line["exchange_a"])
+	assert.Equal(t, "0.0001", line["rate_a"])
+	assert.Equal(t, "0.0002", line["predicted_rate_a"])
+	assert.Equal(t, float64(now+60_000), line["funding_time_a"])
+	assert.Equal(t, float64(60_000), line["time_to_funding_a"])
+	assert.Equal(t, market.ExchangeBinance, line["exchange_b"])
+	assert.Equal(t, testSymbolB, line["symbol_b"])
+	assert.Equal(t, "-10.9500", line["annualized_b"])
+	assert.Equal(t, float64(120_000), line["time_to_funding_b"])
+	assert.NotContains(t, line, "predicted_rate_b", "B publishes no prediction")
+}
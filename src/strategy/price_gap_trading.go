@@ -0,0 +1,593 @@
+package strategy
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+	"trade/src/common"
+	"trade/src/market"
+	"trade/src/paper"
+	"trade/src/risk"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// PriceGapTrading opens a hedge of a pair when the executable cross spread of a sample,
+// selling the rich leg at its bid and buying the cheap one at its ask, net of the taker
+// fees FeeA and FeeB paid on both legs to open and close, reaches Entry percent. It
+// sends both legs as market orders and closes the hedge once the mark price ratio is
+// back within Exit percent or crossed zero. Each leg is Notional in the quote currency
+// of the same base quantity.
+//
+// VenueA and VenueB default to the sources when they implement market.ExecutionVenue,
+// which trades live. The orders of an action that are not final after LegTimeout are
+// canceled, and a hedge left with a failed, partially filled or canceled order is
+// flattened. DryRun logs the orders as filled at the mark price without sending them
+type PriceGapTrading struct {
+	VenueA     market.ExecutionVenue
+	VenueB     market.ExecutionVenue
+	Entry      decimal.Decimal
+	Exit       decimal.Decimal
+	Notional   decimal.Decimal
+	FeeA       decimal.Decimal
+	FeeB       decimal.Decimal
+	LegTimeout time.Duration
+	DryRun     bool
+}
+
+const DefaultPriceGapLegTimeout = 5 * time.Second
+
+// WithTrading trades the samples on the given venues, it requires PriceGapModeExecutable
+// since the venues fill against the book tickers. A venue implementing
+// OnTick(*market.Tick), such as a paper.Venue, is fed the ticks of its leg and a venue
+// implementing InitTrading(context.Context) error, such as the live clients, is
+// initialized by Run unless DryRun is set
+func WithTrading(trading *PriceGapTrading) PriceGapOption {
+	return func(p *PriceGap) {
+		p.trading = trading
+	}
+}
+
+// WithRisk sends the orders of WithTrading through the checks of manager, which wraps
+// both venues. DryRun orders never reach the venues and aren't checked. Once the kill
+// switch of manager is triggered the trader sends nothing more and leaves the hedges to
+// the flatten orders of the manager
+func WithRisk(manager *risk.Manager) PriceGapOption {
+	return func(p *PriceGap) {
+		p.risk = manager
+	}
+}
+
+const (
+	hedgeOpen    = "open"
+	hedgeClose   = "close"
+	hedgeFlatten = "flatten"
+)
+
+// priceGapTrader keeps the hedge of every pair, mu guards the hedges and the orders and
+// is held while sending orders so the updates of an order wait until it is tracked
+type priceGapTrader struct {
+	PriceGapTrading
+	mu       sync.Mutex
+	registry *market.Registry
+	// paper is set when both venues are paper venues, which may size the orders without
+	// the instruments
+	paper  bool
+	hedges map[*Pair]*hedge
+	// orders are keyed by exchange and client order id, which is known before the
+	// order is sent
+	orders       map[string]*hedgeOrder
+	clientPrefix string
+	clientOrders int64
+	dryRunOrders int64
+	tradeLog     *zap.Logger
+	// killed is set once the kill switch of a leg was triggered, the risk manager then
+	// owns the positions and the trader sends nothing more
+	killed bool
+	// replay checks the leg timeouts on the replay time of expireDue instead of timers
+	replay bool
+	now    int64
+}
+
+// hedge positions are signed in each venue's own unit, direction is 1 while A is short
+// and B long, -1 the other way around and 0 when flat. failed is set once an order of the
+// current action did not fill completely and unwind until both legs are flat again after
+// a failure
+type hedge struct {
+	pair      *Pair
+	direction int
+	positionA decimal.Decimal
+	positionB decimal.Decimal
+	pending   int
+	failed    bool
+	unwind    bool
+	timer     *time.Timer
+	// deadline replaces timer in a replay, it is zero without pending orders
+	deadline int64
+	// the last sample, the flatten orders are sent between samples
+	ratio   decimal.Decimal
+	symbolA string
+	symbolB string
+	priceA  decimal.Decimal
+	priceB  decimal.Decimal
+}
+
+// priceGapLostOrderAge is how long a lost order is kept for its late updates
+const priceGapLostOrderAge = time.Minute
+
+// hedgeOrder is lost when PlaceOrder failed, it may still exist at the venue so its
+// late updates are applied for priceGapLostOrderAge but it doesn't hold the action
+type hedgeOrder struct {
+	hedge  *hedge
+	venue  market.ExecutionVenue
+	leg    string
+	action string
+	symbol string
+	key    string
+	id     string
+	ratio  decimal.Decimal
+	filled decimal.Decimal
+	lost   bool
+	// lostTime is when PlaceOrder failed
+	lostTime int64
+	done     bool
+}
+
+func newPriceGapTrader(trading PriceGapTrading, tradeLog *zap.Logger) *priceGapTrader {
+	if trading.LegTimeout <= 0 {
+		trading.LegTimeout = DefaultPriceGapLegTimeout
+	}
+	_, paperA := unwrap(trading.VenueA).(*paper.Venue)
+	_, paperB := unwrap(trading.VenueB).(*paper.Venue)
+	t := &priceGapTrader{
+		PriceGapTrading: trading,
+		paper:           paperA && paperB,
+		hedges:          make(map[*Pair]*hedge),
+		orders:          make(map[string]*hedgeOrder),
+		// OKX only takes letters and digits, unique across restarts
+		clientPrefix: "pg" + strconv.FormatInt(time.Now().UnixMilli(), 36) + "x",
+		tradeLog:     tradeLog,
+	}
+	trading.VenueA.OnOrderUpdate(t.onOrderUpdate)
+	trading.VenueB.OnOrderUpdate(t.onOrderUpdate)
+	return t
+}
+
+// feed forwards a tick of a leg to its venue when it simulates the matching
+func feed(venue market.ExecutionVenue, tick *market.Tick) {
+	if consumer, ok := venue.(interface{ OnTick(*market.Tick) }); ok {
+		consumer.OnTick(tick)
+	}
+}
+
+// unwrap returns the venue behind a risk.Venue
+func unwrap(venue market.ExecutionVenue) market.ExecutionVenue {
+	if wrapped, ok := venue.(interface{ Unwrap() market.ExecutionVenue }); ok {
+		return wrapped.Unwrap()
+	}
+	return venue
+}
+
+// initVenues connects the order channels of the live venues
+func (t *priceGapTrader) initVenues(ctx context.Context) error {
+	if t.DryRun {
+		return nil
+	}
+	for _, venue := range []market.ExecutionVenue{t.VenueA, t.VenueB} {
+		if trading, ok := venue.(interface{ InitTrading(context.Context) error }); ok {
+			err := trading.InitTrading(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// onSample acts on a fresh sample once the orders of the previous action are final
+func (t *priceGapTrader) onSample(pair *Pair, record *PriceGapRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hedges[pair]
+	if !ok {
+		h = &hedge{pair: pair}
+		t.hedges[pair] = h
+	}
+	if h.pending > 0 || len(record.Stale) > 0 || t.stopped() {
+		return
+	}
+	h.ratio = record.Ratio
+	h.symbolA, h.symbolB = record.SymbolA, record.SymbolB
+	h.priceA, h.priceB = record.PriceA, record.PriceB
+	if h.unwind {
+		t.closeLegs(h, hedgeFlatten)
+		return
+	}
+	ratio := record.Ratio
+	if h.direction == 0 {
+		direction := t.entry(record)
+		if direction == 0 {
+			return
+		}
+		quantityA, quantityB, ok := t.quantities(record)
+		if !ok {
+			return
+		}
+		h.direction = direction
+		sideA, sideB := market.SideSell, market.SideBuy
+		if h.direction < 0 {
+			sideA, sideB = sideB, sideA
+		}
+		t.place(h, "a", hedgeOpen, &market.OrderRequest{Symbol: record.SymbolA, Side: sideA, Type: market.OrderTypeMarket, Quantity: quantityA})
+		t.place(h, "b", hedgeOpen, &market.OrderRequest{Symbol: record.SymbolB, Side: sideB, Type: market.OrderTypeMarket, Quantity: quantityB})
+		t.watch(h)
+		t.settle(h)
+		return
+	}
+	if ratio.Abs().GreaterThan(t.Exit) && ratio.Sign() == h.direction {
+		return
+	}
+	t.closeLegs(h, hedgeClose)
+}
+
+// entry is 1 when selling A at its bid and buying B at its ask clears the fees by Entry
+// percent, -1 the other way around and 0 otherwise or without book tickers
+func (t *priceGapTrader) entry(record *PriceGapRecord) int {
+	if record.Executable == nil {
+		return 0
+	}
+	if record.Executable.SellABuyB.Sub(t.fees()).GreaterThanOrEqual(t.Entry) {
+		return 1
+	}
+	if record.Executable.SellBBuyA.Sub(t.fees()).GreaterThanOrEqual(t.Entry) {
+		return -1
+	}
+	return 0
+}
+
+// fees is the round trip cost of a hedge in percent, the taker fee of both legs paid to
+// open and to close
+func (t *priceGapTrader) fees() decimal.Decimal {
+	return t.FeeA.Add(t.FeeB).Mul(decimal.NewFromInt(200))
+}
+
+// closeLegs must be called with mu held, it sends reduce only market orders for both legs
+func (t *priceGapTrader) closeLegs(h *hedge, action string) {
+	for _, leg := range []struct {
+		name     string
+		symbol   string
+		position decimal.Decimal
+	}{
+		{"a", h.symbolA, h.positionA},
+		{"b", h.symbolB, h.positionB},
+	} {
+		if leg.position.IsZero() {
+			continue
+		}
+		side := market.SideSell
+		if leg.position.IsNegative() {
+			side = market.SideBuy
+		}
+		t.place(h, leg.name, action, &market.OrderRequest{Symbol: leg.symbol, Side: side, Type: market.OrderTypeMarket, Quantity: leg.position.Abs(), ReduceOnly: true})
+	}
+	t.watch(h)
+	t.settle(h)
+}
+
+// quantities converts Notional at the mark price of A to the same base quantity on both
+// legs, rounded down to the coarser lot of the two venues. It is not ok when the lot
+// is over Notional or the instruments are unknown, except on paper venues which take
+// one contract per base unit then
+func (t *priceGapTrader) quantities(record *PriceGapRecord) (decimal.Decimal, decimal.Decimal, bool) {
+	instrumentA := t.instrument(record.ExchangeA, record.SymbolA)
+	instrumentB := t.instrument(record.ExchangeB, record.SymbolB)
+	if instrumentA == nil || instrumentB == nil {
+		common.Logger.Sugar().Warnf("PriceGapTrading %s skipped: the instruments are unknown", record.Symbol)
+		return decimal.Zero, decimal.Zero, false
+	}
+	base := t.Notional.Div(record.PriceA)
+	step := decimal.Max(instrumentA.LotSize.Mul(instrumentA.ContractValue), instrumentB.LotSize.Mul(instrumentB.ContractValue))
+	if step.IsPositive() {
+		base = base.Div(step).Floor().Mul(step)
+	}
+	quantityA, quantityB := base.Div(instrumentA.ContractValue), base.Div(instrumentB.ContractValue)
+	if quantityA.IsZero() || quantityA.LessThan(instrumentA.MinQuantity) || quantityB.LessThan(instrumentB.MinQuantity) {
+		common.Logger.Sugar().Warnf("PriceGapTrading %s notional %s is under one lot", record.Symbol, t.Notional)
+		return decimal.Zero, decimal.Zero, false
+	}
+	return quantityA, quantityB, true
+}
+
+// instrument returns nil when the instrument is unknown, or one contract per base unit
+// without lot on paper venues
+func (t *priceGapTrader) instrument(exchange string, symbol string) *market.Instrument {
+	if t.registry != nil {
+		if instrument, ok := t.registry.Symbol(exchange, symbol); ok && instrument.ContractValue.IsPositive() {
+			return instrument
+		}
+	}
+	if !t.paper {
+		return nil
+	}
+	return &market.Instrument{ContractValue: decimal.NewFromInt(1)}
+}
+
+// place must be called with mu held, the request is sent with a LegTimeout deadline
+// rather than the Run context so a hedge is still flattened while shutting down. The
+// order is tracked by its client order id before it is sent so an order whose
+// PlaceOrder failed, e.g. on a timeout, still adds its late fills to the leg
+func (t *priceGapTrader) place(h *hedge, leg string, action string, request *market.OrderRequest) {
+	venue, price := t.VenueA, h.priceA
+	if leg == "b" {
+		venue, price = t.VenueB, h.priceB
+	}
+	t.forgetLost()
+	t.clientOrders++
+	request.ClientOrderID = t.clientPrefix + strconv.FormatInt(t.clientOrders, 10)
+	key := venue.Exchange() + "/" + request.ClientOrderID
+	o := &hedgeOrder{hedge: h, venue: venue, leg: leg, action: action, symbol: request.Symbol, key: key, ratio: h.ratio, filled: decimal.Zero}
+	t.orders[key] = o
+	var order *market.Order
+	var err error
+	if t.DryRun {
+		order = t.dryRunOrder(venue.Exchange(), request, price)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), t.LegTimeout)
+		order, err = venue.PlaceOrder(ctx, request)
+		cancel()
+	}
+	if err != nil {
+		common.Logger.Sugar().Warnf("PriceGapTrading %s %s PlaceOrder %s %s error: %v", action, request.Symbol, venue.Exchange(), request.ClientOrderID, err)
+		o.lost = true
+		o.lostTime = t.clock()
+		h.failed = true
+		return
+	}
+	h.pending++
+	t.apply(o, order)
+}
+
+// forgetLost must be called with mu held, it drops the lost orders older than
+// priceGapLostOrderAge which most likely never reached the venue
+func (t *priceGapTrader) forgetLost() {
+	now := t.clock()
+	for key, o := range t.orders {
+		if o.lost && now-o.lostTime > priceGapLostOrderAge.Milliseconds() {
+			delete(t.orders, key)
+		}
+	}
+}
+
+// clock must be called with mu held, it is the replay time in a replay
+func (t *priceGapTrader) clock() int64 {
+	if t.replay {
+		return t.now
+	}
+	return time.Now().UnixMilli()
+}
+
+func (t *priceGapTrader) dryRunOrder(exchange string, request *market.OrderRequest, price decimal.Decimal) *market.Order {
+	t.dryRunOrders++
+	now := time.Now().UnixMilli()
+	return &market.Order{
+		ID:             "dry-run-" + strconv.FormatInt(t.dryRunOrders, 10),
+		ClientOrderID:  request.ClientOrderID,
+		Exchange:       exchange,
+		Symbol:         request.Symbol,
+		Side:           request.Side,
+		Type:           request.Type,
+		Quantity:       request.Quantity,
+		ReduceOnly:     request.ReduceOnly,
+		FilledQuantity: request.Quantity,
+		AvgPrice:       price,
+		Fee:            decimal.Zero,
+		Status:         market.OrderStatusFilled,
+		Reason:         "dry run",
+		CreateTime:     now,
+		UpdateTime:     now,
+	}
+}
+
+// watch must be called with mu held, it cancels the orders of h still pending after
+// LegTimeout
+func (t *priceGapTrader) watch(h *hedge) {
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	h.deadline = 0
+	if h.pending == 0 {
+		return
+	}
+	if t.replay {
+		h.deadline = t.now + t.LegTimeout.Milliseconds()
+		return
+	}
+	h.timer = time.AfterFunc(t.LegTimeout, func() {
+		t.expire(h)
+	})
+}
+
+// expireDue advances the replay time to now and expires the hedges past their deadline
+func (t *priceGapTrader) expireDue(now int64) {
+	t.mu.Lock()
+	t.now = now
+	due := []*hedge{}
+	for _, h := range t.hedges {
+		if h.deadline > 0 && h.deadline <= now {
+			due = append(due, h)
+		}
+	}
+	t.mu.Unlock()
+	for _, h := range due {
+		t.expire(h)
+	}
+}
+
+// expire cancels the pending orders of h, the cancels that fail are retried after
+// another LegTimeout unless the orders are final by then or the kill switch, which
+// cancels every order, was triggered
+func (t *priceGapTrader) expire(h *hedge) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h.timer = nil
+	h.deadline = 0
+	if h.pending == 0 || t.stopped() {
+		return
+	}
+	for _, o := range t.orders {
+		if o.hedge != h || o.done || o.lost {
+			continue
+		}
+		common.Logger.Sugar().Warnf("PriceGapTrading %s %s order %s not filled after %s, canceling", o.action, o.symbol, o.id, t.LegTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), t.LegTimeout)
+		order, err := o.venue.CancelOrder(ctx, o.symbol, o.id)
+		cancel()
+		if err != nil {
+			common.Logger.Sugar().Warnf("PriceGapTrading %s CancelOrder %s %s error: %v", o.action, o.symbol, o.id, err)
+			continue
+		}
+		if order != nil {
+			t.apply(o, order)
+		}
+	}
+	t.watch(h)
+	t.settle(h)
+}
+
+func (t *priceGapTrader) onOrderUpdate(order *market.Order) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.orders[order.Exchange+"/"+order.ClientOrderID]
+	if !ok {
+		return
+	}
+	t.apply(o, order)
+	t.settle(o.hedge)
+}
+
+// apply must be called with mu held, it adds the new fills of order to its leg and logs
+// the order once final. The updates may come out of order with the snapshots returned
+// by the venue so the filled quantity only grows. The fills of a lost order fail its
+// hedge again so the leg is flattened
+func (t *priceGapTrader) apply(o *hedgeOrder, order *market.Order) {
+	if o.done {
+		return
+	}
+	if o.id == "" {
+		o.id = order.ID
+	}
+	if order.FilledQuantity.GreaterThan(o.filled) {
+		if o.lost {
+			o.hedge.failed = true
+			common.Logger.Sugar().Warnf("PriceGapTrading %s %s lost order %s filled %s by %s",
+				o.action, order.Symbol, order.ClientOrderID, order.FilledQuantity, order.Exchange)
+		}
+		delta := order.FilledQuantity.Sub(o.filled)
+		o.filled = order.FilledQuantity
+		if order.Side == market.SideSell {
+			delta = delta.Neg()
+		}
+		if o.leg == "a" {
+			o.hedge.positionA = o.hedge.positionA.Add(delta)
+		} else {
+			o.hedge.positionB = o.hedge.positionB.Add(delta)
+		}
+	}
+	if !order.Status.Final() {
+		return
+	}
+	o.done = true
+	if !o.lost {
+		o.hedge.pending--
+	}
+	delete(t.orders, o.key)
+	if order.Status != market.OrderStatusFilled {
+		o.hedge.failed = true
+		common.Logger.Sugar().Warnf("PriceGapTrading %s %s order %s %s by %s, filled %s of %s: %s",
+			o.action, order.Symbol, order.ID, order.Status, order.Exchange, order.FilledQuantity, order.Quantity, order.Reason)
+	}
+	t.tradeLog.Info("price_gap_trade", zap.Inline(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("symbol", o.hedge.pair.A.Symbol)
+		enc.AddString("action", o.action)
+		enc.AddString("leg", o.leg)
+		enc.AddString("ratio", o.ratio.String())
+		enc.AddBool("dry_run", t.DryRun)
+		enc.AddString("exchange", order.Exchange)
+		enc.AddString("order_symbol", order.Symbol)
+		enc.AddString("order_id", order.ID)
+		enc.AddString("side", order.Side)
+		enc.AddString("quantity", order.Quantity.String())
+		enc.AddString("filled", order.FilledQuantity.String())
+		enc.AddString("avg_price", order.AvgPrice.String())
+		enc.AddString("fee", order.Fee.String())
+		enc.AddString("status", string(order.Status))
+		enc.AddString("reason", order.Reason)
+		enc.AddInt64("time", order.UpdateTime)
+		return nil
+	})))
+}
+
+// settle must be called with mu held once the orders of h may be final. The first
+// failure of an action flattens both legs right away, a failed flatten is retried on
+// the next sample. A hedge is flat again once both legs are closed
+func (t *priceGapTrader) settle(h *hedge) {
+	if h.pending > 0 {
+		return
+	}
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	h.deadline = 0
+	if t.stopped() {
+		return
+	}
+	if h.failed {
+		h.failed = false
+		if !h.unwind {
+			h.unwind = true
+			common.Logger.Sugar().Warnf("PriceGapTrading %s leg risk, flattening %s %s and %s %s",
+				h.pair.A.Symbol, h.symbolA, h.positionA, h.symbolB, h.positionB)
+			t.closeLegs(h, hedgeFlatten)
+			return
+		}
+	}
+	if h.positionA.IsZero() && h.positionB.IsZero() {
+		h.direction = 0
+		h.unwind = false
+	}
+}
+
+// stopped must be called with mu held, it reports whether the kill switch of a venue
+// wrapped by a risk.Manager was triggered
+func (t *priceGapTrader) stopped() bool {
+	if t.killed {
+		return true
+	}
+	for _, venue := range []market.ExecutionVenue{t.VenueA, t.VenueB} {
+		if k, ok := venue.(interface{ Killed() bool }); ok && k.Killed() {
+			t.killed = true
+			common.Logger.Sugar().Warnf("PriceGapTrading stopped: the kill switch of %s was triggered", venue.Exchange())
+			return true
+		}
+	}
+	return false
+}
+
+// logAccounts logs the PnL of the paper venues
+func (t *priceGapTrader) logAccounts() {
+	for _, venue := range []market.ExecutionVenue{t.VenueA, t.VenueB} {
+		v, ok := unwrap(venue).(*paper.Venue)
+		if !ok {
+			continue
+		}
+		account := v.Account()
+		common.Logger.Sugar().Infof("PriceGapTrading paper %s pnl: %s, realized: %s, fees: %s, unrealized: %s, equity: %s, margin: %s",
+			account.Exchange, account.PnL.StringFixed(4), account.RealizedPnL.StringFixed(4), account.Fees.StringFixed(4),
+			account.UnrealizedPnL.StringFixed(4), account.Equity.StringFixed(4), account.Margin.StringFixed(4))
+	}
+}
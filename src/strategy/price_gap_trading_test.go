@@ -0,0 +1,348 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"trade/src/market"
+	"trade/src/paper"
+	"trade/src/risk"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var d = decimal.RequireFromString
+
+const (
+	testSymbolA = "BTC-USDT-SWAP"
+	testSymbolB = "BTCUSDT"
+)
+
+// testVenue is a paper venue that records the requests and fails PlaceOrder with err.
+// A lost order is placed on the paper venue before the error is returned, like an order
+// whose response timed out
+type testVenue struct {
+	*paper.Venue
+	mu     sync.Mutex
+	placed []*market.OrderRequest
+	err    error
+	lost   bool
+}
+
+func newTestVenue(exchange string, opts ...paper.Option) *testVenue {
+	return &testVenue{Venue: paper.NewVenue(exchange, append([]paper.Option{paper.WithLatency(0)}, opts...)...)}
+}
+
+func (v *testVenue) PlaceOrder(ctx context.Context, request *market.OrderRequest) (*market.Order, error) {
+	v.mu.Lock()
+	v.placed = append(v.placed, request)
+	err, lost := v.err, v.lost
+	v.mu.Unlock()
+	if err != nil && !lost {
+		return nil, err
+	}
+	order, placeErr := v.Venue.PlaceOrder(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return order, placeErr
+}
+
+// Unwrap makes the trader size the orders like on a paper venue
+func (v *testVenue) Unwrap() market.ExecutionVenue {
+	return v.Venue
+}
+
+func (v *testVenue) requests() []*market.OrderRequest {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]*market.OrderRequest(nil), v.placed...)
+}
+
+// book fills the open orders of the venue up to quantity on both sides
+func (v *testVenue) book(symbol string, price string, quantity string, localTime int64) {
+	v.OnTick(market.NewBookTick(v.Exchange(), symbol, d(price), d(quantity), d(price), d(quantity), localTime, localTime))
+}
+
+func newTestTrader(venueA market.ExecutionVenue, venueB market.ExecutionVenue, dryRun bool) *priceGapTrader {
+	trader := newPriceGapTrader(PriceGapTrading{
+		VenueA:     venueA,
+		VenueB:     venueB,
+		Entry:      d("0.5"),
+		Exit:       d("0.1"),
+		Notional:   d("1000"),
+		LegTimeout: time.Second,
+		DryRun:     dryRun,
+	}, zap.NewNop())
+	trader.replay = true
+	return trader
+}
+
+func newTestPair() *Pair {
+	return &Pair{A: &ExchangePrice{Symbol: testSymbolA}, B: &ExchangePrice{Symbol: testSymbolB}}
+}
+
+// testRecord samples A and B at the given mark prices, both book tickers are spread
+// around the mark price by spread
+func testRecord(priceA string, priceB string, spread string) *PriceGapRecord {
+	a, b, half := d(priceA), d(priceB), d(spread)
+	avg := a.Add(b).Div(decimal.NewFromInt(2))
+	hundred := decimal.NewFromInt(100)
+	bidA, askA, bidB, askB := a.Sub(half), a.Add(half), b.Sub(half), b.Add(half)
+	return &PriceGapRecord{
+		Schema:    PriceGapSchemaVersion,
+		Symbol:    testSymbolA,
+		Ratio:     a.Sub(b).Div(avg).Mul(hundred),
+		ExchangeA: market.ExchangeOKX,
+		SymbolA:   testSymbolA,
+		PriceA:    a,
+		ExchangeB: market.ExchangeBinance,
+		SymbolB:   testSymbolB,
+		PriceB:    b,
+		Executable: &PriceGapExecutable{
+			BidA:      bidA,
+			AskA:      askA,
+			BidB:      bidB,
+			AskB:      askB,
+			SellABuyB: bidA.Sub(askB).Div(avg).Mul(hundred),
+			SellBBuyA: bidB.Sub(askA).Div(avg).Mul(hundred),
+		},
+	}
+}
+
+func TestPriceGapTrading(t *testing.T) {
+	t.Run("Entry", func(t *testing.T) {
+		testTradingEntry(t)
+	})
+	t.Run("LegRejected", func(t *testing.T) {
+		testTradingLegRejected(t)
+	})
+	t.Run("LegTimeout", func(t *testing.T) {
+		testTradingLegTimeout(t)
+	})
+	t.Run("PlaceOrderError", func(t *testing.T) {
+		testTradingPlaceOrderError(t)
+	})
+	t.Run("DryRun", func(t *testing.T) {
+		testTradingDryRun(t)
+	})
+	t.Run("Quantities", func(t *testing.T) {
+		testTradingQuantities(t)
+	})
+	t.Run("Killed", func(t *testing.T) {
+		testTradingKilled(t)
+	})
+}
+
+func testTradingEntry(t *testing.T) {
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance)
+	venueA.book(testSymbolA, "101", "100", 1)
+	venueB.book(testSymbolB, "100", "100", 1)
+	trader := newTestTrader(venueA, venueB, false)
+	pair := newTestPair()
+
+	// 1% of mark price gap but the spreads leave 0.2%, under the entry of 0.5%
+	trader.onSample(pair, testRecord("101", "100", "0.4"))
+	assert.Empty(t, venueA.requests())
+	record := testRecord("101", "100", "0.4")
+	record.Executable = nil
+	trader.onSample(pair, record)
+	assert.Empty(t, venueA.requests(), "no entry without book tickers")
+
+	// the cheap leg is A, sell B and buy A
+	trader.onSample(pair, testRecord("100", "101", "0.1"))
+	require.Len(t, venueA.requests(), 1)
+	assert.Equal(t, market.SideBuy, venueA.requests()[0].Side)
+	assert.Equal(t, market.SideSell, venueB.requests()[0].Side)
+	assert.Equal(t, -1, trader.hedges[pair].direction)
+}
+
+func testTradingLegRejected(t *testing.T) {
+	// B has no margin for its leg
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance, paper.WithBalance(d("1")))
+	venueA.book(testSymbolA, "102", "100", 1)
+	venueB.book(testSymbolB, "100", "100", 1)
+	trader := newTestTrader(venueA, venueB, false)
+	pair := newTestPair()
+
+	trader.onSample(pair, testRecord("102", "100", "0"))
+	h := trader.hedges[pair]
+	assert.Equal(t, 1, h.pending, "only A is pending")
+	assert.True(t, h.failed)
+
+	// A fills, then is flattened right away
+	venueA.book(testSymbolA, "102", "100", 2)
+	requests := venueA.requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, market.SideSell, requests[0].Side)
+	assert.Equal(t, market.SideBuy, requests[1].Side)
+	assert.True(t, requests[1].ReduceOnly)
+	assert.True(t, requests[1].Quantity.Equal(requests[0].Quantity))
+	assert.True(t, h.unwind)
+	venueA.book(testSymbolA, "102", "100", 3)
+	assert.True(t, venueA.Position(testSymbolA).Quantity.IsZero())
+	assert.True(t, h.positionA.IsZero())
+	assert.Zero(t, h.direction)
+	assert.False(t, h.unwind)
+	assert.Empty(t, trader.orders)
+}
+
+func testTradingLegTimeout(t *testing.T) {
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance)
+	venueA.book(testSymbolA, "100", "100", 1)
+	venueB.book(testSymbolB, "98", "100", 1)
+	trader := newTestTrader(venueA, venueB, false)
+	pair := newTestPair()
+
+	trader.onSample(pair, testRecord("100", "98", "0"))
+	h := trader.hedges[pair]
+	// A only partially fills, B fills
+	venueA.book(testSymbolA, "100", "4", 2)
+	venueB.book(testSymbolB, "98", "100", 2)
+	assert.Equal(t, 1, h.pending)
+	assert.True(t, h.positionA.Equal(d("-4")))
+	assert.True(t, h.positionB.Equal(d("10")))
+
+	// the order of A is canceled after LegTimeout and both legs are flattened
+	trader.expireDue(500)
+	assert.Equal(t, 1, h.pending, "not due yet")
+	trader.expireDue(time.Second.Milliseconds())
+	assert.True(t, h.unwind)
+	requestsA, requestsB := venueA.requests(), venueB.requests()
+	require.Len(t, requestsA, 2)
+	require.Len(t, requestsB, 2)
+	assert.True(t, requestsA[1].Quantity.Equal(d("4")))
+	assert.True(t, requestsB[1].Quantity.Equal(d("10")))
+	assert.True(t, requestsB[1].ReduceOnly)
+
+	venueA.book(testSymbolA, "100", "100", 3)
+	venueB.book(testSymbolB, "98", "100", 3)
+	assert.True(t, venueA.Position(testSymbolA).Quantity.IsZero())
+	assert.True(t, venueB.Position(testSymbolB).Quantity.IsZero())
+	assert.Zero(t, h.direction)
+	assert.False(t, h.unwind)
+}
+
+func testTradingPlaceOrderError(t *testing.T) {
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance)
+	venueA.book(testSymbolA, "100", "100", 1)
+	venueB.book(testSymbolB, "98", "100", 1)
+	trader := newTestTrader(venueA, venueB, false)
+	pair := newTestPair()
+
+	// B is never sent, A is flattened once filled
+	venueB.err = errors.New("connection reset")
+	trader.onSample(pair, testRecord("100", "98", "0"))
+	h := trader.hedges[pair]
+	venueA.book(testSymbolA, "100", "100", 2)
+	venueA.book(testSymbolA, "100", "100", 3)
+	assert.True(t, venueA.Position(testSymbolA).Quantity.IsZero())
+	assert.Len(t, venueA.requests(), 2)
+	assert.Zero(t, h.direction)
+	assert.Len(t, trader.orders, 1, "B is kept in case it reached the venue")
+
+	// B times out but exists at the venue, its late fill is flattened too. The first B
+	// is forgotten by then
+	venueB.err, venueB.lost = context.DeadlineExceeded, true
+	trader.expireDue(priceGapLostOrderAge.Milliseconds() + 1)
+	trader.onSample(pair, testRecord("100", "98", "0"))
+	require.Len(t, venueB.requests(), 2)
+	assert.NotEmpty(t, venueB.requests()[1].ClientOrderID)
+	assert.NotEqual(t, venueA.requests()[2].ClientOrderID, venueB.requests()[1].ClientOrderID)
+	venueA.book(testSymbolA, "100", "100", 4)
+	venueA.book(testSymbolA, "100", "100", 5)
+	assert.True(t, venueA.Position(testSymbolA).Quantity.IsZero())
+	assert.Zero(t, h.direction)
+	venueB.err = nil
+	venueB.book(testSymbolB, "98", "100", 4)
+	assert.True(t, h.positionB.Equal(d("10")))
+	require.Len(t, venueB.requests(), 3)
+	flatten := venueB.requests()[2]
+	assert.Equal(t, market.SideSell, flatten.Side)
+	assert.True(t, flatten.ReduceOnly)
+	venueB.book(testSymbolB, "98", "100", 5)
+	assert.True(t, venueB.Position(testSymbolB).Quantity.IsZero())
+	assert.True(t, h.positionB.IsZero())
+	assert.Zero(t, h.direction)
+	assert.Empty(t, trader.orders)
+}
+
+func testTradingDryRun(t *testing.T) {
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance)
+	trader := newTestTrader(venueA, venueB, true)
+	pair := newTestPair()
+
+	trader.onSample(pair, testRecord("100", "98", "0"))
+	h := trader.hedges[pair]
+	assert.Equal(t, 1, h.direction)
+	assert.True(t, h.positionA.Equal(d("-10")))
+	assert.True(t, h.positionB.Equal(d("10")))
+	assert.Zero(t, h.pending)
+	// the gap closed
+	trader.onSample(pair, testRecord("99", "99", "0"))
+	assert.Zero(t, h.direction)
+	assert.True(t, h.positionA.IsZero())
+	assert.Empty(t, venueA.requests())
+	assert.Empty(t, venueB.requests())
+	assert.NoError(t, trader.initVenues(context.Background()))
+}
+
+func testTradingQuantities(t *testing.T) {
+	trader := newTestTrader(newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance), false)
+	trader.paper = false
+	record := testRecord("30000", "30000", "0")
+	_, _, ok := trader.quantities(record)
+	assert.False(t, ok, "the instruments are unknown")
+
+	trader.registry = market.NewRegistry()
+	trader.registry.Add(
+		&market.Instrument{ID: "BTC/USDT:USDT", Exchange: market.ExchangeOKX, Symbol: testSymbolA, LotSize: d("0.01"), MinQuantity: d("0.01"), ContractValue: d("0.01")},
+		&market.Instrument{ID: "BTC/USDT:USDT", Exchange: market.ExchangeBinance, Symbol: testSymbolB, LotSize: d("0.001"), MinQuantity: d("0.001"), ContractValue: d("1")},
+	)
+	// 1000 at 30000 is 0.0333 BTC, 0.033 on the lot of Binance and 3.3 contracts of 0.01
+	quantityA, quantityB, ok := trader.quantities(record)
+	require.True(t, ok)
+	assert.True(t, quantityA.Equal(d("3.3")), quantityA.String())
+	assert.True(t, quantityB.Equal(d("0.033")), quantityB.String())
+
+	trader.Notional = d("10")
+	_, _, ok = trader.quantities(record)
+	assert.False(t, ok, "under one lot")
+}
+
+func testTradingKilled(t *testing.T) {
+	manager := risk.NewManager(risk.Limits{})
+	venueA, venueB := newTestVenue(market.ExchangeOKX), newTestVenue(market.ExchangeBinance)
+	riskA, riskB := manager.Wrap(venueA), manager.Wrap(venueB)
+	riskA.OnTick(market.NewBookTick(market.ExchangeOKX, testSymbolA, d("100"), d("100"), d("100"), d("100"), 1, 1))
+	riskB.OnTick(market.NewBookTick(market.ExchangeBinance, testSymbolB, d("98"), d("100"), d("98"), d("100"), 1, 1))
+	trader := newTestTrader(riskA, riskB, false)
+	trader.paper = true
+	pair := newTestPair()
+
+	trader.onSample(pair, testRecord("100", "98", "0"))
+	h := trader.hedges[pair]
+	venueA.book(testSymbolA, "100", "100", 2)
+	venueB.book(testSymbolB, "98", "100", 2)
+	require.Zero(t, h.pending)
+	require.Equal(t, 1, h.direction)
+	require.NoError(t, manager.Kill(context.Background(), "test"))
+	requestsA, requestsB := len(venueA.requests()), len(venueB.requests())
+
+	// the gap closed but the trader neither closes nor enters again, the manager flattens
+	trader.onSample(pair, testRecord("99", "99", "0"))
+	trader.onSample(pair, testRecord("99", "99", "0"))
+	trader.expireDue(time.Second.Milliseconds())
+	assert.Len(t, venueA.requests(), requestsA)
+	assert.Len(t, venueB.requests(), requestsB)
+	assert.Zero(t, manager.Stats().Rejections[risk.RejectKillSwitch])
+	venueA.book(testSymbolA, "100", "100", 3)
+	venueB.book(testSymbolB, "98", "100", 3)
+	assert.True(t, venueA.Position(testSymbolA).Quantity.IsZero())
+	assert.True(t, venueB.Position(testSymbolB).Quantity.IsZero())
+}